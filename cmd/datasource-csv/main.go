@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/clems4ever/go-graphkb/graphkb"
 	"github.com/sirupsen/logrus"
@@ -32,8 +36,8 @@ func NewCSVSource(graphAPI *graphkb.GraphAPI) *CSVSource {
 	return csvSource
 }
 
-// Publish the graph built from CSV
-func (cs *CSVSource) Publish() error {
+// Publish the graph built from CSV. In dry-run mode, the changes are printed instead of being sent.
+func (cs *CSVSource) Publish(ctx context.Context, dryRun, forceDeletion bool) error {
 	file, err := os.Open(cs.dataPath)
 	if err != nil {
 		return err
@@ -42,7 +46,7 @@ func (cs *CSVSource) Publish() error {
 
 	r := csv.NewReader(file)
 
-	tx, err := cs.graphAPI.CreateTransaction()
+	tx, err := cs.graphAPI.CreateTransactionContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -73,7 +77,21 @@ func (cs *CSVSource) Publish() error {
 		tx.Relate(record[1], relationType, record[4])
 	}
 
-	err = tx.Commit()
+	if dryRun {
+		plan, err := tx.PlanContext(ctx)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+
+	if forceDeletion {
+		tx.ForceDeletion()
+	}
+
+	err = tx.CommitContext(ctx)
 	if err == nil {
 		logrus.Info("CSV data has been sent successfully")
 	}
@@ -83,6 +101,12 @@ func (cs *CSVSource) Publish() error {
 // ConfigPath string
 var ConfigPath string
 
+// DryRun print the changes instead of sending them
+var DryRun bool
+
+// ForceDeletion bypass the deletion guards
+var ForceDeletion bool
+
 func onInit() {
 	viper.SetConfigFile(ConfigPath)
 	viper.SetConfigType("yaml")
@@ -105,11 +129,35 @@ func main() {
 				URL:        viper.GetString("graphkb_url"),
 				AuthToken:  viper.GetString("graphkb_auth_token"),
 				SkipVerify: viper.GetBool("graphkb_skip_verify"),
+				CacheDir:   viper.GetString("graphkb_cache_dir"),
+				SourceName: viper.GetString("graphkb_source_name"),
+
+				CAFile:         viper.GetString("graphkb_ca_file"),
+				ClientCertFile: viper.GetString("graphkb_client_cert"),
+				ClientKeyFile:  viper.GetString("graphkb_client_key"),
+
+				BinaryWireFormat: viper.GetBool("graphkb_binary_wire_format"),
+				Compression:      viper.GetBool("graphkb_compression"),
+
+				ExpectedUpdateInterval: viper.GetDuration("graphkb_expected_update_interval"),
+
+				DeletionGuard: graphkb.DeletionGuard{
+					MaxRemovedRatio: viper.GetFloat64("graphkb_deletion_guard_max_removed_ratio"),
+					MaxRemoved:      viper.GetInt64("graphkb_deletion_guard_max_removed"),
+				},
+			}
+
+			api, err := graphkb.NewGraphAPI(options)
+			if err != nil {
+				logrus.Fatal(err)
 			}
+			dataSource := NewCSVSource(api)
 
-			dataSource := NewCSVSource(graphkb.NewGraphAPI(options))
+			// Stop sending updates on SIGTERM so that the data source shuts down cleanly
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
 
-			if err := dataSource.Publish(); err != nil {
+			if err := dataSource.Publish(ctx, DryRun, ForceDeletion); err != nil {
 				panic(err)
 			}
 		},
@@ -117,6 +165,10 @@ func main() {
 
 	rootCmd.PersistentFlags().StringVar(&ConfigPath, "config", "config.yml",
 		"Provide the path to the configuration file (required)")
+	rootCmd.PersistentFlags().BoolVar(&DryRun, "dry-run", false,
+		"Print the changes the data source would make instead of sending them")
+	rootCmd.PersistentFlags().BoolVar(&ForceDeletion, "force-deletion", false,
+		"Commit even if the deletion guards would refuse removing that many assets and relations")
 
 	if err := rootCmd.Execute(); err != nil {
 		logrus.Fatal(err)
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,7 +14,9 @@ import (
 	"github.com/clems4ever/go-graphkb/internal/database"
 	"github.com/clems4ever/go-graphkb/internal/history"
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/schema"
 	"github.com/clems4ever/go-graphkb/internal/server"
+	"github.com/clems4ever/go-graphkb/internal/sources"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -67,12 +71,93 @@ func main() {
 		Args: cobra.ExactArgs(1),
 	}
 
+	schemaCmd := &cobra.Command{
+		Use: "schema",
+	}
+
+	schemaHistoryCmd := &cobra.Command{
+		Use:  "history [source]",
+		Run:  schemaHistory,
+		Args: cobra.ExactArgs(1),
+	}
+
+	schemaDiffCmd := &cobra.Command{
+		Use:  "diff [source] [from] [to]",
+		Run:  schemaDiff,
+		Args: cobra.ExactArgs(3),
+	}
+
+	schemaCmd.AddCommand(schemaHistoryCmd, schemaDiffCmd)
+
+	sourcesCmd := &cobra.Command{
+		Use: "sources",
+	}
+
+	sourcesAddCmd := &cobra.Command{
+		Use:  "add [source]",
+		Run:  sourcesAdd,
+		Args: cobra.ExactArgs(1),
+	}
+
+	sourcesListCmd := &cobra.Command{
+		Use: "list",
+		Run: sourcesList,
+	}
+
+	sourcesRotateTokenCmd := &cobra.Command{
+		Use:  "rotate-token [source]",
+		Run:  sourcesRotateToken,
+		Args: cobra.ExactArgs(1),
+	}
+	sourcesRotateTokenCmd.Flags().String("token", sources.DefaultTokenName, "The name of the token to rotate")
+
+	sourcesRenameCmd := &cobra.Command{
+		Use:  "rename [source] [new-name]",
+		Run:  sourcesRename,
+		Args: cobra.ExactArgs(2),
+	}
+
+	sourcesRemoveCmd := &cobra.Command{
+		Use:  "remove [source]",
+		Run:  sourcesRemove,
+		Args: cobra.ExactArgs(1),
+	}
+
+	sourcesTokensCmd := &cobra.Command{
+		Use: "tokens",
+	}
+
+	sourcesTokensListCmd := &cobra.Command{
+		Use:  "list [source]",
+		Run:  sourcesTokensList,
+		Args: cobra.ExactArgs(1),
+	}
+
+	sourcesTokensAddCmd := &cobra.Command{
+		Use:  "add [source] [token]",
+		Run:  sourcesTokensAdd,
+		Args: cobra.ExactArgs(2),
+	}
+	sourcesTokensAddCmd.Flags().StringSlice("scope", nil, fmt.Sprintf("The scopes granted to the token among %v (required)", sources.AllScopes))
+	sourcesTokensAddCmd.Flags().Duration("expires-in", 0, "The duration after which the token expires, it never expires by default")
+
+	sourcesTokensRevokeCmd := &cobra.Command{
+		Use:  "revoke [source] [token]",
+		Run:  sourcesTokensRevoke,
+		Args: cobra.ExactArgs(2),
+	}
+
+	sourcesTokensCmd.AddCommand(sourcesTokensListCmd, sourcesTokensAddCmd, sourcesTokensRevokeCmd)
+
+	sourcesCmd.AddCommand(sourcesAddCmd, sourcesListCmd, sourcesRotateTokenCmd, sourcesRenameCmd, sourcesRemoveCmd,
+		sourcesTokensCmd)
+
 	rootCmd.PersistentFlags().StringVar(&ConfigPath, "config", "config.yml", "Provide the path to the configuration file (required)")
 	rootCmd.PersistentFlags().StringVar(&LogLevel, "log-level", "info", "The log level among 'debug', 'info', 'warn', 'error'")
 
 	cobra.OnInitialize(onInit)
 
-	rootCmd.AddCommand(cleanCmd, listenCmd, countCmd, readCmd, queryCmd)
+	rootCmd.AddCommand(cleanCmd, listenCmd, countCmd, readCmd, queryCmd, schemaCmd, sourcesCmd)
 	if err := rootCmd.Execute(); err != nil {
 		logrus.Fatal(err)
 	}
@@ -99,6 +184,7 @@ func onInit() {
 	viper.SetConfigType("yaml")
 	viper.SetDefault("mariadb_max_idle_conns", 10)
 	viper.SetDefault("mariadb_max_open_conns", 10)
+	viper.SetDefault("entity_resolution", false)
 
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
@@ -164,7 +250,7 @@ func listen(cmd *cobra.Command, args []string) {
 		concurrency = 32
 	}
 
-	server.StartServer(listenInterface, Database, Database, Database, Historizer, concurrency)
+	server.StartServer(listenInterface, Database, Database, Database, Database, Database, Database, Historizer, Database, concurrency)
 }
 
 func read(cmd *cobra.Command, args []string) {
@@ -185,7 +271,14 @@ func queryFunc(cmd *cobra.Command, args []string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	ontology, err := Database.LoadOntology(ctx)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
 	q := knowledge.NewQuerier(Database, Database)
+	q.Options.Ontology = &ontology
+	q.Options.ResolveEntities = viper.GetBool("entity_resolution")
 
 	r, err := q.Query(ctx, args[0])
 	if err != nil {
@@ -213,3 +306,166 @@ func queryFunc(cmd *cobra.Command, args []string) {
 
 	fmt.Printf("%d results found in %fms\n", resultsCount, float64(totalTime.Microseconds())/1000.0)
 }
+
+func schemaHistory(cmd *cobra.Command, args []string) {
+	versions, err := Database.ListSchemaVersions(context.Background(), args[0])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	for _, v := range versions {
+		fmt.Printf("%d\t%s\n", v.Version, v.Timestamp.Format(time.RFC3339))
+	}
+	fmt.Printf("%d versions found\n", len(versions))
+}
+
+func schemaDiff(cmd *cobra.Command, args []string) {
+	from, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		logrus.Fatalf("Unable to parse from version: %v", err)
+	}
+	to, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		logrus.Fatalf("Unable to parse to version: %v", err)
+	}
+
+	fromSchema, err := Database.LoadSchemaVersion(context.Background(), args[0], from)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	toSchema, err := Database.LoadSchemaVersion(context.Background(), args[0], to)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	diff := schema.Diff(fromSchema, toSchema)
+	for _, v := range diff.AddedVertices {
+		fmt.Printf("+ (%s)\n", v)
+	}
+	for _, v := range diff.RemovedVertices {
+		fmt.Printf("- (%s)\n", v)
+	}
+	for _, e := range diff.AddedEdges {
+		fmt.Printf("+ (%s)-[%s]->(%s)\n", e.FromType, e.Type, e.ToType)
+	}
+	for _, e := range diff.RemovedEdges {
+		fmt.Printf("- (%s)-[%s]->(%s)\n", e.FromType, e.Type, e.ToType)
+	}
+}
+
+func sourcesAdd(cmd *cobra.Command, args []string) {
+	if err := sources.CheckName(args[0]); err != nil {
+		logrus.Fatal(err)
+	}
+	token, err := sources.GenerateToken()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := Database.CreateSource(context.Background(), args[0], token); err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("Source %s created with %s auth token %s\n", args[0], sources.DefaultTokenName, token)
+}
+
+func sourcesList(cmd *cobra.Command, args []string) {
+	names, err := Database.ListSources(context.Background())
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	fmt.Printf("%d sources found\n", len(names))
+}
+
+func sourcesRotateToken(cmd *cobra.Command, args []string) {
+	token, err := sources.GenerateToken()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	tokenName, _ := cmd.Flags().GetString("token")
+	if err := Database.RotateToken(context.Background(), args[0], tokenName, token); err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("New secret of token %s of source %s is %s\n", tokenName, args[0], token)
+}
+
+func sourcesRename(cmd *cobra.Command, args []string) {
+	if err := sources.CheckName(args[1]); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := Database.RenameSource(context.Background(), args[0], args[1]); err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("Source %s renamed into %s\n", args[0], args[1])
+}
+
+func sourcesRemove(cmd *cobra.Command, args []string) {
+	report, err := Database.RemoveSource(context.Background(), args[0])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("Source %s removed along with %d assets and %d relations no other source references\n",
+		args[0], report.RemovedAssets, report.RemovedRelations)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func sourcesTokensList(cmd *cobra.Command, args []string) {
+	tokens, err := Database.ListTokens(context.Background(), args[0])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	for _, t := range tokens {
+		scopes := make([]string, len(t.Scopes))
+		for i, s := range t.Scopes {
+			scopes[i] = string(s)
+		}
+		fmt.Printf("%s\t%s\texpires=%s\tlast_used=%s\n", t.Name, strings.Join(scopes, ","),
+			formatOptionalTime(t.ExpiresAt), formatOptionalTime(t.LastUsedAt))
+	}
+	fmt.Printf("%d tokens found\n", len(tokens))
+}
+
+func sourcesTokensAdd(cmd *cobra.Command, args []string) {
+	if err := sources.CheckName(args[1]); err != nil {
+		logrus.Fatal(err)
+	}
+	scopeValues, _ := cmd.Flags().GetStringSlice("scope")
+	scopes, err := sources.ParseScopes(scopeValues)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(scopes) == 0 {
+		logrus.Fatalf("At least one scope among %v is required", sources.AllScopes)
+	}
+
+	spec := sources.Token{Name: args[1], Scopes: scopes}
+	if expiresIn, _ := cmd.Flags().GetDuration("expires-in"); expiresIn > 0 {
+		expiresAt := time.Now().Add(expiresIn).Truncate(time.Second)
+		spec.ExpiresAt = &expiresAt
+	}
+
+	token, err := sources.GenerateToken()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := Database.AddToken(context.Background(), args[0], spec, token); err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("Token %s of source %s created with auth token %s\n", args[1], args[0], token)
+}
+
+func sourcesTokensRevoke(cmd *cobra.Command, args []string) {
+	if err := Database.RevokeToken(context.Background(), args[0], args[1]); err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Printf("Token %s of source %s revoked\n", args[1], args[0])
+}
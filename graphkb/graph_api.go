@@ -11,7 +11,7 @@ type GraphAPI = client.GraphAPI
 // GraphAPIOptions are the options provided to GraphAPI
 type GraphAPIOptions = client.GraphAPIOptions
 
-// NewGraphAPI creates a new graph API
+// NewGraphAPI creates a new graph API, it fails when the graph cannot be persisted under the name of the source
 var NewGraphAPI = client.NewGraphAPI
 
 // QueryResponse is the response from the GraphAPI query
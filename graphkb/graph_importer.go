@@ -1,6 +1,45 @@
 package graphkb
 
-import "github.com/clems4ever/go-graphkb/internal/client"
+import (
+	"github.com/clems4ever/go-graphkb/internal/client"
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+)
 
 // Transaction represent a graph transaction
 type Transaction = client.Transaction
+
+// IncrementalTransaction represent a graph transaction made of explicit additions and removals
+type IncrementalTransaction = client.IncrementalTransaction
+
+// TransactionPlan is the report of the changes a transaction would make if it was committed
+type TransactionPlan = client.TransactionPlan
+
+// DeletionGuard limits the number of assets and relations a commit can remove
+type DeletionGuard = knowledge.DeletionGuard
+
+// DeletionGuardError is returned when a commit is refused by the deletion guard
+type DeletionGuardError = client.DeletionGuardError
+
+// ErrDeletionGuard is matched by the errors returned when a commit is refused by the deletion guard
+var ErrDeletionGuard = knowledge.ErrDeletionGuard
+
+// APIError is an error response returned by the API, it matches one of the error classes below with errors.Is
+type APIError = client.APIError
+
+var (
+	// ErrUnauthorized is matched by the errors returned when the API refused the auth token
+	ErrUnauthorized = client.ErrUnauthorized
+	// ErrForbidden is matched by the errors returned when the API denied the auth token the requested operation
+	ErrForbidden = client.ErrForbidden
+	// ErrTooManyRequests is matched by the errors returned when the API was overloaded
+	ErrTooManyRequests = client.ErrTooManyRequests
+	// ErrSchemaViolation is matched by the errors returned when the API refused updates not matching the schema
+	ErrSchemaViolation = client.ErrSchemaViolation
+	// ErrServerUnavailable is matched by the errors returned when the API failed or was unavailable
+	ErrServerUnavailable = client.ErrServerUnavailable
+)
+
+// IsRetryable returns true if the request which failed with the error can be retried later
+func IsRetryable(err error) bool {
+	return client.IsRetryable(err)
+}
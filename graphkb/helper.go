@@ -6,39 +6,102 @@ import (
 	"github.com/clems4ever/go-graphkb/internal/schema"
 )
 
+// CreateRelationOption is an option that can be used when creating a relation
+type CreateRelationOption func(relation RelationType)
+
+// RelationConstraint is a constraint on a relation type
+type RelationConstraint = schema.RelationConstraint
+
+// WithMaxCardinality ensures an asset has at most max relations of this type
+func WithMaxCardinality(max int) CreateRelationOption {
+	return func(relation RelationType) {
+		schema.AddRelationConstraint(RelationConstraint{
+			Relation:       relation,
+			MaxCardinality: max,
+		})
+	}
+}
+
+// WithRequiredRelation ensures every asset of the source type has at least one relation of this type
+func WithRequiredRelation() CreateRelationOption {
+	return func(relation RelationType) {
+		schema.AddRelationConstraint(RelationConstraint{
+			Relation: relation,
+			Required: true,
+		})
+	}
+}
+
 // CreateRelation helper function for creating a relation
-func CreateRelation(fromType schema.AssetType, relation, toType schema.AssetType) RelationType {
-	return schema.RelationType{
+func CreateRelation(fromType schema.AssetType, relation, toType schema.AssetType, options ...CreateRelationOption) RelationType {
+	relationType := schema.RelationType{
 		FromType: fromType,
 		Type:     RelationKeyType(relation),
 		ToType:   toType,
 	}
+	for _, o := range options {
+		o(relationType)
+	}
+	return relationType
 }
 
 // CreateAssetOption is an option that can be used when creating an asset
 type CreateAssetOption func(asset AssetType)
 
-// WithRegexpValidation adds a regexp validation check to an asset
-func WithRegexpValidation(r string) CreateAssetOption {
-	reg := regexp.MustCompile(r)
+// AssetValidationRule is a validation rule of an asset type
+type AssetValidationRule = schema.AssetValidationRule
+
+// WithValidationRule adds a validation rule to an asset
+func WithValidationRule(rule AssetValidationRule) CreateAssetOption {
 	return func(asset AssetType) {
-		schema.AddAssetValidator(asset, func(s string) bool {
-			return reg.MatchString(s)
-		})
+		schema.AddAssetValidator(asset, rule)
 	}
 }
 
+// WithRegexpValidation adds a regexp validation check to an asset
+func WithRegexpValidation(r string) CreateAssetOption {
+	// Fail early if the regexp is not valid
+	regexp.MustCompile(r)
+	return WithValidationRule(AssetValidationRule{
+		Type:    schema.RegexpValidationRule,
+		Pattern: r,
+	})
+}
+
 // WithValuesValidation adds a check ensuring an asset value is part of the given list
 func WithValuesValidation(expected ...string) CreateAssetOption {
-	ex := make(map[string]bool, len(expected))
-	for _, e := range expected {
-		ex[e] = true
-	}
-	return func(asset AssetType) {
-		schema.AddAssetValidator(asset, func(s string) bool {
-			return ex[s]
-		})
-	}
+	return WithValidationRule(AssetValidationRule{
+		Type:   schema.EnumValidationRule,
+		Values: expected,
+	})
+}
+
+// WithMaxLengthValidation adds a check ensuring an asset value does not exceed the given length
+func WithMaxLengthValidation(maxLength int) CreateAssetOption {
+	return WithValidationRule(AssetValidationRule{
+		Type:      schema.MaxLengthValidationRule,
+		MaxLength: maxLength,
+	})
+}
+
+// WithCIDRValidation adds a check ensuring an asset value is a network in CIDR notation
+func WithCIDRValidation() CreateAssetOption {
+	return WithValidationRule(AssetValidationRule{Type: schema.CIDRValidationRule})
+}
+
+// WithHostnameValidation adds a check ensuring an asset value is a valid hostname
+func WithHostnameValidation() CreateAssetOption {
+	return WithValidationRule(AssetValidationRule{Type: schema.HostnameValidationRule})
+}
+
+// WithEmailValidation adds a check ensuring an asset value is an email address
+func WithEmailValidation() CreateAssetOption {
+	return WithValidationRule(AssetValidationRule{Type: schema.EmailValidationRule})
+}
+
+// WithURLValidation adds a check ensuring an asset value is an absolute URL
+func WithURLValidation() CreateAssetOption {
+	return WithValidationRule(AssetValidationRule{Type: schema.URLValidationRule})
 }
 
 // CreateAsset helper function for creating an asset
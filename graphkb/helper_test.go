@@ -3,6 +3,7 @@ package graphkb
 import (
 	"testing"
 
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
 	"github.com/clems4ever/go-graphkb/internal/schema"
 	"github.com/stretchr/testify/require"
 )
@@ -16,3 +17,17 @@ func TestCreateAsset(t *testing.T) {
 	validators, _ = schema.AssetValidationRegistry.Get(asset)
 	require.Equal(t, 1, len(validators))
 }
+
+func TestShouldShipValidatorsWithExtractedSchema(t *testing.T) {
+	asset := CreateAsset("email_address", WithEmailValidation())
+
+	g := knowledge.NewGraph()
+	_, err := g.AddAsset(asset, "john@example.com")
+	require.NoError(t, err)
+
+	_, err = g.AddAsset(asset, "john")
+	require.ErrorIs(t, err, schema.ErrAssetValidation)
+
+	sg := g.ExtractSchema()
+	require.Equal(t, []schema.AssetValidationRule{{Type: schema.EmailValidationRule}}, sg.Validators[asset])
+}
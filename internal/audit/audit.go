@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// ActorKind is the kind of credential the actor of an operation is authenticated with
+type ActorKind string
+
+const (
+	// ActorAnonymous is the kind of the actors which are not authenticated, either because the authentication failed
+	// or because it is disabled
+	ActorAnonymous ActorKind = "anonymous"
+	// ActorUser is the kind of the users authenticated by password, proxy header or JWT
+	ActorUser ActorKind = "user"
+	// ActorToken is the kind of the sources authenticated by one of their tokens
+	ActorToken ActorKind = "token"
+	// ActorCertificate is the kind of the sources authenticated by a client certificate
+	ActorCertificate ActorKind = "certificate"
+)
+
+// Outcome is the outcome of an audited operation
+type Outcome string
+
+const (
+	// OutcomeSuccess is the outcome of the operations which succeeded
+	OutcomeSuccess Outcome = "success"
+	// OutcomeDenied is the outcome of the operations rejected because the actor is not authenticated or not allowed
+	OutcomeDenied Outcome = "denied"
+	// OutcomeFailure is the outcome of the operations which failed
+	OutcomeFailure Outcome = "failure"
+)
+
+// OutcomeOf return the outcome of an operation replied with the status code
+func OutcomeOf(status int) Outcome {
+	switch {
+	case status < 400:
+		return OutcomeSuccess
+	case status == 401 || status == 403:
+		return OutcomeDenied
+	default:
+		return OutcomeFailure
+	}
+}
+
+// Entry is an entry of the audit log describing a write or administrative operation
+type Entry struct {
+	ID        int64     `json:"id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	ActorKind ActorKind `json:"actor_kind"`
+	// Actor is the name of the user or of the source performing the operation
+	Actor string `json:"actor,omitempty"`
+	// Token is the name of the token the source is authenticated with
+	Token string `json:"token,omitempty"`
+	// Source is the source whose graph is updated or which is administrated
+	Source string `json:"source,omitempty"`
+
+	Method    string `json:"method"`
+	Endpoint  string `json:"endpoint"`
+	Operation string `json:"operation"`
+
+	// Assets and Relations are the number of assets and relations sent along with the operation
+	Assets    int64 `json:"assets"`
+	Relations int64 `json:"relations"`
+
+	Status  int     `json:"status"`
+	Outcome Outcome `json:"outcome"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Filter selects the entries of the audit log, the zero values do not filter
+type Filter struct {
+	Actor     string
+	Source    string
+	Operation string
+	Outcome   Outcome
+	Since     time.Time
+	Until     time.Time
+	// Limit is the maximum number of entries, the most recent ones are returned first
+	Limit int
+}
+
+// Logger records the entries of the audit log
+type Logger interface {
+	RecordAuditEntry(ctx context.Context, entry Entry) error
+}
+
+// Store records the entries of the audit log and lists them
+type Store interface {
+	Logger
+	ListAuditEntries(ctx context.Context, filter Filter) ([]Entry, error)
+}
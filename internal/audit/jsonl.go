@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// JSONLMirror is a store mirroring the entries recorded in the underlying store to a file, one JSON entry per line,
+// so that they can be shipped to an external system. The entries are listed from the underlying store.
+type JSONLMirror struct {
+	store Store
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewJSONLMirror create a store mirroring the entries to the file at path, the entries are appended to the file
+func NewJSONLMirror(store Store, path string) (*JSONLMirror, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open audit log file %s: %v", path, err)
+	}
+	return &JSONLMirror{store: store, file: f}, nil
+}
+
+// RecordAuditEntry record the entry in the underlying store and append it to the file. The entry is appended to the
+// file even when the underlying store fails to record it.
+func (m *JSONLMirror) RecordAuditEntry(ctx context.Context, entry Entry) error {
+	storeErr := m.store.RecordAuditEntry(ctx, entry)
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Unable to encode audit entry: %v", err)
+	}
+
+	m.mutex.Lock()
+	_, err = m.file.Write(append(b, '\n'))
+	m.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("Unable to write audit entry to %s: %v", m.file.Name(), err)
+	}
+	return storeErr
+}
+
+// ListAuditEntries list the entries of the underlying store
+func (m *JSONLMirror) ListAuditEntries(ctx context.Context, filter Filter) ([]Entry, error) {
+	return m.store.ListAuditEntries(ctx, filter)
+}
+
+// Close close the file
+func (m *JSONLMirror) Close() error {
+	return m.file.Close()
+}
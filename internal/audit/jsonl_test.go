@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockStore struct {
+	mockLogger
+}
+
+func (m *mockStore) ListAuditEntries(ctx context.Context, filter Filter) ([]Entry, error) {
+	return m.entries, nil
+}
+
+func TestShouldMirrorEntriesToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	store := &mockStore{}
+
+	mirror, err := NewJSONLMirror(store, path)
+	require.NoError(t, err)
+	require.NoError(t, mirror.RecordAuditEntry(context.Background(), Entry{Actor: "alice", Operation: "flush_database"}))
+
+	store.err = errors.New("database is down")
+	err = mirror.RecordAuditEntry(context.Background(), Entry{Actor: "scanner", Operation: "insert_assets", Assets: 2})
+	assert.EqualError(t, err, "database is down")
+	require.NoError(t, mirror.Close())
+
+	entries, err := mirror.ListAuditEntries(context.Background(), Filter{})
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// The entries are appended to the existing file
+	mirror, err = NewJSONLMirror(&mockStore{}, path)
+	require.NoError(t, err)
+	require.NoError(t, mirror.RecordAuditEntry(context.Background(), Entry{Actor: "bob"}))
+	require.NoError(t, mirror.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 3)
+
+	var entry Entry
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "scanner", entry.Actor)
+	assert.Equal(t, int64(2), entry.Assets)
+}
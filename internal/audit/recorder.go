@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxErrorLength is the maximum length of the error message kept from the body of a failed reply
+const maxErrorLength = 512
+
+type contextKey string
+
+var contextKeyRecorder = contextKey("auditRecorder")
+
+// Recorder collects the details of an operation while the request is processed. The methods do nothing when the
+// recorder is nil so that the handlers can be served without being audited.
+type Recorder struct {
+	mutex sync.Mutex
+	entry Entry
+}
+
+// FromContext return the recorder of the operation served in the context, nil when the operation is not audited
+func FromContext(ctx context.Context) *Recorder {
+	recorder, _ := ctx.Value(contextKeyRecorder).(*Recorder)
+	return recorder
+}
+
+// SetUser record the user performing the operation
+func (r *Recorder) SetUser(name string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entry.ActorKind = ActorUser
+	r.entry.Actor = name
+}
+
+// SetSourceToken record the source performing the operation with one of its tokens
+func (r *Recorder) SetSourceToken(source, token string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entry.ActorKind = ActorToken
+	r.entry.Actor = source
+	r.entry.Token = token
+	r.entry.Source = source
+}
+
+// SetSourceCertificate record the source performing the operation with a client certificate
+func (r *Recorder) SetSourceCertificate(source string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entry.ActorKind = ActorCertificate
+	r.entry.Actor = source
+	r.entry.Source = source
+}
+
+// SetSource record the source targeted by the operation
+func (r *Recorder) SetSource(source string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entry.Source = source
+}
+
+// AddAssets record assets sent along with the operation
+func (r *Recorder) AddAssets(count int) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entry.Assets += int64(count)
+}
+
+// AddRelations record relations sent along with the operation
+func (r *Recorder) AddRelations(count int) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entry.Relations += int64(count)
+}
+
+// SetError record the error the operation failed with
+func (r *Recorder) SetError(err error) {
+	if r == nil || err == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entry.Error = truncate(err.Error())
+}
+
+// Entry return the entry recorded so far
+func (r *Recorder) Entry() Entry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.entry
+}
+
+func truncate(message string) string {
+	message = strings.TrimSpace(message)
+	if len(message) > maxErrorLength {
+		return message[:maxErrorLength]
+	}
+	return message
+}
+
+// statusRecorder keeps the status code and the beginning of the body of the reply
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && len(w.body) < maxErrorLength {
+		w.body = append(w.body, b...)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// RecordTimeout is the time given to record an audit entry once the request has been handled
+const RecordTimeout = 5 * time.Second
+
+// Middleware record an entry in the audit log for every request served by the handler. The handler and the
+// authentication middlewares it is made of complete the entry through the recorder put in the context of the request.
+func Middleware(logger Logger, operation string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &Recorder{entry: Entry{
+			Timestamp: time.Now().UTC(),
+			ActorKind: ActorAnonymous,
+			Method:    r.Method,
+			Endpoint:  r.URL.Path,
+			Operation: operation,
+		}}
+		sw := &statusRecorder{ResponseWriter: w}
+		h(sw, r.WithContext(context.WithValue(r.Context(), contextKeyRecorder, recorder)))
+
+		entry := recorder.Entry()
+		entry.Status = sw.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Outcome = OutcomeOf(entry.Status)
+		if entry.Outcome != OutcomeSuccess && entry.Error == "" {
+			entry.Error = truncate(string(sw.body))
+		}
+
+		// The entry is recorded even when the client has gone away or the request timed out. The reply has already
+		// been sent, failing to record the entry can only be reported.
+		ctx, cancel := context.WithTimeout(context.Background(), RecordTimeout)
+		defer cancel()
+		if err := logger.RecordAuditEntry(ctx, entry); err != nil {
+			logrus.Errorf("Unable to record the audit entry of %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockLogger struct {
+	entries []Entry
+	err     error
+	// ctxErr is the error of the context the last entry was recorded with
+	ctxErr error
+}
+
+func (m *mockLogger) RecordAuditEntry(ctx context.Context, entry Entry) error {
+	m.entries = append(m.entries, entry)
+	m.ctxErr = ctx.Err()
+	return m.err
+}
+
+func TestShouldClassifyOutcomes(t *testing.T) {
+	assert.Equal(t, OutcomeSuccess, OutcomeOf(http.StatusOK))
+	assert.Equal(t, OutcomeSuccess, OutcomeOf(http.StatusNoContent))
+	assert.Equal(t, OutcomeDenied, OutcomeOf(http.StatusUnauthorized))
+	assert.Equal(t, OutcomeDenied, OutcomeOf(http.StatusForbidden))
+	assert.Equal(t, OutcomeFailure, OutcomeOf(http.StatusBadRequest))
+	assert.Equal(t, OutcomeFailure, OutcomeOf(http.StatusInternalServerError))
+}
+
+func TestShouldRecordEntryCompletedByHandler(t *testing.T) {
+	logger := &mockLogger{}
+	handler := Middleware(logger, "insert_assets", func(w http.ResponseWriter, r *http.Request) {
+		recorder := FromContext(r.Context())
+		recorder.SetSourceToken("scanner", "ci")
+		recorder.AddAssets(3)
+		recorder.AddAssets(2)
+		recorder.AddRelations(1)
+		w.Write([]byte("ok"))
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("PUT", "/api/graph/assets", nil))
+	assert.Equal(t, "ok", rec.Body.String())
+
+	require.Len(t, logger.entries, 1)
+	entry := logger.entries[0]
+	assert.Equal(t, ActorToken, entry.ActorKind)
+	assert.Equal(t, "scanner", entry.Actor)
+	assert.Equal(t, "ci", entry.Token)
+	assert.Equal(t, "scanner", entry.Source)
+	assert.Equal(t, "PUT", entry.Method)
+	assert.Equal(t, "/api/graph/assets", entry.Endpoint)
+	assert.Equal(t, "insert_assets", entry.Operation)
+	assert.Equal(t, int64(5), entry.Assets)
+	assert.Equal(t, int64(1), entry.Relations)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.Equal(t, OutcomeSuccess, entry.Outcome)
+	assert.Empty(t, entry.Error)
+}
+
+func TestShouldRecordErrorOfFailedOperation(t *testing.T) {
+	logger := &mockLogger{err: errors.New("database is down")}
+	handler := Middleware(logger, "flush_database", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).SetUser("alice")
+		http.Error(w, "Role admin is required", http.StatusForbidden)
+	})
+
+	// Failing to record the entry does not change the reply
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("POST", "/api/admin/flush", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	require.Len(t, logger.entries, 1)
+	assert.Equal(t, ActorUser, logger.entries[0].ActorKind)
+	assert.Equal(t, "alice", logger.entries[0].Actor)
+	assert.Equal(t, OutcomeDenied, logger.entries[0].Outcome)
+	assert.Equal(t, "Role admin is required", logger.entries[0].Error)
+
+	handler = Middleware(logger, "insert_assets", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).SetError(errors.New("Unable to insert assets"))
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("PUT", "/api/graph/assets", nil))
+	require.Len(t, logger.entries, 2)
+	assert.Equal(t, ActorAnonymous, logger.entries[1].ActorKind)
+	assert.Equal(t, OutcomeFailure, logger.entries[1].Outcome)
+	assert.Equal(t, "Unable to insert assets", logger.entries[1].Error)
+}
+
+func TestShouldRecordEntryOfCanceledRequest(t *testing.T) {
+	logger := &mockLogger{}
+	handler := Middleware(logger, "insert_assets", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Request canceled", http.StatusInternalServerError)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler(httptest.NewRecorder(), httptest.NewRequest("PUT", "/api/graph/assets", nil).WithContext(ctx))
+
+	require.Len(t, logger.entries, 1)
+	assert.NoError(t, logger.ctxErr)
+}
+
+func TestShouldIgnoreRecordingWithoutRecorder(t *testing.T) {
+	recorder := FromContext(context.Background())
+	assert.Nil(t, recorder)
+	recorder.SetUser("alice")
+	recorder.AddAssets(1)
+	recorder.SetError(errors.New("error"))
+}
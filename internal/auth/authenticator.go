@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	httpauth "github.com/abbot/go-http-auth"
+	"github.com/clems4ever/go-graphkb/internal/audit"
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Realm is the realm of the basic authentication
+const Realm = "go-graphkb"
+
+// ProxyConfiguration configures the authentication delegated to a reverse proxy
+type ProxyConfiguration struct {
+	// Enabled trusts the user header set by the reverse proxy. The proxy must strip the header from the client
+	// requests otherwise anyone can impersonate any user.
+	Enabled bool `mapstructure:"enabled"`
+	// Header is the header carrying the name of the authenticated user
+	Header string `mapstructure:"header"`
+	// DefaultRole is the role of the users authenticated by the proxy which are not declared in the users
+	DefaultRole Role `mapstructure:"default_role"`
+}
+
+// ErrNotAuthenticated error returned when the request does not carry valid credentials
+var ErrNotAuthenticated = errors.New("not authenticated")
+
+// Authenticator authenticates the users of the web API and checks their role
+type Authenticator struct {
+	users Users
+	roles RoleSet
+	proxy ProxyConfiguration
+	jwt   *JWTValidator
+	basic *httpauth.BasicAuth
+}
+
+// NewAuthenticator create an authenticator of the users. The JWT bearer tokens are not accepted when the validator is
+// nil. The authentication is disabled when there is no user and neither the reverse proxy nor the JWT mode is enabled.
+func NewAuthenticator(users Users, roles RoleSet, proxy ProxyConfiguration, jwt *JWTValidator) (*Authenticator, error) {
+	if proxy.Header == "" {
+		proxy.Header = "X-Forwarded-User"
+	}
+	if proxy.DefaultRole == "" {
+		proxy.DefaultRole = RoleViewer
+	}
+	if err := roles.Check(proxy.DefaultRole); err != nil {
+		return nil, fmt.Errorf("reverse proxy default role: %w", err)
+	}
+
+	a := &Authenticator{users: users, roles: roles, proxy: proxy, jwt: jwt}
+	a.basic = httpauth.NewBasicAuthenticator(Realm, a.secret)
+	return a, nil
+}
+
+// NewAuthenticatorFromConfig create an authenticator from the `auth` options
+func NewAuthenticatorFromConfig() (*Authenticator, error) {
+	roles, err := LoadRoles()
+	if err != nil {
+		return nil, err
+	}
+	users, err := LoadUsers(roles)
+	if err != nil {
+		return nil, err
+	}
+	proxy := ProxyConfiguration{}
+	if err := viper.UnmarshalKey("auth.reverse_proxy", &proxy); err != nil {
+		return nil, fmt.Errorf("unable to read the reverse proxy configuration: %w", err)
+	}
+
+	jwtConfig := JWTConfiguration{}
+	if err := viper.UnmarshalKey("auth.jwt", &jwtConfig); err != nil {
+		return nil, fmt.Errorf("unable to read the JWT configuration: %w", err)
+	}
+	var jwt *JWTValidator
+	if jwtConfig.Enabled() {
+		if jwt, err = NewJWTValidator(jwtConfig, roles); err != nil {
+			return nil, err
+		}
+	}
+	return NewAuthenticator(users, roles, proxy, jwt)
+}
+
+func (a *Authenticator) secret(user, realm string) string {
+	return a.users[user].Password
+}
+
+// Enabled returns true if the requests must be authenticated
+func (a *Authenticator) Enabled() bool {
+	return len(a.users) > 0 || a.proxy.Enabled || a.jwt != nil
+}
+
+func bearerToken(r *http.Request) string {
+	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(s) != 2 || !strings.EqualFold(s[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(s[1])
+}
+
+// Authenticate returns the user authenticated by the reverse proxy, by a JWT bearer token or by basic auth
+func (a *Authenticator) Authenticate(r *http.Request) (User, error) {
+	if a.proxy.Enabled {
+		if name := r.Header.Get(a.proxy.Header); name != "" {
+			if u, ok := a.users[name]; ok {
+				return u, nil
+			}
+			return User{Name: name, Role: a.proxy.DefaultRole}, nil
+		}
+	}
+
+	if a.jwt != nil {
+		if token := bearerToken(r); token != "" {
+			return a.jwt.Validate(r.Context(), token)
+		}
+	}
+
+	name := a.basic.CheckAuth(r)
+	if name == "" {
+		return User{}, ErrNotAuthenticated
+	}
+	return a.users[name], nil
+}
+
+// challenge reply with 401 and the authentication schemes the client can use
+func (a *Authenticator) challenge(w http.ResponseWriter, r *http.Request, err error) {
+	if a.jwt == nil {
+		a.basic.RequireAuth(w, r)
+		return
+	}
+
+	bearer := fmt.Sprintf(`Bearer realm="%s"`, Realm)
+	if errors.Is(err, ErrInvalidToken) {
+		bearer += `, error="invalid_token"`
+	}
+	w.Header().Add("WWW-Authenticate", bearer)
+	if len(a.users) > 0 {
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, Realm))
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// Require serve the requests of the users granted the role with the handler. The other requests are rejected with
+// 401 when the user is not authenticated or 403 when the user is not granted the role. The name of the user and the
+// sources the role is restricted to are put in the context of the request.
+func (a *Authenticator) Require(role Role, h http.HandlerFunc) http.HandlerFunc {
+	if !a.Enabled() {
+		return h
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.Authenticate(r)
+		if err != nil {
+			logrus.Debugf("Request %s %s is not authenticated: %v", r.Method, r.URL.Path, err)
+			a.challenge(w, r, err)
+			return
+		}
+		audit.FromContext(r.Context()).SetUser(user.Name)
+		if !a.roles.Allows(user.Role, role) {
+			logrus.Debugf("User %s with role %s is denied access to %s %s requiring role %s",
+				user.Name, user.Role, r.Method, r.URL.Path, role)
+			http.Error(w, fmt.Sprintf("Role %s is required", role), http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), kbcontext.ContextKeyUser, user.Name)
+		if sources := a.roles.AllowedSources(user.Role); sources != nil {
+			ctx = context.WithValue(ctx, kbcontext.ContextKeyAllowedSources, sources)
+		}
+		h(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldOrderRoles(t *testing.T) {
+	assert.True(t, RoleAdmin.Allows(RoleViewer))
+	assert.True(t, RoleAnalyst.Allows(RoleAnalyst))
+	assert.False(t, RoleViewer.Allows(RoleAnalyst))
+	assert.False(t, Role("guest").Allows(RoleViewer))
+
+	_, err := ParseRole("guest")
+	assert.ErrorIs(t, err, ErrInvalidRole)
+}
+
+func newTestRoles(t *testing.T) RoleSet {
+	roles, err := NewRoleSet(map[string]RoleDefinition{
+		"hr-analyst": {Base: RoleAnalyst, Sources: []string{"hr-directory"}},
+	})
+	require.NoError(t, err)
+	return roles
+}
+
+func TestShouldRejectInvalidUsers(t *testing.T) {
+	_, err := NewUsers([]User{{Name: "alice", Role: "guest"}}, newTestRoles(t))
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	_, err = NewUsers([]User{{Name: "alice", Role: RoleViewer}, {Name: "alice", Role: RoleAdmin}}, newTestRoles(t))
+	assert.Error(t, err)
+}
+
+func TestShouldDefineRolesRestrictedToSources(t *testing.T) {
+	roles := newTestRoles(t)
+	assert.True(t, roles.Allows("hr-analyst", RoleAnalyst))
+	assert.False(t, roles.Allows("hr-analyst", RoleAdmin))
+	assert.Equal(t, []string{"hr-directory"}, roles.AllowedSources("hr-analyst"))
+	assert.Nil(t, roles.AllowedSources(RoleAdmin))
+	assert.ErrorIs(t, roles.Check("guest"), ErrInvalidRole)
+
+	roles, err := NewRoleSet(map[string]RoleDefinition{"Viewer": {Sources: []string{"scanner"}}})
+	require.NoError(t, err)
+	assert.True(t, roles.Allows(RoleViewer, RoleViewer))
+	assert.Equal(t, []string{"scanner"}, roles.AllowedSources(RoleViewer))
+
+	_, err = NewRoleSet(map[string]RoleDefinition{"viewer": {Base: RoleAdmin}})
+	assert.Error(t, err)
+	_, err = NewRoleSet(map[string]RoleDefinition{"auditor": {Base: "guest"}})
+	assert.ErrorIs(t, err, ErrInvalidRole)
+}
+
+func newTestAuthenticator(t *testing.T, proxy ProxyConfiguration) *Authenticator {
+	roles := newTestRoles(t)
+	users, err := NewUsers([]User{
+		{Name: "alice", Password: hashLegacyPassword("alice-password"), Role: RoleViewer},
+		{Name: "bob", Password: hashLegacyPassword("bob-password"), Role: RoleAdmin},
+		{Name: "carol", Password: hashLegacyPassword("carol-password"), Role: "hr-analyst"},
+	}, roles)
+	require.NoError(t, err)
+	a, err := NewAuthenticator(users, roles, proxy, nil)
+	require.NoError(t, err)
+	return a
+}
+
+func serve(a *Authenticator, role Role, req *http.Request) (int, string) {
+	user := ""
+	rec := httptest.NewRecorder()
+	a.Require(role, func(w http.ResponseWriter, r *http.Request) {
+		user = kbcontext.User(r.Context())
+	})(rec, req)
+	return rec.Code, user
+}
+
+func TestShouldCheckRoleOfBasicAuthUser(t *testing.T) {
+	a := newTestAuthenticator(t, ProxyConfiguration{})
+
+	req := httptest.NewRequest("GET", "/api/schema", nil)
+	code, _ := serve(a, RoleViewer, req)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	req.SetBasicAuth("alice", "wrong")
+	code, _ = serve(a, RoleViewer, req)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	req.SetBasicAuth("alice", "alice-password")
+	code, user := serve(a, RoleViewer, req)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alice", user)
+
+	code, _ = serve(a, RoleAdmin, req)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	req.SetBasicAuth("bob", "bob-password")
+	code, _ = serve(a, RoleAdmin, req)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestShouldTrustProxyUserOnlyWhenEnabled(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/schema", nil)
+	req.Header.Set("X-Forwarded-User", "bob")
+
+	code, _ := serve(newTestAuthenticator(t, ProxyConfiguration{}), RoleViewer, req)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	a := newTestAuthenticator(t, ProxyConfiguration{Enabled: true})
+	code, user := serve(a, RoleAdmin, req)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "bob", user)
+
+	req.Header.Set("X-Forwarded-User", "dave")
+	code, _ = serve(a, RoleViewer, req)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = serve(a, RoleAnalyst, req)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestShouldNotAuthenticateWhenNoUserIsConfigured(t *testing.T) {
+	a, err := NewAuthenticator(Users{}, newTestRoles(t), ProxyConfiguration{}, nil)
+	require.NoError(t, err)
+	assert.False(t, a.Enabled())
+
+	code, _ := serve(a, RoleAdmin, httptest.NewRequest("POST", "/api/admin/flush", nil))
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestShouldPutAllowedSourcesInContext(t *testing.T) {
+	a := newTestAuthenticator(t, ProxyConfiguration{})
+
+	var sources []string
+	handler := a.Require(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		sources = kbcontext.AllowedSources(r.Context())
+	})
+
+	req := httptest.NewRequest("POST", "/api/query", nil)
+	req.SetBasicAuth("carol", "carol-password")
+	handler(httptest.NewRecorder(), req)
+	assert.Equal(t, []string{"hr-directory"}, sources)
+
+	req.SetBasicAuth("bob", "bob-password")
+	handler(httptest.NewRecorder(), req)
+	assert.Nil(t, sources)
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jwksMinRefreshInterval is the minimum time between two reloads of the key set triggered by an unknown key
+const jwksMinRefreshInterval = time.Minute
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// ParseJWKS parse a JSON Web Key Set and returns the signature keys indexed by key ID. The keys of unsupported types
+// are ignored.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("unable to parse the key set: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("the key set does not contain any supported signature key")
+	}
+	return keys, nil
+}
+
+// keySet is a JSON Web Key Set reloaded periodically and when a token is signed by an unknown key
+type keySet struct {
+	fetch           func(ctx context.Context) ([]byte, error)
+	refreshInterval time.Duration
+
+	mutex     sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+
+	now func() time.Time
+}
+
+func newFileKeySet(path string) *keySet {
+	return &keySet{now: time.Now, fetch: func(context.Context) ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}}
+}
+
+func newURLKeySet(url string, refreshInterval time.Duration) *keySet {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &keySet{now: time.Now, refreshInterval: refreshInterval, fetch: func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", res.Status)
+		}
+		return ioutil.ReadAll(res.Body)
+	}}
+}
+
+// refresh reload the key set, the lock must be held
+func (ks *keySet) refresh(ctx context.Context) error {
+	ks.fetchedAt = ks.now()
+	data, err := ks.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to fetch the key set: %w", err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	return nil
+}
+
+// Load read the key set
+func (ks *keySet) Load(ctx context.Context) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	return ks.refresh(ctx)
+}
+
+// Key returns the key with the ID or the only key of the set when the token does not tell the key ID
+func (ks *keySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	now := ks.now()
+	expired := ks.keys == nil || (ks.refreshInterval > 0 && now.Sub(ks.fetchedAt) > ks.refreshInterval)
+	if _, ok := ks.lookup(kid); !ok && now.Sub(ks.fetchedAt) > jwksMinRefreshInterval {
+		expired = true
+	}
+	if expired {
+		if err := ks.refresh(ctx); err != nil {
+			if ks.keys == nil {
+				return nil, err
+			}
+			logrus.Warnf("Unable to refresh the JSON Web Key Set, the previous keys are kept: %v", err)
+		}
+	}
+
+	key, ok := ks.lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken error returned when a bearer token cannot be trusted
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWTConfiguration configures the authentication by JWT bearer tokens issued by an identity provider
+type JWTConfiguration struct {
+	// JWKSFile is the path to the JSON Web Key Set used to verify the signatures
+	JWKSFile string `mapstructure:"jwks_file"`
+	// JWKSURL is the URL of the JSON Web Key Set used to verify the signatures when no file is given
+	JWKSURL string `mapstructure:"jwks_url"`
+	// JWKSRefreshInterval is the time after which the key set is fetched again from the URL
+	JWKSRefreshInterval time.Duration `mapstructure:"jwks_refresh_interval"`
+
+	// Issuer and Audience are the expected `iss` and `aud` claims. Both are required so that the tokens issued by the
+	// identity provider for other applications are refused.
+	Issuer   string `mapstructure:"issuer"`
+	Audience string `mapstructure:"audience"`
+	// Leeway is the tolerated clock skew when checking the expiry of the tokens
+	Leeway time.Duration `mapstructure:"leeway"`
+
+	// UserClaim is the claim holding the name of the user
+	UserClaim string `mapstructure:"user_claim"`
+	// RolesClaim is the claim holding the roles or groups of the user, nested claims are separated by dots
+	RolesClaim string `mapstructure:"roles_claim"`
+	// RoleMapping maps the values of the roles claim to roles, the values named after a role are mapped to it
+	RoleMapping map[string]Role `mapstructure:"role_mapping"`
+	// DefaultRole is the role of the users with no mapped role. Those users are denied access when it is empty.
+	DefaultRole Role `mapstructure:"default_role"`
+}
+
+// Enabled returns true if a key set is configured
+func (c JWTConfiguration) Enabled() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// JWTValidator validates the JWT bearer tokens and derives the user from their claims
+type JWTValidator struct {
+	config JWTConfiguration
+	roles  RoleSet
+	keys   *keySet
+	now    func() time.Time
+}
+
+// NewJWTValidator create a validator of the tokens signed by the keys of the configured key set
+func NewJWTValidator(config JWTConfiguration, roles RoleSet) (*JWTValidator, error) {
+	if config.UserClaim == "" {
+		config.UserClaim = "sub"
+	}
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+	if config.JWKSRefreshInterval == 0 {
+		config.JWKSRefreshInterval = time.Hour
+	}
+	if config.Leeway == 0 {
+		config.Leeway = 30 * time.Second
+	}
+	if config.DefaultRole != "" {
+		if err := roles.Check(config.DefaultRole); err != nil {
+			return nil, fmt.Errorf("JWT default role: %w", err)
+		}
+	}
+	for value, role := range config.RoleMapping {
+		if err := roles.Check(role); err != nil {
+			return nil, fmt.Errorf("JWT role mapping of %s: %w", value, err)
+		}
+	}
+
+	if config.Issuer == "" {
+		return nil, fmt.Errorf("JWT issuer is required")
+	}
+	if config.Audience == "" {
+		return nil, fmt.Errorf("JWT audience is required")
+	}
+
+	v := &JWTValidator{config: config, roles: roles, now: time.Now}
+	switch {
+	case config.JWKSFile != "":
+		v.keys = newFileKeySet(config.JWKSFile)
+	case config.JWKSURL != "":
+		v.keys = newURLKeySet(config.JWKSURL, config.JWKSRefreshInterval)
+	default:
+		return nil, fmt.Errorf("either a JWKS file or a JWKS URL is required")
+	}
+	// Fail early on a misconfigured key file, a key set served by an unavailable identity provider is fetched later
+	if config.JWKSFile != "" {
+		if err := v.keys.Load(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match the RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm %s does not match the EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("signature verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", key)
+}
+
+// claimValues returns the values of a claim given as a string, a list of strings or a space separated string
+func claimValues(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		values := []string{}
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// lookupClaim returns the claim at the dotted path
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+	return value
+}
+
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	claim, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := claim.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a date", name)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a date", name)
+	}
+	return time.Unix(int64(f), 0), true, nil
+}
+
+func (v *JWTValidator) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("claim exp is required")
+	}
+	if now.After(exp.Add(v.config.Leeway)) {
+		return fmt.Errorf("token expired at %s", exp.Format(time.RFC3339))
+	}
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.config.Leeway).Before(nbf) {
+		return fmt.Errorf("token is not valid before %s", nbf.Format(time.RFC3339))
+	}
+
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+	found := false
+	for _, aud := range claimValues(claims["aud"]) {
+		found = found || aud == v.config.Audience
+	}
+	if !found {
+		return fmt.Errorf("token is not issued for audience %q", v.config.Audience)
+	}
+	return nil
+}
+
+// role returns the most privileged role mapped from the roles claim
+func (v *JWTValidator) role(claims map[string]interface{}) Role {
+	var role Role
+	for _, value := range claimValues(lookupClaim(claims, v.config.RolesClaim)) {
+		mapped, ok := v.config.RoleMapping[value]
+		if !ok {
+			// The keys of the mapping are lowercased when read from the configuration
+			mapped, ok = v.config.RoleMapping[strings.ToLower(value)]
+		}
+		if !ok {
+			if v.roles.Check(Role(value)) != nil {
+				continue
+			}
+			mapped = Role(value)
+		}
+		current, _ := v.roles.definition(role)
+		if role == "" || v.roles.Allows(mapped, current.Base) {
+			role = mapped
+		}
+	}
+	if role == "" {
+		role = v.config.DefaultRole
+	}
+	return role
+}
+
+// Validate verifies the signature and the claims of the token and returns the user it authenticates. The role of the
+// user is empty when none is mapped from the claims.
+func (v *JWTValidator) Validate(ctx context.Context, token string) (User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return User{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return User{}, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	header := jwtHeader{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return User{}, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return User{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return User{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return User{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return User{}, fmt.Errorf("%w: malformed payload", ErrInvalidToken)
+	}
+	claims := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return User{}, fmt.Errorf("%w: malformed payload", ErrInvalidToken)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return User{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	name, _ := lookupClaim(claims, v.config.UserClaim).(string)
+	if name == "" {
+		return User{}, fmt.Errorf("%w: claim %s is missing", ErrInvalidToken, v.config.UserClaim)
+	}
+	return User{Name: name, Role: v.role(claims)}, nil
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	jwks []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-key", "use": "sig",
+			"n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-key", "crv": "P-256",
+			"x": b64.EncodeToString(ecKey.X.Bytes()), "y": b64.EncodeToString(ecKey.Y.Bytes())},
+	}})
+	require.NoError(t, err)
+	return testKeys{rsa: rsaKey, ec: ecKey, jwks: jwks}
+}
+
+func (k testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+func newTestValidator(t *testing.T, keys testKeys, config JWTConfiguration) *JWTValidator {
+	config.Issuer = "https://sso.example.com"
+	config.Audience = "graphkb"
+	config.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, ioutil.WriteFile(config.JWKSFile, keys.jwks, 0600))
+	v, err := NewJWTValidator(config, newTestRoles(t))
+	require.NoError(t, err)
+	return v
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "alice",
+		"iss":   "https://sso.example.com",
+		"aud":   []string{"graphkb", "other"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"graphkb-analysts", "unrelated"},
+	}
+}
+
+func TestShouldValidateSignedTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestValidator(t, keys, JWTConfiguration{
+		RoleMapping: map[string]Role{"graphkb-analysts": RoleAnalyst},
+	})
+
+	for _, token := range []string{
+		keys.sign(t, "RS256", "rsa-key", validClaims()),
+		keys.sign(t, "ES256", "ec-key", validClaims()),
+	} {
+		user, err := v.Validate(context.Background(), token)
+		require.NoError(t, err)
+		assert.Equal(t, User{Name: "alice", Role: RoleAnalyst}, user)
+	}
+}
+
+func TestShouldRejectInvalidTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestValidator(t, keys, JWTConfiguration{})
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	valid := keys.sign(t, "RS256", "rsa-key", validClaims())
+
+	tokens := map[string]string{
+		"expired":         keys.sign(t, "RS256", "rsa-key", with("exp", time.Now().Add(-time.Hour).Unix())),
+		"no expiry":       keys.sign(t, "RS256", "rsa-key", with("exp", nil)),
+		"not yet valid":   keys.sign(t, "RS256", "rsa-key", with("nbf", time.Now().Add(time.Hour).Unix())),
+		"wrong issuer":    keys.sign(t, "RS256", "rsa-key", with("iss", "https://evil.example.com")),
+		"wrong audience":  keys.sign(t, "RS256", "rsa-key", with("aud", "other")),
+		"unknown key":     keys.sign(t, "RS256", "other-key", validClaims()),
+		"key mismatch":    keys.sign(t, "ES256", "rsa-key", validClaims()),
+		"tampered":        valid[:len(valid)-4] + "AAAA",
+		"unsigned":        b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"alice"}`)) + ".",
+		"malformed":       "not-a-token",
+		"missing subject": keys.sign(t, "RS256", "rsa-key", with("sub", nil)),
+	}
+	for name, token := range tokens {
+		_, err := v.Validate(context.Background(), token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
+
+func TestShouldMapClaimsToRoles(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestValidator(t, keys, JWTConfiguration{
+		UserClaim:   "preferred_username",
+		RolesClaim:  "realm_access.roles",
+		RoleMapping: map[string]Role{"ops": RoleAdmin},
+	})
+
+	claims := map[string]interface{}{
+		"preferred_username": "bob",
+		"iss":                "https://sso.example.com",
+		"aud":                "graphkb",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"realm_access":       map[string]interface{}{"roles": []string{"viewer", "ops"}},
+	}
+	user, err := v.Validate(context.Background(), keys.sign(t, "RS256", "rsa-key", claims))
+	require.NoError(t, err)
+	assert.Equal(t, User{Name: "bob", Role: RoleAdmin}, user)
+
+	claims["realm_access"] = map[string]interface{}{"roles": []string{"hr-analyst"}}
+	user, err = v.Validate(context.Background(), keys.sign(t, "RS256", "rsa-key", claims))
+	require.NoError(t, err)
+	assert.Equal(t, Role("hr-analyst"), user.Role)
+
+	claims["realm_access"] = map[string]interface{}{"roles": "unrelated"}
+	user, err = v.Validate(context.Background(), keys.sign(t, "RS256", "rsa-key", claims))
+	require.NoError(t, err)
+	assert.Equal(t, Role(""), user.Role)
+}
+
+func TestShouldAuthenticateBearerTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestValidator(t, keys, JWTConfiguration{DefaultRole: RoleViewer})
+	a, err := NewAuthenticator(Users{}, newTestRoles(t), ProxyConfiguration{}, v)
+	require.NoError(t, err)
+
+	user := ""
+	handler := a.Require(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		user = kbcontext.User(r.Context())
+	})
+
+	req := httptest.NewRequest("POST", "/api/query", nil)
+	req.Header.Set("Authorization", "Bearer "+keys.sign(t, "ES256", "ec-key", validClaims()))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "alice", user)
+
+	req.Header.Set("Authorization", "Bearer invalid")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
+
+func TestShouldFetchKeySetFromURL(t *testing.T) {
+	keys := newTestKeys(t)
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(keys.jwks)
+	}))
+	defer server.Close()
+
+	v, err := NewJWTValidator(JWTConfiguration{
+		JWKSURL:     server.URL,
+		Issuer:      "https://sso.example.com",
+		Audience:    "graphkb",
+		DefaultRole: RoleViewer,
+	}, newTestRoles(t))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = v.Validate(context.Background(), keys.sign(t, "RS256", "rsa-key", validClaims()))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, fetches)
+}
+
+func TestShouldRequireIssuerAndAudience(t *testing.T) {
+	config := JWTConfiguration{JWKSURL: "https://sso.example.com/certs", Issuer: "https://sso.example.com"}
+	_, err := NewJWTValidator(config, newTestRoles(t))
+	assert.EqualError(t, err, "JWT audience is required")
+
+	config = JWTConfiguration{JWKSURL: "https://sso.example.com/certs", Audience: "graphkb"}
+	_, err = NewJWTValidator(config, newTestRoles(t))
+	assert.EqualError(t, err, "JWT issuer is required")
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/clems4ever/go-graphkb/internal/sources"
+)
+
+// Role is the set of permissions granted to a user, each role is granted the permissions of the roles below it
+type Role string
+
+const (
+	// RoleViewer can read the schema and query the graph
+	RoleViewer Role = "viewer"
+	// RoleAnalyst can also curate the ontology and the entity resolution
+	RoleAnalyst Role = "analyst"
+	// RoleAdmin can also manage the sources and flush the database
+	RoleAdmin Role = "admin"
+)
+
+// Roles are the existing roles from the least to the most privileged
+var Roles = []Role{RoleViewer, RoleAnalyst, RoleAdmin}
+
+// ErrInvalidRole error returned when a role does not exist
+var ErrInvalidRole = errors.New("invalid role")
+
+// ParseRole parse the name of a role
+func ParseRole(name string) (Role, error) {
+	for _, r := range Roles {
+		if string(r) == name {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q: must be one of %v", ErrInvalidRole, name, Roles)
+}
+
+func (r Role) level() int {
+	for i, role := range Roles {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Allows returns true if the role is granted the permissions of the required role
+func (r Role) Allows(required Role) bool {
+	return r.level() >= 0 && r.level() >= required.level()
+}
+
+// RoleDefinition defines a role granted the permissions of a built-in role and possibly restricted to some sources
+type RoleDefinition struct {
+	// Base is the built-in role whose permissions are granted
+	Base Role `mapstructure:"base"`
+	// Sources are the only sources whose assets and relations can be queried, all of them can be queried when empty
+	Sources []string `mapstructure:"sources"`
+}
+
+// RoleSet is the set of the built-in roles and of the roles defined in the configuration indexed by name
+type RoleSet map[Role]RoleDefinition
+
+// NewRoleSet create the set of the built-in roles along with the given definitions. The built-in roles can be
+// redefined to restrict them to some sources.
+func NewRoleSet(definitions map[string]RoleDefinition) (RoleSet, error) {
+	roles := make(RoleSet)
+	for _, r := range Roles {
+		roles[r] = RoleDefinition{Base: r}
+	}
+
+	for name, definition := range definitions {
+		role := Role(strings.ToLower(name))
+		if _, err := ParseRole(string(role)); err == nil {
+			if definition.Base != "" && definition.Base != role {
+				return nil, fmt.Errorf("role %s is built-in and cannot be based on role %s", role, definition.Base)
+			}
+			definition.Base = role
+		} else if _, err := ParseRole(string(definition.Base)); err != nil {
+			return nil, fmt.Errorf("base of role %s: %w", role, err)
+		}
+		for _, s := range definition.Sources {
+			if err := sources.CheckName(s); err != nil {
+				return nil, fmt.Errorf("sources of role %s: %w", role, err)
+			}
+		}
+		roles[role] = definition
+	}
+	return roles, nil
+}
+
+func (rs RoleSet) definition(role Role) (RoleDefinition, bool) {
+	d, ok := rs[Role(strings.ToLower(string(role)))]
+	return d, ok
+}
+
+// Check returns an error if the role is not defined
+func (rs RoleSet) Check(role Role) error {
+	if _, ok := rs.definition(role); !ok {
+		return fmt.Errorf("%w %q: must be a built-in role among %v or be defined in the roles", ErrInvalidRole, role, Roles)
+	}
+	return nil
+}
+
+// Allows returns true if the role is granted the permissions of the required built-in role
+func (rs RoleSet) Allows(role, required Role) bool {
+	d, ok := rs.definition(role)
+	return ok && d.Base.Allows(required)
+}
+
+// AllowedSources returns the only sources the role can query or nil when the role is not restricted
+func (rs RoleSet) AllowedSources(role Role) []string {
+	d, ok := rs.definition(role)
+	if !ok || len(d.Sources) == 0 {
+		return nil
+	}
+	return d.Sources
+}
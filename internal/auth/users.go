@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// LegacyAdminUser is the name of the admin user authenticated by the `password` option
+const LegacyAdminUser = "admin"
+
+// User is an account allowed to use the web API
+type User struct {
+	Name string `mapstructure:"name"`
+	// Password is the hash of the password in htpasswd format (bcrypt, {SHA} or MD5 crypt). Users without password
+	// can only be authenticated by the reverse proxy.
+	Password string `mapstructure:"password"`
+	Role     Role   `mapstructure:"role"`
+}
+
+// Users is the set of accounts indexed by name
+type Users map[string]User
+
+// NewUsers index the users by name and check their roles are defined
+func NewUsers(users []User, roles RoleSet) (Users, error) {
+	indexed := make(Users)
+	for _, u := range users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("user name must not be empty")
+		}
+		if _, ok := indexed[u.Name]; ok {
+			return nil, fmt.Errorf("user %s is declared twice", u.Name)
+		}
+		if err := roles.Check(u.Role); err != nil {
+			return nil, fmt.Errorf("user %s: %w", u.Name, err)
+		}
+		indexed[u.Name] = u
+	}
+	return indexed, nil
+}
+
+// hashLegacyPassword hash the clear password of the `password` option in the htpasswd SHA format
+func hashLegacyPassword(password string) string {
+	h := sha1.Sum([]byte(password))
+	return "{SHA}" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// LoadRoles read the roles defined in the `auth.roles` option
+func LoadRoles() (RoleSet, error) {
+	definitions := make(map[string]RoleDefinition)
+	if err := viper.UnmarshalKey("auth.roles", &definitions); err != nil {
+		return nil, fmt.Errorf("unable to read the roles: %w", err)
+	}
+	return NewRoleSet(definitions)
+}
+
+// LoadUsers read the users from the `auth.users` option. The `password` option is still honored and declares the
+// admin user.
+func LoadUsers(roles RoleSet) (Users, error) {
+	users := []User{}
+	if err := viper.UnmarshalKey("auth.users", &users); err != nil {
+		return nil, fmt.Errorf("unable to read the users: %w", err)
+	}
+	if password := viper.GetString("password"); password != "" {
+		users = append(users, User{Name: LegacyAdminUser, Password: hashLegacyPassword(password), Role: RoleAdmin})
+	}
+	return NewUsers(users, roles)
+}
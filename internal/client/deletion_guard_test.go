@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newGuardedTransaction(url string, guard knowledge.DeletionGuard) *Transaction {
+	// The graph currently stored contains two assets which are not bound anymore
+	g := knowledge.NewGraph()
+	g.AddAsset("ip", "10.0.0.1")
+	g.AddAsset("ip", "10.0.0.2")
+	g.Clean()
+
+	return &Transaction{
+		client:          NewGraphClient(url, "token", "", "", false),
+		graph:           g,
+		binder:          knowledge.NewGraphBinder(g),
+		parallelization: 1,
+		chunkSize:       10,
+		deletionGuard:   guard,
+		onSuccess:       func(context.Context, *knowledge.Graph, int64) {},
+		onError:         func(error) {},
+	}
+}
+
+func TestShouldRefuseCommitRemovingTooManyEntities(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	tx := newGuardedTransaction(server.URL, knowledge.DeletionGuard{MaxRemovedRatio: 0.5})
+	err := tx.Commit()
+
+	var guardErr *DeletionGuardError
+	require.True(t, errors.As(err, &guardErr))
+	assert.False(t, guardErr.ServerSide)
+	assert.ErrorIs(t, err, knowledge.ErrDeletionGuard)
+	assert.Equal(t, 0, calls)
+}
+
+func TestShouldForceDeletionOnClientAndServer(t *testing.T) {
+	forced := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forced = forced && r.Header.Get(utils.XForceDeletionHeader) == "true"
+	}))
+	defer server.Close()
+
+	tx := newGuardedTransaction(server.URL, knowledge.DeletionGuard{MaxRemovedRatio: 0.5})
+	tx.ForceDeletion()
+
+	require.NoError(t, tx.Commit())
+	assert.True(t, forced)
+}
+
+func TestShouldSurfaceServerDeletionGuardAsTypedError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			w.Header().Set(utils.XErrorCodeHeader, utils.DeletionGuardErrorCode)
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("deletion guard triggered"))
+		}
+	}))
+	defer server.Close()
+
+	tx := newGuardedTransaction(server.URL, knowledge.DeletionGuard{})
+	err := tx.Commit()
+
+	var guardErr *DeletionGuardError
+	require.True(t, errors.As(err, &guardErr))
+	assert.True(t, guardErr.ServerSide)
+	assert.Equal(t, "deletion guard triggered", guardErr.Message)
+}
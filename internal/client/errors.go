@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrUnauthorized error returned when the API refused the auth token or the credentials
+	ErrUnauthorized = errors.New("Unauthorized access. Check your auth token")
+	// ErrForbidden error returned when the API authenticated the caller but denied it the requested operation
+	ErrForbidden = errors.New("Forbidden access. Check the permissions of your auth token")
+	// ErrTooManyRequests error representing too many requests to the API
+	ErrTooManyRequests = errors.New("Too Many Requests")
+	// ErrSchemaViolation error returned when the API refused assets or relations not matching the schema
+	ErrSchemaViolation = errors.New("Schema violation")
+	// ErrServerUnavailable error returned when the API failed or is temporarily unable to handle the request
+	ErrServerUnavailable = errors.New("Server unavailable")
+)
+
+// APIError is an error response returned by the API. It matches one of ErrUnauthorized, ErrForbidden, ErrTooManyRequests,
+// ErrSchemaViolation, ErrServerUnavailable, knowledge.ErrRevisionTooOld or knowledge.ErrQuotaExceeded with errors.Is
+// depending on the status code.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	// RetryAfter is the delay requested by the server in the Retry-After header before retrying, 0 if none
+	RetryAfter time.Duration
+
+	kind error
+}
+
+func (e *APIError) Error() string {
+	if e.kind != nil {
+		return fmt.Sprintf("%v: HTTP status %s: %s", e.kind, e.Status, e.Body)
+	}
+	return fmt.Sprintf("Unexpected HTTP status %d with content %s: %s", e.StatusCode, e.Status, e.Body)
+}
+
+// Unwrap returns the class of the error
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// classifyResponse returns the class of error matching the response of the API
+func classifyResponse(res *http.Response) error {
+	switch {
+	case res.StatusCode == http.StatusForbidden && res.Header.Get(utils.XErrorCodeHeader) == utils.QuotaExceededErrorCode:
+		return knowledge.ErrQuotaExceeded
+	case res.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case res.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case res.StatusCode == http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	case res.StatusCode == http.StatusGone:
+		return knowledge.ErrRevisionTooOld
+	case res.StatusCode == http.StatusBadRequest && res.Header.Get(utils.XErrorCodeHeader) == utils.SchemaViolationErrorCode:
+		return ErrSchemaViolation
+	case res.StatusCode >= http.StatusInternalServerError:
+		return ErrServerUnavailable
+	}
+	return nil
+}
+
+// parseRetryAfter parses the Retry-After header expressed either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// checkResponse returns nil if the request succeeded or the typed error matching the response otherwise
+func checkResponse(res *http.Response) error {
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	return handleUnexpectedResponse(res)
+}
+
+func handleUnexpectedResponse(res *http.Response) error {
+	bodyBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		logrus.Errorf("Unable to read error payload: %v", err)
+	}
+	bodyString := string(bodyBytes)
+	if res.Header.Get(utils.XErrorCodeHeader) == utils.DeletionGuardErrorCode {
+		return &DeletionGuardError{Message: bodyString, ServerSide: true}
+	}
+	return &APIError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Body:       bodyString,
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+		kind:       classifyResponse(res),
+	}
+}
+
+// IsRetryable returns true if the request can be sent again later with a chance of success, i.e., the API was
+// overloaded or unavailable or the connection to it was refused, reset or timed out.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrTooManyRequests) || errors.Is(err, ErrServerUnavailable) {
+		return true
+	}
+	// The certificates are not going to be fixed by retrying
+	if isCertificateError(err) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isCertificateError returns true if the error is due to the certificate of the server or of the client
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCertificate x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var recordHeader tls.RecordHeaderError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &invalidCertificate) || errors.As(err, &hostname) ||
+		errors.As(err, &recordHeader)
+}
+
+// DeletionGuardError is returned when a commit is refused because it would remove too many assets and relations.
+// Use ForceDeletion on the transaction to commit anyway.
+type DeletionGuardError struct {
+	Message string
+	// ServerSide is true when the guard of the server refused the update, false when it was the guard of the client
+	ServerSide bool
+}
+
+func (e *DeletionGuardError) Error() string {
+	return e.Message
+}
+
+// Unwrap makes the error match knowledge.ErrDeletionGuard
+func (e *DeletionGuardError) Unwrap() error {
+	return knowledge.ErrDeletionGuard
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -19,9 +21,19 @@ type GraphAPI struct {
 
 	options GraphAPIOptions
 
+	// The on-disk copy of the last committed graph, nil if disabled
+	cache *graphCache
+
 	currentGraph *knowledge.Graph
 	// Stores the date after which the graph will be considered stale
 	currentGraphStaleAfter time.Time
+
+	// The revision of the graph on the server matching the current graph, if known
+	currentRevision      int64
+	currentRevisionKnown bool
+
+	// Whether the expected update interval has been declared to the server
+	intervalDeclared bool
 }
 
 // GraphAPIOptions options to pass to build graph API
@@ -30,12 +42,22 @@ type GraphAPIOptions struct {
 	URL string
 	// Auth token for this data source.
 	AuthToken string
+	// The name of the data source. It names the file of the graph cache and defaults to the subject of the client
+	// certificate, it is required to persist the graph when no client certificate is used. The data sources sharing a
+	// cache directory must have distinct names.
+	SourceName string
 
 	BasicAuthUsername string
 	BasicAuthPassword string
 
 	// Skip verifying the certificate when using https
 	SkipVerify bool
+	// The PEM bundle of the CAs verifying the certificate of the server instead of the system CAs
+	CAFile string
+	// The PEM client certificate and key authenticating the data source with mutual TLS. The auth token is not
+	// required when the server maps the certificate to the data source.
+	ClientCertFile string
+	ClientKeyFile  string
 
 	// The level of parallelization for streaming updates, i.e., number of HTTP requests sent in parallel.
 	Parallelization int
@@ -43,108 +65,318 @@ type GraphAPIOptions struct {
 	// The size of a chunk of updates, i.e., number of assets or relations sent in one HTTP request to the streaming API.
 	ChunkSize int
 
-	// Max number of retries before giving up (default is 10)
+	// Max number of retries of a request failing with a retryable error, i.e., the API is overloaded or unavailable,
+	// before giving up (default is 10)
 	MaxRetries int
-	// The base delay between retries (default is 5 seconds). This delay is multiplied by the backoff factor.
+	// The base delay between retries (default is 5 seconds). This delay is multiplied by the backoff factor at every
+	// retry and jittered. A delay requested by the server with the Retry-After header takes precedence.
 	RetryDelay time.Duration
 
 	// The backoff factor (default is 1.01)
 	RetryBackoffFactor float64
 
+	// The maximum delay between two retries (default is 2 minutes)
+	MaxRetryDelay time.Duration
+
 	// The API stores the lastly pushed graph in memory to avoid fetching the entire data at every run which can be heavy on
 	// DB if importers run very incrementally. The anti entropy duration is a duration before forcing a synchronization against
-	// the server even though the graph is still stored in memory.
+	// the server even though the graph is still stored in memory. The synchronization compares the checksums of the
+	// graph split in buckets and only reads the buckets which differ (default is 24 hours).
 	AntiEntropyDuration time.Duration
+
+	// The number of buckets the graph is split in when synchronizing it against the server (default is 1024)
+	SyncBuckets int
+
+	// The directory where the last committed graph is persisted so that it survives restarts of the data source.
+	// The persisted graph is validated against the checksums provided by the server before being used.
+	CacheDir string
+
+	// Stage the updates of a transaction on the server and apply them atomically on commit so that a failure
+	// in the middle of the upload never leaves a partially updated graph.
+	AtomicCommit bool
+
+	// Send the assets and relations in a compact binary format instead of JSON
+	BinaryWireFormat bool
+
+	// Compress the bodies of the requests with gzip
+	Compression bool
+
+	// Refuse to commit transactions removing too many assets and relations from the graph. The server may also
+	// enforce its own guard.
+	DeletionGuard knowledge.DeletionGuard
+
+	// The interval the data source updates its graph at. It is declared to the server when the first transaction is
+	// created so that the server reports the data source as stale when it does not update its graph for longer.
+	ExpectedUpdateInterval time.Duration
 }
 
-// NewGraphAPI create an emitter of graph
-func NewGraphAPI(options GraphAPIOptions) *GraphAPI {
+// ErrMissingSourceName is returned when the graph cache cannot be named after the data source
+var ErrMissingSourceName = errors.New("The name of the source is required to persist its graph when no client certificate identifies it")
+
+// NewGraphAPI create an emitter of graph. The graph can only be persisted when the data source is identified by its
+// name or by its client certificate, the sources persisting their graph in the same directory would share it
+// otherwise.
+func NewGraphAPI(options GraphAPIOptions) (*GraphAPI, error) {
+	tlsConfig, err := newTLSConfig(options.SkipVerify, options.CAFile, options.ClientCertFile, options.ClientKeyFile)
+
+	var cache *graphCache
+	if options.CacheDir != "" {
+		// The credentials are not part of the name of the cache so that rotating them does not drop the cache
+		identity := options.SourceName
+		if identity == "" && err == nil && len(tlsConfig.Certificates) > 0 {
+			identity = certificateIdentity(tlsConfig.Certificates[0])
+		}
+		if identity == "" {
+			return nil, ErrMissingSourceName
+		}
+		cache = newGraphCache(options.CacheDir, options.URL, identity)
+	}
+
+	client := NewGraphClientWithTLS(
+		options.URL,
+		options.AuthToken,
+		options.BasicAuthUsername,
+		options.BasicAuthPassword,
+		tlsConfig,
+	)
+	// The requests fail with the error so that a misconfigured data source does not silently skip its updates
+	client.configErr = err
+	client.binary = options.BinaryWireFormat
+	client.compress = options.Compression
+
 	return &GraphAPI{
-		client: NewGraphClient(
-			options.URL,
-			options.AuthToken,
-			options.BasicAuthUsername,
-			options.BasicAuthPassword,
-			options.SkipVerify,
-		),
+		cache:   cache,
+		client:  client,
 		options: options,
-	}
+	}, nil
 }
 
 // CreateTransaction create a full graph transaction. This kind of transaction will diff the new graph
 // with previous version of it.
 func (gapi *GraphAPI) CreateTransaction() (*Transaction, error) {
-	if gapi.currentGraph == nil || gapi.currentGraphStaleAfter.Before(time.Now()) {
-		logrus.Debug("transaction: fetching remote graph")
-		g, err := gapi.ReadCurrentGraph()
-		if err != nil {
-			return nil, fmt.Errorf("create transaction: %w", err)
+	return gapi.CreateTransactionContext(context.Background())
+}
+
+// CreateTransactionContext create a full graph transaction, the synchronization of the graph with the server is
+// interrupted when the context is done.
+func (gapi *GraphAPI) CreateTransactionContext(ctx context.Context) (*Transaction, error) {
+	gapi.declareExpectedUpdateInterval(ctx)
+	if err := gapi.synchronize(ctx); err != nil {
+		return nil, fmt.Errorf("create transaction: %w", err)
+	}
+
+	gapi.currentGraph.Clean()
+
+	transaction := new(Transaction)
+	transaction.graph = gapi.currentGraph
+	transaction.binder = knowledge.NewGraphBinder(transaction.graph)
+	transaction.client = gapi.client
+	transaction.parallelization = gapi.parallelization()
+	transaction.chunkSize = gapi.chunkSize()
+
+	transaction.retry = gapi.retryPolicy()
+	transaction.atomic = gapi.options.AtomicCommit
+	transaction.deletionGuard = gapi.options.DeletionGuard
+
+	transaction.onError = func(err error) {
+		// there was an error, we don't know which updates have been applied.
+		// we restore the cached copy to the last known revision and the changes
+		// made since then are fetched on the next run.
+		logrus.Debug("transaction: rolling back graph cache because of error:", err)
+		if gapi.currentGraph != nil {
+			gapi.currentGraph.Rollback()
 		}
+	}
+
+	transaction.onSuccess = func(ctx context.Context, g *knowledge.Graph, revision int64) {
+		// tx was successful, we updated to local graph cache to
+		// speed up the next tx.
 		gapi.currentGraph = g
 
-		gapi.currentGraphStaleAfter = time.Now()
-		if gapi.options.AntiEntropyDuration != 0 {
-			quarter := int64(gapi.options.AntiEntropyDuration) / 4
-			randDelta := time.Duration(rand.Int63n(quarter*2) - quarter)
-			gapi.currentGraphStaleAfter.Add(gapi.options.AntiEntropyDuration).Add(randDelta)
+		if gapi.cache != nil {
+			if err := gapi.cache.Save(g); err != nil {
+				logrus.Warnf("transaction: %v", err)
+			}
+		}
+
+		// The revision produced by the transaction is kept rather than the current one which may already include
+		// other updates. When it is unknown, the cached revision is kept and the next synchronization reads the
+		// changes of the transaction again, which is harmless.
+		if revision != 0 {
+			gapi.currentRevision = revision
+			gapi.currentRevisionKnown = true
 		}
 	}
 
-	var parallelization = gapi.options.Parallelization
-	if parallelization == 0 {
-		parallelization = 30
+	return transaction, nil
+}
+
+// declareExpectedUpdateInterval declare the expected update interval to the server once. A failure does not prevent
+// the data source from updating its graph, the declaration is retried with the next transaction.
+func (gapi *GraphAPI) declareExpectedUpdateInterval(ctx context.Context) {
+	if gapi.options.ExpectedUpdateInterval <= 0 || gapi.intervalDeclared {
+		return
 	}
+	if err := gapi.client.SetExpectedUpdateIntervalContext(ctx, gapi.options.ExpectedUpdateInterval); err != nil {
+		logrus.Warnf("Unable to declare the expected update interval: %v", err)
+		return
+	}
+	gapi.intervalDeclared = true
+}
 
-	var chunkSize = gapi.options.ChunkSize
-	if chunkSize == 0 {
-		chunkSize = 1000
+// CreateIncrementalTransaction create a transaction sending explicit additions and removals. This kind of
+// transaction neither reads nor diffs the graph of the source.
+func (gapi *GraphAPI) CreateIncrementalTransaction() *IncrementalTransaction {
+	return &IncrementalTransaction{
+		client:          gapi.client,
+		assets:          map[knowledge.Asset]knowledge.GraphEntryAction{},
+		relations:       map[knowledge.Relation]knowledge.GraphEntryAction{},
+		parallelization: gapi.parallelization(),
+		chunkSize:       gapi.chunkSize(),
+		atomic:          gapi.options.AtomicCommit,
+		retry:           gapi.retryPolicy(),
 	}
+}
 
-	var maxRetries = gapi.options.MaxRetries
-	if maxRetries == 0 {
-		maxRetries = 10
+func (gapi *GraphAPI) retryPolicy() retryPolicy {
+	policy := retryPolicy{
+		maxRetries:    gapi.options.MaxRetries,
+		delay:         gapi.options.RetryDelay,
+		backoffFactor: gapi.options.RetryBackoffFactor,
+		maxDelay:      gapi.options.MaxRetryDelay,
+	}
+	if policy.maxRetries == 0 {
+		policy.maxRetries = 10
+	}
+	if policy.delay == 0 {
+		policy.delay = 5 * time.Second
 	}
+	if policy.backoffFactor == 0.0 {
+		policy.backoffFactor = 1.01
+	}
+	if policy.maxDelay == 0 {
+		policy.maxDelay = 2 * time.Minute
+	}
+	return policy
+}
 
-	var retryDelay = gapi.options.RetryDelay
-	if retryDelay == 0 {
-		retryDelay = 5 * time.Second
+func (gapi *GraphAPI) parallelization() int {
+	if gapi.options.Parallelization == 0 {
+		return 30
 	}
+	return gapi.options.Parallelization
+}
 
-	var retryBackoff = gapi.options.RetryBackoffFactor
-	if retryBackoff == 0.0 {
-		retryBackoff = 1.01
+func (gapi *GraphAPI) antiEntropyDuration() time.Duration {
+	if gapi.options.AntiEntropyDuration == 0 {
+		return 24 * time.Hour
 	}
+	return gapi.options.AntiEntropyDuration
+}
 
-	gapi.currentGraph.Clean()
+func (gapi *GraphAPI) chunkSize() int {
+	if gapi.options.ChunkSize == 0 {
+		return 1000
+	}
+	return gapi.options.ChunkSize
+}
 
-	transaction := new(Transaction)
-	transaction.graph = gapi.currentGraph
-	transaction.binder = knowledge.NewGraphBinder(transaction.graph)
-	transaction.client = gapi.client
-	transaction.parallelization = parallelization
-	transaction.chunkSize = chunkSize
+// synchronize bring the cached graph up to date with the graph stored on the server. The whole graph is only read
+// when there is no cached copy, otherwise the changes since the cached revision are applied or, when the graph is
+// stale or the changes are not available anymore, the buckets whose checksums differ are read again.
+func (gapi *GraphAPI) synchronize(ctx context.Context) error {
+	if gapi.currentGraph == nil && gapi.cache != nil {
+		g, err := gapi.cache.Load()
+		if err != nil {
+			logrus.Warnf("transaction: %v", err)
+		} else if g != nil {
+			// The revision of the persisted graph is not trusted, it is validated with the checksums instead.
+			logrus.Debug("transaction: validating the persisted graph against the remote graph")
+			gapi.currentGraph = g
+			gapi.currentRevisionKnown = false
+			return gapi.reconcile(ctx)
+		}
+	}
+
+	if gapi.currentGraph == nil {
+		logrus.Debug("transaction: fetching remote graph")
+		g, revision, err := gapi.client.ReadCurrentGraphWithRevisionContext(ctx)
+		if err != nil {
+			return err
+		}
+		gapi.currentGraph = g
+		gapi.currentRevision = revision
+		gapi.currentRevisionKnown = true
+		gapi.resetStaleness()
+		return nil
+	}
 
-	transaction.retryCount = maxRetries
-	transaction.retryDelay = retryDelay
-	transaction.retryBackoffFactor = retryBackoff
+	if gapi.currentRevisionKnown && !gapi.currentGraphStaleAfter.Before(time.Now()) {
+		logrus.Debugf("transaction: fetching changes since revision %d", gapi.currentRevision)
+		changes, err := gapi.client.ReadChangesContext(ctx, gapi.currentRevision)
+		if err == nil {
+			gapi.currentGraph.ApplyChanges(changes.Changes)
+			gapi.currentRevision = changes.Revision
+			return nil
+		}
+		if !errors.Is(err, knowledge.ErrRevisionTooOld) {
+			return err
+		}
+		logrus.Debugf("transaction: %v", err)
+	}
 
-	transaction.onError = func(err error) {
-		// there was an error, we don't know the remote graph state.
-		// we clear the cached copy to refresh in on the next run.
-		logrus.Debug("transaction: clearing graph cache because of error:", err)
-		gapi.currentGraph = nil
+	return gapi.reconcile(ctx)
+}
+
+// reconcile compare the checksums of the cached graph with the ones of the server and read the differing buckets
+func (gapi *GraphAPI) reconcile(ctx context.Context) error {
+	buckets := gapi.options.SyncBuckets
+	if buckets == 0 {
+		buckets = 1024
 	}
 
-	transaction.onSuccess = func(g *knowledge.Graph) {
-		// tx was successful, we updated to local graph cache to
-		// speed up the next tx.
-		gapi.currentGraph = g
+	remote, err := gapi.client.ReadChecksumsContext(ctx, buckets)
+	if err != nil {
+		return err
 	}
+	local := knowledge.ComputeChecksums(gapi.currentGraph, buckets)
 
-	return transaction, nil
+	assetBuckets := knowledge.MismatchingBuckets(local.Assets, remote.Assets)
+	relationBuckets := knowledge.MismatchingBuckets(local.Relations, remote.Relations)
+	logrus.Debugf("transaction: reconciling %d asset buckets and %d relation buckets", len(assetBuckets), len(relationBuckets))
+
+	if len(assetBuckets) > 0 || len(relationBuckets) > 0 {
+		g, err := gapi.client.ReadGraphBucketsContext(ctx, buckets, assetBuckets, relationBuckets)
+		if err != nil {
+			return err
+		}
+		gapi.currentGraph.ReplaceBuckets(buckets, assetBuckets, relationBuckets, g)
+	}
+
+	gapi.currentRevision = remote.Revision
+	gapi.currentRevisionKnown = true
+	gapi.resetStaleness()
+	return nil
+}
+
+// resetStaleness postpone the next synchronization against the checksums of the server. The delay is randomized so
+// that the data sources started together do not synchronize at the same time.
+func (gapi *GraphAPI) resetStaleness() {
+	duration := gapi.antiEntropyDuration()
+	quarter := int64(duration) / 4
+	randDelta := time.Duration(0)
+	if quarter > 0 {
+		randDelta = time.Duration(rand.Int63n(quarter*2) - quarter)
+	}
+	gapi.currentGraphStaleAfter = time.Now().Add(duration).Add(randDelta)
 }
 
 // ReadCurrentGraph read the current graph stored in graph kb
 func (gapi *GraphAPI) ReadCurrentGraph() (*knowledge.Graph, error) {
 	return gapi.client.ReadCurrentGraph()
 }
+
+// ReadCurrentGraphContext read the current graph stored in graph kb, the request is cancelled with the context
+func (gapi *GraphAPI) ReadCurrentGraphContext(ctx context.Context) (*knowledge.Graph, error) {
+	return gapi.client.ReadCurrentGraphContext(ctx)
+}
@@ -0,0 +1,23 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldNotForceSynchronizationOnEveryRunByDefault(t *testing.T) {
+	gapi, err := NewGraphAPI(GraphAPIOptions{URL: "http://localhost"})
+	require.NoError(t, err)
+	gapi.resetStaleness()
+	// The delay is randomized by a quarter of the default duration
+	assert.True(t, gapi.currentGraphStaleAfter.After(time.Now().Add(17*time.Hour)))
+
+	gapi, err = NewGraphAPI(GraphAPIOptions{URL: "http://localhost", AntiEntropyDuration: time.Hour})
+	require.NoError(t, err)
+	gapi.resetStaleness()
+	assert.True(t, gapi.currentGraphStaleAfter.After(time.Now().Add(44*time.Minute)))
+	assert.True(t, gapi.currentGraphStaleAfter.Before(time.Now().Add(76*time.Minute)))
+}
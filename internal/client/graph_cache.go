@@ -0,0 +1,111 @@
+package client
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+)
+
+// graphCache persists the last committed graph of a source on disk so that it survives restarts
+type graphCache struct {
+	path string
+}
+
+// newGraphCache create a cache in the directory. The name of the file is derived from the URL and the identity of
+// the source so that several sources can share the same directory. The identity must not be a credential so that
+// the cache survives the rotation of the credentials.
+func newGraphCache(dir, url, identity string) *graphCache {
+	h := sha256.Sum256([]byte(url + "\x00" + identity))
+	return &graphCache{
+		path: filepath.Join(dir, fmt.Sprintf("graph-%s.cache.gz", hex.EncodeToString(h[:8]))),
+	}
+}
+
+// Load read the graph from the cache, nil is returned when there is no cached graph
+func (c *graphCache) Load() (*knowledge.Graph, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Unable to open graph cache: %v", err)
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read graph cache: %v", err)
+	}
+	defer r.Close()
+
+	decoded := knowledge.NewGraph()
+	if err := knowledge.NewGraphDecoder(r).Decode(decoded); err != nil {
+		return nil, fmt.Errorf("Unable to decode graph cache: %v", err)
+	}
+
+	// The cached entries are known to the server, they must not be discarded by a rollback
+	changes := []knowledge.GraphChange{}
+	for a := range decoded.Assets() {
+		asset := a
+		changes = append(changes, knowledge.GraphChange{Asset: &asset})
+	}
+	for r := range decoded.Relations() {
+		relation := r
+		changes = append(changes, knowledge.GraphChange{Relation: &relation})
+	}
+
+	g := knowledge.NewGraph()
+	g.ApplyChanges(changes)
+	return g, nil
+}
+
+// Save write the assets and relations of the graph which are not about to be removed in the cache
+func (c *graphCache) Save(g *knowledge.Graph) error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return fmt.Errorf("Unable to create graph cache directory: %v", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Unable to create graph cache: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := gzip.NewWriter(f)
+	encoder := knowledge.NewGraphEncoder(w)
+	for a, action := range g.Assets() {
+		if action == knowledge.GraphEntryRemove {
+			continue
+		}
+		if err := encoder.EncodeAsset(a); err != nil {
+			return fmt.Errorf("Unable to write graph cache: %v", err)
+		}
+	}
+	for r, action := range g.Relations() {
+		if action == knowledge.GraphEntryRemove {
+			continue
+		}
+		if err := encoder.EncodeRelation(r); err != nil {
+			return fmt.Errorf("Unable to write graph cache: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("Unable to write graph cache: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to write graph cache: %v", err)
+	}
+
+	// Replace the previous cache atomically so that a crash never leaves a truncated cache behind
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		return fmt.Errorf("Unable to write graph cache: %v", err)
+	}
+	return nil
+}
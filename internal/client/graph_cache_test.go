@@ -0,0 +1,75 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldReturnNoGraphWhenCacheIsEmpty(t *testing.T) {
+	cache := newGraphCache(t.TempDir(), "http://localhost", "source")
+
+	g, err := cache.Load()
+	require.NoError(t, err)
+	assert.Nil(t, g)
+}
+
+func TestShouldSaveAndLoadGraphFromCache(t *testing.T) {
+	cache := newGraphCache(t.TempDir(), "http://localhost", "source")
+
+	g := knowledge.NewGraph()
+	ip, _ := g.AddAsset("ip", "127.0.0.1")
+	device, _ := g.AddAsset("device", "server1")
+	g.AddRelation(device, "has_ip", ip)
+	removed, _ := g.AddAsset("ip", "127.0.0.2")
+	g.Clean()
+
+	// Mark all entries but the removed one as still present in the graph
+	g.AddAsset("ip", "127.0.0.1")
+	g.AddAsset("device", "server1")
+	g.AddRelation(device, "has_ip", ip)
+
+	require.NoError(t, cache.Save(g))
+
+	loaded, err := cache.Load()
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+
+	assert.Len(t, loaded.Assets(), 2)
+	assert.Len(t, loaded.Relations(), 1)
+	assert.False(t, loaded.HasAsset(knowledge.Asset(removed)))
+
+	// The entries loaded from the cache must survive a rollback
+	loaded.Rollback()
+	assert.Len(t, loaded.Assets(), 2)
+	assert.Len(t, loaded.Relations(), 1)
+}
+
+func TestShouldUseDistinctCacheFilesPerSource(t *testing.T) {
+	dir := t.TempDir()
+	assert.NotEqual(t,
+		newGraphCache(dir, "http://localhost", "source1").path,
+		newGraphCache(dir, "http://localhost", "source2").path)
+}
+
+func TestShouldKeepCacheFileWhenTokenIsRotated(t *testing.T) {
+	dir := t.TempDir()
+	options := GraphAPIOptions{URL: "http://localhost", CacheDir: dir, SourceName: "source", AuthToken: "token1"}
+	before, err := NewGraphAPI(options)
+	require.NoError(t, err)
+	options.AuthToken = "token2"
+	after, err := NewGraphAPI(options)
+	require.NoError(t, err)
+	assert.Equal(t, before.cache.path, after.cache.path)
+}
+
+func TestShouldRequireIdentityToPersistGraph(t *testing.T) {
+	_, err := NewGraphAPI(GraphAPIOptions{URL: "http://localhost", CacheDir: t.TempDir(), AuthToken: "token"})
+	assert.ErrorIs(t, err, ErrMissingSourceName)
+
+	// The graph is not persisted, the source needs no name
+	_, err = NewGraphAPI(GraphAPIOptions{URL: "http://localhost", AuthToken: "token"})
+	assert.NoError(t, err)
+}
@@ -2,23 +2,22 @@ package client
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
 	"github.com/clems4ever/go-graphkb/internal/schema"
 	"github.com/clems4ever/go-graphkb/internal/utils"
-	"github.com/sirupsen/logrus"
 )
 
-// ErrTooManyRequests error representing too many requests to the API
-var ErrTooManyRequests = fmt.Errorf("Too Many Requests")
-
 // GraphClient is a client of the GraphKB API
 type GraphClient struct {
 	url           string
@@ -26,13 +25,38 @@ type GraphClient struct {
 	basicAuthUser string
 	basicAuthPass string
 
+	// graphPath is the path prefix of the graph update endpoints
+	graphPath string
+
+	// Whether the assets and relations are sent in the compact binary format instead of JSON
+	binary bool
+
+	// Whether the deletion guard of the server is bypassed
+	forceDeletion bool
+
+	// runID is the ID of the run the updates belong to so that the server adds up their removals
+	runID string
+
+	// Whether the bodies of the requests are compressed with gzip. The responses are always requested compressed
+	// and transparently decompressed by the HTTP transport.
+	compress bool
+
 	client *http.Client
+
+	// configErr is the error preventing the client from being configured, it is returned by every request
+	configErr error
 }
 
 // NewGraphClient create a client of the GraphKB API
 func NewGraphClient(URL, authToken, basicAuthUser, basicAuthPass string, skipVerify bool) *GraphClient {
+	return NewGraphClientWithTLS(URL, authToken, basicAuthUser, basicAuthPass, &tls.Config{InsecureSkipVerify: skipVerify})
+}
+
+// NewGraphClientWithTLS create a client of the GraphKB API using the TLS configuration, e.g., to authenticate with
+// a client certificate. The auth token can be empty in that case.
+func NewGraphClientWithTLS(URL, authToken, basicAuthUser, basicAuthPass string, tlsConfig *tls.Config) *GraphClient {
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipVerify},
+		TLSClientConfig: tlsConfig,
 	}
 	client := &http.Client{Transport: tr}
 
@@ -41,17 +65,66 @@ func NewGraphClient(URL, authToken, basicAuthUser, basicAuthPass string, skipVer
 		authToken:     authToken,
 		basicAuthUser: basicAuthUser,
 		basicAuthPass: basicAuthPass,
+		graphPath:     "/api/graph",
 		client:        client,
 	}
 }
 
+// inTransaction return a copy of the client staging the graph updates in the given transaction
+func (gc *GraphClient) inTransaction(id string) *GraphClient {
+	txClient := *gc
+	txClient.graphPath = fmt.Sprintf("/api/graph/transactions/%s", id)
+	return &txClient
+}
+
+// inRun return a copy of the client sending the graph updates as part of the given run
+func (gc *GraphClient) inRun(id string) *GraphClient {
+	runClient := *gc
+	runClient.runID = id
+	return &runClient
+}
+
+// withForcedDeletion return a copy of the client bypassing the deletion guard of the server
+func (gc *GraphClient) withForcedDeletion() *GraphClient {
+	forcedClient := *gc
+	forcedClient.forceDeletion = true
+	return &forcedClient
+}
+
 func (gc *GraphClient) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	if gc.configErr != nil {
+		return nil, gc.configErr
+	}
+
+	compressed := gc.compress && body != nil
+	if compressed {
+		b := new(bytes.Buffer)
+		gz := gzip.NewWriter(b)
+		if _, err := io.Copy(gz, body); err != nil {
+			return nil, fmt.Errorf("Unable to compress request body: %v", err)
+		}
+		if err := gz.Close(); err != nil {
+			return nil, fmt.Errorf("Unable to compress request body: %v", err)
+		}
+		body = b
+	}
+
 	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", gc.url, path), body)
 	if err != nil {
 		return nil, err
 	}
 	req = req.WithContext(ctx)
 
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if gc.forceDeletion {
+		req.Header.Set(utils.XForceDeletionHeader, "true")
+	}
+	if gc.runID != "" {
+		req.Header.Set(utils.XRunIDHeader, gc.runID)
+	}
+
 	if gc.authToken != "" {
 		req.Header.Add(utils.XAuthTokenHeader, gc.authToken)
 	}
@@ -63,43 +136,78 @@ func (gc *GraphClient) newRequest(ctx context.Context, method, path string, body
 
 // ReadCurrentGraph read the current graph stored in graph kb
 func (gc *GraphClient) ReadCurrentGraph() (*knowledge.Graph, error) {
-	req, err := gc.newRequest(context.Background(), "GET", "/api/graph/read", nil)
+	return gc.ReadCurrentGraphContext(context.Background())
+}
+
+// ReadCurrentGraphContext read the current graph stored in graph kb
+func (gc *GraphClient) ReadCurrentGraphContext(ctx context.Context) (*knowledge.Graph, error) {
+	graph, _, err := gc.ReadCurrentGraphWithRevisionContext(ctx)
+	return graph, err
+}
+
+// ReadCurrentGraphWithRevision read the current graph stored in graph kb along with its revision
+func (gc *GraphClient) ReadCurrentGraphWithRevision() (*knowledge.Graph, int64, error) {
+	return gc.ReadCurrentGraphWithRevisionContext(context.Background())
+}
+
+// ReadCurrentGraphWithRevisionContext read the current graph stored in graph kb along with its revision
+func (gc *GraphClient) ReadCurrentGraphWithRevisionContext(ctx context.Context) (*knowledge.Graph, int64, error) {
+	return gc.readGraph(ctx, "/api/graph/read")
+}
+
+// ReadGraphBuckets read the assets and relations of the graph stored in graph kb falling in the given buckets
+func (gc *GraphClient) ReadGraphBuckets(buckets int, assetBuckets, relationBuckets []int) (*knowledge.Graph, error) {
+	return gc.ReadGraphBucketsContext(context.Background(), buckets, assetBuckets, relationBuckets)
+}
+
+// ReadGraphBucketsContext read the assets and relations of the graph stored in graph kb falling in the given buckets
+func (gc *GraphClient) ReadGraphBucketsContext(ctx context.Context, buckets int, assetBuckets, relationBuckets []int) (*knowledge.Graph, error) {
+	params := url.Values{}
+	params.Set("buckets", strconv.Itoa(buckets))
+	for _, b := range assetBuckets {
+		params.Add("asset_bucket", strconv.Itoa(b))
+	}
+	for _, b := range relationBuckets {
+		params.Add("relation_bucket", strconv.Itoa(b))
+	}
+
+	graph, _, err := gc.readGraph(ctx, "/api/graph/read?"+params.Encode())
+	return graph, err
+}
+
+func (gc *GraphClient) readGraph(ctx context.Context, path string) (*knowledge.Graph, int64, error) {
+	req, err := gc.newRequest(ctx, "GET", path, nil)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	res, err := gc.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return nil, fmt.Errorf("Unauthorized access. Check your auth token")
-	} else if res.StatusCode != http.StatusOK {
-		return nil, handleUnexpectedResponse(res)
+	if err := checkResponse(res); err != nil {
+		return nil, 0, err
+	}
+
+	revision, err := strconv.ParseInt(res.Header.Get(utils.XRevisionHeader), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Unable to parse revision of the graph: %v", err)
 	}
 
 	graphDecoder := knowledge.NewGraphDecoder(res.Body)
 	graph := knowledge.NewGraph()
 	err = graphDecoder.Decode(graph)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return graph, nil
+	return graph, revision, nil
 }
 
-// UpdateSchema send a graph schema update to the API
-func (gc *GraphClient) UpdateSchema(sg schema.SchemaGraph) error {
-	requestBody := PutGraphSchemaRequestBody{}
-	requestBody.Schema = sg
-
-	b, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("Unable to marshall request body")
-	}
-
-	req, err := gc.newRequest(context.Background(), "PUT", "/api/graph/schema", bytes.NewBuffer(b))
+// getJSON send a GET request to the API and decode the JSON response
+func (gc *GraphClient) getJSON(ctx context.Context, path string, v interface{}) error {
+	req, err := gc.newRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return err
 	}
@@ -110,27 +218,103 @@ func (gc *GraphClient) UpdateSchema(sg schema.SchemaGraph) error {
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("Unauthorized access. Check your auth token")
-	} else if res.StatusCode == http.StatusTooManyRequests {
-		return ErrTooManyRequests
-	} else if res.StatusCode != http.StatusOK {
-		return handleUnexpectedResponse(res)
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("Unable to decode response: %v", err)
 	}
 	return nil
 }
 
-// InsertAssets send asset insert operations to the API
-func (gc *GraphClient) InsertAssets(assets []knowledge.Asset) error {
-	requestBody := PutGraphAssetRequestBody{}
-	requestBody.Assets = assets
+// ReadRevision read the current revision of the graph stored in graph kb
+func (gc *GraphClient) ReadRevision() (int64, error) {
+	return gc.ReadRevisionContext(context.Background())
+}
+
+// ReadRevisionContext read the current revision of the graph stored in graph kb
+func (gc *GraphClient) ReadRevisionContext(ctx context.Context) (int64, error) {
+	responseBody := RevisionResponseBody{}
+	if err := gc.getJSON(ctx, "/api/graph/revision", &responseBody); err != nil {
+		return 0, err
+	}
+	return responseBody.Revision, nil
+}
+
+// ReadChanges read the changes made to the graph stored in graph kb since the given revision
+func (gc *GraphClient) ReadChanges(since int64) (*ChangesResponseBody, error) {
+	return gc.ReadChangesContext(context.Background(), since)
+}
+
+// ReadChangesContext read the changes made to the graph stored in graph kb since the given revision
+func (gc *GraphClient) ReadChangesContext(ctx context.Context, since int64) (*ChangesResponseBody, error) {
+	responseBody := ChangesResponseBody{}
+	if err := gc.getJSON(ctx, fmt.Sprintf("/api/graph/changes?since=%d", since), &responseBody); err != nil {
+		return nil, err
+	}
+	return &responseBody, nil
+}
+
+// ReadChecksums read the checksums of the graph stored in graph kb split in buckets
+func (gc *GraphClient) ReadChecksums(buckets int) (*knowledge.GraphChecksums, error) {
+	return gc.ReadChecksumsContext(context.Background(), buckets)
+}
+
+// ReadChecksumsContext read the checksums of the graph stored in graph kb split in buckets
+func (gc *GraphClient) ReadChecksumsContext(ctx context.Context, buckets int) (*knowledge.GraphChecksums, error) {
+	checksums := knowledge.GraphChecksums{}
+	if err := gc.getJSON(ctx, fmt.Sprintf("/api/graph/checksum?buckets=%d", buckets), &checksums); err != nil {
+		return nil, err
+	}
+	return &checksums, nil
+}
+
+// ReadSchema read the current schema of the graph stored in graph kb
+func (gc *GraphClient) ReadSchema() (schema.SchemaGraph, error) {
+	return gc.ReadSchemaContext(context.Background())
+}
+
+// ReadSchemaContext read the current schema of the graph stored in graph kb
+func (gc *GraphClient) ReadSchemaContext(ctx context.Context) (schema.SchemaGraph, error) {
+	sg := schema.NewSchemaGraph()
+	if err := gc.getJSON(ctx, "/api/graph/schema", &sg); err != nil {
+		return schema.SchemaGraph{}, err
+	}
+	return sg, nil
+}
+
+// UpdateSchema send a graph schema update to the API
+func (gc *GraphClient) UpdateSchema(sg schema.SchemaGraph) error {
+	return gc.UpdateSchemaContext(context.Background(), sg)
+}
+
+// UpdateSchemaContext send a graph schema update to the API
+func (gc *GraphClient) UpdateSchemaContext(ctx context.Context, sg schema.SchemaGraph) error {
+	return gc.putSchema(ctx, sg, false)
+}
+
+// MergeSchema send a schema to be merged into the schema of the source
+func (gc *GraphClient) MergeSchema(sg schema.SchemaGraph) error {
+	return gc.MergeSchemaContext(context.Background(), sg)
+}
+
+// MergeSchemaContext send a schema to be merged into the schema of the source
+func (gc *GraphClient) MergeSchemaContext(ctx context.Context, sg schema.SchemaGraph) error {
+	return gc.putSchema(ctx, sg, true)
+}
+
+func (gc *GraphClient) putSchema(ctx context.Context, sg schema.SchemaGraph, merge bool) error {
+	requestBody := PutGraphSchemaRequestBody{}
+	requestBody.Schema = sg
+	requestBody.Merge = merge
 
 	b, err := json.Marshal(requestBody)
 	if err != nil {
 		return fmt.Errorf("Unable to marshall request body")
 	}
 
-	req, err := gc.newRequest(context.Background(), "PUT", "/api/graph/assets", bytes.NewBuffer(b))
+	req, err := gc.newRequest(ctx, "PUT", gc.graphPath+"/schema", bytes.NewBuffer(b))
 	if err != nil {
 		return err
 	}
@@ -141,30 +325,92 @@ func (gc *GraphClient) InsertAssets(assets []knowledge.Asset) error {
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("Unauthorized access. Check your auth token")
-	} else if res.StatusCode == http.StatusTooManyRequests {
-		return ErrTooManyRequests
-	} else if res.StatusCode != http.StatusOK {
-		return handleUnexpectedResponse(res)
-	}
-	return nil
+	return checkResponse(res)
+}
+
+// InsertAssets send asset insert operations to the API
+func (gc *GraphClient) InsertAssets(assets []knowledge.Asset) error {
+	return gc.InsertAssetsContext(context.Background(), assets)
+}
+
+// InsertAssetsContext send asset insert operations to the API
+func (gc *GraphClient) InsertAssetsContext(ctx context.Context, assets []knowledge.Asset) error {
+	return gc.sendAssets(ctx, "PUT", assets)
 }
 
 // DeleteAssets send asset removal operations to the API
 func (gc *GraphClient) DeleteAssets(assets []knowledge.Asset) error {
-	requestBody := DeleteGraphAssetRequestBody{}
-	requestBody.Assets = assets
+	return gc.DeleteAssetsContext(context.Background(), assets)
+}
 
-	b, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("Unable to marshall request body")
+// DeleteAssetsContext send asset removal operations to the API
+func (gc *GraphClient) DeleteAssetsContext(ctx context.Context, assets []knowledge.Asset) error {
+	return gc.sendAssets(ctx, "DELETE", assets)
+}
+
+// InsertRelations send relation insert operations to the API
+func (gc *GraphClient) InsertRelations(relations []knowledge.Relation) error {
+	return gc.InsertRelationsContext(context.Background(), relations)
+}
+
+// InsertRelationsContext send relation insert operations to the API
+func (gc *GraphClient) InsertRelationsContext(ctx context.Context, relations []knowledge.Relation) error {
+	return gc.sendRelations(ctx, "PUT", relations)
+}
+
+// DeleteRelations send relation removal operations to the API
+func (gc *GraphClient) DeleteRelations(relations []knowledge.Relation) error {
+	return gc.DeleteRelationsContext(context.Background(), relations)
+}
+
+// DeleteRelationsContext send relation removal operations to the API
+func (gc *GraphClient) DeleteRelationsContext(ctx context.Context, relations []knowledge.Relation) error {
+	return gc.sendRelations(ctx, "DELETE", relations)
+}
+
+func (gc *GraphClient) sendAssets(ctx context.Context, method string, assets []knowledge.Asset) error {
+	b := new(bytes.Buffer)
+	if gc.binary {
+		if err := knowledge.EncodeAssetsBinary(b, assets); err != nil {
+			return fmt.Errorf("Unable to encode request body: %v", err)
+		}
+	} else {
+		requestBody := PutGraphAssetRequestBody{}
+		requestBody.Assets = assets
+		if err := json.NewEncoder(b).Encode(requestBody); err != nil {
+			return fmt.Errorf("Unable to marshall request body")
+		}
 	}
+	return gc.sendUpdates(ctx, method, gc.graphPath+"/assets", b)
+}
 
-	req, err := gc.newRequest(context.Background(), "DELETE", "/api/graph/assets", bytes.NewBuffer(b))
+func (gc *GraphClient) sendRelations(ctx context.Context, method string, relations []knowledge.Relation) error {
+	b := new(bytes.Buffer)
+	if gc.binary {
+		if err := knowledge.EncodeRelationsBinary(b, relations); err != nil {
+			return fmt.Errorf("Unable to encode request body: %v", err)
+		}
+	} else {
+		requestBody := PutGraphRelationRequestBody{}
+		requestBody.Relations = relations
+		if err := json.NewEncoder(b).Encode(requestBody); err != nil {
+			return fmt.Errorf("Unable to marshall request body")
+		}
+	}
+	return gc.sendUpdates(ctx, method, gc.graphPath+"/relations", b)
+}
+
+// sendUpdates send a body of updates encoded in JSON or in the binary format depending on the client settings
+func (gc *GraphClient) sendUpdates(ctx context.Context, method, path string, body io.Reader) error {
+	req, err := gc.newRequest(ctx, method, path, body)
 	if err != nil {
 		return err
 	}
+	if gc.binary {
+		req.Header.Set("Content-Type", utils.BinaryContentType)
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	res, err := gc.client.Do(req)
 	if err != nil {
@@ -172,27 +418,24 @@ func (gc *GraphClient) DeleteAssets(assets []knowledge.Asset) error {
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("Unauthorized access. Check your auth token")
-	} else if res.StatusCode == http.StatusTooManyRequests {
-		return ErrTooManyRequests
-	} else if res.StatusCode != http.StatusOK {
-		return handleUnexpectedResponse(res)
-	}
-	return nil
+	return checkResponse(res)
 }
 
-// InsertRelations send relation insert operations to the API
-func (gc *GraphClient) InsertRelations(relations []knowledge.Relation) error {
-	requestBody := PutGraphRelationRequestBody{}
-	requestBody.Relations = relations
+// SetExpectedUpdateInterval declare the interval the data source updates its graph at so that the server reports the
+// data source as stale when it does not update its graph for longer
+func (gc *GraphClient) SetExpectedUpdateInterval(interval time.Duration) error {
+	return gc.SetExpectedUpdateIntervalContext(context.Background(), interval)
+}
 
-	b, err := json.Marshal(requestBody)
+// SetExpectedUpdateIntervalContext declare the interval the data source updates its graph at so that the server
+// reports the data source as stale when it does not update its graph for longer
+func (gc *GraphClient) SetExpectedUpdateIntervalContext(ctx context.Context, interval time.Duration) error {
+	b, err := json.Marshal(PutGraphFreshnessRequestBody{ExpectedUpdateInterval: interval.String()})
 	if err != nil {
 		return fmt.Errorf("Unable to marshall request body")
 	}
 
-	req, err := gc.newRequest(context.Background(), "PUT", "/api/graph/relations", bytes.NewBuffer(b))
+	req, err := gc.newRequest(ctx, "PUT", "/api/graph/freshness", bytes.NewBuffer(b))
 	if err != nil {
 		return err
 	}
@@ -203,27 +446,41 @@ func (gc *GraphClient) InsertRelations(relations []knowledge.Relation) error {
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("Unauthorized access. Check your auth token")
-	} else if res.StatusCode == http.StatusTooManyRequests {
-		return ErrTooManyRequests
-	} else if res.StatusCode != http.StatusOK {
-		return handleUnexpectedResponse(res)
-	}
-	return nil
+	return checkResponse(res)
 }
 
-// DeleteRelations send relation removal operations to the API
-func (gc *GraphClient) DeleteRelations(relations []knowledge.Relation) error {
-	requestBody := DeleteGraphRelationRequestBody{}
-	requestBody.Relations = relations
+// BeginTransaction open a staged transaction in which the updates are applied atomically on commit
+func (gc *GraphClient) BeginTransaction() (string, error) {
+	return gc.BeginTransactionContext(context.Background())
+}
 
-	b, err := json.Marshal(requestBody)
+// BeginTransactionContext open a staged transaction in which the updates are applied atomically on commit
+func (gc *GraphClient) BeginTransactionContext(ctx context.Context) (string, error) {
+	req, err := gc.newRequest(ctx, "POST", "/api/graph/transactions", nil)
 	if err != nil {
-		return fmt.Errorf("Unable to marshall request body")
+		return "", err
+	}
+
+	res, err := gc.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res); err != nil {
+		return "", err
 	}
 
-	req, err := gc.newRequest(context.Background(), "DELETE", "/api/graph/relations", bytes.NewBuffer(b))
+	responseBody := BeginTransactionResponseBody{}
+	if err := json.NewDecoder(res.Body).Decode(&responseBody); err != nil {
+		return "", fmt.Errorf("Unable to decode transaction: %v", err)
+	}
+	return responseBody.ID, nil
+}
+
+// post send a POST request without body and decode the JSON reply into v unless v is nil
+func (gc *GraphClient) post(ctx context.Context, path string, v interface{}) error {
+	req, err := gc.newRequest(ctx, "POST", path, nil)
 	if err != nil {
 		return err
 	}
@@ -234,21 +491,56 @@ func (gc *GraphClient) DeleteRelations(relations []knowledge.Relation) error {
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return fmt.Errorf("Unauthorized access. Check your auth token")
-	} else if res.StatusCode == http.StatusTooManyRequests {
-		return ErrTooManyRequests
-	} else if res.StatusCode != http.StatusOK {
-		return handleUnexpectedResponse(res)
+	if err := checkResponse(res); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("Unable to decode response: %v", err)
 	}
 	return nil
 }
 
-func handleUnexpectedResponse(res *http.Response) error {
-	bodyBytes, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		logrus.Errorf("Unable to read error payload: %v", err)
+// CommitTransaction apply all the updates staged in the transaction atomically and return the revision of the graph
+// produced by the commit
+func (gc *GraphClient) CommitTransaction(id string) (int64, error) {
+	return gc.CommitTransactionContext(context.Background(), id)
+}
+
+// CommitTransactionContext apply all the updates staged in the transaction atomically and return the revision of the
+// graph produced by the commit
+func (gc *GraphClient) CommitTransactionContext(ctx context.Context, id string) (int64, error) {
+	responseBody := RevisionResponseBody{}
+	if err := gc.post(ctx, fmt.Sprintf("/api/graph/transactions/%s/commit", id), &responseBody); err != nil {
+		return 0, err
+	}
+	return responseBody.Revision, nil
+}
+
+// AbortTransaction discard all the updates staged in the transaction
+func (gc *GraphClient) AbortTransaction(id string) error {
+	return gc.AbortTransactionContext(context.Background(), id)
+}
+
+// AbortTransactionContext discard all the updates staged in the transaction
+func (gc *GraphClient) AbortTransactionContext(ctx context.Context, id string) error {
+	return gc.post(ctx, fmt.Sprintf("/api/graph/transactions/%s/abort", id), nil)
+}
+
+// CompleteRun end the run the updates have been sent in and return the last revision of the graph produced by the
+// run, 0 if the run did not update the graph
+func (gc *GraphClient) CompleteRun(id string) (int64, error) {
+	return gc.CompleteRunContext(context.Background(), id)
+}
+
+// CompleteRunContext end the run the updates have been sent in and return the last revision of the graph produced by
+// the run, 0 if the run did not update the graph
+func (gc *GraphClient) CompleteRunContext(ctx context.Context, id string) (int64, error) {
+	responseBody := RevisionResponseBody{}
+	if err := gc.post(ctx, fmt.Sprintf("/api/graph/runs/%s/complete", id), &responseBody); err != nil {
+		return 0, err
 	}
-	bodyString := string(bodyBytes)
-	return fmt.Errorf("Unexpected HTTP status %d with content %s: %s", res.StatusCode, res.Status, bodyString)
+	return responseBody.Revision, nil
 }
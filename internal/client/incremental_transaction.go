@@ -0,0 +1,255 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/sirupsen/logrus"
+)
+
+// IncrementalTransaction represent a transaction sending explicit additions and removals of assets and relations.
+// Contrary to Transaction, it does not read nor diff the graph of the source so anything which is not explicitly
+// removed is kept. It is meant for event-driven sources only knowing individual updates.
+type IncrementalTransaction struct {
+	client *GraphClient
+
+	// The action to perform on each asset and relation, the last update of an item wins
+	assets    map[knowledge.Asset]knowledge.GraphEntryAction
+	relations map[knowledge.Relation]knowledge.GraphEntryAction
+
+	// Lock used when adding or removing assets and relations
+	mutex sync.Mutex
+
+	// Number of parallel queries to the Graph API
+	parallelization int
+
+	// The number of items to send to the streaming API in one request
+	chunkSize int
+
+	// Whether the updates are staged on the server and applied atomically on commit
+	atomic bool
+
+	// Whether the deletion guard of the server is bypassed
+	forceDeletion bool
+
+	// How the requests failing with a retryable error are retried
+	retry retryPolicy
+
+	err error
+}
+
+func (it *IncrementalTransaction) setError(err error) {
+	if err != nil && it.err == nil {
+		it.err = fmt.Errorf("tx: %w", err)
+	}
+}
+
+func newAsset(asset string, assetType schema.AssetType) (knowledge.Asset, error) {
+	validators, _ := schema.AssetValidationRegistry.Get(assetType)
+	if err := schema.ValidateAsset(validators, assetType, asset); err != nil {
+		return knowledge.Asset{}, err
+	}
+	return knowledge.NewAsset(assetType, asset), nil
+}
+
+// AddAsset add an asset of the given type to the graph
+func (it *IncrementalTransaction) AddAsset(asset string, assetType schema.AssetType) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	a, err := newAsset(asset, assetType)
+	if err != nil {
+		it.setError(err)
+		return
+	}
+	it.assets[a] = knowledge.GraphEntryAdd
+}
+
+// RemoveAsset remove an asset of the given type from the graph
+func (it *IncrementalTransaction) RemoveAsset(asset string, assetType schema.AssetType) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	it.assets[knowledge.NewAsset(assetType, asset)] = knowledge.GraphEntryRemove
+}
+
+// AddRelation add a relation between two assets to the graph. The assets are added as well.
+func (it *IncrementalTransaction) AddRelation(from string, relationType schema.RelationType, to string) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	fromAsset, err := newAsset(from, relationType.FromType)
+	if err != nil {
+		it.setError(fmt.Errorf("relate: from asset: %w", err))
+		return
+	}
+	toAsset, err := newAsset(to, relationType.ToType)
+	if err != nil {
+		it.setError(fmt.Errorf("relate: to asset: %w", err))
+		return
+	}
+
+	it.assets[fromAsset] = knowledge.GraphEntryAdd
+	it.assets[toAsset] = knowledge.GraphEntryAdd
+	it.relations[knowledge.Relation{
+		Type: relationType.Type,
+		From: knowledge.AssetKey(fromAsset),
+		To:   knowledge.AssetKey(toAsset),
+	}] = knowledge.GraphEntryAdd
+}
+
+// RemoveRelation remove a relation between two assets from the graph. The assets are kept.
+func (it *IncrementalTransaction) RemoveRelation(from string, relationType schema.RelationType, to string) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	it.relations[knowledge.Relation{
+		Type: relationType.Type,
+		From: knowledge.AssetKey(knowledge.NewAsset(relationType.FromType, from)),
+		To:   knowledge.AssetKey(knowledge.NewAsset(relationType.ToType, to)),
+	}] = knowledge.GraphEntryRemove
+}
+
+// ForceDeletion bypass the deletion guard of the server on commit
+func (it *IncrementalTransaction) ForceDeletion() {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	it.forceDeletion = true
+}
+
+// extractSchema extract the schema of the added assets and relations
+func (it *IncrementalTransaction) extractSchema() schema.SchemaGraph {
+	g := knowledge.NewGraph()
+	for a, action := range it.assets {
+		if action == knowledge.GraphEntryAdd {
+			g.AddAsset(a.Type, a.Key)
+		}
+	}
+	for r, action := range it.relations {
+		if action == knowledge.GraphEntryAdd {
+			g.AddRelation(r.From, r.Type, r.To)
+		}
+	}
+	return g.ExtractSchema()
+}
+
+// Commit send the updates to the API. The schema of the added assets and relations is merged into the schema of the
+// source before the updates are sent.
+func (it *IncrementalTransaction) Commit() error {
+	return it.CommitContext(context.Background())
+}
+
+// CommitContext send the updates to the API, the upload is interrupted when the context is done
+func (it *IncrementalTransaction) CommitContext(ctx context.Context) error {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	if it.err != nil {
+		return fmt.Errorf("tx: commit: %w", it.err)
+	}
+
+	// Merging the schema only adds types so it is done outside of the staged transaction.
+	logrus.Debug("Start merging the schema of the updates...")
+	sg := it.extractSchema()
+	err := it.retry.do(ctx, func(ctx context.Context) error {
+		return it.client.MergeSchemaContext(ctx, sg)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to merge the schema of the graph: %w", err)
+	}
+
+	client := it.client
+	if it.forceDeletion {
+		client = client.withForcedDeletion()
+	}
+	var txID string
+	if it.atomic {
+		var id string
+		err := it.retry.do(ctx, func(ctx context.Context) (err error) {
+			id, err = client.BeginTransactionContext(ctx)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to begin the transaction: %w", err)
+		}
+		txID = id
+		client = client.inTransaction(txID)
+	} else {
+		runID, err := newRunID()
+		if err != nil {
+			return err
+		}
+		client = client.inRun(runID)
+	}
+
+	if err := it.upload(ctx, client); err != nil {
+		if it.atomic {
+			// The transaction is aborted even if the context is done so that it does not linger until it expires
+			if abortErr := it.client.AbortTransactionContext(context.Background(), txID); abortErr != nil {
+				logrus.Errorf("Unable to abort transaction %s: %v", txID, abortErr)
+			}
+		}
+		return err
+	}
+
+	if it.atomic {
+		logrus.Debugf("Committing transaction %s...", txID)
+		err := it.retry.doWhen(ctx, isRateLimited, func(ctx context.Context) error {
+			_, err := client.CommitTransactionContext(ctx, txID)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to commit the transaction: %w", err)
+		}
+	} else {
+		completeRun(ctx, client, it.retry)
+	}
+
+	it.assets = map[knowledge.Asset]knowledge.GraphEntryAction{}
+	it.relations = map[knowledge.Relation]knowledge.GraphEntryAction{}
+	return nil
+}
+
+// upload send the updates with the given client
+func (it *IncrementalTransaction) upload(ctx context.Context, client *GraphClient) error {
+	logrus.Debug("Start uploading the updates...")
+	now := time.Now()
+
+	totalCount := 0
+
+	count, err := chunkedTransfer(ctx, it.parallelization, it.chunkSize, it.assets, knowledge.GraphEntryAdd, withRetry(it.retry, client.InsertAssetsContext))
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Inserted %d assets", count)
+	totalCount += count
+
+	count, err = chunkedTransfer(ctx, it.parallelization, it.chunkSize, it.relations, knowledge.GraphEntryAdd, withRetry(it.retry, client.InsertRelationsContext))
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Inserted %d relations", count)
+	totalCount += count
+
+	count, err = chunkedTransfer(ctx, it.parallelization, it.chunkSize, it.relations, knowledge.GraphEntryRemove, withRetry(it.retry, client.DeleteRelationsContext))
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Deleted %d relations", count)
+	totalCount += count
+
+	count, err = chunkedTransfer(ctx, it.parallelization, it.chunkSize, it.assets, knowledge.GraphEntryRemove, withRetry(it.retry, client.DeleteAssetsContext))
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Deleted %d assets", count)
+	totalCount += count
+
+	logrus.Debugf("Finished uploading the updates (%d operations) in %s...", totalCount, time.Since(now))
+	return nil
+}
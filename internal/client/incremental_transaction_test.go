@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedUpdates struct {
+	mutex          sync.Mutex
+	schema         *PutGraphSchemaRequestBody
+	insertedAssets []knowledge.Asset
+	removedAssets  []knowledge.Asset
+	insertedRels   []knowledge.Relation
+	removedRels    []knowledge.Relation
+	// The IDs of the runs the removals have been sent in and of the completed runs
+	removalRuns    map[string]bool
+	completedRuns  []string
+	unexpectedCall bool
+}
+
+func newRecordingServer(t *testing.T, updates *recordedUpdates) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		updates.mutex.Lock()
+		defer updates.mutex.Unlock()
+
+		if r.Method == "DELETE" {
+			if updates.removalRuns == nil {
+				updates.removalRuns = map[string]bool{}
+			}
+			updates.removalRuns[r.Header.Get(utils.XRunIDHeader)] = true
+		}
+
+		if r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/graph/runs/") && strings.HasSuffix(r.URL.Path, "/complete") {
+			updates.completedRuns = append(updates.completedRuns,
+				strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/graph/runs/"), "/complete"))
+			w.Write([]byte(`{"revision":5}`))
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "PUT /api/graph/schema":
+			body := PutGraphSchemaRequestBody{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			updates.schema = &body
+		case "PUT /api/graph/assets":
+			body := PutGraphAssetRequestBody{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			updates.insertedAssets = append(updates.insertedAssets, body.Assets...)
+		case "DELETE /api/graph/assets":
+			body := DeleteGraphAssetRequestBody{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			updates.removedAssets = append(updates.removedAssets, body.Assets...)
+		case "PUT /api/graph/relations":
+			body := PutGraphRelationRequestBody{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			updates.insertedRels = append(updates.insertedRels, body.Relations...)
+		case "DELETE /api/graph/relations":
+			body := DeleteGraphRelationRequestBody{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			updates.removedRels = append(updates.removedRels, body.Relations...)
+		default:
+			updates.unexpectedCall = true
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestShouldCommitIncrementalUpdatesWithoutReadingGraph(t *testing.T) {
+	updates := &recordedUpdates{}
+	server := newRecordingServer(t, updates)
+	defer server.Close()
+
+	gapi, err := NewGraphAPI(GraphAPIOptions{URL: server.URL, AuthToken: "token"})
+	require.NoError(t, err)
+	tx := gapi.CreateIncrementalTransaction()
+
+	hasIP := schema.RelationType{FromType: "device", Type: "has_ip", ToType: "ip"}
+	tx.AddRelation("server1", hasIP, "10.0.0.1")
+	tx.RemoveRelation("server2", hasIP, "10.0.0.2")
+	tx.RemoveAsset("10.0.0.2", "ip")
+	tx.AddAsset("10.0.0.3", "ip")
+	// The last update of an item wins
+	tx.AddAsset("10.0.0.4", "ip")
+	tx.RemoveAsset("10.0.0.4", "ip")
+
+	require.NoError(t, tx.Commit())
+
+	assert.False(t, updates.unexpectedCall)
+
+	require.NotNil(t, updates.schema)
+	assert.True(t, updates.schema.Merge)
+	assert.ElementsMatch(t, []schema.AssetType{"device", "ip"}, updates.schema.Schema.Assets())
+	assert.ElementsMatch(t, []schema.RelationType{hasIP}, updates.schema.Schema.Relations())
+
+	assert.ElementsMatch(t, []knowledge.Asset{
+		knowledge.NewAsset("device", "server1"),
+		knowledge.NewAsset("ip", "10.0.0.1"),
+		knowledge.NewAsset("ip", "10.0.0.3"),
+	}, updates.insertedAssets)
+	assert.ElementsMatch(t, []knowledge.Asset{
+		knowledge.NewAsset("ip", "10.0.0.2"),
+		knowledge.NewAsset("ip", "10.0.0.4"),
+	}, updates.removedAssets)
+	assert.Len(t, updates.insertedRels, 1)
+	assert.Len(t, updates.removedRels, 1)
+
+	// The removals are sent in one run completed at the end of the commit
+	require.Len(t, updates.removalRuns, 1)
+	require.Len(t, updates.completedRuns, 1)
+	assert.True(t, updates.removalRuns[updates.completedRuns[0]])
+}
+
+func TestShouldReportRevisionProducedByRun(t *testing.T) {
+	updates := &recordedUpdates{}
+	server := newRecordingServer(t, updates)
+	defer server.Close()
+
+	g := knowledge.NewGraph()
+	var revision int64
+	tx := &Transaction{
+		client:          NewGraphClient(server.URL, "token", "", "", false),
+		graph:           g,
+		binder:          knowledge.NewGraphBinder(g),
+		parallelization: 1,
+		chunkSize:       10,
+		onSuccess:       func(ctx context.Context, g *knowledge.Graph, r int64) { revision = r },
+		onError:         func(error) {},
+	}
+	tx.Bind("10.0.0.1", "ip")
+
+	require.NoError(t, tx.Commit())
+	assert.False(t, updates.unexpectedCall)
+	require.Len(t, updates.completedRuns, 1)
+	assert.Equal(t, int64(5), revision)
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 )
 
 func (gapi *GraphAPI) Query(ctx context.Context, q string, includeSources bool) (*QueryResponse, error) {
@@ -28,12 +27,8 @@ func (gapi *GraphAPI) Query(ctx context.Context, q string, includeSources bool)
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return nil, fmt.Errorf("Unauthorized access. Check your auth token")
-	} else if res.StatusCode == http.StatusTooManyRequests {
-		return nil, ErrTooManyRequests
-	} else if res.StatusCode != http.StatusOK {
-		return nil, handleUnexpectedResponse(res)
+	if err := checkResponse(res); err != nil {
+		return nil, err
 	}
 
 	result := &QueryResponse{}
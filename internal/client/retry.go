@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// retryPolicy defines how the requests failing with a retryable error are retried. The zero value does not retry.
+type retryPolicy struct {
+	// Max number of retries before giving up
+	maxRetries int
+	// The delay before the first retry, multiplied by the backoff factor at every retry
+	delay         time.Duration
+	backoffFactor float64
+	// The maximum delay between two retries, the Retry-After delay requested by the server is not capped
+	maxDelay time.Duration
+}
+
+// backoff computes the delay before the given retry. The delay grows exponentially and is jittered in
+// [delay/2, delay] so that the clients throttled together do not retry together. A delay requested by the
+// server with Retry-After takes precedence.
+func (p retryPolicy) backoff(trial int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+
+	delay := float64(p.delay) * math.Pow(p.backoffFactor, float64(trial))
+	if p.maxDelay > 0 && delay > float64(p.maxDelay) {
+		delay = float64(p.maxDelay)
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return time.Duration(delay)
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// do run the function until it succeeds, fails with an error which is not retryable or the retries are exhausted.
+// The retries stop as soon as the context is done.
+func (p retryPolicy) do(ctx context.Context, fn func(context.Context) error) error {
+	return p.doWhen(ctx, IsRetryable, fn)
+}
+
+// isRateLimited returns true if the API refused the request before processing it because it was overloaded. It is
+// used to retry the requests which are not safe to send twice.
+func isRateLimited(err error) bool {
+	return errors.Is(err, ErrTooManyRequests)
+}
+
+// doWhen run the function until it succeeds, fails with an error for which retryable returns false or the retries
+// are exhausted.
+func (p retryPolicy) doWhen(ctx context.Context, retryable func(error) bool, fn func(context.Context) error) error {
+	trials := 0
+	for {
+		err := fn(ctx)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if trials >= p.maxRetries {
+			if p.maxRetries == 0 {
+				return err
+			}
+			return fmt.Errorf("Too many retries... Aborting: %w", err)
+		}
+
+		backoffTime := p.backoff(trials, err)
+		logrus.Warnf("Request failed, retrying in %s: %v", backoffTime, err)
+		timer := time.NewTimer(backoffTime)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		trials++
+	}
+}
+
+// withRetry wraps a chunk transfer function so that each chunk is retried according to the policy
+func withRetry[T any](p retryPolicy, fn func(context.Context, []T) error) func(context.Context, []T) error {
+	return func(ctx context.Context, chunk []T) error {
+		return p.do(ctx, func(ctx context.Context) error {
+			return fn(ctx, chunk)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldReturnTypedErrors(t *testing.T) {
+	cases := []struct {
+		status int
+		header string
+		target error
+	}{
+		{http.StatusUnauthorized, "", ErrUnauthorized},
+		{http.StatusTooManyRequests, "", ErrTooManyRequests},
+		{http.StatusBadRequest, utils.SchemaViolationErrorCode, ErrSchemaViolation},
+		{http.StatusServiceUnavailable, "", ErrServerUnavailable},
+		{http.StatusInternalServerError, "", ErrServerUnavailable},
+		{http.StatusGone, "", knowledge.ErrRevisionTooOld},
+		{http.StatusForbidden, "", ErrForbidden},
+		{http.StatusForbidden, utils.QuotaExceededErrorCode, knowledge.ErrQuotaExceeded},
+	}
+
+	for _, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.header != "" {
+				w.Header().Set(utils.XErrorCodeHeader, c.header)
+			}
+			w.WriteHeader(c.status)
+			w.Write([]byte("details"))
+		}))
+
+		err := NewGraphClient(server.URL, "token", "", "", false).InsertAssets(nil)
+		server.Close()
+
+		assert.ErrorIs(t, err, c.target, "status %d", c.status)
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, c.status, apiErr.StatusCode)
+		assert.Equal(t, "details", apiErr.Body)
+	}
+}
+
+func TestShouldNotClassifyPlainBadRequestAsSchemaViolation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := NewGraphClient(server.URL, "token", "", "", false).InsertAssets(nil)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrSchemaViolation))
+	assert.False(t, IsRetryable(err))
+}
+
+func TestShouldNotClassifyForbiddenAsUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := NewGraphClient(server.URL, "token", "", "", false).InsertAssets(nil)
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.False(t, errors.Is(err, ErrUnauthorized))
+	assert.False(t, IsRetryable(err))
+}
+
+func TestShouldParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, 10*time.Second, parseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestShouldClassifyRetryableErrors(t *testing.T) {
+	assert.True(t, IsRetryable(&APIError{StatusCode: 429, kind: ErrTooManyRequests}))
+	assert.True(t, IsRetryable(&APIError{StatusCode: 503, kind: ErrServerUnavailable}))
+	assert.False(t, IsRetryable(&APIError{StatusCode: 401, kind: ErrUnauthorized}))
+	assert.False(t, IsRetryable(&APIError{StatusCode: 400, kind: ErrSchemaViolation}))
+	assert.False(t, IsRetryable(&DeletionGuardError{}))
+	assert.False(t, IsRetryable(&APIError{StatusCode: 403, kind: knowledge.ErrQuotaExceeded}))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.False(t, IsRetryable(nil))
+}
+
+func TestShouldOnlyRetryTransientTransportErrors(t *testing.T) {
+	wrap := func(err error) error {
+		return &url.Error{Op: "Put", URL: "https://graphkb", Err: err}
+	}
+	assert.True(t, IsRetryable(wrap(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)})))
+	assert.True(t, IsRetryable(wrap(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)})))
+	assert.True(t, IsRetryable(wrap(io.ErrUnexpectedEOF)))
+	assert.True(t, IsRetryable(wrap(timeoutError{})))
+
+	assert.False(t, IsRetryable(wrap(context.Canceled)))
+	assert.False(t, IsRetryable(wrap(x509.UnknownAuthorityError{})))
+	assert.False(t, IsRetryable(wrap(x509.HostnameError{Host: "graphkb"})))
+	assert.False(t, IsRetryable(wrap(errors.New("unsupported protocol scheme"))))
+
+	// A request to a closed port is refused
+	_, err := NewGraphClient("http://127.0.0.1:1", "token", "", "", false).ReadRevision()
+	assert.True(t, IsRetryable(err))
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestShouldJitterExponentialBackoff(t *testing.T) {
+	p := retryPolicy{maxRetries: 5, delay: time.Second, backoffFactor: 2, maxDelay: 5 * time.Second}
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1, ErrServerUnavailable)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 2*time.Second)
+
+		d = p.backoff(10, ErrServerUnavailable)
+		assert.GreaterOrEqual(t, d, 2500*time.Millisecond)
+		assert.LessOrEqual(t, d, 5*time.Second)
+	}
+
+	assert.Equal(t, time.Minute, p.backoff(10, &APIError{RetryAfter: time.Minute, kind: ErrTooManyRequests}))
+}
+
+func TestShouldRetryOnlyRetryableErrors(t *testing.T) {
+	p := retryPolicy{maxRetries: 3, delay: time.Millisecond, backoffFactor: 2}
+
+	calls := 0
+	err := p.do(context.Background(), func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return &APIError{StatusCode: 503, kind: ErrServerUnavailable}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = p.do(context.Background(), func(context.Context) error {
+		calls++
+		return &APIError{StatusCode: 401, kind: ErrUnauthorized}
+	})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	err = p.do(context.Background(), func(context.Context) error {
+		calls++
+		return &APIError{StatusCode: 429, kind: ErrTooManyRequests}
+	})
+	assert.ErrorIs(t, err, ErrTooManyRequests)
+	assert.Equal(t, 4, calls)
+}
+
+func TestShouldRetryCommitWhenRateLimited(t *testing.T) {
+	assetCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/graph/assets" {
+			assetCalls++
+			if assetCalls == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	g := knowledge.NewGraph()
+	tx := &Transaction{
+		client:          NewGraphClient(server.URL, "token", "", "", false),
+		graph:           g,
+		binder:          knowledge.NewGraphBinder(g),
+		parallelization: 1,
+		chunkSize:       10,
+		retry:           retryPolicy{maxRetries: 2, delay: time.Millisecond, backoffFactor: 2},
+		onSuccess:       func(context.Context, *knowledge.Graph, int64) {},
+		onError:         func(error) {},
+	}
+	tx.Bind("10.0.0.1", "ip")
+
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, 2, assetCalls)
+}
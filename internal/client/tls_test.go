@@ -0,0 +1,108 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, issuer *testCertificate) testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parent, signer := template, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return testCertificate{cert: cert, key: key}
+}
+
+func (c testCertificate) writeFiles(t *testing.T, dir, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	return certFile, keyFile
+}
+
+func TestShouldAuthenticateWithClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "test-ca"}, IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign,
+	}, nil)
+	serverCert := newTestCertificate(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "graphkb"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	clientCert := newTestCertificate(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "datasource-csv"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	clientCertFile, clientKeyFile := clientCert.writeFiles(t, dir, "client")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	var commonName string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commonName = r.TLS.VerifiedChains[0][0].Subject.CommonName
+		w.Write([]byte(`{"revision":3}`))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	api, err := NewGraphAPI(GraphAPIOptions{URL: server.URL, CAFile: caFile, ClientCertFile: clientCertFile, ClientKeyFile: clientKeyFile})
+	require.NoError(t, err)
+	_, err = api.client.ReadRevision()
+	require.NoError(t, err)
+	assert.Equal(t, "datasource-csv", commonName)
+
+	api, err = NewGraphAPI(GraphAPIOptions{URL: server.URL, CAFile: caFile})
+	require.NoError(t, err)
+	_, err = api.client.ReadRevision()
+	assert.Error(t, err)
+}
+
+func TestShouldFailRequestsWhenTLSIsMisconfigured(t *testing.T) {
+	api, err := NewGraphAPI(GraphAPIOptions{URL: "https://127.0.0.1:1", ClientCertFile: "client.crt"})
+	require.NoError(t, err)
+	_, err = api.client.ReadRevision()
+	assert.EqualError(t, err, "Both the client certificate and the client key are required for mutual TLS")
+	assert.False(t, IsRetryable(err))
+}
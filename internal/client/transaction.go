@@ -1,8 +1,10 @@
 package client
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"math"
 	"sync"
 	"time"
 
@@ -29,71 +31,212 @@ type Transaction struct {
 	// The number of items to send to the streaming API in one request
 	chunkSize int
 
-	retryCount         int
-	retryDelay         time.Duration
-	retryBackoffFactor float64
+	// How the requests failing with a retryable error are retried
+	retry retryPolicy
+
+	// Whether the updates are staged on the server and applied atomically on commit
+	atomic bool
+
+	deletionGuard knowledge.DeletionGuard
+	// Whether the deletion guards of the client and the server are bypassed
+	forceDeletion bool
 
 	err error
+	// The errors raised while binding or relating assets, reported by Plan
+	bindErrors     []string
+	bindErrorCount int
 
-	onSuccess func(*knowledge.Graph)
+	// onSuccess is called with the revision of the graph produced by the transaction, 0 when it is unknown
+	onSuccess func(context.Context, *knowledge.Graph, int64)
 	onError   func(error)
 }
 
 // Relate create a relation between two assets
 func (cgt *Transaction) Relate(from string, relationType schema.RelationType, to string) {
 	cgt.mutex.Lock()
-	err := cgt.binder.Relate(from, relationType, to)
-	if err != nil && cgt.err == nil {
-		cgt.err = fmt.Errorf("tx: %w", err)
-	}
+	cgt.recordError(cgt.binder.Relate(from, relationType, to))
 	cgt.mutex.Unlock()
 }
 
 // Bind bind one asset to an asset type from the schema
 func (cgt *Transaction) Bind(asset string, assetType schema.AssetType) {
 	cgt.mutex.Lock()
-	err := cgt.binder.Bind(asset, assetType)
-	if err != nil && cgt.err == nil {
-		cgt.err = fmt.Errorf("tx: %w", err)
-	}
+	cgt.recordError(cgt.binder.Bind(asset, assetType))
 	cgt.mutex.Unlock()
 }
 
-// withRetryOnTooManyRequests helper retrying the function when too many request error has been received
-func withRetryOnTooManyRequests(fn func() error, backoffFactor float64, maxRetries int, delay time.Duration) error {
-	trials := 0
-	for {
-		err := fn()
-		if err != nil {
-			logrus.Error(err)
-			backoffTime := time.Duration(int(math.Pow(backoffFactor, float64(trials)))) * delay
-			logrus.Infof("Sleeping for %s", backoffTime)
-			time.Sleep(backoffTime)
-		} else {
-			return nil
+// ForceDeletion bypass the deletion guards of the client and the server on commit. It is meant to be used when the
+// removal of a large part of the graph is expected.
+func (cgt *Transaction) ForceDeletion() {
+	cgt.mutex.Lock()
+	cgt.forceDeletion = true
+	cgt.mutex.Unlock()
+}
+
+// checkDeletion verifies the deletion guard of the client allows the removals of the transaction
+func (cgt *Transaction) checkDeletion() error {
+	if cgt.forceDeletion || !cgt.deletionGuard.Enabled() {
+		return nil
+	}
+
+	var removed, total int64
+	for _, action := range cgt.graph.Assets() {
+		if action == knowledge.GraphEntryRemove {
+			removed++
+		}
+		if action != knowledge.GraphEntryAdd {
+			total++
+		}
+	}
+	for _, action := range cgt.graph.Relations() {
+		if action == knowledge.GraphEntryRemove {
+			removed++
 		}
-		trials++
-		if trials == maxRetries {
-			return fmt.Errorf("Too many retries... Aborting: %v", err)
+		if action != knowledge.GraphEntryAdd {
+			total++
 		}
 	}
+
+	if err := cgt.deletionGuard.Check(removed, total); err != nil {
+		return &DeletionGuardError{Message: fmt.Sprintf("tx: commit: %v", err)}
+	}
+	return nil
+}
+
+// recordError keep the first error to fail the commit and the first ones for the plan
+func (cgt *Transaction) recordError(err error) {
+	if err == nil {
+		return
+	}
+	if cgt.err == nil {
+		cgt.err = fmt.Errorf("tx: %w", err)
+	}
+	cgt.bindErrorCount++
+	if len(cgt.bindErrors) < maxPlanFailures {
+		cgt.bindErrors = append(cgt.bindErrors, err.Error())
+	}
 }
 
 // Commit commit the transaction and gives ownership to the source for caching.
 func (cgt *Transaction) Commit() error {
+	return cgt.CommitContext(context.Background())
+}
+
+// CommitContext commit the transaction and gives ownership to the source for caching. The upload is interrupted
+// and the transaction fails when the context is done.
+func (cgt *Transaction) CommitContext(ctx context.Context) error {
 	if cgt.err != nil {
 		return fmt.Errorf("tx: commit: %w", cgt.err)
 	}
 
 	sg := cgt.graph.ExtractSchema()
 
-	logrus.Debug("Start uploading the schema of the graph...")
-	if err := cgt.client.UpdateSchema(sg); err != nil {
-		err := fmt.Errorf("Unable to update the schema of the graph: %v", err)
+	if violations := knowledge.CheckConstraints(cgt.graph, sg.Constraints); len(violations) > 0 {
+		err := fmt.Errorf("tx: commit: %w: %s (%d violations in total)", schema.ErrConstraintViolation, violations[0], len(violations))
+		cgt.onError(err)
+		return err
+	}
+
+	if err := cgt.checkDeletion(); err != nil {
+		cgt.onError(err)
+		return err
+	}
+
+	client := cgt.client
+	if cgt.forceDeletion {
+		client = client.withForcedDeletion()
+	}
+	var txID string
+	var revision int64
+	if cgt.atomic {
+		var id string
+		err := cgt.retry.do(ctx, func(ctx context.Context) (err error) {
+			id, err = client.BeginTransactionContext(ctx)
+			return err
+		})
+		if err != nil {
+			err := fmt.Errorf("Unable to begin the transaction: %w", err)
+			cgt.onError(err)
+			return err
+		}
+		txID = id
+		client = client.inTransaction(txID)
+	} else {
+		runID, err := newRunID()
+		if err != nil {
+			cgt.onError(err)
+			return err
+		}
+		client = client.inRun(runID)
+	}
+
+	if err := cgt.upload(ctx, client, sg); err != nil {
+		if cgt.atomic {
+			// The transaction is aborted even if the context is done so that it does not linger until it expires
+			if abortErr := cgt.client.AbortTransactionContext(context.Background(), txID); abortErr != nil {
+				logrus.Errorf("Unable to abort transaction %s: %v", txID, abortErr)
+			}
+		}
 		cgt.onError(err)
 		return err
 	}
 
+	if cgt.atomic {
+		logrus.Debugf("Committing transaction %s...", txID)
+		// The commit is only retried when it was refused before being processed, it would fail otherwise since the
+		// transaction does not exist anymore once committed
+		err := cgt.retry.doWhen(ctx, isRateLimited, func(ctx context.Context) (err error) {
+			revision, err = client.CommitTransactionContext(ctx, txID)
+			return err
+		})
+		if err != nil {
+			err := fmt.Errorf("Unable to commit the transaction: %w", err)
+			cgt.onError(err)
+			return err
+		}
+	} else {
+		revision = completeRun(ctx, client, cgt.retry)
+	}
+
+	cgt.onSuccess(ctx, cgt.graph, revision)
+	cgt.graph = knowledge.NewGraph()
+	return nil
+}
+
+// newRunID generate the ID of a run sending the updates of a transaction in several requests
+func newRunID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Unable to generate the ID of the run: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// completeRun end the run of the client and return the last revision produced by the run, 0 when it is unknown. A
+// failure is only logged since the updates have been applied, the server forgets the run after a while anyway.
+func completeRun(ctx context.Context, client *GraphClient, retry retryPolicy) int64 {
+	var revision int64
+	err := retry.do(ctx, func(ctx context.Context) (err error) {
+		revision, err = client.CompleteRunContext(ctx, client.runID)
+		return err
+	})
+	if err != nil {
+		logrus.Warnf("Unable to complete run %s: %v", client.runID, err)
+		return 0
+	}
+	return revision
+}
+
+// upload send the schema and the updates of the graph with the given client
+func (cgt *Transaction) upload(ctx context.Context, client *GraphClient, sg schema.SchemaGraph) error {
+	logrus.Debug("Start uploading the schema of the graph...")
+	err := cgt.retry.do(ctx, func(ctx context.Context) error {
+		return client.UpdateSchemaContext(ctx, sg)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to update the schema of the graph: %w", err)
+	}
+
 	logrus.Debug("Finished uploading the schema of the graph...")
 
 	logrus.Debug("Start uploading the graph...")
@@ -102,11 +245,12 @@ func (cgt *Transaction) Commit() error {
 	totalCount := 0
 
 	count, err := chunkedTransfer(
+		ctx,
 		cgt.parallelization,
 		cgt.chunkSize,
 		cgt.graph.Assets(),
 		knowledge.GraphEntryAdd,
-		cgt.client.InsertAssets,
+		withRetry(cgt.retry, client.InsertAssetsContext),
 	)
 	if err != nil {
 		return err
@@ -115,11 +259,12 @@ func (cgt *Transaction) Commit() error {
 	totalCount += count
 
 	count, err = chunkedTransfer(
+		ctx,
 		cgt.parallelization,
 		cgt.chunkSize,
 		cgt.graph.Relations(),
 		knowledge.GraphEntryAdd,
-		cgt.client.InsertRelations,
+		withRetry(cgt.retry, client.InsertRelationsContext),
 	)
 	if err != nil {
 		return err
@@ -128,11 +273,12 @@ func (cgt *Transaction) Commit() error {
 	totalCount += count
 
 	count, err = chunkedTransfer(
+		ctx,
 		cgt.parallelization,
 		cgt.chunkSize,
 		cgt.graph.Relations(),
 		knowledge.GraphEntryRemove,
-		cgt.client.DeleteRelations,
+		withRetry(cgt.retry, client.DeleteRelationsContext),
 	)
 	if err != nil {
 		return err
@@ -141,11 +287,12 @@ func (cgt *Transaction) Commit() error {
 	totalCount += count
 
 	count, err = chunkedTransfer(
+		ctx,
 		cgt.parallelization,
 		cgt.chunkSize,
 		cgt.graph.Assets(),
 		knowledge.GraphEntryRemove,
-		cgt.client.DeleteAssets,
+		withRetry(cgt.retry, client.DeleteAssetsContext),
 	)
 	if err != nil {
 		return err
@@ -153,30 +300,25 @@ func (cgt *Transaction) Commit() error {
 	logrus.Debugf("Deleted %d old assets", count)
 	totalCount += count
 
-	if totalCount == 0 {
-		// if there were no operations to perform, make an empty
-		// call so the server knows that we ran
-		err = cgt.client.InsertAssets(nil)
-		if err != nil {
-			return err
-		}
-	}
-
 	elapsed := time.Since(now)
 	logrus.Debugf("Finished uploading the graph (%d operations) in %s...", totalCount, elapsed)
-
-	cgt.onSuccess(cgt.graph)
-	cgt.graph = knowledge.NewGraph()
 	return nil
 }
 
+// chunkedTransfer send the entries matching the action by chunks with parallel workers. The transfer stops at the
+// first error or when the context is done.
 func chunkedTransfer[T comparable](
+	ctx context.Context,
 	parallelization,
 	chunkSize int,
 	in map[T]knowledge.GraphEntryAction,
 	actionMatch knowledge.GraphEntryAction,
-	do func([]T) error,
+	do func(context.Context, []T) error,
 ) (int, error) {
+	// The requests in flight are cancelled as soon as one of them fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	tasks := make(chan func() error)
 	stop := make(chan struct{})
 
@@ -194,6 +336,7 @@ func chunkedTransfer[T comparable](
 					stopOnce.Do(func() {
 						err = e
 						close(stop)
+						cancel()
 					})
 				}
 			}
@@ -207,6 +350,8 @@ func chunkedTransfer[T comparable](
 		case <-stop:
 			fmt.Println("stop")
 			goto Done
+		case <-ctx.Done():
+			goto Done
 		default:
 		}
 
@@ -217,7 +362,7 @@ func chunkedTransfer[T comparable](
 		if len(chunk) == chunkSize {
 			func(chunk []T) {
 				tasks <- func() error {
-					return do(chunk)
+					return do(ctx, chunk)
 				}
 			}(chunk)
 			chunk = make([]T, 0, chunkSize)
@@ -225,7 +370,7 @@ func chunkedTransfer[T comparable](
 	}
 	if len(chunk) > 0 {
 		tasks <- func() error {
-			return do(chunk)
+			return do(ctx, chunk)
 		}
 	}
 
@@ -233,5 +378,8 @@ Done:
 	close(tasks)
 
 	wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
 	return count, err
 }
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldInterruptCommitWhenContextIsDone(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	g := knowledge.NewGraph()
+	rolledBack := false
+	tx := &Transaction{
+		client:          NewGraphClient(server.URL, "token", "", "", false),
+		graph:           g,
+		binder:          knowledge.NewGraphBinder(g),
+		parallelization: 1,
+		chunkSize:       10,
+		onSuccess:       func(context.Context, *knowledge.Graph, int64) {},
+		onError:         func(error) { rolledBack = true },
+	}
+	tx.Bind("10.0.0.1", "ip")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := tx.CommitContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, rolledBack)
+}
+
+func TestShouldStopChunkedTransferWhenContextIsCancelled(t *testing.T) {
+	in := map[int]knowledge.GraphEntryAction{}
+	for i := 0; i < 100; i++ {
+		in[i] = knowledge.GraphEntryAdd
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	_, err := chunkedTransfer(ctx, 1, 1, in, knowledge.GraphEntryAdd, func(ctx context.Context, chunk []int) error {
+		calls++
+		cancel()
+		return ctx.Err()
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, calls, 100)
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/schema"
+)
+
+// maxPlanFailures is the maximum number of validation failures and constraint violations listed in a plan
+const maxPlanFailures = 100
+
+// AssetTypeChanges is the number of assets of a type a transaction would add and remove
+type AssetTypeChanges struct {
+	Type    schema.AssetType `json:"type"`
+	Added   int              `json:"added"`
+	Removed int              `json:"removed"`
+}
+
+// RelationTypeChanges is the number of relations of a type a transaction would add and remove
+type RelationTypeChanges struct {
+	schema.RelationType
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// TransactionPlan is the report of the changes a transaction would make if it was committed
+type TransactionPlan struct {
+	Assets    []AssetTypeChanges    `json:"assets"`
+	Relations []RelationTypeChanges `json:"relations"`
+
+	// Schema is the difference between the current schema of the source and the schema of the transaction
+	Schema schema.SchemaDiff `json:"schema"`
+
+	// ValidationFailures are the first assets rejected by the validators, the count includes all of them
+	ValidationFailures     []string `json:"validation_failures"`
+	ValidationFailureCount int      `json:"validation_failure_count"`
+
+	// ConstraintViolations are the first violations of the relation constraints, the count includes all of them
+	ConstraintViolations     []knowledge.ConstraintViolation `json:"constraint_violations"`
+	ConstraintViolationCount int                             `json:"constraint_violation_count"`
+}
+
+// IsEmpty return true if committing the transaction would not change anything
+func (p *TransactionPlan) IsEmpty() bool {
+	return len(p.Assets) == 0 && len(p.Relations) == 0 && p.Schema.IsEmpty()
+}
+
+// Plan compute the changes the transaction would make without sending any update. The current schema of the source
+// is read from the API to report the differences.
+func (cgt *Transaction) Plan() (*TransactionPlan, error) {
+	return cgt.PlanContext(context.Background())
+}
+
+// PlanContext compute the changes the transaction would make without sending any update
+func (cgt *Transaction) PlanContext(ctx context.Context) (*TransactionPlan, error) {
+	cgt.mutex.Lock()
+	defer cgt.mutex.Unlock()
+
+	currentSchema, err := cgt.client.ReadSchemaContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tx: plan: unable to read the schema: %w", err)
+	}
+	sg := cgt.graph.ExtractSchema()
+
+	plan := &TransactionPlan{
+		Assets:                 []AssetTypeChanges{},
+		Relations:              []RelationTypeChanges{},
+		Schema:                 schema.Diff(currentSchema, sg),
+		ValidationFailures:     append([]string{}, cgt.bindErrors...),
+		ValidationFailureCount: cgt.bindErrorCount,
+		ConstraintViolations:   []knowledge.ConstraintViolation{},
+	}
+
+	assets := map[schema.AssetType]*AssetTypeChanges{}
+	for a, action := range cgt.graph.Assets() {
+		if action == knowledge.GraphEntryNone {
+			continue
+		}
+		changes, ok := assets[a.Type]
+		if !ok {
+			changes = &AssetTypeChanges{Type: a.Type}
+			assets[a.Type] = changes
+		}
+		if action == knowledge.GraphEntryAdd {
+			changes.Added++
+		} else {
+			changes.Removed++
+		}
+	}
+	for _, changes := range assets {
+		plan.Assets = append(plan.Assets, *changes)
+	}
+	sort.Slice(plan.Assets, func(i, j int) bool { return plan.Assets[i].Type < plan.Assets[j].Type })
+
+	relations := map[schema.RelationType]*RelationTypeChanges{}
+	for r, action := range cgt.graph.Relations() {
+		if action == knowledge.GraphEntryNone {
+			continue
+		}
+		relationType := schema.RelationType{FromType: r.From.Type, Type: r.Type, ToType: r.To.Type}
+		changes, ok := relations[relationType]
+		if !ok {
+			changes = &RelationTypeChanges{RelationType: relationType}
+			relations[relationType] = changes
+		}
+		if action == knowledge.GraphEntryAdd {
+			changes.Added++
+		} else {
+			changes.Removed++
+		}
+	}
+	for _, changes := range relations {
+		plan.Relations = append(plan.Relations, *changes)
+	}
+	sort.Slice(plan.Relations, func(i, j int) bool {
+		a, b := plan.Relations[i].RelationType, plan.Relations[j].RelationType
+		if a.FromType != b.FromType {
+			return a.FromType < b.FromType
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ToType < b.ToType
+	})
+
+	violations := knowledge.CheckConstraints(cgt.graph, sg.Constraints)
+	plan.ConstraintViolationCount = len(violations)
+	if len(violations) > maxPlanFailures {
+		violations = violations[:maxPlanFailures]
+	}
+	plan.ConstraintViolations = append(plan.ConstraintViolations, violations...)
+	return plan, nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldPlanTransactionWithoutSendingUpdates(t *testing.T) {
+	current := schema.NewSchemaGraph()
+	current.AddAsset("ip")
+	current.AddAsset("user")
+
+	updates := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/api/graph/schema" {
+			json.NewEncoder(w).Encode(&current)
+			return
+		}
+		updates++
+	}))
+	defer server.Close()
+
+	// The graph currently stored contains one ip which is not bound anymore
+	g := knowledge.NewGraph()
+	g.AddAsset("ip", "10.0.0.1")
+	g.AddAsset("ip", "10.0.0.2")
+	g.Clean()
+
+	tx := &Transaction{
+		client: NewGraphClient(server.URL, "token", "", "", false),
+		graph:  g,
+		binder: knowledge.NewGraphBinder(g),
+	}
+	hasIP := schema.RelationType{FromType: "device", Type: "has_ip", ToType: "ip"}
+	tx.Relate("server1", hasIP, "10.0.0.1")
+
+	plan, err := tx.Plan()
+	require.NoError(t, err)
+	assert.Equal(t, 0, updates)
+
+	assert.Equal(t, []AssetTypeChanges{
+		{Type: "device", Added: 1},
+		{Type: "ip", Removed: 1},
+	}, plan.Assets)
+	assert.Equal(t, []RelationTypeChanges{{RelationType: hasIP, Added: 1}}, plan.Relations)
+	assert.Equal(t, []schema.AssetType{"device"}, plan.Schema.AddedVertices)
+	assert.Equal(t, []schema.AssetType{"user"}, plan.Schema.RemovedVertices)
+	assert.Equal(t, []schema.RelationType{hasIP}, plan.Schema.AddedEdges)
+	assert.Equal(t, 0, plan.ValidationFailureCount)
+	assert.False(t, plan.IsEmpty())
+}
@@ -8,6 +8,8 @@ import (
 // PutGraphSchemaRequestBody a request body for the schema update
 type PutGraphSchemaRequestBody struct {
 	Schema schema.SchemaGraph `json:"schema"`
+	// Merge the schema into the current schema of the source instead of replacing it
+	Merge bool `json:"merge,omitempty"`
 }
 
 // PutGraphAssetRequestBody a request body for the asset upsert
@@ -30,6 +32,28 @@ type DeleteGraphRelationRequestBody struct {
 	Relations []knowledge.Relation `json:"relations"`
 }
 
+// PutGraphFreshnessRequestBody a request body declaring the interval the source updates its graph at
+type PutGraphFreshnessRequestBody struct {
+	// ExpectedUpdateInterval is a duration like 1h30m, an empty or zero duration removes the interval
+	ExpectedUpdateInterval string `json:"expected_update_interval"`
+}
+
+// BeginTransactionResponseBody the response body of the creation of a staged transaction
+type BeginTransactionResponseBody struct {
+	ID string `json:"id"`
+}
+
+// RevisionResponseBody the response body of the revision of the graph of the source
+type RevisionResponseBody struct {
+	Revision int64 `json:"revision"`
+}
+
+// ChangesResponseBody the response body of the changes made to the graph of the source since a revision
+type ChangesResponseBody struct {
+	Revision int64                   `json:"revision"`
+	Changes  []knowledge.GraphChange `json:"changes"`
+}
+
 type QueryRequestBody struct {
 	Q              string `json:"q"`
 	IncludeSources bool   `json:"include_sources"`
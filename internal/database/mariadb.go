@@ -2,28 +2,29 @@ package database
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"hash/fnv"
-	"io"
 	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/VividCortex/mysqlerr"
+	"github.com/clems4ever/go-graphkb/internal/audit"
 	"github.com/clems4ever/go-graphkb/internal/kbcontext"
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
 	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/clems4ever/go-graphkb/internal/sources"
 	"github.com/clems4ever/go-graphkb/internal/utils"
 	mysql "github.com/go-sql-driver/mysql"
 	"github.com/golang-collections/go-datastructures/queue"
 	"github.com/sirupsen/logrus"
 )
 
-var zeroBytes = []byte{0}
-
 type MariaDBConfig struct {
 	Username               string
 	Password               string
@@ -45,7 +46,7 @@ type MariaDB struct {
 func NewMariaDB(cfg MariaDBConfig) *MariaDB {
 	db, err := sql.Open(
 		"mysql",
-		fmt.Sprintf("%s:%s@(%s)/%s?allowCleartextPasswords=%s",
+		fmt.Sprintf("%s:%s@(%s)/%s?allowCleartextPasswords=%s&parseTime=true",
 			cfg.Username,
 			cfg.Password,
 			cfg.Host,
@@ -78,6 +79,82 @@ func (m *MariaDB) InitializeSchema() error {
 		return fmt.Errorf("unable to create sources table: %v", err)
 	}
 
+	// The tokens of the sources used to be stored in the sources table, first in plaintext in auth_token and then
+	// hashed. They are only read to be migrated to source_tokens. The prefix of the tokens stored in clear is dropped.
+	_, err = m.db.ExecContext(context.Background(), `
+		ALTER TABLE sources
+			DROP INDEX IF EXISTS token_prefix_idx,
+			DROP COLUMN IF EXISTS token_prefix,
+			ADD COLUMN IF NOT EXISTS token_salt CHAR(32) CHARACTER SET ascii COLLATE ascii_bin,
+			ADD COLUMN IF NOT EXISTS token_hash CHAR(64) CHARACTER SET ascii COLLATE ascii_bin`)
+	if err != nil {
+		return fmt.Errorf("unable to add token hash columns to sources table: %v", err)
+	}
+
+	// A source can have several tokens with their own scopes and expiry
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS source_tokens (
+			id INTEGER AUTO_INCREMENT NOT NULL,
+			source_id INT NOT NULL,
+			name VARCHAR(64) NOT NULL,
+			token_id VARCHAR(32) CHARACTER SET ascii COLLATE ascii_bin NULL DEFAULT NULL,
+			token_salt CHAR(32) CHARACTER SET ascii COLLATE ascii_bin NULL DEFAULT NULL,
+			token_hash VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			scopes VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			expires_at TIMESTAMP NULL DEFAULT NULL,
+			last_used_at TIMESTAMP NULL DEFAULT NULL,
+
+			CONSTRAINT pk_source_tokens PRIMARY KEY (id),
+			CONSTRAINT fk_source_tokens_source_id FOREIGN KEY (source_id) REFERENCES sources (id) ON DELETE CASCADE,
+
+			UNIQUE KEY source_token_name (source_id, name),
+			INDEX token_id_idx (token_id))`)
+	if err != nil {
+		return fmt.Errorf("unable to create source_tokens table: %v", err)
+	}
+
+	// The tokens used to be looked up by their first characters stored in clear, they are now looked up by their
+	// public ID. The legacy salted SHA-256 hashes are kept until they are replaced by bcrypt hashes on next use.
+	_, err = m.db.ExecContext(context.Background(), `
+		ALTER TABLE source_tokens
+			DROP INDEX IF EXISTS token_prefix_idx,
+			DROP COLUMN IF EXISTS token_prefix,
+			ADD COLUMN IF NOT EXISTS token_id VARCHAR(32) CHARACTER SET ascii COLLATE ascii_bin NULL DEFAULT NULL AFTER name,
+			ADD INDEX IF NOT EXISTS token_id_idx (token_id),
+			MODIFY token_salt CHAR(32) CHARACTER SET ascii COLLATE ascii_bin NULL DEFAULT NULL,
+			MODIFY token_hash VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("unable to migrate source_tokens table: %v", err)
+	}
+
+	if err := m.migrateSourceTokens(context.Background()); err != nil {
+		return err
+	}
+
+	// The bcrypt hashes of the legacy tokens used to be stored without the fingerprint of the token they are now
+	// looked up by. They cannot be fingerprinted without the token, such tokens must be rotated.
+	var unfingerprinted int
+	err = m.db.QueryRowContext(context.Background(),
+		"SELECT COUNT(*) FROM source_tokens WHERE token_id IS NULL AND token_salt IS NULL").Scan(&unfingerprinted)
+	if err != nil {
+		return fmt.Errorf("unable to count the legacy tokens without fingerprint: %v", err)
+	}
+	if unfingerprinted > 0 {
+		logrus.Warnf("%d legacy tokens have no fingerprint and are not accepted anymore, they must be rotated", unfingerprinted)
+	}
+
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS source_freshness (
+			source_id INT NOT NULL,
+			last_update_at TIMESTAMP NULL DEFAULT NULL,
+			expected_interval_seconds BIGINT NOT NULL DEFAULT 0,
+
+			CONSTRAINT pk_source_freshness PRIMARY KEY (source_id),
+			CONSTRAINT fk_source_freshness_source_id FOREIGN KEY (source_id) REFERENCES sources (id) ON DELETE CASCADE)`)
+	if err != nil {
+		return fmt.Errorf("unable to create source_freshness table: %v", err)
+	}
+
 	// type must be part of the primary key to be a partition key
 	_, err = m.db.ExecContext(context.Background(), `
 		CREATE TABLE IF NOT EXISTS assets (
@@ -159,6 +236,169 @@ func (m *MariaDB) InitializeSchema() error {
 		return fmt.Errorf("unable to create graph_schema tables: %v", err)
 	}
 
+	// Create the table storing the ontology shared by all sources
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS graph_ontology (
+			id INTEGER AUTO_INCREMENT NOT NULL,
+			type VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			kind ENUM('alias', 'subtype') NOT NULL,
+			parent VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			timestamp TIMESTAMP,
+
+			CONSTRAINT pk_ontology PRIMARY KEY (id),
+
+			UNIQUE KEY type_parent (type, parent))`)
+	if err != nil {
+		return fmt.Errorf("unable to create graph_ontology table: %v", err)
+	}
+
+	// Create the tables storing the same-as links between assets and the rules creating them
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS asset_same_as (
+			alias_id BIGINT UNSIGNED NOT NULL,
+			canonical_id BIGINT UNSIGNED NOT NULL,
+			origin ENUM('manual', 'rule') NOT NULL,
+
+			CONSTRAINT pk_asset_same_as PRIMARY KEY (alias_id),
+			CONSTRAINT fk_asset_same_as_alias_id FOREIGN KEY (alias_id) REFERENCES assets (id) ON DELETE CASCADE,
+			CONSTRAINT fk_asset_same_as_canonical_id FOREIGN KEY (canonical_id) REFERENCES assets (id) ON DELETE CASCADE,
+
+			INDEX canonical_idx (canonical_id))`)
+	if err != nil {
+		return fmt.Errorf("unable to create asset_same_as table: %v", err)
+	}
+
+	// The links of the tables created without foreign keys may refer to assets removed since then
+	_, err = m.db.ExecContext(context.Background(), `
+		DELETE FROM asset_same_as
+		WHERE alias_id NOT IN (SELECT id FROM assets) OR canonical_id NOT IN (SELECT id FROM assets)`)
+	if err != nil {
+		return fmt.Errorf("unable to remove the same-as links of unknown assets: %v", err)
+	}
+	_, err = m.db.ExecContext(context.Background(), `
+		ALTER TABLE asset_same_as
+			ADD CONSTRAINT fk_asset_same_as_alias_id FOREIGN KEY IF NOT EXISTS (alias_id) REFERENCES assets (id) ON DELETE CASCADE,
+			ADD CONSTRAINT fk_asset_same_as_canonical_id FOREIGN KEY IF NOT EXISTS (canonical_id) REFERENCES assets (id) ON DELETE CASCADE`)
+	if err != nil {
+		return fmt.Errorf("unable to migrate asset_same_as table: %v", err)
+	}
+
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS entity_resolution_rules (
+			id INTEGER AUTO_INCREMENT NOT NULL,
+			alias_type VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			canonical_type VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			normalizer VARCHAR(64) NOT NULL,
+
+			CONSTRAINT pk_entity_resolution_rules PRIMARY KEY (id),
+
+			UNIQUE KEY alias_canonical (alias_type, canonical_type))`)
+	if err != nil {
+		return fmt.Errorf("unable to create entity_resolution_rules table: %v", err)
+	}
+
+	// Create the tables storing the revision of the graph of each source and the changes made in each revision
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS graph_revisions (
+			source_id INT NOT NULL,
+			revision BIGINT NOT NULL,
+			pruned_revision BIGINT NOT NULL DEFAULT 0,
+
+			CONSTRAINT pk_graph_revisions PRIMARY KEY (source_id),
+			CONSTRAINT fk_graph_revisions_source_id FOREIGN KEY (source_id) REFERENCES sources (id) ON DELETE CASCADE)`)
+	if err != nil {
+		return fmt.Errorf("unable to create graph_revisions table: %v", err)
+	}
+
+	// The changes used to be recorded in JSON, they are dropped and the sources reconcile their graph instead
+	var changelogPayload int
+	err = m.db.QueryRowContext(context.Background(), `
+		SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'graph_changelog' AND COLUMN_NAME = 'payload'`).Scan(&changelogPayload)
+	if err != nil {
+		return fmt.Errorf("unable to read the columns of graph_changelog table: %v", err)
+	}
+	if changelogPayload > 0 {
+		if _, err := m.db.ExecContext(context.Background(), "UPDATE graph_revisions SET pruned_revision = revision"); err != nil {
+			return fmt.Errorf("unable to prune the changes of the sources: %v", err)
+		}
+		if _, err := m.db.ExecContext(context.Background(), "DROP TABLE graph_changelog"); err != nil {
+			return fmt.Errorf("unable to drop graph_changelog table: %v", err)
+		}
+	}
+
+	// Only the IDs of the assets and relations are recorded, their content is read from the graph
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS graph_changelog (
+			id BIGINT UNSIGNED AUTO_INCREMENT NOT NULL,
+			source_id INT NOT NULL,
+			revision BIGINT NOT NULL,
+			kind ENUM('asset', 'relation') NOT NULL,
+			entity_id BIGINT UNSIGNED NOT NULL,
+			removed BOOLEAN NOT NULL,
+			timestamp TIMESTAMP,
+
+			CONSTRAINT pk_graph_changelog PRIMARY KEY (id),
+			CONSTRAINT fk_graph_changelog_source_id FOREIGN KEY (source_id) REFERENCES sources (id) ON DELETE CASCADE,
+
+			INDEX source_revision_idx (source_id, revision),
+			INDEX timestamp_idx (timestamp))`)
+	if err != nil {
+		return fmt.Errorf("unable to create graph_changelog table: %v", err)
+	}
+
+	// Create the tables storing the updates of the sources until their transaction is committed
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS staged_transactions (
+			id CHAR(32) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			source_id INT NOT NULL,
+			graph_schema MEDIUMTEXT,
+			ttl_seconds INT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+
+			CONSTRAINT pk_staged_transactions PRIMARY KEY (id),
+			CONSTRAINT fk_staged_transactions_source_id FOREIGN KEY (source_id) REFERENCES sources (id) ON DELETE CASCADE,
+
+			INDEX expires_idx (expires_at))`)
+	if err != nil {
+		return fmt.Errorf("unable to create staged_transactions table: %v", err)
+	}
+
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS staged_operations (
+			id BIGINT UNSIGNED AUTO_INCREMENT NOT NULL,
+			transaction_id CHAR(32) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			operation ENUM('insert_assets', 'insert_relations', 'remove_assets', 'remove_relations') NOT NULL,
+			payload LONGTEXT NOT NULL,
+
+			CONSTRAINT pk_staged_operations PRIMARY KEY (id),
+			CONSTRAINT fk_staged_operations_transaction_id FOREIGN KEY (transaction_id) REFERENCES staged_transactions (id) ON DELETE CASCADE,
+
+			INDEX transaction_idx (transaction_id))`)
+	if err != nil {
+		return fmt.Errorf("unable to create staged_operations table: %v", err)
+	}
+
+	// The removals of the runs of the sources are counted in the database so that the deletion guard applies to the
+	// whole run whichever server receives its requests. The removals sent without run ID have an empty run ID. The
+	// last revision produced by a run is returned to the source when the run completes.
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS source_runs (
+			source_id INT NOT NULL,
+			run_id VARCHAR(64) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			total BIGINT NULL DEFAULT NULL,
+			removed BIGINT NOT NULL DEFAULT 0,
+			revision BIGINT NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP NOT NULL,
+
+			CONSTRAINT pk_source_runs PRIMARY KEY (source_id, run_id),
+			CONSTRAINT fk_source_runs_source_id FOREIGN KEY (source_id) REFERENCES sources (id) ON DELETE CASCADE,
+
+			INDEX expires_idx (expires_at))`)
+	if err != nil {
+		return fmt.Errorf("unable to create source_runs table: %v", err)
+	}
+
 	_, err = m.db.ExecContext(context.Background(), `
 		CREATE TABLE IF NOT EXISTS query_history (
 			id INTEGER AUTO_INCREMENT NOT NULL,
@@ -174,6 +414,31 @@ func (m *MariaDB) InitializeSchema() error {
 		return fmt.Errorf("unable to create query_history tables: %v", err)
 	}
 
+	// The audit log is kept when the database is flushed so that the flush itself can be audited
+	_, err = m.db.ExecContext(context.Background(), `
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGINT AUTO_INCREMENT NOT NULL,
+			timestamp TIMESTAMP(3) NOT NULL,
+			actor_kind VARCHAR(16) NOT NULL,
+			actor VARCHAR(255) NOT NULL,
+			token VARCHAR(64) NOT NULL,
+			source VARCHAR(64) NOT NULL,
+			method VARCHAR(16) NOT NULL,
+			endpoint VARCHAR(255) NOT NULL,
+			operation VARCHAR(64) NOT NULL,
+			assets BIGINT NOT NULL,
+			relations BIGINT NOT NULL,
+			status INT NOT NULL,
+			outcome VARCHAR(16) NOT NULL,
+			error TEXT,
+			CONSTRAINT pk_audit_log PRIMARY KEY (id),
+			INDEX timestamp_idx (timestamp),
+			INDEX actor_idx (actor),
+			INDEX source_idx (source))`)
+	if err != nil {
+		return fmt.Errorf("unable to create audit_log table: %v", err)
+	}
+
 	return nil
 }
 
@@ -226,44 +491,6 @@ func (m *MariaDB) resolveSourceID(ctx context.Context, sourceName string) (int,
 	return m.resolveSourceIDFromDB(ctx, sourceName)
 }
 
-func writeAsset(w io.Writer, asset knowledge.Asset) error {
-	_, err := w.Write([]byte(asset.Type))
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(zeroBytes)
-	if err != nil {
-		return err
-	}
-	_, err = w.Write([]byte(asset.Key))
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func hashAsset(asset knowledge.Asset) uint64 {
-	h := fnv.New64()
-	writeAsset(h, asset)
-	return h.Sum64()
-}
-
-func hashRelation(relation knowledge.Relation) uint64 {
-	h := fnv.New64()
-
-	rel := []byte(relation.Type)
-
-	writeAsset(h, knowledge.Asset(relation.From))
-
-	h.Write(zeroBytes)
-	h.Write(rel)
-	h.Write(zeroBytes)
-
-	writeAsset(h, knowledge.Asset(relation.To))
-
-	return h.Sum64()
-}
-
 // InTransaction make sure a function is properly using the transaction
 func InTransaction(db *sql.DB, txFunc func(*sql.Tx) error) (err error) {
 	tx, err := db.Begin()
@@ -284,414 +511,1313 @@ func InTransaction(db *sql.DB, txFunc func(*sql.Tx) error) (err error) {
 	return err
 }
 
-// InsertAssets insert multiple assets into the graph of the given source
-func (m *MariaDB) InsertAssets(ctx context.Context, source string, assets []knowledge.Asset) error {
+// bumpRevision increment the revision of the graph of the source and return the new revision
+func bumpRevision(ctx context.Context, tx *sql.Tx, sourceID int) (int64, error) {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO graph_revisions (source_id, revision) VALUES (?, 1)
+ON DUPLICATE KEY UPDATE revision = revision + 1`, sourceID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to increment revision of source with ID %d: %v", sourceID, err)
+	}
+
+	var revision int64
+	row := tx.QueryRowContext(ctx, "SELECT revision FROM graph_revisions WHERE source_id = ?", sourceID)
+	if err := row.Scan(&revision); err != nil {
+		return 0, fmt.Errorf("unable to read revision of source with ID %d: %v", sourceID, err)
+	}
+	return revision, nil
+}
+
+// changelogKind is the kind of the entity changed by a change recorded in the changelog
+type changelogKind string
+
+const (
+	changelogAsset    changelogKind = "asset"
+	changelogRelation changelogKind = "relation"
+)
+
+// logChange record a change made in a revision of the graph of the source
+func logChange(ctx context.Context, tx *sql.Tx, sourceID int, revision int64, kind changelogKind, id uint64, removed bool) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO graph_changelog (source_id, revision, kind, entity_id, removed, timestamp) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP())",
+		sourceID, revision, kind, id, removed)
+	if err != nil {
+		return fmt.Errorf("unable to record change in revision %d: %v", revision, err)
+	}
+	return nil
+}
+
+// GetRevision return the current revision of the graph of the source
+func (m *MariaDB) GetRevision(ctx context.Context, source string) (int64, error) {
+	var revision int64
+	row := m.db.QueryRowContext(ctx, `
+SELECT gr.revision FROM graph_revisions gr
+INNER JOIN sources s ON s.id = gr.source_id
+WHERE s.name = ?`, source)
+	if err := row.Scan(&revision); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("unable to read revision of source %s: %v", source, err)
+	}
+	return revision, nil
+}
+
+// ReadChanges return the current revision of the graph of the source and the changes made since the given revision
+func (m *MariaDB) ReadChanges(ctx context.Context, source string, since int64) (int64, []knowledge.GraphChange, error) {
 	sourceID, err := m.resolveSourceID(ctx, source)
 	if err != nil {
-		return fmt.Errorf("unable to resolve source ID of source %s for inserting assets: %v", source, err)
+		return 0, nil, fmt.Errorf("unable to resolve source ID of source %s for reading changes: %v", source, err)
 	}
 
-	return InTransaction(m.db, func(tx *sql.Tx) error {
-		for _, asset := range assets {
-			h := hashAsset(asset)
+	var revision, prunedRevision int64
+	changes := []knowledge.GraphChange{}
+	err = InTransaction(m.db, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, "SELECT revision, pruned_revision FROM graph_revisions WHERE source_id = ?", sourceID)
+		if err := row.Scan(&revision, &prunedRevision); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("unable to read revision of source %s: %v", source, err)
+		}
 
-			_, err = tx.ExecContext(ctx,
-				`INSERT INTO assets (id, type, value) VALUES (?, ?, ?)`,
-				h, asset.Type, asset.Key)
+		if since < prunedRevision || since > revision {
+			return fmt.Errorf("%w: changes since revision %d of source %s are not available (current revision is %d)",
+				knowledge.ErrRevisionTooOld, since, source, revision)
+		}
+
+		// The content of the added assets and relations is read from the graph. An added asset or relation which
+		// does not exist anymore has been removed by a later change.
+		rows, err := tx.QueryContext(ctx, `
+SELECT c.kind, c.entity_id, c.removed, a.type, a.value, r.type, af.type, af.value, at.type, at.value
+FROM graph_changelog c
+LEFT JOIN assets a ON c.kind = 'asset' AND NOT c.removed AND a.id = c.entity_id
+LEFT JOIN relations r ON c.kind = 'relation' AND NOT c.removed AND r.id = c.entity_id
+LEFT JOIN assets af ON af.id = r.from_id
+LEFT JOIN assets at ON at.id = r.to_id
+WHERE c.source_id = ? AND c.revision > ? AND c.revision <= ? ORDER BY c.id`,
+			sourceID, since, revision)
+		if err != nil {
+			return fmt.Errorf("unable to read changes of source %s: %v", source, err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var kind changelogKind
+			var id uint64
+			var removed bool
+			var assetType, assetKey, relationType, fromType, fromKey, toType, toKey sql.NullString
+			err := rows.Scan(&kind, &id, &removed, &assetType, &assetKey, &relationType, &fromType, &fromKey, &toType, &toKey)
 			if err != nil {
-				if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
-					// If the entry is duplicated, it's fine but we still need insert a line into assets_by_source.
-				} else {
-					return fmt.Errorf("unable to insert asset %v (%d) in DB from source %s: %v", asset, h, source, err)
-				}
+				return fmt.Errorf("unable to read change of source %s: %v", source, err)
 			}
 
-			_, err = tx.ExecContext(ctx,
-				`INSERT INTO assets_by_source (source_id, asset_id) VALUES (?, ?)`, sourceID, h)
-			if err != nil {
-				if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
-					// TODO(c.michaud): update the update_time?
-				} else {
-					return fmt.Errorf("unable to insert binding between asset %s (%d) and source %s: %v", asset, h, source, err)
+			change := knowledge.GraphChange{Removed: removed}
+			switch {
+			case removed && kind == changelogAsset:
+				change.AssetID = id
+			case removed && kind == changelogRelation:
+				change.RelationID = id
+			case kind == changelogAsset && assetType.Valid:
+				change.Asset = &knowledge.Asset{Type: schema.AssetType(assetType.String), Key: assetKey.String}
+			case kind == changelogRelation && relationType.Valid:
+				change.Relation = &knowledge.Relation{
+					Type: schema.RelationKeyType(relationType.String),
+					From: knowledge.AssetKey{Type: schema.AssetType(fromType.String), Key: fromKey.String},
+					To:   knowledge.AssetKey{Type: schema.AssetType(toType.String), Key: toKey.String},
 				}
+			default:
+				continue
 			}
+			changes = append(changes, change)
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return revision, changes, nil
 }
 
-// InsertRelations upsert one relation into the graph of the given source
-func (m *MariaDB) InsertRelations(ctx context.Context, source string, relations []knowledge.Relation) error {
+// PruneChanges remove the changes recorded before the given time, the sources will not be able to read the changes
+// since the pruned revisions anymore.
+func (m *MariaDB) PruneChanges(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	err := InTransaction(m.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+UPDATE graph_revisions gr
+INNER JOIN (
+	SELECT source_id, MAX(revision) AS revision FROM graph_changelog
+	WHERE timestamp < ? GROUP BY source_id) c ON c.source_id = gr.source_id
+SET gr.pruned_revision = GREATEST(gr.pruned_revision, c.revision)`, before)
+		if err != nil {
+			return fmt.Errorf("unable to update pruned revisions: %v", err)
+		}
+
+		res, err := tx.ExecContext(ctx, `
+DELETE c FROM graph_changelog c
+INNER JOIN graph_revisions gr ON gr.source_id = c.source_id
+WHERE c.revision <= gr.pruned_revision`)
+		if err != nil {
+			return fmt.Errorf("unable to prune changes: %v", err)
+		}
+		count, err = res.RowsAffected()
+		return err
+	})
+	return count, err
+}
+
+// GetChecksums compute the checksums of the graph of the source split in buckets
+func (m *MariaDB) GetChecksums(ctx context.Context, source string, buckets int) (knowledge.GraphChecksums, error) {
+	checksums := knowledge.GraphChecksums{
+		Assets:    make([]uint64, buckets),
+		Relations: make([]uint64, buckets),
+	}
+
 	sourceID, err := m.resolveSourceID(ctx, source)
 	if err != nil {
-		return fmt.Errorf("unable to resolve source ID of source %s for inserting relations: %v", source, err)
+		return checksums, fmt.Errorf("unable to resolve source ID of source %s for computing checksums: %v", source, err)
 	}
 
-	return InTransaction(m.db, func(tx *sql.Tx) error {
-		for _, relation := range relations {
-			// TODO(c.michaud): make the source compute the hash directly to reduce the size of the payload.
-			aFrom := hashAsset(knowledge.Asset(relation.From))
-			aTo := hashAsset(knowledge.Asset(relation.To))
-			rH := hashRelation(relation)
+	err = InTransaction(m.db, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, "SELECT revision FROM graph_revisions WHERE source_id = ?", sourceID)
+		if err := row.Scan(&checksums.Revision); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("unable to read revision of source %s: %v", source, err)
+		}
 
-			_, err = tx.ExecContext(ctx,
-				"INSERT INTO relations (id, from_id, to_id, type) VALUES (?, ?, ?, ?)",
-				rH, aFrom, aTo, relation.Type)
+		for _, q := range []struct {
+			query     string
+			checksums []uint64
+		}{
+			{"SELECT asset_id % ?, BIT_XOR(asset_id) FROM assets_by_source WHERE source_id = ? GROUP BY 1", checksums.Assets},
+			{"SELECT relation_id % ?, BIT_XOR(relation_id) FROM relations_by_source WHERE source_id = ? GROUP BY 1", checksums.Relations},
+		} {
+			rows, err := tx.QueryContext(ctx, q.query, buckets, sourceID)
 			if err != nil {
-				if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
-					// If the entry is duplicated, it's fine but we still need insert a line into relations_by_source.
-				} else {
-					return fmt.Errorf("unable insert relation %v (%d) in DB from source %s: %v", relation, rH, source, err)
-				}
+				return fmt.Errorf("unable to compute checksums of source %s: %v", source, err)
 			}
 
-			_, err = tx.ExecContext(ctx,
-				`INSERT INTO relations_by_source (source_id, relation_id) VALUES (?, ?)`, sourceID, rH)
-			if err != nil {
-				if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
-					// TODO(c.michaud): update the update_time?
-				} else {
-					return fmt.Errorf("unable to insert binding between relation %v (%d) and source %s: %v", relation, rH, source, err)
+			for rows.Next() {
+				var bucket int
+				var checksum uint64
+				if err := rows.Scan(&bucket, &checksum); err != nil {
+					rows.Close()
+					return fmt.Errorf("unable to read checksum of source %s: %v", source, err)
 				}
+				q.checksums[bucket] = checksum
 			}
+			rows.Close()
 		}
 		return nil
 	})
+	return checksums, err
 }
 
-// RemoveAssets remove one asset from the graph of the given source
-func (m *MariaDB) RemoveAssets(ctx context.Context, source string, assets []knowledge.Asset) error {
+// InsertAssets insert multiple assets into the graph of the given source
+func (m *MariaDB) InsertAssets(ctx context.Context, source string, assets []knowledge.Asset) (int64, error) {
 	sourceID, err := m.resolveSourceID(ctx, source)
 	if err != nil {
-		return fmt.Errorf("unable to resolve source ID of source %s for removing assets: %v", source, err)
+		return 0, fmt.Errorf("unable to resolve source ID of source %s for inserting assets: %v", source, err)
 	}
 
-	return InTransaction(m.db, func(tx *sql.Tx) error {
-		for _, asset := range assets {
-			h := hashAsset(asset)
+	if len(assets) == 0 {
+		return 0, nil
+	}
+	var revision int64
+	err = InTransaction(m.db, func(tx *sql.Tx) error {
+		var err error
+		if revision, err = bumpRevision(ctx, tx, sourceID); err != nil {
+			return err
+		}
+		return insertAssets(ctx, tx, source, sourceID, revision, assets)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
 
-			_, err = tx.ExecContext(ctx,
-				`DELETE FROM assets_by_source WHERE asset_id = ? AND source_id = ?`,
-				h, sourceID)
-			if err != nil {
-				return fmt.Errorf("unable to remove binding between asset %v (%d) and source %s: %v", asset, h, source, err)
+func insertAssets(ctx context.Context, tx *sql.Tx, source string, sourceID int, revision int64, assets []knowledge.Asset) error {
+	for _, asset := range assets {
+		h := knowledge.HashAsset(asset)
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO assets (id, type, value) VALUES (?, ?, ?)`,
+			h, asset.Type, asset.Key)
+		if err != nil {
+			if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
+				// If the entry is duplicated, it's fine but we still need insert a line into assets_by_source.
+			} else {
+				return fmt.Errorf("unable to insert asset %v (%d) in DB from source %s: %v", asset, h, source, err)
 			}
+		}
 
-			_, err = tx.ExecContext(ctx,
-				`DELETE FROM assets WHERE id = ? AND NOT EXISTS (
-			SELECT * FROM assets_by_source WHERE asset_id = ?
-		)`,
-				h, h)
-			if err != nil {
-				return fmt.Errorf("unable to remove asset %v (%d) from source %s: %v", asset, h, source, err)
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO assets_by_source (source_id, asset_id) VALUES (?, ?)`, sourceID, h)
+		if err != nil {
+			if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
+				// The asset was already bound to the source so there is no change to record.
+				// TODO(c.michaud): update the update_time?
+				continue
 			}
+			return fmt.Errorf("unable to insert binding between asset %s (%d) and source %s: %v", asset, h, source, err)
+		}
 
+		if err := logChange(ctx, tx, sourceID, revision, changelogAsset, h, false); err != nil {
+			return err
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
-// RemoveRelations remove relations from the graph of the given source
-func (m *MariaDB) RemoveRelations(ctx context.Context, source string, relations []knowledge.Relation) error {
+// InsertRelations upsert one relation into the graph of the given source
+func (m *MariaDB) InsertRelations(ctx context.Context, source string, relations []knowledge.Relation) (int64, error) {
 	sourceID, err := m.resolveSourceID(ctx, source)
 	if err != nil {
-		return fmt.Errorf("unable to resolve source ID of source %s for removing relations: %v", source, err)
+		return 0, fmt.Errorf("unable to resolve source ID of source %s for inserting relations: %v", source, err)
 	}
-	return InTransaction(m.db, func(tx *sql.Tx) error {
-		for _, relation := range relations {
-			rH := hashRelation(relation)
 
-			_, err = tx.ExecContext(ctx,
-				`DELETE FROM relations_by_source WHERE relation_id = ? AND source_id = ?`,
-				rH, sourceID)
-			if err != nil {
-				return fmt.Errorf("unable to remove binding between relation %v (%d) and source %s: %v", relation, rH, source, err)
+	if len(relations) == 0 {
+		return 0, nil
+	}
+	var revision int64
+	err = InTransaction(m.db, func(tx *sql.Tx) error {
+		var err error
+		if revision, err = bumpRevision(ctx, tx, sourceID); err != nil {
+			return err
+		}
+		return insertRelations(ctx, tx, source, sourceID, revision, relations)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+func insertRelations(ctx context.Context, tx *sql.Tx, source string, sourceID int, revision int64, relations []knowledge.Relation) error {
+	for _, relation := range relations {
+		// The IDs are computed by the server since the ones computed by a source could not be trusted
+		aFrom := knowledge.HashAsset(knowledge.Asset(relation.From))
+		aTo := knowledge.HashAsset(knowledge.Asset(relation.To))
+		rH := knowledge.HashRelation(relation)
+
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO relations (id, from_id, to_id, type) VALUES (?, ?, ?, ?)",
+			rH, aFrom, aTo, relation.Type)
+		if err != nil {
+			if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
+				// If the entry is duplicated, it's fine but we still need insert a line into relations_by_source.
+			} else {
+				return fmt.Errorf("unable insert relation %v (%d) in DB from source %s: %v", relation, rH, source, err)
 			}
+		}
 
-			_, err = tx.ExecContext(ctx,
-				`DELETE FROM relations WHERE id = ? AND NOT EXISTS (
-			SELECT * FROM relations_by_source WHERE relation_id = ?
-		)`, rH, rH)
-			if err != nil {
-				return fmt.Errorf("unable to remove relation %v (%d) from source %s: %v", relation, rH, source, err)
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO relations_by_source (source_id, relation_id) VALUES (?, ?)`, sourceID, rH)
+		if err != nil {
+			if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
+				// The relation was already bound to the source so there is no change to record.
+				// TODO(c.michaud): update the update_time?
+				continue
 			}
+			return fmt.Errorf("unable to insert binding between relation %v (%d) and source %s: %v", relation, rH, source, err)
 		}
-		return nil
-	})
+
+		if err := logChange(ctx, tx, sourceID, revision, changelogRelation, rH, false); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// ReadGraph read source subgraph
-func (m *MariaDB) ReadGraph(ctx context.Context, sourceName string, encoder *knowledge.GraphEncoder) error {
-	logrus.Debugf("Start reading graph of data source with name %s", sourceName)
-	sourceID, err := m.resolveSourceID(ctx, sourceName)
+// RemoveAssets remove one asset from the graph of the given source
+func (m *MariaDB) RemoveAssets(ctx context.Context, source string, assets []knowledge.Asset) (int64, error) {
+	sourceID, err := m.resolveSourceID(ctx, source)
 	if err != nil {
-		return fmt.Errorf("unable to resolve source ID from name %s: %v", sourceName, err)
+		return 0, fmt.Errorf("unable to resolve source ID of source %s for removing assets: %v", source, err)
 	}
 
-	now := time.Now()
-
+	if len(assets) == 0 {
+		return 0, nil
+	}
+	var revision int64
 	err = InTransaction(m.db, func(tx *sql.Tx) error {
-		{
-			// Select all relations produced by this source
-			rows, err := tx.QueryContext(ctx, `
-	SELECT a.type, a.value, b.type, b.value, r.type FROM relations_by_source rbs
-	INNER JOIN relations r ON rbs.relation_id = r.id
-	INNER JOIN assets a ON a.id=r.from_id
-	INNER JOIN assets b ON b.id=r.to_id
-	WHERE rbs.source_id = ?
-		`, sourceID)
-
-			if err != nil {
-				return fmt.Errorf("unable to retrieve relations: %v", err)
-			}
-			defer rows.Close()
-
-			for rows.Next() {
-				var FromType, ToType, FromKey, ToKey, Type string
-				if err := rows.Scan(&FromType, &FromKey, &ToType, &ToKey, &Type); err != nil {
-					return err
-				}
-				fromAsset := knowledge.AssetKey{
-					Type: schema.AssetType(FromType),
-					Key:  FromKey,
-				}
-				toAsset := knowledge.AssetKey{
-					Type: schema.AssetType(ToType),
-					Key:  ToKey,
-				}
-
-				relation := knowledge.Relation{
-					Type: schema.RelationKeyType(Type),
-					From: fromAsset,
-					To:   toAsset,
-				}
-
-				err = encoder.EncodeRelation(relation)
-				if err != nil {
-					return fmt.Errorf("unable to write relation %v: %v", relation, err)
-				}
-			}
+		var err error
+		if revision, err = bumpRevision(ctx, tx, sourceID); err != nil {
+			return err
 		}
+		return removeAssets(ctx, tx, source, sourceID, revision, assets)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
 
-		{
-			// Select all assets produced by this source. This is useful in case there are some standalone nodes in the graph of the source.
-			// TODO(c.michaud): optimization could be done by only selecting assets without any relation since the others have already have been retrieved in the previous query.
-			rows, err := tx.QueryContext(ctx, `
-	SELECT a.type, a.value FROM assets_by_source abs
-	INNER JOIN assets a ON a.id=abs.asset_id
-	WHERE abs.source_id = ?
-		`, sourceID)
-
-			if err != nil {
-				return fmt.Errorf("unable to retrieve assets: %v", err)
-			}
-			defer rows.Close()
+func removeAssets(ctx context.Context, tx *sql.Tx, source string, sourceID int, revision int64, assets []knowledge.Asset) error {
+	for _, asset := range assets {
+		h := knowledge.HashAsset(asset)
 
-			for rows.Next() {
-				var Key, Type string
-				if err := rows.Scan(&Type, &Key); err != nil {
-					return fmt.Errorf("unable to read standalone asset: %v", err)
-				}
+		res, err := tx.ExecContext(ctx,
+			`DELETE FROM assets_by_source WHERE asset_id = ? AND source_id = ?`,
+			h, sourceID)
+		if err != nil {
+			return fmt.Errorf("unable to remove binding between asset %v (%d) and source %s: %v", asset, h, source, err)
+		}
+		unbound, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("unable to count removed bindings between asset %v (%d) and source %s: %v", asset, h, source, err)
+		}
+		if unbound == 0 {
+			// The asset was not bound to the source so there is no change to record.
+			continue
+		}
 
-				asset := knowledge.Asset{
-					Type: schema.AssetType(Type),
-					Key:  Key,
-				}
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM assets WHERE id = ? AND NOT EXISTS (
+			SELECT * FROM assets_by_source WHERE asset_id = ?
+		)`,
+			h, h)
+		if err != nil {
+			return fmt.Errorf("unable to remove asset %v (%d) from source %s: %v", asset, h, source, err)
+		}
 
-				err := encoder.EncodeAsset(asset)
-				if err != nil {
-					return fmt.Errorf("unable to write asset %v: %v", asset, err)
-				}
-			}
+		if err := logChange(ctx, tx, sourceID, revision, changelogAsset, h, true); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		return nil
+// RemoveRelations remove relations from the graph of the given source
+func (m *MariaDB) RemoveRelations(ctx context.Context, source string, relations []knowledge.Relation) (int64, error) {
+	sourceID, err := m.resolveSourceID(ctx, source)
+	if err != nil {
+		return 0, fmt.Errorf("unable to resolve source ID of source %s for removing relations: %v", source, err)
+	}
+	if len(relations) == 0 {
+		return 0, nil
+	}
+	var revision int64
+	err = InTransaction(m.db, func(tx *sql.Tx) error {
+		var err error
+		if revision, err = bumpRevision(ctx, tx, sourceID); err != nil {
+			return err
+		}
+		return removeRelations(ctx, tx, source, sourceID, revision, relations)
 	})
 	if err != nil {
-		return fmt.Errorf("unable to handle transaction: %v", err)
+		return 0, err
 	}
-
-	elapsed := time.Since(now)
-	logrus.Debugf("Read graph of data source with name %s in %fs", sourceName, elapsed.Seconds())
-	return nil
+	return revision, nil
 }
 
-// FlushAll flush the database
-func (m *MariaDB) FlushAll(ctx context.Context) error {
-	return InTransaction(m.db, func(tx *sql.Tx) error {
-		_, err := tx.ExecContext(ctx, "DROP TABLE relations_by_source")
-		if err != nil {
-			if !isUnknownTableError(err) {
-				return err
-			}
-		}
+func removeRelations(ctx context.Context, tx *sql.Tx, source string, sourceID int, revision int64, relations []knowledge.Relation) error {
+	for _, relation := range relations {
+		rH := knowledge.HashRelation(relation)
 
-		_, err = tx.ExecContext(ctx, "DROP TABLE assets_by_source")
+		res, err := tx.ExecContext(ctx,
+			`DELETE FROM relations_by_source WHERE relation_id = ? AND source_id = ?`,
+			rH, sourceID)
 		if err != nil {
-			if !isUnknownTableError(err) {
-				return err
-			}
+			return fmt.Errorf("unable to remove binding between relation %v (%d) and source %s: %v", relation, rH, source, err)
 		}
-
-		_, err = tx.ExecContext(ctx, "DROP TABLE relations")
+		unbound, err := res.RowsAffected()
 		if err != nil {
-			if !isUnknownTableError(err) {
-				return err
-			}
+			return fmt.Errorf("unable to count removed bindings between relation %v (%d) and source %s: %v", relation, rH, source, err)
 		}
-
-		_, err = tx.ExecContext(ctx, "DROP TABLE assets")
-		if err != nil {
-			if !isUnknownTableError(err) {
-				return err
-			}
+		if unbound == 0 {
+			// The relation was not bound to the source so there is no change to record.
+			continue
 		}
 
-		_, err = tx.ExecContext(ctx, "DROP TABLE graph_schema")
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM relations WHERE id = ? AND NOT EXISTS (
+			SELECT * FROM relations_by_source WHERE relation_id = ?
+		)`, rH, rH)
 		if err != nil {
-			if !isUnknownTableError(err) {
-				return err
-			}
+			return fmt.Errorf("unable to remove relation %v (%d) from source %s: %v", relation, rH, source, err)
 		}
 
-		_, err = tx.ExecContext(ctx, "DROP TABLE query_history")
-		if err != nil {
-			if !isUnknownTableError(err) {
-				return err
-			}
+		if err := logChange(ctx, tx, sourceID, revision, changelogRelation, rH, true); err != nil {
+			return err
 		}
-		return nil
-	})
-}
-
-// CountAssets count the total number of assets in db.
-func (m *MariaDB) CountAssets(ctx context.Context) (int64, error) {
-	var count int64
-	row := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets")
-	return count, row.Scan(&count)
+	}
+	return nil
 }
 
-// CountAssetsBySource count the total number of assets in db by source
-func (m *MariaDB) CountAssetsBySource(ctx context.Context) (map[string]int64, error) {
-	res := map[string]int64{}
-	rows, err := m.db.QueryContext(ctx, `
-		SELECT s.name, COUNT(*)
-		FROM assets_by_source r
-		JOIN sources s on r.source_id = s.id
-		GROUP BY (source_id);
-	`)
+// BeginTransaction open a staged transaction for the source which expires after ttl without activity
+func (m *MariaDB) BeginTransaction(ctx context.Context, source string, ttl time.Duration) (string, error) {
+	sourceID, err := m.resolveSourceID(ctx, source)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("unable to resolve source ID of source %s for beginning a transaction: %v", source, err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		name := ""
-		count := int64(0)
-		err := rows.Scan(&name, &count)
-		if err != nil {
-			return nil, err
-		}
 
-		res[name] = count
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate transaction ID: %v", err)
 	}
+	id := hex.EncodeToString(b)
 
-	return res, nil
+	ttlSeconds := int(ttl.Seconds())
+	_, err = m.db.ExecContext(ctx, `
+INSERT INTO staged_transactions (id, source_id, ttl_seconds, expires_at)
+VALUES (?, ?, ?, TIMESTAMPADD(SECOND, ?, CURRENT_TIMESTAMP()))`,
+		id, sourceID, ttlSeconds, ttlSeconds)
+	if err != nil {
+		return "", fmt.Errorf("unable to create transaction of source %s: %v", source, err)
+	}
+	return id, nil
 }
 
-// CountRelations count the total number of relations in db.
-func (m *MariaDB) CountRelations(ctx context.Context) (int64, error) {
-	var count int64
-	row := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM relations")
-	return count, row.Scan(&count)
+// lockTransaction lock the transaction of the source, extend its expiration and return the ID of the source
+func lockTransaction(ctx context.Context, tx *sql.Tx, source, id string) (int, error) {
+	row := tx.QueryRowContext(ctx, `
+SELECT t.source_id FROM staged_transactions t
+INNER JOIN sources s ON s.id = t.source_id
+WHERE t.id = ? AND s.name = ? AND t.expires_at > CURRENT_TIMESTAMP()
+FOR UPDATE`, id, source)
+
+	var sourceID int
+	if err := row.Scan(&sourceID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: %s", knowledge.ErrTransactionNotFound, id)
+		}
+		return 0, fmt.Errorf("unable to read transaction %s: %v", id, err)
+	}
+
+	_, err := tx.ExecContext(ctx,
+		"UPDATE staged_transactions SET expires_at = TIMESTAMPADD(SECOND, ttl_seconds, CURRENT_TIMESTAMP()) WHERE id = ?", id)
+	if err != nil {
+		return 0, fmt.Errorf("unable to extend expiration of transaction %s: %v", id, err)
+	}
+	return sourceID, nil
 }
 
-// CountRelationsBySource count the total number of relations in db by source.
-func (m *MariaDB) CountRelationsBySource(ctx context.Context) (map[string]int64, error) {
-	res := map[string]int64{}
-	rows, err := m.db.QueryContext(ctx, `
-		SELECT s.name, COUNT(*)
-		FROM relations_by_source r
-		JOIN sources s on r.source_id = s.id
-		GROUP BY (source_id);
-	`)
+// StageSchema stage the schema of the source in the transaction, it replaces any previously staged schema
+func (m *MariaDB) StageSchema(ctx context.Context, source, id string, sg schema.SchemaGraph) error {
+	b, err := json.Marshal(sg)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("unable to json encode schema: %v", err)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		name := ""
-		count := int64(0)
-		err := rows.Scan(&name, &count)
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		if _, err := lockTransaction(ctx, tx, source, id); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE staged_transactions SET graph_schema = ? WHERE id = ?", string(b), id)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("unable to stage schema in transaction %s: %v", id, err)
 		}
+		return nil
+	})
+}
 
-		res[name] = count
+func stageOperation(ctx context.Context, db *sql.DB, source, id string, operation knowledge.StagedOperation, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to json encode staged operation: %v", err)
 	}
 
-	return res, nil
-}
+	return InTransaction(db, func(tx *sql.Tx) error {
+		if _, err := lockTransaction(ctx, tx, source, id); err != nil {
+			return err
+		}
 
-// Close close the connection to maria
-func (m *MariaDB) Close() error {
-	return m.db.Close()
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO staged_operations (transaction_id, operation, payload) VALUES (?, ?, ?)",
+			id, operation, string(b))
+		if err != nil {
+			return fmt.Errorf("unable to stage operation %s in transaction %s: %v", operation, id, err)
+		}
+		return nil
+	})
 }
 
-// Query the database with provided intermediate query representation
-func (m *MariaDB) Query(ctx context.Context, sqlTranslation knowledge.SQLTranslation) (*knowledge.GraphQueryResult, error) {
-	deadline, ok := ctx.Deadline()
+// StageAssets stage assets to insert or remove in the transaction
+func (m *MariaDB) StageAssets(ctx context.Context, source, id string, operation knowledge.StagedOperation, assets []knowledge.Asset) error {
+	if operation != knowledge.InsertAssetsOperation && operation != knowledge.RemoveAssetsOperation {
+		return fmt.Errorf("operation %s cannot be applied on assets", operation)
+	}
+	return stageOperation(ctx, m.db, source, id, operation, assets)
+}
 
-	// If there is a deadline, we make sure the query stops right after it has been reached.
-	if ok {
-		// Query can take 35 seconds max before being aborted...
-		sqlTranslation.Query = fmt.Sprintf("SET STATEMENT max_statement_time=%f FOR %s", time.Until(deadline).Seconds()+5, sqlTranslation.Query)
+// StageRelations stage relations to insert or remove in the transaction
+func (m *MariaDB) StageRelations(ctx context.Context, source, id string, operation knowledge.StagedOperation, relations []knowledge.Relation) error {
+	if operation != knowledge.InsertRelationsOperation && operation != knowledge.RemoveRelationsOperation {
+		return fmt.Errorf("operation %s cannot be applied on relations", operation)
 	}
+	return stageOperation(ctx, m.db, source, id, operation, relations)
+}
 
-	user := kbcontext.XForwardedUser(ctx)
+// stagedOperationsBatchSize is the number of staged operations read at once when a transaction is committed. Each
+// staged operation holds one chunk of the items uploaded by the source.
+const stagedOperationsBatchSize = 10
 
-	logrus.Debugf("Query to be executed for user %s: %s", user, sqlTranslation.Query)
+// readStagedSchema read the schema staged in the transaction, it is nil when no schema has been staged
+func readStagedSchema(ctx context.Context, tx *sql.Tx, id string) (*schema.SchemaGraph, error) {
+	var rawSchema sql.NullString
+	row := tx.QueryRowContext(ctx, "SELECT graph_schema FROM staged_transactions WHERE id = ?", id)
+	if err := row.Scan(&rawSchema); err != nil {
+		return nil, fmt.Errorf("unable to read staged schema of transaction %s: %v", id, err)
+	}
+	if !rawSchema.Valid {
+		return nil, nil
+	}
+	sg := schema.NewSchemaGraph()
+	if err := json.Unmarshal([]byte(rawSchema.String), &sg); err != nil {
+		return nil, fmt.Errorf("unable to decode staged schema of transaction %s: %v", id, err)
+	}
+	return &sg, nil
+}
 
-	cursor, err := NewMariaDBCursor(ctx, m.db, sqlTranslation)
+// readStagedBatches read the next batch of staged operations of the given kind following the operation with ID
+// afterID. It returns the batches and the ID of the last operation read. The rows are read entirely before the
+// batches are applied since the connection of the transaction cannot run other statements while rows are open.
+func readStagedBatches(ctx context.Context, tx *sql.Tx, id string, operation knowledge.StagedOperation, afterID uint64) ([]knowledge.StagedBatch, uint64, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT id, payload FROM staged_operations
+WHERE transaction_id = ? AND operation = ? AND id > ?
+ORDER BY id LIMIT ?`, id, operation, afterID, stagedOperationsBatchSize)
 	if err != nil {
-		return nil, err
+		return nil, 0, fmt.Errorf("unable to read staged operations of transaction %s: %v", id, err)
 	}
+	defer rows.Close()
 
-	res := new(knowledge.GraphQueryResult)
-	res.Cursor = cursor
-	res.Projections = sqlTranslation.ProjectionTypes
-	return res, nil
-}
+	batches := []knowledge.StagedBatch{}
+	lastID := afterID
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&lastID, &payload); err != nil {
+			return nil, 0, fmt.Errorf("unable to read staged operation of transaction %s: %v", id, err)
+		}
 
-func (m *MariaDB) GetAssetSources(ctx context.Context, ids []string) (map[string][]string, error) {
-	if len(ids) == 0 {
-		return nil, nil
+		batch := knowledge.StagedBatch{Operation: operation}
+		switch operation {
+		case knowledge.InsertAssetsOperation, knowledge.RemoveAssetsOperation:
+			err = json.Unmarshal([]byte(payload), &batch.Assets)
+		case knowledge.InsertRelationsOperation, knowledge.RemoveRelationsOperation:
+			err = json.Unmarshal([]byte(payload), &batch.Relations)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to decode staged operation %s of transaction %s: %v", operation, id, err)
+		}
+		batches = append(batches, batch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("unable to read staged operations of transaction %s: %v", id, err)
 	}
+	return batches, lastID, nil
+}
 
-	args := make([]interface{}, len(ids))
-	for i, id := range ids {
-		args[i] = id
+// applyStagedBatch apply a batch of staged operations to the graph of the source
+func applyStagedBatch(ctx context.Context, tx *sql.Tx, source string, sourceID int, revision int64, batch knowledge.StagedBatch) error {
+	switch batch.Operation {
+	case knowledge.InsertAssetsOperation:
+		return insertAssets(ctx, tx, source, sourceID, revision, batch.Assets)
+	case knowledge.InsertRelationsOperation:
+		return insertRelations(ctx, tx, source, sourceID, revision, batch.Relations)
+	case knowledge.RemoveRelationsOperation:
+		return removeRelations(ctx, tx, source, sourceID, revision, batch.Relations)
+	case knowledge.RemoveAssetsOperation:
+		return removeAssets(ctx, tx, source, sourceID, revision, batch.Assets)
 	}
+	return fmt.Errorf("unknown staged operation %s", batch.Operation)
+}
 
-	idsSet := make(map[string][]string)
-	argsSlices := utils.ChunkSlice(args, 500).([][]interface{})
+// countSourceGraphInTx count the assets and relations bound to the source, including the changes of the transaction
+func countSourceGraphInTx(ctx context.Context, tx *sql.Tx, sourceID int) (int64, int64, error) {
+	var assets, relations int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM assets_by_source WHERE source_id = ?),
+			(SELECT COUNT(*) FROM relations_by_source WHERE source_id = ?)`,
+		sourceID, sourceID).Scan(&assets, &relations)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to count the graph of source with ID %d: %v", sourceID, err)
+	}
+	return assets, relations, nil
+}
 
-	for _, argsSlice := range argsSlices {
-		stmt, err := m.db.PrepareContext(ctx, `
-SELECT asset_id, sources.name FROM sources
-INNER JOIN assets_by_source ON sources.id = assets_by_source.source_id
-WHERE asset_id IN (?`+strings.Repeat(",?", len(argsSlice)-1)+`)`)
+// CommitTransaction apply all the changes staged in the transaction in one database transaction. The staged
+// operations are streamed by batches so that the transaction is never entirely held in memory.
+func (m *MariaDB) CommitTransaction(ctx context.Context, source, id string, hooks knowledge.CommitHooks) (int64, error) {
+	var revision int64
+	err := InTransaction(m.db, func(tx *sql.Tx) error {
+		sourceID, err := lockTransaction(ctx, tx, source, id)
 		if err != nil {
-			return nil, fmt.Errorf("unable to prepare statement for retrieving asset sources: %w", err)
+			return err
 		}
-		defer stmt.Close()
 
-		row, err := stmt.QueryContext(ctx, argsSlice...)
+		staged, err := readStagedSchema(ctx, tx, id)
 		if err != nil {
-			return nil, fmt.Errorf("unable to retrieve sources for assets: %w", err)
+			return err
 		}
-		defer row.Close()
+		sg, err := hooks.PrepareSchema(staged)
+		if err != nil {
+			return err
+		}
+		if sg != nil {
+			b, err := json.Marshal(sg)
+			if err != nil {
+				return fmt.Errorf("unable to json encode schema: %v", err)
+			}
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO graph_schema (source_id, graph, timestamp) VALUES (?, ?, CURRENT_TIMESTAMP())",
+				sourceID, string(b))
+			if err != nil {
+				return fmt.Errorf("unable to save schema in DB: %v", err)
+			}
+		}
+
+		var stats knowledge.CommitStats
+		stats.AssetsBefore, stats.RelationsBefore, err = countSourceGraphInTx(ctx, tx, sourceID)
+		if err != nil {
+			return err
+		}
+
+		revision, err = bumpRevision(ctx, tx, sourceID)
+		if err != nil {
+			return err
+		}
+
+		operations := []knowledge.StagedOperation{
+			knowledge.InsertAssetsOperation,
+			knowledge.InsertRelationsOperation,
+			knowledge.RemoveRelationsOperation,
+			knowledge.RemoveAssetsOperation,
+		}
+		for _, operation := range operations {
+			var lastID uint64
+			for {
+				var batches []knowledge.StagedBatch
+				batches, lastID, err = readStagedBatches(ctx, tx, id, operation, lastID)
+				if err != nil {
+					return err
+				}
+				if len(batches) == 0 {
+					break
+				}
+				for _, batch := range batches {
+					if err := hooks.CheckBatch(batch); err != nil {
+						return err
+					}
+					if err := applyStagedBatch(ctx, tx, source, sourceID, revision, batch); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		stats.AssetsAfter, stats.RelationsAfter, err = countSourceGraphInTx(ctx, tx, sourceID)
+		if err != nil {
+			return err
+		}
+		if err := hooks.Check(stats); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM staged_transactions WHERE id = ?", id); err != nil {
+			return fmt.Errorf("unable to remove committed transaction %s: %v", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// AbortTransaction discard the changes staged in the transaction
+func (m *MariaDB) AbortTransaction(ctx context.Context, source, id string) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		if _, err := lockTransaction(ctx, tx, source, id); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM staged_transactions WHERE id = ?", id); err != nil {
+			return fmt.Errorf("unable to remove aborted transaction %s: %v", id, err)
+		}
+		return nil
+	})
+}
+
+// ExpireTransactions discard the transactions which have expired
+func (m *MariaDB) ExpireTransactions(ctx context.Context) (int64, error) {
+	res, err := m.db.ExecContext(ctx, "DELETE FROM staged_transactions WHERE expires_at <= CURRENT_TIMESTAMP()")
+	if err != nil {
+		return 0, fmt.Errorf("unable to remove expired transactions: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// AddRunRemovals lock the removals of the run of the source, call check with the removals of the run including the
+// given number of assets and relations and record them if check succeeds
+func (m *MariaDB) AddRunRemovals(ctx context.Context, source, run string, removed int64, ttl time.Duration, check func(knowledge.RunRemovals) error) error {
+	sourceID, err := m.resolveSourceID(ctx, source)
+	if err != nil {
+		return fmt.Errorf("unable to resolve source ID of source %s for counting removals: %v", source, err)
+	}
+
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		// A run which expired but has not been reaped yet starts over
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM source_runs WHERE source_id = ? AND run_id = ? AND expires_at <= CURRENT_TIMESTAMP()",
+			sourceID, run)
+		if err != nil {
+			return fmt.Errorf("unable to remove expired run %q of source %s: %v", run, source, err)
+		}
+
+		// The run is locked so that the concurrent requests of the run are counted one after the other
+		_, err = tx.ExecContext(ctx,
+			"INSERT IGNORE INTO source_runs (source_id, run_id, expires_at) VALUES (?, ?, CURRENT_TIMESTAMP())",
+			sourceID, run)
+		if err != nil {
+			return fmt.Errorf("unable to create run %q of source %s: %v", run, source, err)
+		}
+		var total sql.NullInt64
+		var removals knowledge.RunRemovals
+		row := tx.QueryRowContext(ctx,
+			"SELECT total, removed FROM source_runs WHERE source_id = ? AND run_id = ? FOR UPDATE", sourceID, run)
+		if err := row.Scan(&total, &removals.Removed); err != nil {
+			return fmt.Errorf("unable to read removals of run %q of source %s: %v", run, source, err)
+		}
+
+		if total.Valid {
+			removals.Total = total.Int64
+		} else {
+			assets, relations, err := countSourceGraphInTx(ctx, tx, sourceID)
+			if err != nil {
+				return err
+			}
+			removals.Total = assets + relations
+		}
+		removals.Removed += removed
+		if err := check(removals); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+UPDATE source_runs SET total = ?, removed = ?, expires_at = TIMESTAMPADD(SECOND, ?, CURRENT_TIMESTAMP())
+WHERE source_id = ? AND run_id = ?`,
+			removals.Total, removals.Removed, int64(ttl/time.Second), sourceID, run)
+		if err != nil {
+			return fmt.Errorf("unable to record removals of run %q of source %s: %v", run, source, err)
+		}
+		return nil
+	})
+}
+
+// RecordRunRevision record a revision produced by the run of the source
+func (m *MariaDB) RecordRunRevision(ctx context.Context, source, run string, revision int64, ttl time.Duration) error {
+	sourceID, err := m.resolveSourceID(ctx, source)
+	if err != nil {
+		return fmt.Errorf("unable to resolve source ID of source %s for recording revision: %v", source, err)
+	}
+
+	// The removals of a run which expired but has not been reaped yet are forgotten, the expiry is updated last
+	_, err = m.db.ExecContext(ctx, `
+INSERT INTO source_runs (source_id, run_id, revision, expires_at)
+VALUES (?, ?, ?, TIMESTAMPADD(SECOND, ?, CURRENT_TIMESTAMP()))
+ON DUPLICATE KEY UPDATE
+	total = IF(expires_at <= CURRENT_TIMESTAMP(), NULL, total),
+	removed = IF(expires_at <= CURRENT_TIMESTAMP(), 0, removed),
+	revision = GREATEST(revision, VALUES(revision)),
+	expires_at = VALUES(expires_at)`,
+		sourceID, run, revision, int64(ttl/time.Second))
+	if err != nil {
+		return fmt.Errorf("unable to record revision of run %q of source %s: %v", run, source, err)
+	}
+	return nil
+}
+
+// CompleteRun forget the run of the source and return the last revision it produced
+func (m *MariaDB) CompleteRun(ctx context.Context, source, run string) (int64, error) {
+	sourceID, err := m.resolveSourceID(ctx, source)
+	if err != nil {
+		return 0, fmt.Errorf("unable to resolve source ID of source %s for completing run: %v", source, err)
+	}
+
+	var revision int64
+	err = InTransaction(m.db, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx,
+			"SELECT revision FROM source_runs WHERE source_id = ? AND run_id = ? FOR UPDATE", sourceID, run)
+		if err := row.Scan(&revision); err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return fmt.Errorf("unable to read revision of run %q of source %s: %v", run, source, err)
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM source_runs WHERE source_id = ? AND run_id = ?", sourceID, run)
+		if err != nil {
+			return fmt.Errorf("unable to remove run %q of source %s: %v", run, source, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// ExpireRuns forget the runs which have expired
+func (m *MariaDB) ExpireRuns(ctx context.Context) (int64, error) {
+	res, err := m.db.ExecContext(ctx, "DELETE FROM source_runs WHERE expires_at <= CURRENT_TIMESTAMP()")
+	if err != nil {
+		return 0, fmt.Errorf("unable to remove expired runs: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+// ReadGraph read source subgraph
+func (m *MariaDB) ReadGraph(ctx context.Context, sourceName string, encoder *knowledge.GraphEncoder) error {
+	return m.readGraph(ctx, sourceName, "", "", nil, nil, encoder)
+}
+
+// ReadGraphBuckets read the assets and relations of the source subgraph falling in the given buckets
+func (m *MariaDB) ReadGraphBuckets(ctx context.Context, sourceName string, buckets int, assetBuckets, relationBuckets []int, encoder *knowledge.GraphEncoder) error {
+	bucketsFilter := func(column string, selected []int) (string, []interface{}) {
+		if len(selected) == 0 {
+			return " AND FALSE", nil
+		}
+		args := []interface{}{buckets}
+		for _, b := range selected {
+			args = append(args, b)
+		}
+		return fmt.Sprintf(" AND %s %% ? IN (?%s)", column, strings.Repeat(",?", len(selected)-1)), args
+	}
+
+	assetsFilter, assetsArgs := bucketsFilter("abs.asset_id", assetBuckets)
+	relationsFilter, relationsArgs := bucketsFilter("rbs.relation_id", relationBuckets)
+	return m.readGraph(ctx, sourceName, relationsFilter, assetsFilter, relationsArgs, assetsArgs, encoder)
+}
+
+func (m *MariaDB) readGraph(ctx context.Context, sourceName string, relationsFilter, assetsFilter string,
+	relationsArgs, assetsArgs []interface{}, encoder *knowledge.GraphEncoder) error {
+	logrus.Debugf("Start reading graph of data source with name %s", sourceName)
+	sourceID, err := m.resolveSourceID(ctx, sourceName)
+	if err != nil {
+		return fmt.Errorf("unable to resolve source ID from name %s: %v", sourceName, err)
+	}
+
+	now := time.Now()
+
+	err = InTransaction(m.db, func(tx *sql.Tx) error {
+		{
+			// Select all relations produced by this source
+			rows, err := tx.QueryContext(ctx, `
+	SELECT a.type, a.value, b.type, b.value, r.type FROM relations_by_source rbs
+	INNER JOIN relations r ON rbs.relation_id = r.id
+	INNER JOIN assets a ON a.id=r.from_id
+	INNER JOIN assets b ON b.id=r.to_id
+	WHERE rbs.source_id = ?`+relationsFilter, append([]interface{}{sourceID}, relationsArgs...)...)
+
+			if err != nil {
+				return fmt.Errorf("unable to retrieve relations: %v", err)
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var FromType, ToType, FromKey, ToKey, Type string
+				if err := rows.Scan(&FromType, &FromKey, &ToType, &ToKey, &Type); err != nil {
+					return err
+				}
+				fromAsset := knowledge.AssetKey{
+					Type: schema.AssetType(FromType),
+					Key:  FromKey,
+				}
+				toAsset := knowledge.AssetKey{
+					Type: schema.AssetType(ToType),
+					Key:  ToKey,
+				}
+
+				relation := knowledge.Relation{
+					Type: schema.RelationKeyType(Type),
+					From: fromAsset,
+					To:   toAsset,
+				}
+
+				err = encoder.EncodeRelation(relation)
+				if err != nil {
+					return fmt.Errorf("unable to write relation %v: %v", relation, err)
+				}
+			}
+		}
+
+		{
+			// Select all assets produced by this source. This is useful in case there are some standalone nodes in the graph of the source.
+			// TODO(c.michaud): optimization could be done by only selecting assets without any relation since the others have already have been retrieved in the previous query.
+			rows, err := tx.QueryContext(ctx, `
+	SELECT a.type, a.value FROM assets_by_source abs
+	INNER JOIN assets a ON a.id=abs.asset_id
+	WHERE abs.source_id = ?`+assetsFilter, append([]interface{}{sourceID}, assetsArgs...)...)
+
+			if err != nil {
+				return fmt.Errorf("unable to retrieve assets: %v", err)
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var Key, Type string
+				if err := rows.Scan(&Type, &Key); err != nil {
+					return fmt.Errorf("unable to read standalone asset: %v", err)
+				}
+
+				asset := knowledge.Asset{
+					Type: schema.AssetType(Type),
+					Key:  Key,
+				}
+
+				err := encoder.EncodeAsset(asset)
+				if err != nil {
+					return fmt.Errorf("unable to write asset %v: %v", asset, err)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to handle transaction: %v", err)
+	}
+
+	elapsed := time.Since(now)
+	logrus.Debugf("Read graph of data source with name %s in %fs", sourceName, elapsed.Seconds())
+	return nil
+}
+
+// FlushAll flush the database
+func (m *MariaDB) FlushAll(ctx context.Context) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		// The staged operations refer to the staged transactions, they must be dropped first
+		_, err := tx.ExecContext(ctx, "DROP TABLE staged_operations")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE staged_transactions")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE source_runs")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		// The same-as links refer to the assets, they must be dropped first
+		_, err = tx.ExecContext(ctx, "DROP TABLE asset_same_as")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE entity_resolution_rules")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE relations_by_source")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE assets_by_source")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE relations")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE assets")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE graph_changelog")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE graph_revisions")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE graph_schema")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DROP TABLE query_history")
+		if err != nil {
+			if !isUnknownTableError(err) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CountAssets count the total number of assets in db.
+func (m *MariaDB) CountAssets(ctx context.Context) (int64, error) {
+	var count int64
+	row := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM assets")
+	return count, row.Scan(&count)
+}
+
+// CountAssetsBySource count the total number of assets in db by source
+func (m *MariaDB) CountAssetsBySource(ctx context.Context) (map[string]int64, error) {
+	res := map[string]int64{}
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT s.name, COUNT(*)
+		FROM assets_by_source r
+		JOIN sources s on r.source_id = s.id
+		GROUP BY (source_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		name := ""
+		count := int64(0)
+		err := rows.Scan(&name, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		res[name] = count
+	}
+
+	return res, nil
+}
+
+// CountRelations count the total number of relations in db.
+func (m *MariaDB) CountRelations(ctx context.Context) (int64, error) {
+	var count int64
+	row := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM relations")
+	return count, row.Scan(&count)
+}
+
+// CountRelationsBySource count the total number of relations in db by source.
+func (m *MariaDB) CountRelationsBySource(ctx context.Context) (map[string]int64, error) {
+	res := map[string]int64{}
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT s.name, COUNT(*)
+		FROM relations_by_source r
+		JOIN sources s on r.source_id = s.id
+		GROUP BY (source_id);
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		name := ""
+		count := int64(0)
+		err := rows.Scan(&name, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		res[name] = count
+	}
+
+	return res, nil
+}
+
+// CountSourceGraph count the assets and relations of the graph of the source
+func (m *MariaDB) CountSourceGraph(ctx context.Context, sourceName string) (int64, int64, error) {
+	var assets, relations int64
+	err := m.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM assets_by_source a INNER JOIN sources s ON s.id = a.source_id WHERE s.name = ?),
+			(SELECT COUNT(*) FROM relations_by_source r INNER JOIN sources s ON s.id = r.source_id WHERE s.name = ?)`,
+		sourceName, sourceName).Scan(&assets, &relations)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to count the graph of source %s: %v", sourceName, err)
+	}
+	return assets, relations, nil
+}
+
+// CountBoundAssets count the assets among the given IDs which are already bound to the source
+func (m *MariaDB) CountBoundAssets(ctx context.Context, sourceName string, ids []uint64) (int64, error) {
+	return m.countBound(ctx, "assets_by_source", "asset_id", sourceName, ids)
+}
+
+// CountBoundRelations count the relations among the given IDs which are already bound to the source
+func (m *MariaDB) CountBoundRelations(ctx context.Context, sourceName string, ids []uint64) (int64, error) {
+	return m.countBound(ctx, "relations_by_source", "relation_id", sourceName, ids)
+}
+
+func (m *MariaDB) countBound(ctx context.Context, table, column, sourceName string, ids []uint64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	sourceID, err := m.resolveSourceID(ctx, sourceName)
+	if err != nil {
+		return 0, fmt.Errorf("unable to resolve source ID of source %s: %v", sourceName, err)
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	var bound int64
+	for _, argsSlice := range utils.ChunkSlice(args, 500).([][]interface{}) {
+		var count int64
+		err := m.db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM "+table+" WHERE source_id = ? AND "+column+" IN (?"+strings.Repeat(",?", len(argsSlice)-1)+")",
+			append([]interface{}{sourceID}, argsSlice...)...).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("unable to count the items bound to source %s: %v", sourceName, err)
+		}
+		bound += count
+	}
+	return bound, nil
+}
+
+// FindConstraintViolations find at most limit assets violating the relation constraint in the whole graph
+func (m *MariaDB) FindConstraintViolations(ctx context.Context, constraint schema.RelationConstraint, limit int) ([]knowledge.ConstraintViolation, error) {
+	violations := []knowledge.ConstraintViolation{}
+
+	if constraint.Required {
+		rows, err := m.db.QueryContext(ctx, `
+SELECT a.type, a.value FROM assets a
+WHERE a.type = ? AND NOT EXISTS (
+	SELECT 1 FROM relations r
+	INNER JOIN assets b ON b.id = r.to_id
+	WHERE r.from_id = a.id AND r.type = ? AND b.type = ?)
+LIMIT ?`,
+			constraint.Relation.FromType, constraint.Relation.Type, constraint.Relation.ToType, limit)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find violations of constraint %s: %v", constraint, err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			v := knowledge.ConstraintViolation{Constraint: constraint}
+			if err := rows.Scan(&v.Asset.Type, &v.Asset.Key); err != nil {
+				return nil, fmt.Errorf("unable to read violation of constraint %s: %v", constraint, err)
+			}
+			violations = append(violations, v)
+		}
+	}
+
+	if constraint.MaxCardinality > 0 {
+		rows, err := m.db.QueryContext(ctx, `
+SELECT a.type, a.value, COUNT(*) FROM relations r
+INNER JOIN assets a ON a.id = r.from_id
+INNER JOIN assets b ON b.id = r.to_id
+WHERE r.type = ? AND a.type = ? AND b.type = ?
+GROUP BY r.from_id, a.type, a.value
+HAVING COUNT(*) > ?
+LIMIT ?`,
+			constraint.Relation.Type, constraint.Relation.FromType, constraint.Relation.ToType,
+			constraint.MaxCardinality, limit)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find violations of constraint %s: %v", constraint, err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			v := knowledge.ConstraintViolation{Constraint: constraint}
+			if err := rows.Scan(&v.Asset.Type, &v.Asset.Key, &v.Count); err != nil {
+				return nil, fmt.Errorf("unable to read violation of constraint %s: %v", constraint, err)
+			}
+			violations = append(violations, v)
+		}
+	}
+	return violations, nil
+}
+
+// Close close the connection to maria
+func (m *MariaDB) Close() error {
+	return m.db.Close()
+}
+
+// Query the database with provided intermediate query representation
+func (m *MariaDB) Query(ctx context.Context, sqlTranslation knowledge.SQLTranslation) (*knowledge.GraphQueryResult, error) {
+	deadline, ok := ctx.Deadline()
+
+	// If there is a deadline, we make sure the query stops right after it has been reached.
+	if ok {
+		// Query can take 35 seconds max before being aborted...
+		sqlTranslation.Query = fmt.Sprintf("SET STATEMENT max_statement_time=%f FOR %s", time.Until(deadline).Seconds()+5, sqlTranslation.Query)
+	}
+
+	user := kbcontext.User(ctx)
+
+	logrus.Debugf("Query to be executed for user %s: %s", user, sqlTranslation.Query)
+
+	cursor, err := NewMariaDBCursor(ctx, m.db, sqlTranslation)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(knowledge.GraphQueryResult)
+	res.Cursor = cursor
+	res.Projections = sqlTranslation.ProjectionTypes
+	return res, nil
+}
+
+func (m *MariaDB) GetAssetSources(ctx context.Context, ids []string) (map[string][]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	idsSet := make(map[string][]string)
+	argsSlices := utils.ChunkSlice(args, 500).([][]interface{})
+
+	for _, argsSlice := range argsSlices {
+		stmt, err := m.db.PrepareContext(ctx, `
+SELECT asset_id, sources.name FROM sources
+INNER JOIN assets_by_source ON sources.id = assets_by_source.source_id
+WHERE asset_id IN (?`+strings.Repeat(",?", len(argsSlice)-1)+`)`)
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare statement for retrieving asset sources: %w", err)
+		}
+		defer stmt.Close()
+
+		row, err := stmt.QueryContext(ctx, argsSlice...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve sources for assets: %w", err)
+		}
+		defer row.Close()
 
 		var source string
 		var assetId uint64
@@ -701,166 +1827,898 @@ WHERE asset_id IN (?`+strings.Repeat(",?", len(argsSlice)-1)+`)`)
 			if err != nil {
 				return nil, fmt.Errorf("unable to scan row of asset source: %w", err)
 			}
-			assetIdStr := fmt.Sprintf("%d", assetId)
-			if _, ok := idsSet[assetIdStr]; !ok {
-				idsSet[assetIdStr] = []string{}
+			assetIdStr := fmt.Sprintf("%d", assetId)
+			if _, ok := idsSet[assetIdStr]; !ok {
+				idsSet[assetIdStr] = []string{}
+			}
+			idsSet[assetIdStr] = append(idsSet[assetIdStr], source)
+		}
+	}
+	return idsSet, nil
+}
+
+func (m *MariaDB) GetRelationSources(ctx context.Context, ids []string) (map[string][]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	idsSet := make(map[string][]string)
+
+	argsSlices := utils.ChunkSlice(args, 500).([][]interface{})
+
+	for _, argsSlice := range argsSlices {
+		stmt, err := m.db.PrepareContext(ctx, `
+		SELECT relation_id, sources.name FROM sources
+		INNER JOIN relations_by_source ON sources.id = relations_by_source.source_id
+		WHERE relation_id IN (?`+strings.Repeat(",?", len(argsSlice)-1)+`)`)
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare statement for retrieving relation sources: %w", err)
+		}
+		defer stmt.Close()
+
+		row, err := stmt.QueryContext(ctx, argsSlice...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve sources for relations: %w", err)
+		}
+		defer row.Close()
+
+		var source string
+		var relationId string
+		for row.Next() {
+			err = row.Scan(&relationId, &source)
+			if err != nil {
+				return nil, fmt.Errorf("unable to scan row of relation source: %w", err)
+			}
+			if _, ok := idsSet[relationId]; !ok {
+				idsSet[relationId] = []string{}
+			}
+			idsSet[relationId] = append(idsSet[relationId], source)
+		}
+	}
+	return idsSet, nil
+}
+
+// SaveSuccessfulQuery log an entry to mark a successful query
+func (m *MariaDB) SaveSuccessfulQuery(ctx context.Context, cypher, sql string, duration time.Duration) error {
+	_, err := m.db.ExecContext(ctx, "INSERT INTO query_history (id, timestamp, query_cypher, query_sql, status, execution_time_ms) VALUES (NULL, CURRENT_TIMESTAMP(), ?, ?, 'SUCCESS', ?)",
+		cypher, sql, duration)
+	if err != nil {
+		return err
+	}
+	return err
+}
+
+// SaveFailedQuery log an entry to mark a failed query
+func (m *MariaDB) SaveFailedQuery(ctx context.Context, cypher, sql string, err error) error {
+	_, inErr := m.db.ExecContext(ctx, "INSERT INTO query_history (id, timestamp, query_cypher, query_sql, status, error) VALUES (NULL, CURRENT_TIMESTAMP(), ?, ?, 'FAILURE', ?)",
+		cypher, sql, err.Error())
+	if inErr != nil {
+		return inErr
+	}
+	return nil
+}
+
+// RecordAuditEntry record an entry in the audit log
+func (m *MariaDB) RecordAuditEntry(ctx context.Context, entry audit.Entry) error {
+	_, err := m.db.ExecContext(ctx, `INSERT INTO audit_log
+		(timestamp, actor_kind, actor, token, source, method, endpoint, operation, assets, relations, status, outcome, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Timestamp, entry.ActorKind, entry.Actor, entry.Token, entry.Source, entry.Method, entry.Endpoint,
+		entry.Operation, entry.Assets, entry.Relations, entry.Status, entry.Outcome, entry.Error)
+	if err != nil {
+		return fmt.Errorf("unable to record audit entry: %v", err)
+	}
+	return nil
+}
+
+// ListAuditEntries list the entries of the audit log matching the filter, the most recent first
+func (m *MariaDB) ListAuditEntries(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Source != "" {
+		conditions = append(conditions, "source = ?")
+		args = append(args, filter.Source)
+	}
+	if filter.Operation != "" {
+		conditions = append(conditions, "operation = ?")
+		args = append(args, filter.Operation)
+	}
+	if filter.Outcome != "" {
+		conditions = append(conditions, "outcome = ?")
+		args = append(args, filter.Outcome)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, filter.Until)
+	}
+	args = append(args, filter.Limit)
+
+	rows, err := m.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, timestamp, actor_kind, actor, token, source, method, endpoint, operation, assets, relations, status, outcome, error
+		FROM audit_log
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT ?`, strings.Join(conditions, " AND ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list audit entries: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []audit.Entry{}
+	for rows.Next() {
+		var entry audit.Entry
+		var entryError sql.NullString
+		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.ActorKind, &entry.Actor, &entry.Token, &entry.Source,
+			&entry.Method, &entry.Endpoint, &entry.Operation, &entry.Assets, &entry.Relations, &entry.Status,
+			&entry.Outcome, &entryError)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read audit entry: %v", err)
+		}
+		entry.Error = entryError.String
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// SaveSchema save the schema graph in database
+func (m *MariaDB) SaveSchema(ctx context.Context, sourceName string, schema schema.SchemaGraph) error {
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("unable to json encode schema: %v", err)
+	}
+
+	sourceID, err := m.resolveSourceID(ctx, sourceName)
+	if err != nil {
+		return fmt.Errorf("unable to resolve source ID for source name %s: %v", sourceName, err)
+	}
+
+	_, err = m.db.ExecContext(ctx, "INSERT INTO graph_schema (source_id, graph, timestamp) VALUES (?, ?, CURRENT_TIMESTAMP())",
+		sourceID, string(b))
+	if err != nil {
+		return fmt.Errorf("unable to save schema in DB: %v", err)
+	}
+
+	return nil
+}
+
+func (m *MariaDB) CollectMetrics(ctx context.Context) (map[string]int, error) {
+	rows, err := m.db.QueryContext(ctx, "show global status like 'Com_stmt%'")
+	if err != nil {
+		return nil, fmt.Errorf("unable to collect metrics from database: %v", err)
+	}
+	defer rows.Close()
+
+	var value int
+	var variableName string
+	metrics := make(map[string]int)
+
+	for rows.Next() {
+		err := rows.Scan(&variableName, &value)
+		if err != nil {
+			return nil, err
+		}
+		metrics[variableName] = value
+	}
+	return metrics, nil
+}
+
+// LoadSchema load the schema graph of the source from DB
+func (m *MariaDB) LoadSchema(ctx context.Context, sourceName string) (schema.SchemaGraph, error) {
+	row := m.db.QueryRowContext(ctx, `
+SELECT gs.graph FROM graph_schema gs
+INNER JOIN sources s ON s.id = gs.source_id
+WHERE s.name = ?
+ORDER BY gs.id DESC LIMIT 1`,
+		sourceName)
+	var rawJSON string
+	if err := row.Scan(&rawJSON); err != nil {
+		if err == sql.ErrNoRows {
+			return schema.NewSchemaGraph(), nil
+		}
+		return schema.NewSchemaGraph(), err
+	}
+
+	graph := schema.NewSchemaGraph()
+	err := json.Unmarshal([]byte(rawJSON), &graph)
+	if err != nil {
+		return schema.NewSchemaGraph(), err
+	}
+
+	return graph, nil
+}
+
+// ListSchemaVersions list the versions of the schema of the source from the oldest to the newest
+func (m *MariaDB) ListSchemaVersions(ctx context.Context, sourceName string) ([]schema.SchemaVersion, error) {
+	rows, err := m.db.QueryContext(ctx, `
+SELECT gs.id, gs.timestamp FROM graph_schema gs
+INNER JOIN sources s ON s.id = gs.source_id
+WHERE s.name = ?
+ORDER BY gs.id ASC`,
+		sourceName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list schema versions of source %s: %v", sourceName, err)
+	}
+	defer rows.Close()
+
+	versions := []schema.SchemaVersion{}
+	for rows.Next() {
+		var version schema.SchemaVersion
+		if err := rows.Scan(&version.Version, &version.Timestamp); err != nil {
+			return nil, fmt.Errorf("unable to read schema version: %v", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// LoadSchemaVersion load a given version of the schema of the source from DB
+func (m *MariaDB) LoadSchemaVersion(ctx context.Context, sourceName string, version int64) (schema.SchemaGraph, error) {
+	row := m.db.QueryRowContext(ctx, `
+SELECT gs.graph FROM graph_schema gs
+INNER JOIN sources s ON s.id = gs.source_id
+WHERE s.name = ? AND gs.id = ?`,
+		sourceName, version)
+	var rawJSON string
+	if err := row.Scan(&rawJSON); err != nil {
+		if err == sql.ErrNoRows {
+			return schema.NewSchemaGraph(), fmt.Errorf("%w: version %d of source %s", schema.ErrSchemaVersionNotFound, version, sourceName)
+		}
+		return schema.NewSchemaGraph(), err
+	}
+
+	graph := schema.NewSchemaGraph()
+	err := json.Unmarshal([]byte(rawJSON), &graph)
+	if err != nil {
+		return schema.NewSchemaGraph(), err
+	}
+
+	return graph, nil
+}
+
+// LoadOntology load the ontology from DB
+func (m *MariaDB) LoadOntology(ctx context.Context) (schema.Ontology, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT type, kind, parent FROM graph_ontology ORDER BY parent, type")
+	if err != nil {
+		return schema.NewOntology(), fmt.Errorf("unable to read ontology from database: %v", err)
+	}
+	defer rows.Close()
+
+	ontology := schema.NewOntology()
+	for rows.Next() {
+		var entry schema.OntologyEntry
+		if err := rows.Scan(&entry.Type, &entry.Kind, &entry.Parent); err != nil {
+			return schema.NewOntology(), fmt.Errorf("unable to read ontology entry: %v", err)
+		}
+		ontology.Entries = append(ontology.Entries, entry)
+	}
+	return ontology, nil
+}
+
+// AddOntologyEntry add an entry to the ontology or update the kind of an existing one
+func (m *MariaDB) AddOntologyEntry(ctx context.Context, entry schema.OntologyEntry) error {
+	_, err := m.db.ExecContext(ctx, `
+INSERT INTO graph_ontology (type, kind, parent, timestamp) VALUES (?, ?, ?, CURRENT_TIMESTAMP())
+ON DUPLICATE KEY UPDATE kind = VALUES(kind), timestamp = CURRENT_TIMESTAMP()`,
+		entry.Type, entry.Kind, entry.Parent)
+	if err != nil {
+		return fmt.Errorf("unable to save ontology entry in DB: %v", err)
+	}
+	return nil
+}
+
+// RemoveOntologyEntry remove an entry from the ontology
+func (m *MariaDB) RemoveOntologyEntry(ctx context.Context, entry schema.OntologyEntry) error {
+	_, err := m.db.ExecContext(ctx, "DELETE FROM graph_ontology WHERE type = ? AND parent = ?",
+		entry.Type, entry.Parent)
+	if err != nil {
+		return fmt.Errorf("unable to remove ontology entry from DB: %v", err)
+	}
+	return nil
+}
+
+// ListResolutionRules list the entity resolution rules
+func (m *MariaDB) ListResolutionRules(ctx context.Context) ([]knowledge.ResolutionRule, error) {
+	rows, err := m.db.QueryContext(ctx,
+		"SELECT alias_type, canonical_type, normalizer FROM entity_resolution_rules ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read entity resolution rules from database: %v", err)
+	}
+	defer rows.Close()
+
+	rules := []knowledge.ResolutionRule{}
+	for rows.Next() {
+		var rule knowledge.ResolutionRule
+		if err := rows.Scan(&rule.AliasType, &rule.CanonicalType, &rule.Normalizer); err != nil {
+			return nil, fmt.Errorf("unable to read entity resolution rule: %v", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// AddResolutionRule add an entity resolution rule or update the normalizer of an existing one
+func (m *MariaDB) AddResolutionRule(ctx context.Context, rule knowledge.ResolutionRule) error {
+	if rule.Normalizer == "" {
+		rule.Normalizer = knowledge.IdentityNormalizer
+	}
+	_, err := m.db.ExecContext(ctx, `
+INSERT INTO entity_resolution_rules (alias_type, canonical_type, normalizer) VALUES (?, ?, ?)
+ON DUPLICATE KEY UPDATE normalizer = VALUES(normalizer)`,
+		rule.AliasType, rule.CanonicalType, rule.Normalizer)
+	if err != nil {
+		return fmt.Errorf("unable to save entity resolution rule in DB: %v", err)
+	}
+	return nil
+}
+
+// RemoveResolutionRule remove an entity resolution rule
+func (m *MariaDB) RemoveResolutionRule(ctx context.Context, rule knowledge.ResolutionRule) error {
+	_, err := m.db.ExecContext(ctx,
+		"DELETE FROM entity_resolution_rules WHERE alias_type = ? AND canonical_type = ?",
+		rule.AliasType, rule.CanonicalType)
+	if err != nil {
+		return fmt.Errorf("unable to remove entity resolution rule from DB: %v", err)
+	}
+	return nil
+}
+
+// AddSameAsLink add a manual same-as link between two assets. It takes precedence over the links created by rules.
+func (m *MariaDB) AddSameAsLink(ctx context.Context, link knowledge.SameAsLink) error {
+	_, err := m.db.ExecContext(ctx, `
+INSERT INTO asset_same_as (alias_id, canonical_id, origin) VALUES (?, ?, 'manual')
+ON DUPLICATE KEY UPDATE canonical_id = VALUES(canonical_id), origin = 'manual'`,
+		knowledge.HashAsset(knowledge.Asset(link.Alias)), knowledge.HashAsset(knowledge.Asset(link.Canonical)))
+	if err != nil {
+		if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_NO_REFERENCED_ROW_2 {
+			return fmt.Errorf("unable to save same-as link in DB: %w", knowledge.ErrUnknownAsset)
+		}
+		return fmt.Errorf("unable to save same-as link in DB: %v", err)
+	}
+	return nil
+}
+
+// RemoveSameAsLink remove a same-as link between two assets
+func (m *MariaDB) RemoveSameAsLink(ctx context.Context, link knowledge.SameAsLink) error {
+	_, err := m.db.ExecContext(ctx, "DELETE FROM asset_same_as WHERE alias_id = ? AND canonical_id = ?",
+		knowledge.HashAsset(knowledge.Asset(link.Alias)), knowledge.HashAsset(knowledge.Asset(link.Canonical)))
+	if err != nil {
+		return fmt.Errorf("unable to remove same-as link from DB: %v", err)
+	}
+	return nil
+}
+
+// ResolveEntities recompute the same-as links created by the rules. An asset already linked manually or by a
+// previous rule is not linked again and canonical assets are never aliases themselves.
+func (m *MariaDB) ResolveEntities(ctx context.Context) (int64, error) {
+	rules, err := m.ListResolutionRules(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = InTransaction(m.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM asset_same_as WHERE origin = 'rule'"); err != nil {
+			return fmt.Errorf("unable to remove same-as links created by rules: %v", err)
+		}
+
+		for _, rule := range rules {
+			aliasValue, err := rule.Normalizer.SQLExpression("a.value")
+			if err != nil {
+				return err
+			}
+			canonicalValue, err := rule.Normalizer.SQLExpression("c.value")
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, `
+INSERT IGNORE INTO asset_same_as (alias_id, canonical_id, origin)
+SELECT a.id, MIN(c.id), 'rule' FROM assets a
+INNER JOIN assets c ON c.type = ? AND `+canonicalValue+` = `+aliasValue+`
+WHERE a.type = ? AND c.id NOT IN (SELECT alias_id FROM asset_same_as)
+GROUP BY a.id`, rule.CanonicalType, rule.AliasType)
+			if err != nil {
+				return fmt.Errorf("unable to apply entity resolution rule %s: %v", rule, err)
 			}
-			idsSet[assetIdStr] = append(idsSet[assetIdStr], source)
 		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return idsSet, nil
+
+	var count int64
+	row := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM asset_same_as")
+	return count, row.Scan(&count)
 }
 
-func (m *MariaDB) GetRelationSources(ctx context.Context, ids []string) (map[string][]string, error) {
+// GetAssetAliases return the aliases of the canonical assets having the provided ids
+func (m *MariaDB) GetAssetAliases(ctx context.Context, ids []string) (map[string][]knowledge.AssetWithID, error) {
+	aliasesByID := make(map[string][]knowledge.AssetWithID)
 	if len(ids) == 0 {
-		return nil, nil
+		return aliasesByID, nil
 	}
+
 	args := make([]interface{}, len(ids))
 	for i, id := range ids {
 		args[i] = id
 	}
-	idsSet := make(map[string][]string)
-
-	argsSlices := utils.ChunkSlice(args, 500).([][]interface{})
 
-	for _, argsSlice := range argsSlices {
-		stmt, err := m.db.PrepareContext(ctx, `
-		SELECT relation_id, sources.name FROM sources
-		INNER JOIN relations_by_source ON sources.id = relations_by_source.source_id
-		WHERE relation_id IN (?`+strings.Repeat(",?", len(argsSlice)-1)+`)`)
+	for _, argsSlice := range utils.ChunkSlice(args, 500).([][]interface{}) {
+		rows, err := m.db.QueryContext(ctx, `
+SELECT s.canonical_id, a.id, a.type, a.value FROM asset_same_as s
+INNER JOIN assets a ON a.id = s.alias_id
+WHERE s.canonical_id IN (?`+strings.Repeat(",?", len(argsSlice)-1)+`)`, argsSlice...)
 		if err != nil {
-			return nil, fmt.Errorf("unable to prepare statement for retrieving relation sources: %w", err)
+			return nil, fmt.Errorf("unable to retrieve aliases of assets: %w", err)
 		}
-		defer stmt.Close()
 
-		row, err := stmt.QueryContext(ctx, argsSlice...)
-		if err != nil {
-			return nil, fmt.Errorf("unable to retrieve sources for relations: %w", err)
+		for rows.Next() {
+			var canonicalID, aliasID uint64
+			var alias knowledge.AssetWithID
+			if err := rows.Scan(&canonicalID, &aliasID, &alias.Type, &alias.Key); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("unable to scan row of asset alias: %w", err)
+			}
+			alias.ID = fmt.Sprintf("%d", aliasID)
+			canonicalIDStr := fmt.Sprintf("%d", canonicalID)
+			aliasesByID[canonicalIDStr] = append(aliasesByID[canonicalIDStr], alias)
 		}
-		defer row.Close()
+		rows.Close()
+	}
+	return aliasesByID, nil
+}
 
-		var source string
-		var relationId string
-		for row.Next() {
-			err = row.Scan(&relationId, &source)
-			if err != nil {
-				return nil, fmt.Errorf("unable to scan row of relation source: %w", err)
-			}
-			if _, ok := idsSet[relationId]; !ok {
-				idsSet[relationId] = []string{}
-			}
-			idsSet[relationId] = append(idsSet[relationId], source)
+// ListSources list the names of the sources
+func (m *MariaDB) ListSources(ctx context.Context) ([]string, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT name FROM sources")
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read sources from database: %v", err)
+	}
+	defer rows.Close()
+
+	sources := []string{}
+	for rows.Next() {
+		var sourceName string
+		if err := rows.Scan(&sourceName); err != nil {
+			return nil, err
 		}
+		sources = append(sources, sourceName)
 	}
-	return idsSet, nil
+	return sources, rows.Err()
 }
 
-// SaveSuccessfulQuery log an entry to mark a successful query
-func (m *MariaDB) SaveSuccessfulQuery(ctx context.Context, cypher, sql string, duration time.Duration) error {
-	_, err := m.db.ExecContext(ctx, "INSERT INTO query_history (id, timestamp, query_cypher, query_sql, status, execution_time_ms) VALUES (NULL, CURRENT_TIMESTAMP(), ?, ?, 'SUCCESS', ?)",
-		cypher, sql, duration)
+// ListCredentials list the hashed authentication tokens of all the sources
+func (m *MariaDB) ListCredentials(ctx context.Context) ([]sources.Credential, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT s.name, t.name, t.scopes, t.expires_at, t.last_used_at, t.token_id, t.token_salt, t.token_hash
+		FROM source_tokens t INNER JOIN sources s ON s.id = t.source_id`)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("unable to read credentials of sources from database: %v", err)
 	}
-	return err
+	defer rows.Close()
+
+	credentials := []sources.Credential{}
+	for rows.Next() {
+		var c sources.Credential
+		var scopes string
+		var id, salt sql.NullString
+		if err := rows.Scan(&c.Source, &c.Name, &scopes, &c.ExpiresAt, &c.LastUsedAt, &id, &salt, &c.Hash); err != nil {
+			return nil, err
+		}
+		c.Scopes = splitScopes(scopes)
+		c.ID, c.Salt = id.String, salt.String
+		credentials = append(credentials, c)
+	}
+	return credentials, rows.Err()
 }
 
-// SaveFailedQuery log an entry to mark a failed query
-func (m *MariaDB) SaveFailedQuery(ctx context.Context, cypher, sql string, err error) error {
-	_, inErr := m.db.ExecContext(ctx, "INSERT INTO query_history (id, timestamp, query_cypher, query_sql, status, error) VALUES (NULL, CURRENT_TIMESTAMP(), ?, ?, 'FAILURE', ?)",
-		cypher, sql, err.Error())
-	if inErr != nil {
-		return inErr
+// nullIfEmpty store the empty strings as NULL
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func joinScopes(scopes []sources.Scope) string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
 	}
-	return nil
+	return strings.Join(s, ",")
 }
 
-// SaveSchema save the schema graph in database
-func (m *MariaDB) SaveSchema(ctx context.Context, sourceName string, schema schema.SchemaGraph) error {
-	b, err := json.Marshal(schema)
-	if err != nil {
-		return fmt.Errorf("unable to json encode schema: %v", err)
+func splitScopes(s string) []sources.Scope {
+	scopes := []sources.Scope{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			scopes = append(scopes, sources.Scope(scope))
+		}
 	}
+	return scopes
+}
 
-	sourceID, err := m.resolveSourceID(ctx, sourceName)
+// migrateSourceTokens move the tokens stored in the sources table, either in plaintext or hashed, into the default
+// tokens of the sources. The sources keep using the same tokens.
+func (m *MariaDB) migrateSourceTokens(ctx context.Context) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, auth_token, token_salt, token_hash FROM sources
+			WHERE auth_token <> '' OR token_hash IS NOT NULL FOR UPDATE`)
+		if err != nil {
+			return fmt.Errorf("unable to read the tokens of the sources: %v", err)
+		}
+		legacy := make(map[int]sources.HashedToken)
+		for rows.Next() {
+			var id int
+			var plaintext string
+			var salt, hash sql.NullString
+			if err := rows.Scan(&id, &plaintext, &salt, &hash); err != nil {
+				rows.Close()
+				return err
+			}
+			if hash.Valid {
+				legacy[id] = sources.HashedToken{Salt: salt.String, Hash: hash.String}
+				continue
+			}
+			hashed, err := sources.HashToken(plaintext)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			legacy[id] = hashed
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		token := sources.Token{Name: sources.DefaultTokenName, Scopes: sources.AllScopes}
+		for id, hashed := range legacy {
+			if err := insertToken(ctx, tx, id, token, hashed); err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `
+				UPDATE sources SET auth_token = '', token_salt = NULL, token_hash = NULL
+				WHERE id = ?`, id)
+			if err != nil {
+				return fmt.Errorf("unable to clear the legacy token of source %d: %w", id, err)
+			}
+		}
+		if len(legacy) > 0 {
+			logrus.Infof("Migrated the tokens of %d sources to hashed default tokens", len(legacy))
+		}
+		return nil
+	})
+}
+
+// insertToken store the hash of a token of the source
+func insertToken(ctx context.Context, tx *sql.Tx, sourceID int, token sources.Token, hashed sources.HashedToken) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO source_tokens (source_id, name, token_id, token_salt, token_hash, scopes, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		sourceID, token.Name, nullIfEmpty(hashed.ID), nullIfEmpty(hashed.Salt), hashed.Hash, joinScopes(token.Scopes), token.ExpiresAt)
 	if err != nil {
-		return fmt.Errorf("unable to resolve source ID for source name %s: %v", sourceName, err)
+		if driverErr, ok := err.(*mysql.MySQLError); ok && driverErr.Number == mysqlerr.ER_DUP_ENTRY {
+			return fmt.Errorf("%w: %s", sources.ErrTokenAlreadyExists, token.Name)
+		}
+		return fmt.Errorf("unable to store token %s of source %d: %w", token.Name, sourceID, err)
 	}
+	return nil
+}
 
-	_, err = m.db.ExecContext(ctx, "INSERT INTO graph_schema (source_id, graph, timestamp) VALUES (?, ?, CURRENT_TIMESTAMP())",
-		sourceID, string(b))
-	if err != nil {
-		return fmt.Errorf("unable to save schema in DB: %v", err)
+// lockSourceID resolve the ID of the source and lock its row until the end of the transaction
+func lockSourceID(ctx context.Context, tx *sql.Tx, name string) (int, error) {
+	var sourceID int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM sources WHERE name = ? LIMIT 1 FOR UPDATE", name).Scan(&sourceID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s", sources.ErrSourceNotFound, name)
+	} else if err != nil {
+		return 0, fmt.Errorf("unable to resolve source %s: %w", name, err)
 	}
+	return sourceID, nil
+}
 
+// checkSourceAbsent returns an error if a source with the name is already registered
+func checkSourceAbsent(ctx context.Context, tx *sql.Tx, name string) error {
+	_, err := lockSourceID(ctx, tx, name)
+	if err == nil {
+		return fmt.Errorf("%w: %s", sources.ErrSourceAlreadyExists, name)
+	} else if !errors.Is(err, sources.ErrSourceNotFound) {
+		return err
+	}
 	return nil
 }
 
-func (m *MariaDB) CollectMetrics(ctx context.Context) (map[string]int, error) {
-	rows, err := m.db.QueryContext(ctx, "show global status like 'Com_stmt%'")
+// CreateSource register a new data source along with its default token granted all the scopes
+func (m *MariaDB) CreateSource(ctx context.Context, name, authToken string) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		if err := checkSourceAbsent(ctx, tx, name); err != nil {
+			return err
+		}
+		hashed, err := sources.HashToken(authToken)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "INSERT INTO sources (name, auth_token) VALUES (?, '')", name)
+		if err != nil {
+			return fmt.Errorf("unable to create source %s: %w", name, err)
+		}
+		sourceID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		token := sources.Token{Name: sources.DefaultTokenName, Scopes: sources.AllScopes}
+		return insertToken(ctx, tx, int(sourceID), token, hashed)
+	})
+}
+
+// RotateToken replace the secret of a token of the source, its scopes and expiry are kept
+func (m *MariaDB) RotateToken(ctx context.Context, name, tokenName, authToken string) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		sourceID, err := lockSourceID(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		hashed, err := sources.HashToken(authToken)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE source_tokens SET token_id = ?, token_salt = NULL, token_hash = ?, last_used_at = NULL
+			WHERE source_id = ? AND name = ?`,
+			nullIfEmpty(hashed.ID), hashed.Hash, sourceID, tokenName)
+		if err != nil {
+			return fmt.Errorf("unable to rotate token %s of source %s: %w", tokenName, name, err)
+		}
+		if count, err := res.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			return fmt.Errorf("%w: %s", sources.ErrTokenNotFound, tokenName)
+		}
+		return nil
+	})
+}
+
+// ListTokens list the tokens of the source, the secrets are not returned
+func (m *MariaDB) ListTokens(ctx context.Context, name string) ([]sources.Token, error) {
+	sourceID, err := m.resolveSourceIDFromDB(ctx, name)
 	if err != nil {
-		return nil, fmt.Errorf("unable to collect metrics from database: %v", err)
+		return nil, err
+	}
+	if sourceID == 0 {
+		return nil, fmt.Errorf("%w: %s", sources.ErrSourceNotFound, name)
 	}
-	defer rows.Close()
 
-	var value int
-	var variableName string
-	metrics := make(map[string]int)
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT name, scopes, expires_at, last_used_at FROM source_tokens WHERE source_id = ? ORDER BY name`, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tokens of source %s: %w", name, err)
+	}
+	defer rows.Close()
 
+	tokens := []sources.Token{}
 	for rows.Next() {
-		err := rows.Scan(&variableName, &value)
-		if err != nil {
+		var t sources.Token
+		var scopes string
+		if err := rows.Scan(&t.Name, &scopes, &t.ExpiresAt, &t.LastUsedAt); err != nil {
 			return nil, err
 		}
-		metrics[variableName] = value
+		t.Scopes = splitScopes(scopes)
+		tokens = append(tokens, t)
 	}
-	return metrics, nil
+	return tokens, rows.Err()
 }
 
-// LoadSchema load the schema graph of the source from DB
-func (m *MariaDB) LoadSchema(ctx context.Context, sourceName string) (schema.SchemaGraph, error) {
-	row := m.db.QueryRowContext(ctx, `
-SELECT gs.graph FROM graph_schema gs
-INNER JOIN sources s ON s.id = gs.source_id
-WHERE s.name = ?
-ORDER BY gs.id DESC LIMIT 1`,
-		sourceName)
-	var rawJSON string
-	if err := row.Scan(&rawJSON); err != nil {
-		if err == sql.ErrNoRows {
-			return schema.NewSchemaGraph(), nil
+// AddToken add a token to the source, only a hash of the token is stored
+func (m *MariaDB) AddToken(ctx context.Context, name string, token sources.Token, authToken string) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		sourceID, err := lockSourceID(ctx, tx, name)
+		if err != nil {
+			return err
 		}
-		return schema.NewSchemaGraph(), err
-	}
-
-	graph := schema.NewSchemaGraph()
-	err := json.Unmarshal([]byte(rawJSON), &graph)
-	if err != nil {
-		return schema.NewSchemaGraph(), err
-	}
+		hashed, err := sources.HashToken(authToken)
+		if err != nil {
+			return err
+		}
+		return insertToken(ctx, tx, sourceID, token, hashed)
+	})
+}
 
-	return graph, nil
+// RevokeToken remove a token of the source
+func (m *MariaDB) RevokeToken(ctx context.Context, name, tokenName string) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		sourceID, err := lockSourceID(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM source_tokens WHERE source_id = ? AND name = ?", sourceID, tokenName)
+		if err != nil {
+			return fmt.Errorf("unable to revoke token %s of source %s: %w", tokenName, name, err)
+		}
+		if count, err := res.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			return fmt.Errorf("%w: %s", sources.ErrTokenNotFound, tokenName)
+		}
+		return nil
+	})
 }
 
-// ListSources list sources with their authentication tokens
-func (m *MariaDB) ListSources(ctx context.Context) (map[string]string, error) {
-	rows, err := m.db.QueryContext(ctx, "SELECT name, auth_token FROM sources")
+// MarkTokensUsed record the last time the tokens have been used and replace their legacy hashes, the tokens which do
+// not exist anymore are ignored
+func (m *MariaDB) MarkTokensUsed(ctx context.Context, usages []sources.TokenUsage) error {
+	for _, u := range usages {
+		if u.Rehashed != nil {
+			// Only the legacy hash the token matched is replaced in case the token has been rotated in the meantime
+			_, err := m.db.ExecContext(ctx, `
+				UPDATE source_tokens t INNER JOIN sources s ON s.id = t.source_id
+				SET t.token_id = ?, t.token_salt = NULL, t.token_hash = ?
+				WHERE s.name = ? AND t.name = ? AND t.token_salt IS NOT NULL`,
+				nullIfEmpty(u.Rehashed.ID), u.Rehashed.Hash, u.Source, u.Token)
+			if err != nil {
+				return fmt.Errorf("unable to rehash token %s of source %s: %w", u.Token, u.Source, err)
+			}
+		}
+		_, err := m.db.ExecContext(ctx, `
+			UPDATE source_tokens t INNER JOIN sources s ON s.id = t.source_id SET t.last_used_at = ?
+			WHERE s.name = ? AND t.name = ? AND (t.last_used_at IS NULL OR t.last_used_at < ?)`,
+			u.At, u.Source, u.Token, u.At)
+		if err != nil {
+			return fmt.Errorf("unable to record the use of token %s of source %s: %w", u.Token, u.Source, err)
+		}
+	}
+	return nil
+}
 
+// ListFreshness list the last successful update and the expected update interval of all the sources
+func (m *MariaDB) ListFreshness(ctx context.Context) ([]sources.Freshness, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT s.name, f.last_update_at, COALESCE(f.expected_interval_seconds, 0)
+		FROM sources s LEFT JOIN source_freshness f ON f.source_id = s.id`)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read sources from database: %v", err)
+		return nil, fmt.Errorf("unable to read freshness of sources from database: %v", err)
 	}
 	defer rows.Close()
 
-	sources := make(map[string]string)
+	freshness := []sources.Freshness{}
 	for rows.Next() {
-		var sourceName string
-		var authToken string
-		if err := rows.Scan(&sourceName, &authToken); err != nil {
+		var f sources.Freshness
+		var intervalSeconds int64
+		if err := rows.Scan(&f.Source, &f.LastUpdateAt, &intervalSeconds); err != nil {
 			return nil, err
 		}
-		sources[sourceName] = authToken
+		f.ExpectedInterval = time.Duration(intervalSeconds) * time.Second
+		freshness = append(freshness, f)
+	}
+	return freshness, rows.Err()
+}
+
+// MarkSourcesUpdated record the last time the sources successfully updated their graph
+func (m *MariaDB) MarkSourcesUpdated(ctx context.Context, updates []sources.SourceUpdate) error {
+	for _, u := range updates {
+		_, err := m.db.ExecContext(ctx, `
+			INSERT INTO source_freshness (source_id, last_update_at)
+			SELECT id, ? FROM sources WHERE name = ?
+			ON DUPLICATE KEY UPDATE last_update_at = GREATEST(COALESCE(last_update_at, VALUES(last_update_at)), VALUES(last_update_at))`,
+			u.At, u.Source)
+		if err != nil {
+			return fmt.Errorf("unable to record the update of source %s: %w", u.Source, err)
+		}
 	}
-	return sources, nil
+	return nil
+}
+
+// SetExpectedInterval record the interval the source declares updating its graph at, 0 removes it
+func (m *MariaDB) SetExpectedInterval(ctx context.Context, name string, interval time.Duration) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		sourceID, err := lockSourceID(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO source_freshness (source_id, expected_interval_seconds) VALUES (?, ?)
+			ON DUPLICATE KEY UPDATE expected_interval_seconds = VALUES(expected_interval_seconds)`,
+			sourceID, int64(interval/time.Second))
+		if err != nil {
+			return fmt.Errorf("unable to set the expected update interval of source %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// RenameSource rename the source, its graph is kept
+func (m *MariaDB) RenameSource(ctx context.Context, name, newName string) error {
+	return InTransaction(m.db, func(tx *sql.Tx) error {
+		sourceID, err := lockSourceID(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		if err := checkSourceAbsent(ctx, tx, newName); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE sources SET name = ? WHERE id = ?", newName, sourceID)
+		if err != nil {
+			return fmt.Errorf("unable to rename source %s into %s: %w", name, newName, err)
+		}
+		delete(m.sourcesCache, name)
+		return nil
+	})
+}
+
+// RemoveSource unregister the source. Its bindings, schema, revisions and staged transactions are removed by cascade
+// and the assets and relations which are not bound to any other source anymore are garbage collected.
+func (m *MariaDB) RemoveSource(ctx context.Context, name string) (sources.RemovalReport, error) {
+	report := sources.RemovalReport{}
+	err := InTransaction(m.db, func(tx *sql.Tx) error {
+		sourceID, err := lockSourceID(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		// The IDs bound to the source are kept aside since the bindings are removed by cascade with the source.
+		// Temporary tables are bound to the connection of the transaction.
+		_, err = tx.ExecContext(ctx, "DROP TEMPORARY TABLE IF EXISTS removed_source_assets, removed_source_relations")
+		if err != nil {
+			return fmt.Errorf("unable to collect the graph of source %s: %w", name, err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			CREATE TEMPORARY TABLE removed_source_relations (id BIGINT UNSIGNED NOT NULL PRIMARY KEY)
+			SELECT relation_id AS id FROM relations_by_source WHERE source_id = ?`, sourceID)
+		if err != nil {
+			return fmt.Errorf("unable to collect the relations of source %s: %w", name, err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			CREATE TEMPORARY TABLE removed_source_assets (id BIGINT UNSIGNED NOT NULL PRIMARY KEY)
+			SELECT asset_id AS id FROM assets_by_source WHERE source_id = ?`, sourceID)
+		if err != nil {
+			return fmt.Errorf("unable to collect the assets of source %s: %w", name, err)
+		}
+		defer tx.ExecContext(ctx, "DROP TEMPORARY TABLE IF EXISTS removed_source_assets, removed_source_relations")
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM sources WHERE id = ?", sourceID); err != nil {
+			return fmt.Errorf("unable to remove source %s: %w", name, err)
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			DELETE FROM relations WHERE id IN (SELECT id FROM removed_source_relations) AND NOT EXISTS (
+				SELECT * FROM relations_by_source WHERE relation_id = relations.id
+			)`)
+		if err != nil {
+			return fmt.Errorf("unable to remove orphaned relations of source %s: %w", name, err)
+		}
+		if report.RemovedRelations, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		// Assets still referenced by a relation cannot be removed, this should not happen since the source of a
+		// relation also binds its assets.
+		res, err = tx.ExecContext(ctx, `
+			DELETE FROM assets WHERE id IN (SELECT id FROM removed_source_assets) AND NOT EXISTS (
+				SELECT * FROM assets_by_source WHERE asset_id = assets.id
+			) AND NOT EXISTS (
+				SELECT * FROM relations WHERE from_id = assets.id OR to_id = assets.id
+			)`)
+		if err != nil {
+			return fmt.Errorf("unable to remove orphaned assets of source %s: %w", name, err)
+		}
+		if report.RemovedAssets, err = res.RowsAffected(); err != nil {
+			return err
+		}
+
+		delete(m.sourcesCache, name)
+		return nil
+	})
+	return report, err
 }
 
 // MariaDBCursor is a cursor of data retrieved by MariaDB
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// certificateIdentities returns the identities of the certificate held by the field: common_name, dns_name, uri or
+// email
+func certificateIdentities(cert *x509.Certificate, field string) []string {
+	switch field {
+	case "", "common_name":
+		if cert.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{cert.Subject.CommonName}
+	case "dns_name":
+		return cert.DNSNames
+	case "uri":
+		identities := []string{}
+		for _, u := range cert.URIs {
+			identities = append(identities, u.String())
+		}
+		return identities
+	case "email":
+		return cert.EmailAddresses
+	}
+	logrus.Errorf("Unknown client certificate identity field %s", field)
+	return nil
+}
+
+// ClientCertificateSource returns the source identified by the verified client certificate of the request or an
+// empty string if there is none. The identity is read from the field configured by `server_tls_client_identity` and
+// mapped to a source by `server_tls_client_sources`, the identity is the name of the source when there is no mapping.
+func ClientCertificateSource(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	// The keys of the mapping are lowercased by the configuration loader
+	mapping := viper.GetStringMapString("server_tls_client_sources")
+	for _, identity := range certificateIdentities(cert, viper.GetString("server_tls_client_identity")) {
+		if len(mapping) == 0 {
+			return identity
+		}
+		if source, ok := mapping[strings.ToLower(identity)]; ok {
+			return source
+		}
+	}
+	logrus.Debugf("Client certificate %s is not mapped to any source", cert.Subject)
+	return ""
+}
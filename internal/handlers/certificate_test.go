@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldMapClientCertificateToSource(t *testing.T) {
+	defer viper.Reset()
+	spiffeID, err := url.Parse("spiffe://example.com/datasource/csv")
+	require.NoError(t, err)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "scanner"}, URIs: []*url.URL{spiffeID}}
+
+	req := httptest.NewRequest("GET", "/api/graph/read", nil)
+	assert.Equal(t, "", ClientCertificateSource(req))
+
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	assert.Equal(t, "scanner", ClientCertificateSource(req))
+
+	viper.Set("server_tls_client_identity", "uri")
+	assert.Equal(t, "spiffe://example.com/datasource/csv", ClientCertificateSource(req))
+
+	viper.Set("server_tls_client_sources", map[string]string{"spiffe://example.com/datasource/csv": "datasource-csv"})
+	assert.Equal(t, "datasource-csv", ClientCertificateSource(req))
+
+	viper.Set("server_tls_client_identity", "common_name")
+	assert.Equal(t, "", ClientCertificateSource(req))
+}
+
+func TestShouldAuthenticateSourceFromClientCertificate(t *testing.T) {
+	defer viper.Reset()
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "0123456789abcdef"}}
+
+	req := httptest.NewRequest("PUT", "/api/graph/assets", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "scanner"}}}}}
+	ok, source, err := IsSourceAuthenticated(registry, req, sources.ScopeGraphWrite)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "scanner", source)
+
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "unknown"}}}}}
+	ok, _, err = IsSourceAuthenticated(registry, req, sources.ScopeGraphWrite)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
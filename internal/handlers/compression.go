@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxDecompressedBodySize is the maximum size of a decompressed request body when none is configured
+const DefaultMaxDecompressedBodySize = 64 << 20
+
+// ErrRequestBodyTooLarge is returned when reading a decompressed request body larger than the limit
+var ErrRequestBodyTooLarge = errors.New("request body too large")
+
+// decompressedBody is the body of a request decompressed on the fly, it fails once more than the limit is read so
+// that a small compressed body cannot expand into an unbounded amount of data.
+type decompressedBody struct {
+	reader    *gzip.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrRequestBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.reader.Read(p)
+	if int64(n) > b.remaining {
+		b.exceeded = true
+		return int(b.remaining), ErrRequestBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	return b.reader.Close()
+}
+
+// bodyTooLarge returns true if the decompressed body of the request exceeded the limit. The decoders may not wrap
+// the error of the body, the body tells the truth.
+func bodyTooLarge(r *http.Request, err error) bool {
+	if errors.Is(err, ErrRequestBodyTooLarge) {
+		return true
+	}
+	body, ok := r.Body.(*decompressedBody)
+	return ok && body.exceeded
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// gzipResponseWriter compresses the body of the response
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(statusCode int) {
+	// The length of the compressed body is not known in advance
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
+
+// acceptsGzip returns true if the client declared accepting gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0])
+		if encoding == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// WithCompression decompress the body of the requests sent with gzip content encoding and compress the responses of
+// the clients accepting the gzip encoding. The requests whose decompressed body is larger than maxBodySize bytes are
+// refused.
+func WithCompression(maxBodySize int64, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Content-Encoding") {
+		case "", "identity":
+		case "gzip":
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				ReplyWithBadRequest(w, fmt.Errorf("Unable to decompress request body: %v", err))
+				return
+			}
+			defer reader.Close()
+			r.Body = &decompressedBody{reader: reader, remaining: maxBodySize}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		default:
+			ReplyWithUnsupportedMediaType(w, fmt.Errorf("Unsupported content encoding %s", r.Header.Get("Content-Encoding")))
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			h(w, r)
+			return
+		}
+
+		gz := gzipWriterPool.Get().(*gzip.Writer)
+		gz.Reset(w)
+		defer func() {
+			gz.Close()
+			gzipWriterPool.Put(gz)
+		}()
+
+		w.Header().Set("Content-Encoding", "gzip")
+		h(&gzipResponseWriter{ResponseWriter: w, writer: gz}, r)
+	}
+}
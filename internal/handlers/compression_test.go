@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Write(body)
+}
+
+func TestShouldDecompressGzipRequestBody(t *testing.T) {
+	b := new(bytes.Buffer)
+	gz := gzip.NewWriter(b)
+	gz.Write([]byte("payload"))
+	gz.Close()
+
+	req := httptest.NewRequest("PUT", "/api/graph/assets", b)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+
+	WithCompression(DefaultMaxDecompressedBodySize, echoHandler)(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "payload", rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+}
+
+func TestShouldCompressResponseWhenAccepted(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/api/graph/assets", strings.NewReader("payload"))
+	req.Header.Set("Accept-Encoding", "deflate, gzip;q=1.0")
+	rec := httptest.NewRecorder()
+
+	WithCompression(DefaultMaxDecompressedBodySize, echoHandler)(rec, req)
+
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(body))
+}
+
+func TestShouldRejectUnsupportedContentEncoding(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/api/graph/assets", strings.NewReader("payload"))
+	req.Header.Set("Content-Encoding", "br")
+	rec := httptest.NewRecorder()
+
+	WithCompression(DefaultMaxDecompressedBodySize, echoHandler)(rec, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+}
+
+func TestShouldRefuseDecompressedBodyLargerThanLimit(t *testing.T) {
+	b := new(bytes.Buffer)
+	gz := gzip.NewWriter(b)
+	gz.Write(bytes.Repeat([]byte("a"), 1<<20))
+	gz.Close()
+
+	send := func(h http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/graph/assets", bytes.NewReader(b.Bytes()))
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		WithCompression(1024, h)(rec, req)
+		return rec
+	}
+
+	var read int
+	rec := send(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		read = len(body)
+		if err != nil {
+			ReplyWithBadRequest(w, err)
+		}
+	})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, 1024, read)
+
+	// The decompressed body is refused as well when the decoder hides the error of the body
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "0123456789abcdef"}}
+	update := handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		_, err := io.ReadAll(r.Body)
+		return fmt.Errorf("Unable to decode: %v", err)
+	}, NewUpdateLimiter(1, nil), "insert_assets")
+	rec = send(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(utils.XAuthTokenHeader, "0123456789abcdef")
+		update(w, r)
+	})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/audit"
+)
+
+const (
+	// DefaultAuditEntriesLimit is the number of audit entries returned when the request does not set a limit
+	DefaultAuditEntriesLimit = 100
+	// MaxAuditEntriesLimit is the maximum number of audit entries returned by a request
+	MaxAuditEntriesLimit = 1000
+)
+
+// parseAuditFilter parse the filter of the audit entries from the query string of the request
+func parseAuditFilter(r *http.Request) (audit.Filter, error) {
+	params := r.URL.Query()
+	filter := audit.Filter{
+		Actor:     params.Get("actor"),
+		Source:    params.Get("source"),
+		Operation: params.Get("operation"),
+		Outcome:   audit.Outcome(params.Get("outcome")),
+		Limit:     DefaultAuditEntriesLimit,
+	}
+
+	switch filter.Outcome {
+	case "", audit.OutcomeSuccess, audit.OutcomeDenied, audit.OutcomeFailure:
+	default:
+		return filter, fmt.Errorf("Outcome must be one of %s, %s or %s", audit.OutcomeSuccess, audit.OutcomeDenied, audit.OutcomeFailure)
+	}
+
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := params.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("Parameter %s must be a RFC3339 timestamp: %v", name, err)
+			}
+			*t = parsed
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MaxAuditEntriesLimit {
+			return filter, fmt.Errorf("Parameter limit must be a number between 1 and %d", MaxAuditEntriesLimit)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// GetAdminAudit GET the entries of the audit log matching the filter of the query string, the most recent first
+func GetAdminAudit(store audit.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		entries, err := store.ListAuditEntries(r.Context(), filter)
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
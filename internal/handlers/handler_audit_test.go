@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/audit"
+	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAuditStore struct {
+	entries []audit.Entry
+	filter  audit.Filter
+}
+
+func (m *mockAuditStore) RecordAuditEntry(ctx context.Context, entry audit.Entry) error {
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *mockAuditStore) ListAuditEntries(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	m.filter = filter
+	return m.entries, nil
+}
+
+func TestShouldAuditUpdatesOfSources(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "0123456789abcdef"}}
+	store := &mockAuditStore{}
+	handler := audit.Middleware(store, "insert_assets", handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		if _, err := decodeAssets(r); err != nil {
+			return err
+		}
+		if source == "" {
+			return errors.New("no source")
+		}
+		return nil
+	}, NewUpdateLimiter(1, nil), "insert_assets"))
+
+	req := httptest.NewRequest("PUT", "/api/graph/assets", strings.NewReader(`{"assets":[{"type":"ip","key":"10.0.0.1"},{"type":"ip","key":"10.0.0.2"}]}`))
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789abcdef")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest("PUT", "/api/graph/assets", strings.NewReader(`{"assets":[]}`))
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789000000")
+	handler(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("PUT", "/api/graph/assets", strings.NewReader(`not json`))
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789abcdef")
+	handler(httptest.NewRecorder(), req)
+
+	require.Len(t, store.entries, 3)
+	entry := store.entries[0]
+	assert.Equal(t, audit.ActorToken, entry.ActorKind)
+	assert.Equal(t, "scanner", entry.Actor)
+	assert.Equal(t, sources.DefaultTokenName, entry.Token)
+	assert.Equal(t, "scanner", entry.Source)
+	assert.Equal(t, "PUT", entry.Method)
+	assert.Equal(t, "/api/graph/assets", entry.Endpoint)
+	assert.Equal(t, "insert_assets", entry.Operation)
+	assert.Equal(t, int64(2), entry.Assets)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.Equal(t, audit.OutcomeSuccess, entry.Outcome)
+	assert.WithinDuration(t, time.Now(), entry.Timestamp, time.Minute)
+
+	assert.Equal(t, audit.ActorAnonymous, store.entries[1].ActorKind)
+	assert.Equal(t, audit.OutcomeDenied, store.entries[1].Outcome)
+
+	assert.Equal(t, "scanner", store.entries[2].Actor)
+	assert.Equal(t, audit.OutcomeFailure, store.entries[2].Outcome)
+	assert.Contains(t, store.entries[2].Error, "invalid character")
+}
+
+func TestShouldAuditAdministrationOfSources(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "old"}}
+	store := &mockAuditStore{}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/admin/sources/{name}", audit.Middleware(store, "remove_source", DeleteAdminSource(registry))).Methods("DELETE")
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/admin/sources/scanner", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/admin/sources/scanner", nil))
+
+	require.Len(t, store.entries, 2)
+	assert.Equal(t, "scanner", store.entries[0].Source)
+	assert.Equal(t, audit.OutcomeSuccess, store.entries[0].Outcome)
+	assert.Equal(t, http.StatusNotFound, store.entries[1].Status)
+	assert.Equal(t, audit.OutcomeFailure, store.entries[1].Outcome)
+	assert.NotEmpty(t, store.entries[1].Error)
+}
+
+func TestShouldListAuditEntries(t *testing.T) {
+	store := &mockAuditStore{entries: []audit.Entry{{ID: 1, Actor: "alice", Operation: "flush_database"}}}
+
+	rec := httptest.NewRecorder()
+	GetAdminAudit(store)(rec, httptest.NewRequest("GET",
+		"/api/admin/audit?actor=alice&source=scanner&outcome=denied&since=2020-01-01T00:00:00Z&limit=10", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"actor":"alice"`)
+	assert.Equal(t, audit.Filter{
+		Actor:   "alice",
+		Source:  "scanner",
+		Outcome: audit.OutcomeDenied,
+		Since:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Limit:   10,
+	}, store.filter)
+
+	rec = httptest.NewRecorder()
+	GetAdminAudit(store)(rec, httptest.NewRequest("GET", "/api/admin/audit", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, DefaultAuditEntriesLimit, store.filter.Limit)
+
+	for _, query := range []string{"outcome=unknown", "since=yesterday", "limit=0", "limit=100000"} {
+		rec = httptest.NewRecorder()
+		GetAdminAudit(store)(rec, httptest.NewRequest("GET", "/api/admin/audit?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
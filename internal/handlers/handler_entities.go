@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+)
+
+// ResolveEntitiesResponseBody is the response body of the entity resolution endpoint
+type ResolveEntitiesResponseBody struct {
+	Links int64 `json:"links"`
+}
+
+func replyWithResolvedEntities(w http.ResponseWriter, r *http.Request, resolver knowledge.EntityResolver) {
+	count, err := resolver.ResolveEntities(r.Context())
+	if err != nil {
+		ReplyWithInternalError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(ResolveEntitiesResponseBody{Links: count}); err != nil {
+		ReplyWithInternalError(w, err)
+	}
+}
+
+// GetResolutionRules GET the entity resolution rules
+func GetResolutionRules(resolver knowledge.EntityResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rules, err := resolver.ListResolutionRules(r.Context())
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(rules); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+// PutResolutionRule PUT an entity resolution rule and apply it right away
+func PutResolutionRule(resolver knowledge.EntityResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rule := knowledge.ResolutionRule{}
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := rule.Check(); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := resolver.AddResolutionRule(r.Context(), rule); err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+		replyWithResolvedEntities(w, r, resolver)
+	}
+}
+
+// DeleteResolutionRule DELETE an entity resolution rule along with the links it created
+func DeleteResolutionRule(resolver knowledge.EntityResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rule := knowledge.ResolutionRule{}
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := resolver.RemoveResolutionRule(r.Context(), rule); err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+		replyWithResolvedEntities(w, r, resolver)
+	}
+}
+
+// PutSameAsLink PUT a link declaring two assets as the same entity
+func PutSameAsLink(resolver knowledge.EntityResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link := knowledge.SameAsLink{}
+		if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := link.Check(); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := resolver.AddSameAsLink(r.Context(), link); err != nil {
+			if errors.Is(err, knowledge.ErrUnknownAsset) {
+				ReplyWithBadRequest(w, err)
+				return
+			}
+			ReplyWithInternalError(w, err)
+			return
+		}
+	}
+}
+
+// DeleteSameAsLink DELETE a link between two assets
+func DeleteSameAsLink(resolver knowledge.EntityResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link := knowledge.SameAsLink{}
+		if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := resolver.RemoveSameAsLink(r.Context(), link); err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+	}
+}
+
+// PostResolveEntities POST a request to recompute the links created by the entity resolution rules
+func PostResolveEntities(resolver knowledge.EntityResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		replyWithResolvedEntities(w, r, resolver)
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/client"
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
+	"github.com/clems4ever/go-graphkb/internal/metrics"
+	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	// StaleActionFlag lists the sources stale beyond the threshold in the query results
+	StaleActionFlag = "flag"
+	// StaleActionHide excludes the assets and relations only bound to the sources stale beyond the threshold from
+	// the query results
+	StaleActionHide = "hide"
+)
+
+// FreshnessConfiguration configures when the sources are stale and what is done with their data
+type FreshnessConfiguration struct {
+	// DefaultInterval is the expected update interval of the sources which did not declare one, 0 means these
+	// sources are never stale
+	DefaultInterval time.Duration `mapstructure:"default_expected_update_interval"`
+	// StaleAction is applied to the data of the sources which have been stale for longer than the threshold
+	StaleAction    string        `mapstructure:"stale_action"`
+	StaleThreshold time.Duration `mapstructure:"stale_threshold"`
+}
+
+// LoadFreshnessConfiguration read the freshness configuration
+func LoadFreshnessConfiguration() (FreshnessConfiguration, error) {
+	config := FreshnessConfiguration{}
+	if err := viper.UnmarshalKey("freshness", &config); err != nil {
+		return config, fmt.Errorf("Unable to read the freshness configuration: %v", err)
+	}
+	switch config.StaleAction {
+	case "", StaleActionFlag, StaleActionHide:
+	default:
+		return config, fmt.Errorf("Option `freshness.stale_action` must be either %s or %s", StaleActionFlag, StaleActionHide)
+	}
+	return config, nil
+}
+
+// SourceStatus is the freshness of the data of a source
+type SourceStatus struct {
+	Name         string                 `json:"name"`
+	State        sources.FreshnessState `json:"state"`
+	LastUpdateAt *time.Time             `json:"last_update_at,omitempty"`
+	// ExpectedUpdateInterval is the interval declared by the source or the default interval
+	ExpectedUpdateInterval string `json:"expected_update_interval,omitempty"`
+	StaleFor               string `json:"stale_for,omitempty"`
+	// StaleAction is the action applied to the data of the source when it has been stale for longer than the threshold
+	StaleAction string `json:"stale_action,omitempty"`
+}
+
+// beyondThreshold return true if the stale action applies to the data of the source
+func (c FreshnessConfiguration) beyondThreshold(f sources.Freshness, now time.Time) bool {
+	if c.StaleAction == "" {
+		return false
+	}
+	staleFor := f.StaleFor(now, c.DefaultInterval)
+	return staleFor > 0 && staleFor >= c.StaleThreshold
+}
+
+// sourceStatus return the status of the source
+func (c FreshnessConfiguration) sourceStatus(f sources.Freshness, now time.Time) SourceStatus {
+	status := SourceStatus{
+		Name:         f.Source,
+		State:        f.State(now, c.DefaultInterval),
+		LastUpdateAt: f.LastUpdateAt,
+	}
+	if interval := f.Interval(c.DefaultInterval); interval > 0 {
+		status.ExpectedUpdateInterval = interval.String()
+	}
+	if staleFor := f.StaleFor(now, c.DefaultInterval); staleFor > 0 {
+		status.StaleFor = staleFor.Truncate(time.Second).String()
+	}
+	if c.beyondThreshold(f, now) {
+		status.StaleAction = c.StaleAction
+	}
+	return status
+}
+
+// markSourceUpdated record the source successfully updated its graph. A failure is only logged since the update has
+// been applied.
+func markSourceUpdated(ctx context.Context, registry sources.Registry, source string) {
+	now := time.Now()
+	metrics.LastSuccessfulDatasourceUpdateTimestampGauge.
+		With(prometheus.Labels{"source": source}).
+		Set(float64(now.Unix()))
+
+	if err := registry.MarkSourcesUpdated(ctx, []sources.SourceUpdate{{Source: source, At: now}}); err != nil {
+		logrus.Errorf("Unable to record the update of source %s: %v", source, err)
+	}
+}
+
+// GetSourcesStatus GET the freshness of the data of the sources the user is allowed to query
+func GetSourcesStatus(registry sources.Registry, config FreshnessConfiguration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		freshness, err := registry.ListFreshness(r.Context())
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		allowedSources := kbcontext.AllowedSources(r.Context())
+		now := time.Now()
+		statuses := []SourceStatus{}
+		for _, f := range freshness {
+			if allowedSources != nil && !utils.IsStringInSlice(f.Source, allowedSources) {
+				continue
+			}
+			statuses = append(statuses, config.sourceStatus(f, now))
+		}
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+// PutSourceFreshness PUT the interval the authenticated source declares updating its graph at
+func PutSourceFreshness(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, source, err := IsSourceAuthenticated(registry, r, sources.ScopeGraphWrite)
+		if !ok {
+			replyWithSourceAuthError(w, err)
+			return
+		}
+
+		requestBody := client.PutGraphFreshnessRequestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		var interval time.Duration
+		if requestBody.ExpectedUpdateInterval != "" {
+			interval, err = time.ParseDuration(requestBody.ExpectedUpdateInterval)
+			if err != nil || interval < 0 {
+				ReplyWithBadRequest(w, fmt.Errorf("Expected update interval %q must be a positive duration", requestBody.ExpectedUpdateInterval))
+				return
+			}
+		}
+
+		if err := registry.SetExpectedInterval(r.Context(), source, interval); err != nil {
+			replyWithSourceError(w, err)
+			return
+		}
+	}
+}
+
+// WithStaleSources apply the stale action to the data of the sources which have been stale for longer than the
+// threshold. Hidden sources are removed from the sources the request is allowed to query while flagged sources are
+// put in the context to be listed in the query results. The handler must be wrapped by the authentication so that
+// the sources the user is restricted to are known.
+func WithStaleSources(registry sources.Registry, config FreshnessConfiguration, h http.HandlerFunc) http.HandlerFunc {
+	if config.StaleAction == "" {
+		return h
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		freshness, err := registry.ListFreshness(r.Context())
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		ctx := r.Context()
+		allowedSources := kbcontext.AllowedSources(ctx)
+		now := time.Now()
+		stale := []string{}
+		visible := []string{}
+		for _, f := range freshness {
+			if allowedSources != nil && !utils.IsStringInSlice(f.Source, allowedSources) {
+				continue
+			}
+			if config.beyondThreshold(f, now) {
+				stale = append(stale, f.Source)
+			} else {
+				visible = append(visible, f.Source)
+			}
+		}
+		if len(stale) == 0 {
+			h(w, r)
+			return
+		}
+		sort.Strings(stale)
+		sort.Strings(visible)
+
+		switch config.StaleAction {
+		case StaleActionHide:
+			ctx = context.WithValue(ctx, kbcontext.ContextKeyAllowedSources, visible)
+		case StaleActionFlag:
+			ctx = context.WithValue(ctx, kbcontext.ContextKeyStaleSources, stale)
+		}
+		h(w, r.WithContext(ctx))
+	}
+}
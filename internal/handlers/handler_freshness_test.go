@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/client"
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFreshnessRegistry() *mockRegistry {
+	recently := time.Now().Add(-time.Minute)
+	longAgo := time.Now().Add(-48 * time.Hour)
+	return &mockRegistry{
+		tokens: map[string]string{"scanner": "0123456789abcdef", "crawler": "0123456789fedcba", "importer": "0123456789aaaaaa"},
+		freshness: map[string]sources.Freshness{
+			"scanner": {LastUpdateAt: &recently, ExpectedInterval: time.Hour},
+			"crawler": {LastUpdateAt: &longAgo, ExpectedInterval: time.Hour},
+		},
+	}
+}
+
+func TestShouldReportStatusOfSources(t *testing.T) {
+	registry := newFreshnessRegistry()
+	config := FreshnessConfiguration{StaleAction: StaleActionFlag, StaleThreshold: 24 * time.Hour}
+
+	rec := httptest.NewRecorder()
+	GetSourcesStatus(registry, config)(rec, httptest.NewRequest("GET", "/api/sources/status", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var statuses []SourceStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	require.Len(t, statuses, 3)
+	assert.Equal(t, "crawler", statuses[0].Name)
+	assert.Equal(t, sources.StateStale, statuses[0].State)
+	assert.Equal(t, "1h0m0s", statuses[0].ExpectedUpdateInterval)
+	assert.Equal(t, StaleActionFlag, statuses[0].StaleAction)
+	assert.Equal(t, "importer", statuses[1].Name)
+	assert.Equal(t, sources.StateNeverReported, statuses[1].State)
+	assert.Nil(t, statuses[1].LastUpdateAt)
+	assert.Equal(t, "scanner", statuses[2].Name)
+	assert.Equal(t, sources.StateFresh, statuses[2].State)
+	assert.Empty(t, statuses[2].StaleFor)
+
+	// The users restricted to some sources only see the status of these sources
+	req := httptest.NewRequest("GET", "/api/sources/status", nil)
+	req = req.WithContext(context.WithValue(req.Context(), kbcontext.ContextKeyAllowedSources, []string{"scanner"}))
+	rec = httptest.NewRecorder()
+	GetSourcesStatus(registry, config)(rec, req)
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "scanner", statuses[0].Name)
+}
+
+func TestShouldApplyStaleActionBeyondThreshold(t *testing.T) {
+	registry := newFreshnessRegistry()
+
+	var allowed, stale []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		allowed = kbcontext.AllowedSources(r.Context())
+		stale = kbcontext.StaleSources(r.Context())
+	}
+	send := func(config FreshnessConfiguration, restrictedTo []string) {
+		allowed, stale = nil, nil
+		req := httptest.NewRequest("POST", "/api/query", nil)
+		if restrictedTo != nil {
+			req = req.WithContext(context.WithValue(req.Context(), kbcontext.ContextKeyAllowedSources, restrictedTo))
+		}
+		WithStaleSources(registry, config, handler)(httptest.NewRecorder(), req)
+	}
+
+	send(FreshnessConfiguration{StaleAction: StaleActionHide, StaleThreshold: time.Hour}, nil)
+	assert.ElementsMatch(t, []string{"importer", "scanner"}, allowed)
+	assert.Nil(t, stale)
+
+	send(FreshnessConfiguration{StaleAction: StaleActionHide, StaleThreshold: time.Hour}, []string{"crawler"})
+	assert.NotNil(t, allowed)
+	assert.Empty(t, allowed)
+
+	send(FreshnessConfiguration{StaleAction: StaleActionFlag, StaleThreshold: time.Hour}, nil)
+	assert.Nil(t, allowed)
+	assert.Equal(t, []string{"crawler"}, stale)
+
+	// The sources stale for less than the threshold are left untouched
+	send(FreshnessConfiguration{StaleAction: StaleActionHide, StaleThreshold: 72 * time.Hour}, nil)
+	assert.Nil(t, allowed)
+	assert.Nil(t, stale)
+}
+
+type mockRunGraphDB struct {
+	knowledge.GraphDB
+	completed []string
+}
+
+func (m *mockRunGraphDB) CompleteRun(ctx context.Context, sourceName, run string) (int64, error) {
+	m.completed = append(m.completed, run)
+	return 42, nil
+}
+
+func TestShouldRecordUpdatesOfSourcesWhenRunCompletes(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "0123456789abcdef"}}
+	limiter := NewUpdateLimiter(1, nil)
+	insert := handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		return nil
+	}, limiter, "insert_assets")
+	graphDB := &mockRunGraphDB{}
+	complete := PostRunComplete(registry, knowledge.NewGraphUpdater(graphDB, nil, nil), limiter)
+
+	req := httptest.NewRequest("PUT", "/api/graph/assets", nil)
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789abcdef")
+	rec := httptest.NewRecorder()
+	insert(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	// The run may still fail, the source has not updated its graph yet
+	assert.Nil(t, registry.freshness["scanner"].LastUpdateAt)
+
+	req = httptest.NewRequest("POST", "/api/graph/runs/run1/complete", nil)
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789abcdef")
+	req = mux.SetURLVars(req, map[string]string{"id": "run1"})
+	rec = httptest.NewRecorder()
+	complete(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"run1"}, graphDB.completed)
+	// The source is told the revision its run produced
+	var body client.RevisionResponseBody
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, int64(42), body.Revision)
+	require.NotNil(t, registry.freshness["scanner"].LastUpdateAt)
+	assert.WithinDuration(t, time.Now(), *registry.freshness["scanner"].LastUpdateAt, time.Minute)
+}
+
+func TestShouldDeclareExpectedUpdateInterval(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "0123456789abcdef"}}
+
+	send := func(token, body string) int {
+		req := httptest.NewRequest("PUT", "/api/graph/freshness", strings.NewReader(body))
+		req.Header.Set(utils.XAuthTokenHeader, token)
+		rec := httptest.NewRecorder()
+		PutSourceFreshness(registry)(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("0123456789abcdef", `{"expected_update_interval":"6h"}`))
+	assert.Equal(t, 6*time.Hour, registry.freshness["scanner"].ExpectedInterval)
+
+	assert.Equal(t, http.StatusBadRequest, send("0123456789abcdef", `{"expected_update_interval":"-1h"}`))
+	assert.Equal(t, http.StatusBadRequest, send("0123456789abcdef", `{"expected_update_interval":"daily"}`))
+	assert.Equal(t, http.StatusUnauthorized, send("0123456789000000", `{"expected_update_interval":"1h"}`))
+
+	assert.Equal(t, http.StatusOK, send("0123456789abcdef", `{}`))
+	assert.Equal(t, time.Duration(0), registry.freshness["scanner"].ExpectedInterval)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/clems4ever/go-graphkb/internal/schema"
+)
+
+// GetOntology GET the ontology shared by all the sources
+func GetOntology(persistor schema.OntologyPersistor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ontology, err := persistor.LoadOntology(r.Context())
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(ontology); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+// PutOntologyEntry PUT an entry declaring an asset type as an alias or a subtype of another one
+func PutOntologyEntry(persistor schema.OntologyPersistor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := schema.OntologyEntry{}
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := entry.Check(); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := persistor.AddOntologyEntry(r.Context(), entry); err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+	}
+}
+
+// DeleteOntologyEntry DELETE an entry from the ontology
+func DeleteOntologyEntry(persistor schema.OntologyPersistor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := schema.OntologyEntry{}
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := persistor.RemoveOntologyEntry(r.Context(), entry); err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+	}
+}
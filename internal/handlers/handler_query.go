@@ -12,6 +12,7 @@ import (
 	"github.com/clems4ever/go-graphkb/internal/kbcontext"
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
 	"github.com/clems4ever/go-graphkb/internal/metrics"
+	"github.com/clems4ever/go-graphkb/internal/schema"
 	"github.com/patrickmn/go-cache"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
@@ -31,10 +32,14 @@ type QueryResponseBody struct {
 	Items           [][]interface{} `json:"items"`
 	Columns         []ColumnType    `json:"columns"`
 	ExecutionTimeMs time.Duration   `json:"execution_time_ms"`
+	// StaleSources are the sources stale beyond the threshold which may have contributed outdated data to the results
+	StaleSources []string `json:"stale_sources,omitempty"`
 }
 
 type AssetWithIDAndSources struct {
 	Sources []string `json:"sources,omitempty"`
+	// Aliases are the assets resolved as the same entity as this canonical asset
+	Aliases []knowledge.AssetWithID `json:"aliases,omitempty"`
 	knowledge.AssetWithID
 }
 
@@ -44,7 +49,7 @@ type RelationWithIDAndSources struct {
 }
 
 // PostQuery post endpoint to query the graph
-func PostQuery(database knowledge.GraphDB, queryHistorizer history.Historizer, cacheTTL time.Duration) http.HandlerFunc {
+func PostQuery(database knowledge.GraphDB, queryHistorizer history.Historizer, ontologyPersistor schema.OntologyPersistor, resolver knowledge.EntityResolver, cacheTTL time.Duration) http.HandlerFunc {
 	cache := cache.New(cacheTTL, cacheTTL*2)
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -54,16 +59,21 @@ func PostQuery(database knowledge.GraphDB, queryHistorizer history.Historizer, c
 			return
 		}
 
-		cacheKey := string(body)
-
-		var response []byte
-
 		ctx := r.Context()
-		user := r.Header.Get("X-Forwarded-User")
-		if user != "" {
-			ctx = context.WithValue(ctx, kbcontext.ContextKeyXForwardedUser, user)
+		user := kbcontext.User(ctx)
+
+		ontology, err := ontologyPersistor.LoadOntology(ctx)
+		if err != nil {
+			ReplyWithInternalError(w, fmt.Errorf("Unable to load the ontology: %v", err))
+			return
 		}
 
+		// The results depend on the ontology, on the sources the user is allowed to query and on the stale sources
+		allowedSources, staleSources := kbcontext.AllowedSources(ctx), kbcontext.StaleSources(ctx)
+		cacheKey := fmt.Sprintf("%v:%q:%q:%s", ontology.Entries, allowedSources, staleSources, body)
+
+		var response []byte
+
 		if res, ok := cache.Get(cacheKey); ok {
 			response = res.([]byte)
 			metrics.GraphQueryStatusCounter.With(prometheus.Labels{
@@ -71,7 +81,7 @@ func PostQuery(database knowledge.GraphDB, queryHistorizer history.Historizer, c
 				"user":   user,
 			}).Inc()
 		} else {
-			res, err := executeQuery(ctx, database, queryHistorizer, body)
+			res, err := executeQuery(ctx, database, queryHistorizer, ontology, resolver, body)
 			if err != nil {
 				ReplyWithInternalError(w, err)
 				return
@@ -87,7 +97,7 @@ func PostQuery(database knowledge.GraphDB, queryHistorizer history.Historizer, c
 	}
 }
 
-func executeQuery(ctx context.Context, database knowledge.GraphDB, queryHistorizer history.Historizer, body []byte) ([]byte, error) {
+func executeQuery(ctx context.Context, database knowledge.GraphDB, queryHistorizer history.Historizer, ontology schema.Ontology, resolver knowledge.EntityResolver, body []byte) ([]byte, error) {
 
 	requestBody := QueryRequestBody{}
 	err := json.Unmarshal(body, &requestBody)
@@ -103,10 +113,15 @@ func executeQuery(ctx context.Context, database knowledge.GraphDB, queryHistoriz
 	if QueryMaxTime == 0 {
 		QueryMaxTime = 30 * time.Second
 	}
-	querier := knowledge.NewQuerier(database, queryHistorizer)
 	ctx, cancel := context.WithTimeout(ctx, QueryMaxTime)
 	defer cancel()
 
+	querier := knowledge.NewQuerier(database, queryHistorizer)
+	querier.Options.Ontology = &ontology
+	querier.Options.ResolveEntities = viper.GetBool("entity_resolution")
+	allowedSources := kbcontext.AllowedSources(ctx)
+	querier.Options.AllowedSources = allowedSources
+
 	res, err := querier.Query(ctx, requestBody.Query)
 	if err != nil {
 		return nil, err
@@ -149,7 +164,7 @@ func executeQuery(ctx context.Context, database knowledge.GraphDB, queryHistoriz
 			switch v := x.(type) {
 			case knowledge.AssetWithID:
 				rowDocs = append(rowDocs, v)
-				if requestBody.IncludeSources {
+				if requestBody.IncludeSources || querier.Options.ResolveEntities {
 					assetIDs[v.ID] = struct{}{}
 				}
 			case knowledge.RelationWithID:
@@ -164,41 +179,64 @@ func executeQuery(ctx context.Context, database knowledge.GraphDB, queryHistoriz
 		items = append(items, rowDocs)
 	}
 
-	if requestBody.IncludeSources {
+	if requestBody.IncludeSources || querier.Options.ResolveEntities {
 		ids := []string{}
 		for k := range assetIDs {
 			ids = append(ids, k)
 		}
-		sourcesByID, err := database.GetAssetSources(ctx, ids)
-		if err != nil {
-			return nil, err
+
+		var sourcesByID map[string][]string
+		if requestBody.IncludeSources {
+			sourcesByID, err = database.GetAssetSources(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			sourcesByID = filterAllowedSources(sourcesByID, allowedSources)
+		}
+
+		var aliasesByID map[string][]knowledge.AssetWithID
+		if querier.Options.ResolveEntities {
+			aliasesByID, err = resolver.GetAssetAliases(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			if aliasesByID, err = filterAllowedAliases(ctx, database, aliasesByID, allowedSources); err != nil {
+				return nil, err
+			}
 		}
 
 		for i, row := range items {
 			for j, col := range row {
 				switch v := col.(type) {
 				case knowledge.AssetWithID:
-					sources, ok := sourcesByID[v.ID]
-					if !ok {
-						return nil, fmt.Errorf("Unable to find sources of asset with ID %s", v.ID)
-					}
-					items[i][j] = AssetWithIDAndSources{
+					asset := AssetWithIDAndSources{
 						AssetWithID: v,
-						Sources:     sources,
+						Aliases:     aliasesByID[v.ID],
+					}
+					if requestBody.IncludeSources {
+						sources, ok := sourcesByID[v.ID]
+						if !ok {
+							return nil, fmt.Errorf("Unable to find sources of asset with ID %s", v.ID)
+						}
+						asset.Sources = sources
 					}
+					items[i][j] = asset
 				}
 			}
 		}
+	}
 
-		ids = []string{}
+	if requestBody.IncludeSources {
+		ids := []string{}
 		for k := range relationIDs {
 			ids = append(ids, k)
 		}
 
-		sourcesByID, err = database.GetRelationSources(ctx, ids)
+		sourcesByID, err := database.GetRelationSources(ctx, ids)
 		if err != nil {
 			return nil, err
 		}
+		sourcesByID = filterAllowedSources(sourcesByID, allowedSources)
 
 		for i, row := range items {
 			for j, col := range row {
@@ -222,5 +260,36 @@ func executeQuery(ctx context.Context, database knowledge.GraphDB, queryHistoriz
 		Items:           items,
 		Columns:         columns,
 		ExecutionTimeMs: res.Statistics.Execution / time.Millisecond,
+		StaleSources:    kbcontext.StaleSources(ctx),
 	})
 }
+
+// filterAllowedAliases drop the aliases bound to none of the sources the user is allowed to query. Nothing is
+// filtered when allowed is nil.
+func filterAllowedAliases(ctx context.Context, database knowledge.GraphDB, aliasesByID map[string][]knowledge.AssetWithID, allowed []string) (map[string][]knowledge.AssetWithID, error) {
+	if allowed == nil {
+		return aliasesByID, nil
+	}
+
+	ids := []string{}
+	for _, aliases := range aliasesByID {
+		for _, a := range aliases {
+			ids = append(ids, a.ID)
+		}
+	}
+	sourcesByID, err := database.GetAssetSources(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	sourcesByID = filterAllowedSources(sourcesByID, allowed)
+
+	filtered := make(map[string][]knowledge.AssetWithID)
+	for id, aliases := range aliasesByID {
+		for _, a := range aliases {
+			if _, ok := sourcesByID[a.ID]; ok {
+				filtered[id] = append(filtered[id], a)
+			}
+		}
+	}
+	return filtered, nil
+}
@@ -6,11 +6,30 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/utils"
 )
 
 const MaxIds = 20000
 
+// filterAllowedSources keep only the sources the user is allowed to query and drop the IDs bound to none of them.
+// Nothing is filtered when allowed is nil.
+func filterAllowedSources(sourcesByID map[string][]string, allowed []string) map[string][]string {
+	if allowed == nil {
+		return sourcesByID
+	}
+	filtered := make(map[string][]string)
+	for id, sources := range sourcesByID {
+		for _, s := range sources {
+			if utils.IsStringInSlice(s, allowed) {
+				filtered[id] = append(filtered[id], s)
+			}
+		}
+	}
+	return filtered
+}
+
 func postAssetSources(database knowledge.GraphDB, fetcherFn func(context.Context, []string) (map[string][]string, error)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type RequestBody struct {
@@ -50,7 +69,7 @@ func postAssetSources(database knowledge.GraphDB, fetcherFn func(context.Context
 		}
 
 		response := ResponseBody{
-			Results: sources,
+			Results: filterAllowedSources(sources, kbcontext.AllowedSources(r.Context())),
 		}
 
 		err = json.NewEncoder(w).Encode(response)
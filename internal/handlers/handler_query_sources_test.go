@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldFilterSourcesNotAllowed(t *testing.T) {
+	sourcesByID := map[string][]string{
+		"1": {"scanner", "hr-directory"},
+		"2": {"hr-directory"},
+		"3": {"scanner"},
+	}
+
+	assert.Equal(t, sourcesByID, filterAllowedSources(sourcesByID, nil))
+	assert.Equal(t, map[string][]string{"1": {"scanner"}, "3": {"scanner"}},
+		filterAllowedSources(sourcesByID, []string{"scanner"}))
+	assert.Empty(t, filterAllowedSources(sourcesByID, []string{}))
+}
@@ -2,26 +2,102 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/clems4ever/go-graphkb/internal/client"
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/schema"
 	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/clems4ever/go-graphkb/internal/utils"
 )
 
-// GetGraphRead GET the entire graph generated by the data source
+// MaxSyncBuckets is the maximum number of buckets the graph of a source can be split in for synchronization
+const MaxSyncBuckets = 65536
+
+// authenticateSource check the client certificate or the auth token of the request is allowed to read the graph and reply when the source
+// cannot be authenticated
+func authenticateSource(registry sources.Registry, w http.ResponseWriter, r *http.Request) (string, bool) {
+	ok, source, err := IsSourceAuthenticated(registry, r, sources.ScopeGraphRead)
+	if errors.Is(err, sources.ErrInsufficientScope) {
+		ReplyWithForbidden(w, err)
+		return "", false
+	} else if err != nil {
+		ReplyWithInternalError(w, err)
+		return "", false
+	}
+
+	if !ok {
+		ReplyWithUnauthorized(w)
+		return "", false
+	}
+	return source, true
+}
+
+func parseBuckets(r *http.Request) (int, error) {
+	buckets, err := strconv.Atoi(r.URL.Query().Get("buckets"))
+	if err != nil || buckets <= 0 || buckets > MaxSyncBuckets {
+		return 0, fmt.Errorf("Parameter buckets must be a number between 1 and %d", MaxSyncBuckets)
+	}
+	return buckets, nil
+}
+
+func parseBucketList(r *http.Request, name string, buckets int) ([]int, error) {
+	selected := []int{}
+	for _, v := range r.URL.Query()[name] {
+		b, err := strconv.Atoi(v)
+		if err != nil || b < 0 || b >= buckets {
+			return nil, fmt.Errorf("Parameter %s must be a number between 0 and %d", name, buckets-1)
+		}
+		selected = append(selected, b)
+	}
+	return selected, nil
+}
+
+// GetGraphRead GET the entire graph generated by the data source or, when buckets are provided, the part of
+// the graph falling in those buckets.
 func GetGraphRead(registry sources.Registry, graphDB knowledge.GraphDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ok, source, err := IsTokenValid(registry, r)
+		source, ok := authenticateSource(registry, w, r)
+		if !ok {
+			return
+		}
+
+		// The revision is read before the graph so that replaying the changes since this revision on top
+		// of the graph gives the latest state.
+		revision, err := graphDB.GetRevision(r.Context(), source)
 		if err != nil {
 			ReplyWithInternalError(w, err)
 			return
 		}
 
-		if !ok {
-			ReplyWithUnauthorized(w)
+		if r.URL.Query().Get("buckets") != "" {
+			buckets, err := parseBuckets(r)
+			if err != nil {
+				ReplyWithBadRequest(w, err)
+				return
+			}
+			assetBuckets, err := parseBucketList(r, "asset_bucket", buckets)
+			if err != nil {
+				ReplyWithBadRequest(w, err)
+				return
+			}
+			relationBuckets, err := parseBucketList(r, "relation_bucket", buckets)
+			if err != nil {
+				ReplyWithBadRequest(w, err)
+				return
+			}
+
+			w.Header().Set(utils.XRevisionHeader, strconv.FormatInt(revision, 10))
+			if err := graphDB.ReadGraphBuckets(r.Context(), source, buckets, assetBuckets, relationBuckets, knowledge.NewGraphEncoder(w)); err != nil {
+				ReplyWithInternalError(w, err)
+			}
 			return
 		}
 
+		w.Header().Set(utils.XRevisionHeader, strconv.FormatInt(revision, 10))
 		g := knowledge.NewGraph()
 		if err := graphDB.ReadGraph(r.Context(), source, knowledge.NewGraphEncoder(w)); err != nil {
 			ReplyWithInternalError(w, err)
@@ -39,3 +115,97 @@ func GetGraphRead(registry sources.Registry, graphDB knowledge.GraphDB) http.Han
 		}
 	}
 }
+
+// GetGraphRevision GET the current revision of the graph of the data source
+func GetGraphRevision(registry sources.Registry, graphDB knowledge.GraphDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source, ok := authenticateSource(registry, w, r)
+		if !ok {
+			return
+		}
+
+		revision, err := graphDB.GetRevision(r.Context(), source)
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(client.RevisionResponseBody{Revision: revision}); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+// GetGraphSchema GET the current schema of the graph of the data source
+func GetGraphSchema(registry sources.Registry, schemaPersistor schema.Persistor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source, ok := authenticateSource(registry, w, r)
+		if !ok {
+			return
+		}
+
+		sg, err := schemaPersistor.LoadSchema(r.Context(), source)
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+		ReplyWithSourceGraph(w, &sg)
+	}
+}
+
+// GetGraphChanges GET the changes made to the graph of the data source since a given revision
+func GetGraphChanges(registry sources.Registry, graphDB knowledge.GraphDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source, ok := authenticateSource(registry, w, r)
+		if !ok {
+			return
+		}
+
+		since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil {
+			ReplyWithBadRequest(w, fmt.Errorf("Parameter since must be a revision number"))
+			return
+		}
+
+		revision, changes, err := graphDB.ReadChanges(r.Context(), source, since)
+		if err != nil {
+			if errors.Is(err, knowledge.ErrRevisionTooOld) {
+				ReplyWithGone(w, err)
+				return
+			}
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		responseBody := client.ChangesResponseBody{Revision: revision, Changes: changes}
+		if err := json.NewEncoder(w).Encode(responseBody); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+// GetGraphChecksum GET the checksums of the graph of the data source split in buckets
+func GetGraphChecksum(registry sources.Registry, graphDB knowledge.GraphDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source, ok := authenticateSource(registry, w, r)
+		if !ok {
+			return
+		}
+
+		buckets, err := parseBuckets(r)
+		if err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		checksums, err := graphDB.GetChecksums(r.Context(), source, buckets)
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(checksums); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
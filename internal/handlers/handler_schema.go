@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+)
+
+// SchemaHistoryResponseBody is the response body of the schema history endpoint
+type SchemaHistoryResponseBody struct {
+	Source   string                 `json:"source"`
+	Versions []schema.SchemaVersion `json:"versions"`
+}
+
+// SchemaDiffResponseBody is the response body of the schema diff endpoint
+type SchemaDiffResponseBody struct {
+	Source string `json:"source"`
+	From   int64  `json:"from"`
+	To     int64  `json:"to"`
+	schema.SchemaDiff
+}
+
+// sourceFromQuery read the source parameter from the URL and check the source exists
+func sourceFromQuery(registry sources.Registry, r *http.Request) (string, error) {
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		return "", fmt.Errorf("Parameter source is required")
+	}
+
+	names, err := registry.ListSources(r.Context())
+	if err != nil {
+		return "", fmt.Errorf("Unable to list the sources: %v", err)
+	}
+	if !utils.IsStringInSlice(source, names) {
+		return "", fmt.Errorf("Source %s does not exist", source)
+	}
+	return source, nil
+}
+
+// GetSchemaHistory GET the list of versions of the schema of a source
+func GetSchemaHistory(registry sources.Registry, persistor schema.Persistor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source, err := sourceFromQuery(registry, r)
+		if err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		versions, err := persistor.ListSchemaVersions(r.Context(), source)
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(SchemaHistoryResponseBody{
+			Source:   source,
+			Versions: versions,
+		})
+		if err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+// GetSchemaDiff GET the vertices and edges added and removed between two versions of the schema of a source.
+// When the `to` parameter is omitted, the diff is computed against the latest version.
+func GetSchemaDiff(registry sources.Registry, persistor schema.Persistor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source, err := sourceFromQuery(registry, r)
+		if err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		if err != nil {
+			ReplyWithBadRequest(w, fmt.Errorf("Parameter from must be a schema version: %v", err))
+			return
+		}
+
+		var to int64
+		if toParam := r.URL.Query().Get("to"); toParam != "" {
+			to, err = strconv.ParseInt(toParam, 10, 64)
+			if err != nil {
+				ReplyWithBadRequest(w, fmt.Errorf("Parameter to must be a schema version: %v", err))
+				return
+			}
+		} else {
+			versions, err := persistor.ListSchemaVersions(r.Context(), source)
+			if err != nil {
+				ReplyWithInternalError(w, err)
+				return
+			}
+			if len(versions) == 0 {
+				ReplyWithNotFound(w, fmt.Errorf("Source %s has no schema", source))
+				return
+			}
+			to = versions[len(versions)-1].Version
+		}
+
+		fromSchema, err := persistor.LoadSchemaVersion(r.Context(), source, from)
+		if err != nil {
+			replyWithSchemaVersionError(w, err)
+			return
+		}
+
+		toSchema, err := persistor.LoadSchemaVersion(r.Context(), source, to)
+		if err != nil {
+			replyWithSchemaVersionError(w, err)
+			return
+		}
+
+		err = json.NewEncoder(w).Encode(SchemaDiffResponseBody{
+			Source:     source,
+			From:       from,
+			To:         to,
+			SchemaDiff: schema.Diff(fromSchema, toSchema),
+		})
+		if err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+func replyWithSchemaVersionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, schema.ErrSchemaVersionNotFound) {
+		ReplyWithNotFound(w, err)
+		return
+	}
+	ReplyWithInternalError(w, err)
+}
+
+// ConstraintViolationsResponseBody is the response body of the constraint violations report endpoint
+type ConstraintViolationsResponseBody struct {
+	Violations []knowledge.ConstraintViolation `json:"violations"`
+}
+
+// GetConstraintViolations GET the report of the assets violating the relation constraints declared by the sources
+func GetConstraintViolations(registry sources.Registry, persistor schema.Persistor, graphDB knowledge.GraphDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+			l, err := strconv.Atoi(limitParam)
+			if err != nil || l <= 0 {
+				ReplyWithBadRequest(w, fmt.Errorf("Parameter limit must be a positive integer"))
+				return
+			}
+			limit = l
+		}
+
+		names, err := registry.ListSources(r.Context())
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+
+		sg := schema.NewSchemaGraph()
+		for _, source := range names {
+			g, err := persistor.LoadSchema(r.Context(), source)
+			if err != nil {
+				ReplyWithInternalError(w, err)
+				return
+			}
+			sg.Merge(g)
+		}
+
+		response := ConstraintViolationsResponseBody{
+			Violations: []knowledge.ConstraintViolation{},
+		}
+		for _, c := range sg.Constraints {
+			violations, err := graphDB.FindConstraintViolations(r.Context(), c, limit)
+			if err != nil {
+				ReplyWithInternalError(w, err)
+				return
+			}
+			response.Violations = append(response.Violations, violations...)
+		}
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
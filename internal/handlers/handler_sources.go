@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/audit"
+	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/gorilla/mux"
+)
+
+// SourceRequestBody is the request body of the source creation and renaming endpoints
+type SourceRequestBody struct {
+	Name string `json:"name"`
+}
+
+// SourceCredentialsResponseBody is the response body of the endpoints generating a token for a source. The token
+// cannot be read afterwards.
+type SourceCredentialsResponseBody struct {
+	Name      string `json:"name"`
+	TokenName string `json:"token"`
+	AuthToken string `json:"auth_token"`
+}
+
+// SourceTokenRequestBody is the request body of the token creation endpoint
+type SourceTokenRequestBody struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// replyWithSourceError reply with the status matching the error returned by the sources registry
+func replyWithSourceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sources.ErrSourceNotFound), errors.Is(err, sources.ErrTokenNotFound):
+		ReplyWithNotFound(w, err)
+	case errors.Is(err, sources.ErrSourceAlreadyExists), errors.Is(err, sources.ErrTokenAlreadyExists):
+		ReplyWithConflict(w, err)
+	case errors.Is(err, sources.ErrInvalidSourceName), errors.Is(err, sources.ErrInvalidScope):
+		ReplyWithBadRequest(w, err)
+	default:
+		ReplyWithInternalError(w, err)
+	}
+}
+
+func replyWithCredentials(w http.ResponseWriter, name, tokenName, token string) {
+	err := json.NewEncoder(w).Encode(SourceCredentialsResponseBody{Name: name, TokenName: tokenName, AuthToken: token})
+	if err != nil {
+		ReplyWithInternalError(w, err)
+	}
+}
+
+// GetAdminSources GET the names of the registered sources
+func GetAdminSources(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names, err := registry.ListSources(r.Context())
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+		sort.Strings(names)
+
+		if err := json.NewEncoder(w).Encode(names); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+// PostAdminSource POST a new source and reply with its generated token
+func PostAdminSource(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestBody := SourceRequestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+		audit.FromContext(r.Context()).SetSource(requestBody.Name)
+		if err := sources.CheckName(requestBody.Name); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		token, err := sources.GenerateToken()
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+		if err := registry.CreateSource(r.Context(), requestBody.Name, token); err != nil {
+			replyWithSourceError(w, err)
+			return
+		}
+		replyWithCredentials(w, requestBody.Name, sources.DefaultTokenName, token)
+	}
+}
+
+// PostAdminSourceToken POST a request to replace the secret of a token of a source, the default token when none is
+// given, and reply with the new secret
+func PostAdminSourceToken(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		audit.FromContext(r.Context()).SetSource(name)
+		tokenName := mux.Vars(r)["token"]
+		if tokenName == "" {
+			tokenName = sources.DefaultTokenName
+		}
+
+		token, err := sources.GenerateToken()
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+		if err := registry.RotateToken(r.Context(), name, tokenName, token); err != nil {
+			replyWithSourceError(w, err)
+			return
+		}
+		replyWithCredentials(w, name, tokenName, token)
+	}
+}
+
+// GetAdminSourceTokens GET the tokens of a source without their secrets
+func GetAdminSourceTokens(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		audit.FromContext(r.Context()).SetSource(mux.Vars(r)["name"])
+		tokens, err := registry.ListTokens(r.Context(), mux.Vars(r)["name"])
+		if err != nil {
+			replyWithSourceError(w, err)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(tokens); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
+
+// PostAdminSourceTokens POST a new token for a source and reply with its generated secret
+func PostAdminSourceTokens(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		audit.FromContext(r.Context()).SetSource(mux.Vars(r)["name"])
+		requestBody := SourceTokenRequestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+		if err := sources.CheckName(requestBody.Name); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+		scopes, err := sources.ParseScopes(requestBody.Scopes)
+		if err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+		if len(scopes) == 0 {
+			ReplyWithBadRequest(w, fmt.Errorf("At least one scope among %v is required", sources.AllScopes))
+			return
+		}
+
+		token, err := sources.GenerateToken()
+		if err != nil {
+			ReplyWithInternalError(w, err)
+			return
+		}
+		name := mux.Vars(r)["name"]
+		spec := sources.Token{Name: requestBody.Name, Scopes: scopes, ExpiresAt: requestBody.ExpiresAt}
+		if err := registry.AddToken(r.Context(), name, spec, token); err != nil {
+			replyWithSourceError(w, err)
+			return
+		}
+		replyWithCredentials(w, name, requestBody.Name, token)
+	}
+}
+
+// DeleteAdminSourceToken DELETE a token of a source
+func DeleteAdminSourceToken(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		audit.FromContext(r.Context()).SetSource(mux.Vars(r)["name"])
+		if err := registry.RevokeToken(r.Context(), mux.Vars(r)["name"], mux.Vars(r)["token"]); err != nil {
+			replyWithSourceError(w, err)
+			return
+		}
+	}
+}
+
+// PutAdminSource PUT a new name for a source
+func PutAdminSource(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		audit.FromContext(r.Context()).SetSource(mux.Vars(r)["name"])
+		requestBody := SourceRequestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+		if err := sources.CheckName(requestBody.Name); err != nil {
+			ReplyWithBadRequest(w, err)
+			return
+		}
+
+		if err := registry.RenameSource(r.Context(), mux.Vars(r)["name"], requestBody.Name); err != nil {
+			replyWithSourceError(w, err)
+			return
+		}
+	}
+}
+
+// DeleteAdminSource DELETE a source along with the assets and relations no other source references
+func DeleteAdminSource(registry sources.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		audit.FromContext(r.Context()).SetSource(mux.Vars(r)["name"])
+		report, err := registry.RemoveSource(r.Context(), mux.Vars(r)["name"])
+		if err != nil {
+			replyWithSourceError(w, err)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			ReplyWithInternalError(w, err)
+		}
+	}
+}
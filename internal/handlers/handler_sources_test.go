@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockRegistry keeps the secret of the default token of each source in tokens, the other tokens in named and the
+// last update and expected interval of the sources in freshness
+type mockRegistry struct {
+	tokens    map[string]string
+	named     map[string][]mockToken
+	freshness map[string]sources.Freshness
+}
+
+type mockToken struct {
+	sources.Token
+	secret string
+}
+
+func (m *mockRegistry) ListSources(ctx context.Context) ([]string, error) {
+	names := []string{}
+	for name := range m.tokens {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (m *mockRegistry) ListCredentials(ctx context.Context) ([]sources.Credential, error) {
+	credentials := []sources.Credential{}
+	for name, token := range m.tokens {
+		tokens := append([]mockToken{{Token: sources.Token{Name: sources.DefaultTokenName, Scopes: sources.AllScopes}, secret: token}},
+			m.named[name]...)
+		for _, t := range tokens {
+			hashed, err := hashMockSecret(t.secret)
+			if err != nil {
+				return nil, err
+			}
+			credentials = append(credentials, sources.Credential{Source: name, Token: t.Token, HashedToken: hashed})
+		}
+	}
+	return credentials, nil
+}
+
+// mockHashes keeps the hashes of the secrets of the mock registries since hashing is slow
+var mockHashes sync.Map
+
+func hashMockSecret(secret string) (sources.HashedToken, error) {
+	if hashed, ok := mockHashes.Load(secret); ok {
+		return hashed.(sources.HashedToken), nil
+	}
+	hashed, err := sources.HashToken(secret)
+	if err != nil {
+		return hashed, err
+	}
+	mockHashes.Store(secret, hashed)
+	return hashed, nil
+}
+
+func (m *mockRegistry) CreateSource(ctx context.Context, name, authToken string) error {
+	if _, ok := m.tokens[name]; ok {
+		return fmt.Errorf("%w: %s", sources.ErrSourceAlreadyExists, name)
+	}
+	m.tokens[name] = authToken
+	return nil
+}
+
+func (m *mockRegistry) RotateToken(ctx context.Context, name, tokenName, authToken string) error {
+	if _, ok := m.tokens[name]; !ok {
+		return fmt.Errorf("%w: %s", sources.ErrSourceNotFound, name)
+	}
+	if tokenName == sources.DefaultTokenName {
+		m.tokens[name] = authToken
+		return nil
+	}
+	for i, t := range m.named[name] {
+		if t.Name == tokenName {
+			m.named[name][i].secret = authToken
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", sources.ErrTokenNotFound, tokenName)
+}
+
+func (m *mockRegistry) RenameSource(ctx context.Context, name, newName string) error {
+	token, ok := m.tokens[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", sources.ErrSourceNotFound, name)
+	}
+	delete(m.tokens, name)
+	m.tokens[newName] = token
+	return nil
+}
+
+func (m *mockRegistry) RemoveSource(ctx context.Context, name string) (sources.RemovalReport, error) {
+	if _, ok := m.tokens[name]; !ok {
+		return sources.RemovalReport{}, fmt.Errorf("%w: %s", sources.ErrSourceNotFound, name)
+	}
+	delete(m.tokens, name)
+	return sources.RemovalReport{RemovedAssets: 2, RemovedRelations: 1}, nil
+}
+
+func (m *mockRegistry) ListTokens(ctx context.Context, name string) ([]sources.Token, error) {
+	if _, ok := m.tokens[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", sources.ErrSourceNotFound, name)
+	}
+	tokens := []sources.Token{{Name: sources.DefaultTokenName, Scopes: sources.AllScopes}}
+	for _, t := range m.named[name] {
+		tokens = append(tokens, t.Token)
+	}
+	return tokens, nil
+}
+
+func (m *mockRegistry) AddToken(ctx context.Context, name string, token sources.Token, authToken string) error {
+	if _, ok := m.tokens[name]; !ok {
+		return fmt.Errorf("%w: %s", sources.ErrSourceNotFound, name)
+	}
+	for _, t := range m.named[name] {
+		if t.Name == token.Name {
+			return fmt.Errorf("%w: %s", sources.ErrTokenAlreadyExists, token.Name)
+		}
+	}
+	if m.named == nil {
+		m.named = make(map[string][]mockToken)
+	}
+	m.named[name] = append(m.named[name], mockToken{Token: token, secret: authToken})
+	return nil
+}
+
+func (m *mockRegistry) RevokeToken(ctx context.Context, name, tokenName string) error {
+	for i, t := range m.named[name] {
+		if t.Name == tokenName {
+			m.named[name] = append(m.named[name][:i], m.named[name][i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", sources.ErrTokenNotFound, tokenName)
+}
+
+func (m *mockRegistry) MarkTokensUsed(ctx context.Context, usages []sources.TokenUsage) error {
+	return nil
+}
+
+func (m *mockRegistry) ListFreshness(ctx context.Context) ([]sources.Freshness, error) {
+	freshness := []sources.Freshness{}
+	for name := range m.tokens {
+		f := m.freshness[name]
+		f.Source = name
+		freshness = append(freshness, f)
+	}
+	return freshness, nil
+}
+
+func (m *mockRegistry) MarkSourcesUpdated(ctx context.Context, updates []sources.SourceUpdate) error {
+	if m.freshness == nil {
+		m.freshness = map[string]sources.Freshness{}
+	}
+	for _, u := range updates {
+		f := m.freshness[u.Source]
+		at := u.At
+		f.LastUpdateAt = &at
+		m.freshness[u.Source] = f
+	}
+	return nil
+}
+
+func (m *mockRegistry) SetExpectedInterval(ctx context.Context, name string, interval time.Duration) error {
+	if _, ok := m.tokens[name]; !ok {
+		return fmt.Errorf("%w: %s", sources.ErrSourceNotFound, name)
+	}
+	if m.freshness == nil {
+		m.freshness = map[string]sources.Freshness{}
+	}
+	f := m.freshness[name]
+	f.ExpectedInterval = interval
+	m.freshness[name] = f
+	return nil
+}
+
+func newSourcesRouter(registry sources.Registry) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/admin/sources", GetAdminSources(registry)).Methods("GET")
+	r.HandleFunc("/api/admin/sources", PostAdminSource(registry)).Methods("POST")
+	r.HandleFunc("/api/admin/sources/{name}", PutAdminSource(registry)).Methods("PUT")
+	r.HandleFunc("/api/admin/sources/{name}", DeleteAdminSource(registry)).Methods("DELETE")
+	r.HandleFunc("/api/admin/sources/{name}/token", PostAdminSourceToken(registry)).Methods("POST")
+	r.HandleFunc("/api/admin/sources/{name}/tokens", GetAdminSourceTokens(registry)).Methods("GET")
+	r.HandleFunc("/api/admin/sources/{name}/tokens", PostAdminSourceTokens(registry)).Methods("POST")
+	r.HandleFunc("/api/admin/sources/{name}/tokens/{token}", DeleteAdminSourceToken(registry)).Methods("DELETE")
+	r.HandleFunc("/api/admin/sources/{name}/tokens/{token}/rotate", PostAdminSourceToken(registry)).Methods("POST")
+	return r
+}
+
+func TestShouldCreateSourceWithGeneratedToken(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{}}
+	rec := httptest.NewRecorder()
+	newSourcesRouter(registry).ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources", strings.NewReader(`{"name":"scanner"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	credentials := SourceCredentialsResponseBody{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&credentials))
+	assert.Equal(t, "scanner", credentials.Name)
+	assert.NotEmpty(t, sources.TokenID(credentials.AuthToken))
+	assert.Equal(t, credentials.AuthToken, registry.tokens["scanner"])
+
+	rec = httptest.NewRecorder()
+	newSourcesRouter(registry).ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources", strings.NewReader(`{"name":"scanner"}`)))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = httptest.NewRecorder()
+	newSourcesRouter(registry).ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources", strings.NewReader(`{"name":"bad name"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestShouldListSources(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"b": "token-b", "a": "token-a"}}
+	rec := httptest.NewRecorder()
+	newSourcesRouter(registry).ServeHTTP(rec, httptest.NewRequest("GET", "/api/admin/sources", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `["a","b"]`, rec.Body.String())
+}
+
+func TestShouldRotateRenameAndRemoveSource(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "old"}}
+	router := newSourcesRouter(registry)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources/scanner/token", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, "old", registry.tokens["scanner"])
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("PUT", "/api/admin/sources/scanner", strings.NewReader(`{"name":"scanner-v2"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, registry.tokens, "scanner-v2")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/admin/sources/scanner-v2", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"removed_assets":2,"removed_relations":1}`, rec.Body.String())
+	assert.Empty(t, registry.tokens)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/admin/sources/scanner-v2", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestShouldAuthenticateSourceFromToken(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "0123456789abcdef", "crawler": "0123456789fedcba"}}
+
+	req := httptest.NewRequest("GET", "/api/graph/read", nil)
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789fedcba")
+	ok, source, err := IsTokenValid(registry, req, sources.ScopeGraphRead)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "crawler", source)
+
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789000000")
+	ok, _, err = IsTokenValid(registry, req, sources.ScopeGraphRead)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestShouldManageTokensOfSource(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "0123456789abcdef"}}
+	router := newSourcesRouter(registry)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources/scanner/tokens",
+		strings.NewReader(`{"name":"reader","scopes":["graph:read"],"expires_at":"2030-01-01T00:00:00Z"}`)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	credentials := SourceCredentialsResponseBody{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&credentials))
+	assert.Equal(t, "reader", credentials.TokenName)
+	assert.NotEmpty(t, sources.TokenID(credentials.AuthToken))
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources/scanner/tokens",
+		strings.NewReader(`{"name":"reader","scopes":["graph:read"]}`)))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources/scanner/tokens",
+		strings.NewReader(`{"name":"writer","scopes":["graph:delete"]}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources/scanner/tokens",
+		strings.NewReader(`{"name":"writer"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/admin/sources/scanner/tokens", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"name":"default","scopes":["graph:read","graph:write","query"]},
+		{"name":"reader","scopes":["graph:read"],"expires_at":"2030-01-01T00:00:00Z"}]`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/admin/sources/scanner/tokens/reader/rotate", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, credentials.AuthToken, registry.named["scanner"][0].secret)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/admin/sources/scanner/tokens/reader", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, registry.named["scanner"])
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/admin/sources/scanner/tokens/reader", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestShouldEnforceScopesOfTokens(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	registry := &mockRegistry{
+		tokens: map[string]string{"scanner": "0123456789abcdef"},
+		named: map[string][]mockToken{"scanner": {
+			{Token: sources.Token{Name: "reader", Scopes: []sources.Scope{sources.ScopeGraphRead}}, secret: "0123456789reader"},
+			{Token: sources.Token{Name: "old", Scopes: sources.AllScopes, ExpiresAt: &expired}, secret: "0123456789expire"},
+		}},
+	}
+
+	req := httptest.NewRequest("GET", "/api/graph/read", nil)
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789reader")
+	ok, source, err := IsTokenValid(registry, req, sources.ScopeGraphRead)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "scanner", source)
+
+	ok, _, err = IsTokenValid(registry, req, sources.ScopeGraphWrite)
+	assert.False(t, ok)
+	assert.ErrorIs(t, err, sources.ErrInsufficientScope)
+
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789expire")
+	ok, _, err = IsTokenValid(registry, req, sources.ScopeGraphRead)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestShouldServeQueriesOfSourcesGrantedQueryScope(t *testing.T) {
+	registry := &mockRegistry{
+		tokens: map[string]string{"scanner": "0123456789abcdef"},
+		named: map[string][]mockToken{"scanner": {
+			{Token: sources.Token{Name: "reader", Scopes: []sources.Scope{sources.ScopeGraphRead}}, secret: "0123456789reader"},
+		}},
+	}
+	handler := WithSourceToken(registry, sources.ScopeQuery,
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) },
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	cases := map[string]int{
+		"":                 http.StatusNoContent,
+		"0123456789abcdef": http.StatusAccepted,
+		"0123456789reader": http.StatusForbidden,
+		"0123456789000000": http.StatusUnauthorized,
+	}
+	for token, status := range cases {
+		req := httptest.NewRequest("POST", "/api/query", nil)
+		if token != "" {
+			req.Header.Set(utils.XAuthTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, status, rec.Code, "token %q", token)
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/client"
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/metrics"
+	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TransactionTimeout return the duration after which a transaction without activity is discarded
+func TransactionTimeout() time.Duration {
+	timeout := viper.GetDuration("transaction_timeout")
+	if timeout == 0 {
+		timeout = time.Hour
+	}
+	return timeout
+}
+
+type deletionGuardConfiguration struct {
+	knowledge.DeletionGuard `mapstructure:",squash"`
+	// Sources overrides the default guard for some sources. The names of the sources are lowercased by the
+	// configuration loader.
+	Sources map[string]knowledge.DeletionGuard `mapstructure:"sources"`
+}
+
+// DeletionGuard return the guard applying to the updates of the source. The guard is disabled when the request
+// forces the deletion.
+func DeletionGuard(r *http.Request, source string) (knowledge.DeletionGuard, error) {
+	if forced, _ := strconv.ParseBool(r.Header.Get(utils.XForceDeletionHeader)); forced {
+		logrus.Warnf("Deletion guard of source %s is bypassed by the request", source)
+		return knowledge.DeletionGuard{}, nil
+	}
+
+	config := deletionGuardConfiguration{}
+	if err := viper.UnmarshalKey("deletion_guard", &config); err != nil {
+		return knowledge.DeletionGuard{}, fmt.Errorf("Unable to read the deletion guard configuration: %v", err)
+	}
+	if guard, ok := config.Sources[strings.ToLower(source)]; ok {
+		return guard, nil
+	}
+	return config.DeletionGuard, nil
+}
+
+// QuotaConfiguration holds the quotas applying to the graphs of the sources
+type QuotaConfiguration struct {
+	knowledge.Quota `mapstructure:",squash"`
+	// Sources overrides the default quota for some sources. The names of the sources are lowercased by the
+	// configuration loader.
+	Sources map[string]knowledge.Quota `mapstructure:"sources"`
+}
+
+// LoadQuotaConfiguration read the quota configuration
+func LoadQuotaConfiguration() (QuotaConfiguration, error) {
+	config := QuotaConfiguration{}
+	if err := viper.UnmarshalKey("quota", &config); err != nil {
+		return config, fmt.Errorf("Unable to read the quota configuration: %v", err)
+	}
+	return config, nil
+}
+
+// Of return the quota applying to the graph of the source
+func (c QuotaConfiguration) Of(source string) knowledge.Quota {
+	if quota, ok := c.Sources[strings.ToLower(source)]; ok {
+		return quota
+	}
+	return c.Quota
+}
+
+// PostTransaction open a staged transaction for the data source
+func PostTransaction(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return handleSourceRequest(registry, func(r *http.Request, source string) (interface{}, error) {
+		id, err := graphUpdater.BeginTransaction(r.Context(), source, TransactionTimeout())
+		if err != nil {
+			return nil, err
+		}
+		return client.BeginTransactionResponseBody{ID: id}, nil
+	}, limiter, "begin_transaction")
+}
+
+// PutTransactionSchema stage the schema of the data source in the transaction
+func PutTransactionSchema(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return handleSourceRequest(registry, func(r *http.Request, source string) (interface{}, error) {
+		requestBody := client.PutGraphSchemaRequestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			return nil, err
+		}
+
+		if err := graphUpdater.StageSchema(r.Context(), source, mux.Vars(r)["id"], requestBody.Schema); err != nil {
+			return nil, fmt.Errorf("Unable to stage the schema: %w", err)
+		}
+		return nil, nil
+	}, limiter, "stage_schema")
+}
+
+// stageAssets stage the assets of the request body in the transaction
+func stageAssets(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter, operation knowledge.StagedOperation) http.HandlerFunc {
+	return handleSourceRequest(registry, func(r *http.Request, source string) (interface{}, error) {
+		assets, err := decodeAssets(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := graphUpdater.StageAssets(r.Context(), source, mux.Vars(r)["id"], operation, assets); err != nil {
+			return nil, fmt.Errorf("Unable to stage assets: %w", err)
+		}
+		return nil, nil
+	}, limiter, "stage_"+string(operation))
+}
+
+// stageRelations stage the relations of the request body in the transaction
+func stageRelations(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter, operation knowledge.StagedOperation) http.HandlerFunc {
+	return handleSourceRequest(registry, func(r *http.Request, source string) (interface{}, error) {
+		relations, err := decodeRelations(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := graphUpdater.StageRelations(r.Context(), source, mux.Vars(r)["id"], operation, relations); err != nil {
+			return nil, fmt.Errorf("Unable to stage relations: %w", err)
+		}
+		return nil, nil
+	}, limiter, "stage_"+string(operation))
+}
+
+// PutTransactionAssets stage assets to upsert in the transaction
+func PutTransactionAssets(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return stageAssets(registry, graphUpdater, limiter, knowledge.InsertAssetsOperation)
+}
+
+// DeleteTransactionAssets stage assets to remove in the transaction
+func DeleteTransactionAssets(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return stageAssets(registry, graphUpdater, limiter, knowledge.RemoveAssetsOperation)
+}
+
+// PutTransactionRelations stage relations to upsert in the transaction
+func PutTransactionRelations(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return stageRelations(registry, graphUpdater, limiter, knowledge.InsertRelationsOperation)
+}
+
+// DeleteTransactionRelations stage relations to remove in the transaction
+func DeleteTransactionRelations(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return stageRelations(registry, graphUpdater, limiter, knowledge.RemoveRelationsOperation)
+}
+
+// PostTransactionCommit apply all the changes staged in the transaction atomically and return the revision produced
+// by the commit
+func PostTransactionCommit(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter,
+	quotas QuotaConfiguration) http.HandlerFunc {
+	return handleSourceRequest(registry, func(r *http.Request, source string) (interface{}, error) {
+		guard, err := DeletionGuard(r, source)
+		if err != nil {
+			return nil, err
+		}
+
+		revision, err := graphUpdater.CommitTransaction(r.Context(), source, mux.Vars(r)["id"], guard, quotas.Of(source))
+		if err != nil {
+			return nil, err
+		}
+		markSourceUpdated(r.Context(), registry, source)
+
+		metrics.GraphUpdateTransactionsCommittedCounter.
+			With(prometheus.Labels{"source": source}).
+			Inc()
+		return client.RevisionResponseBody{Revision: revision}, nil
+	}, limiter, "commit_transaction")
+}
+
+// PostTransactionAbort discard the changes staged in the transaction
+func PostTransactionAbort(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return handleSourceRequest(registry, func(r *http.Request, source string) (interface{}, error) {
+		return nil, graphUpdater.AbortTransaction(r.Context(), source, mux.Vars(r)["id"])
+	}, limiter, "abort_transaction")
+}
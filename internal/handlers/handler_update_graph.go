@@ -3,23 +3,84 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"regexp"
 	"time"
 
+	"github.com/clems4ever/go-graphkb/internal/audit"
 	"github.com/clems4ever/go-graphkb/internal/client"
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
 	"github.com/clems4ever/go-graphkb/internal/metrics"
+	"github.com/clems4ever/go-graphkb/internal/ratelimit"
+	"github.com/clems4ever/go-graphkb/internal/schema"
 	"github.com/clems4ever/go-graphkb/internal/sources"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
-	"golang.org/x/sync/semaphore"
 )
 
-func handleUpdate(registry sources.Registry, fn func(ctx context.Context, source string, body io.Reader) error, sem *semaphore.Weighted, operationDescriptor string) http.HandlerFunc {
+// TooManyRequestsRetryAfter is the delay after which a source is told to retry when all the update slots are taken
+const TooManyRequestsRetryAfter = 2 * time.Second
+
+func handleUpdate(registry sources.Registry, fn func(ctx context.Context, source string, r *http.Request) error, limiter *UpdateLimiter, operationDescriptor string) http.HandlerFunc {
+	return handleSourceRequest(registry, func(r *http.Request, source string) (interface{}, error) {
+		return nil, fn(r.Context(), source, r)
+	}, limiter, operationDescriptor)
+}
+
+// isBinaryRequest returns true if the body of the request is encoded in the compact binary format
+func isBinaryRequest(r *http.Request) bool {
+	return r.Header.Get("Content-Type") == utils.BinaryContentType
+}
+
+// decodeAssets decode the assets from the body of the request, either encoded in JSON or in the binary format. The
+// number of assets is recorded in the audit log.
+func decodeAssets(r *http.Request) ([]knowledge.Asset, error) {
+	var assets []knowledge.Asset
+	if isBinaryRequest(r) {
+		var err error
+		if assets, err = knowledge.DecodeAssetsBinary(r.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		requestBody := client.PutGraphAssetRequestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			return nil, err
+		}
+		assets = requestBody.Assets
+	}
+	audit.FromContext(r.Context()).AddAssets(len(assets))
+	return assets, nil
+}
+
+// decodeRelations decode the relations from the body of the request, either encoded in JSON or in the binary format. The
+// number of relations is recorded in the audit log.
+func decodeRelations(r *http.Request) ([]knowledge.Relation, error) {
+	var relations []knowledge.Relation
+	if isBinaryRequest(r) {
+		var err error
+		if relations, err = knowledge.DecodeRelationsBinary(r.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		requestBody := client.PutGraphRelationRequestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			return nil, err
+		}
+		relations = requestBody.Relations
+	}
+	audit.FromContext(r.Context()).AddRelations(len(relations))
+	return relations, nil
+}
+
+// handleSourceRequest authenticate the source and process its update request. The reply returned by the function
+// is sent as JSON, a default message is sent when there is none.
+func handleSourceRequest(registry sources.Registry, fn func(r *http.Request, source string) (interface{}, error), limiter *UpdateLimiter, operationDescriptor string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ok, source, err := IsTokenValid(registry, r)
-		if err != nil {
+		ok, source, err := IsSourceAuthenticated(registry, r, sources.ScopeGraphWrite)
+		if err != nil && !errors.Is(err, sources.ErrInsufficientScope) {
 			ReplyWithInternalError(w, err)
 			return
 		}
@@ -33,29 +94,74 @@ func handleUpdate(registry sources.Registry, fn func(ctx context.Context, source
 			metrics.GraphUpdateRequestsUnauthorizedCounter.
 				With(promLabels).
 				Inc()
+			if err != nil {
+				ReplyWithForbidden(w, err)
+				return
+			}
 			ReplyWithUnauthorized(w)
 			return
 		}
 
+		if ok, retryAfter := limiter.rates.Allow(ratelimit.Key{Kind: ratelimit.KeySource, Name: source}); !ok {
+			metrics.GraphUpdateRequestsSourceRateLimitedCounter.
+				With(promLabels).
+				Inc()
+			ReplyWithTooManyRequests(w, retryAfter)
+			return
+		}
+
+		var reply interface{}
 		{
-			ok = sem.TryAcquire(1)
+			ok = limiter.semaphore.TryAcquire(1)
 			if !ok {
 				metrics.GraphUpdateRequestsRateLimitedCounter.
 					With(promLabels).
 					Inc()
-				ReplyWithTooManyRequests(w)
+				ReplyWithTooManyRequests(w, TooManyRequestsRetryAfter)
 				return
 			}
-			defer sem.Release(1)
+			defer limiter.semaphore.Release(1)
 
 			metrics.GraphUpdateRequestsReceivedCounter.
 				With(promLabels).
 				Inc()
 
-			if err = fn(r.Context(), source, r.Body); err != nil {
+			reply, err = fn(r, source)
+			if err != nil {
+				audit.FromContext(r.Context()).SetError(err)
 				metrics.GraphUpdateRequestsFailedCounter.
 					With(promLabels).
 					Inc()
+				if bodyTooLarge(r, err) {
+					ReplyWithRequestEntityTooLarge(w, ErrRequestBodyTooLarge)
+					return
+				}
+				if errors.Is(err, schema.ErrAssetValidation) || errors.Is(err, schema.ErrInvalidValidationRule) {
+					ReplyWithSchemaViolation(w, err)
+					return
+				}
+				if errors.Is(err, knowledge.ErrInvalidBinaryPayload) || errors.Is(err, errInvalidRunID) {
+					ReplyWithBadRequest(w, err)
+					return
+				}
+				if errors.Is(err, knowledge.ErrDeletionGuard) {
+					metrics.GraphUpdateDeletionGuardTriggeredCounter.
+						With(prometheus.Labels{"source": source}).
+						Inc()
+					ReplyWithDeletionGuard(w, err)
+					return
+				}
+				if errors.Is(err, knowledge.ErrQuotaExceeded) {
+					metrics.GraphUpdateQuotaExceededCounter.
+						With(prometheus.Labels{"source": source}).
+						Inc()
+					ReplyWithQuotaExceeded(w, err)
+					return
+				}
+				if errors.Is(err, knowledge.ErrTransactionNotFound) {
+					ReplyWithNotFound(w, err)
+					return
+				}
 				ReplyWithInternalError(w, err)
 				return
 			}
@@ -63,13 +169,13 @@ func handleUpdate(registry sources.Registry, fn func(ctx context.Context, source
 			metrics.GraphUpdateRequestsSucceededCounter.
 				With(promLabels).
 				Inc()
-
-			metrics.LastSuccessfulDatasourceUpdateTimestampGauge.
-				With(prometheus.Labels{"source": source}).
-				Set(float64(time.Now().Unix()))
 		}
 
-		_, err = fmt.Fprint(w, "Graph update has been processed")
+		if reply != nil {
+			err = json.NewEncoder(w).Encode(reply)
+		} else {
+			_, err = fmt.Fprint(w, "Graph update has been processed")
+		}
 		if err != nil {
 			ReplyWithInternalError(w, err)
 			return
@@ -78,17 +184,22 @@ func handleUpdate(registry sources.Registry, fn func(ctx context.Context, source
 }
 
 // PutSchema upsert an asset into the graph of the data source
-func PutSchema(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, sem *semaphore.Weighted) http.HandlerFunc {
-	return handleUpdate(registry, func(ctx context.Context, source string, body io.Reader) error {
+func PutSchema(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
 		requestBody := client.PutGraphSchemaRequestBody{}
-		if err := json.NewDecoder(body).Decode(&requestBody); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
 			return err
 		}
 
 		// TODO(c.michaud): verify compatibility of the schema with graph updates
-		err := graphUpdater.UpdateSchema(ctx, source, requestBody.Schema)
+		var err error
+		if requestBody.Merge {
+			err = graphUpdater.MergeSchema(ctx, source, requestBody.Schema)
+		} else {
+			err = graphUpdater.UpdateSchema(ctx, source, requestBody.Schema)
+		}
 		if err != nil {
-			return fmt.Errorf("Unable to update the schema: %v", err)
+			return fmt.Errorf("Unable to update the schema: %w", err)
 		}
 
 		labels := prometheus.Labels{"source": source}
@@ -96,93 +207,155 @@ func PutSchema(registry sources.Registry, graphUpdater *knowledge.GraphUpdater,
 			With(labels).
 			Inc()
 		return nil
-	}, sem, "update_schema")
+	}, limiter, "update_schema")
 }
 
 // PutAssets upsert several assets into the graph of the data source
-func PutAssets(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, sem *semaphore.Weighted) http.HandlerFunc {
-	return handleUpdate(registry, func(ctx context.Context, source string, body io.Reader) error {
-		requestBody := client.PutGraphAssetRequestBody{}
-		if err := json.NewDecoder(body).Decode(&requestBody); err != nil {
+func PutAssets(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter, quotas QuotaConfiguration) http.HandlerFunc {
+	return handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		assets, err := decodeAssets(r)
+		if err != nil {
+			return err
+		}
+
+		run, err := runID(r.Header.Get(utils.XRunIDHeader))
+		if err != nil {
 			return err
 		}
 
 		// TODO(c.michaud): verify compatibility of the schema with graph updates
-		err := graphUpdater.InsertAssets(ctx, source, requestBody.Assets)
+		err = graphUpdater.InsertAssets(ctx, source, run, assets, quotas.Of(source))
 		if err != nil {
-			return fmt.Errorf("Unable to insert assets: %v", err)
+			return fmt.Errorf("Unable to insert assets: %w", err)
 		}
 		labels := prometheus.Labels{"source": source}
 		metrics.GraphUpdateAssetsInsertedCounter.
 			With(labels).
-			Add(float64(len(requestBody.Assets)))
+			Add(float64(len(assets)))
 
 		return nil
-	}, sem, "insert_assets")
+	}, limiter, "insert_assets")
 }
 
 // PutRelations upsert multiple relations into the graph of the data source
-func PutRelations(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, sem *semaphore.Weighted) http.HandlerFunc {
-	return handleUpdate(registry, func(ctx context.Context, source string, body io.Reader) error {
-		requestBody := client.PutGraphRelationRequestBody{}
-		if err := json.NewDecoder(body).Decode(&requestBody); err != nil {
+func PutRelations(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter, quotas QuotaConfiguration) http.HandlerFunc {
+	return handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		relations, err := decodeRelations(r)
+		if err != nil {
+			return err
+		}
+
+		run, err := runID(r.Header.Get(utils.XRunIDHeader))
+		if err != nil {
 			return err
 		}
 
 		// TODO(c.michaud): verify compatibility of the schema with graph updates
-		err := graphUpdater.InsertRelations(ctx, source, requestBody.Relations)
+		err = graphUpdater.InsertRelations(ctx, source, run, relations, quotas.Of(source))
 		if err != nil {
-			return fmt.Errorf("Unable to insert relation: %v", err)
+			return fmt.Errorf("Unable to insert relation: %w", err)
 		}
 
 		labels := prometheus.Labels{"source": source}
 		metrics.GraphUpdateRelationsInsertedCounter.
 			With(labels).
-			Add(float64(len(requestBody.Relations)))
+			Add(float64(len(relations)))
 		return nil
-	}, sem, "insert_relations")
+	}, limiter, "insert_relations")
 }
 
 // DeleteAssets delete multiple assets from the graph of the data source
-func DeleteAssets(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, sem *semaphore.Weighted) http.HandlerFunc {
-	return handleUpdate(registry, func(ctx context.Context, source string, body io.Reader) error {
-		requestBody := client.DeleteGraphAssetRequestBody{}
-		if err := json.NewDecoder(body).Decode(&requestBody); err != nil {
+func DeleteAssets(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		assets, err := decodeAssets(r)
+		if err != nil {
+			return err
+		}
+
+		guard, err := DeletionGuard(r, source)
+		if err != nil {
+			return err
+		}
+
+		run, err := runID(r.Header.Get(utils.XRunIDHeader))
+		if err != nil {
 			return err
 		}
 
 		// TODO(c.michaud): verify compatibility of the schema with graph updates
-		err := graphUpdater.RemoveAssets(ctx, source, requestBody.Assets)
+		err = graphUpdater.RemoveAssets(ctx, source, run, assets, guard)
 		if err != nil {
-			return fmt.Errorf("Unable to remove assets: %v", err)
+			return fmt.Errorf("Unable to remove assets: %w", err)
 		}
 
 		labels := prometheus.Labels{"source": source}
 		metrics.GraphUpdateAssetsDeletedCounter.
 			With(labels).
-			Add(float64(len(requestBody.Assets)))
+			Add(float64(len(assets)))
 		return nil
-	}, sem, "delete_assets")
+	}, limiter, "delete_assets")
 }
 
 // DeleteRelations remove multiple relations from the graph of the data source
-func DeleteRelations(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, sem *semaphore.Weighted) http.HandlerFunc {
-	return handleUpdate(registry, func(ctx context.Context, source string, body io.Reader) error {
-		requestBody := client.DeleteGraphRelationRequestBody{}
-		if err := json.NewDecoder(body).Decode(&requestBody); err != nil {
+func DeleteRelations(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		relations, err := decodeRelations(r)
+		if err != nil {
+			return err
+		}
+
+		guard, err := DeletionGuard(r, source)
+		if err != nil {
+			return err
+		}
+
+		run, err := runID(r.Header.Get(utils.XRunIDHeader))
+		if err != nil {
 			return err
 		}
 
 		// TODO(c.michaud): verify compatibility of the schema with graph updates
-		err := graphUpdater.RemoveRelations(ctx, source, requestBody.Relations)
+		err = graphUpdater.RemoveRelations(ctx, source, run, relations, guard)
 		if err != nil {
-			return fmt.Errorf("Unable to remove relation: %v", err)
+			return fmt.Errorf("Unable to remove relation: %w", err)
 		}
 
 		labels := prometheus.Labels{"source": source}
 		metrics.GraphUpdateRelationsDeletedCounter.
 			With(labels).
-			Add(float64(len(requestBody.Relations)))
+			Add(float64(len(relations)))
 		return nil
-	}, sem, "delete_relations")
+	}, limiter, "delete_relations")
+}
+
+// errInvalidRunID is returned when the ID of a run is malformed
+var errInvalidRunID = errors.New("invalid run ID")
+
+// runIDPattern is the format of the IDs of the runs generated by the data sources
+var runIDPattern = regexp.MustCompile("^[a-zA-Z0-9_-]{1,64}$")
+
+// runID validate the ID of the run a request belongs to, the requests without ID belong to no run
+func runID(id string) (string, error) {
+	if id != "" && !runIDPattern.MatchString(id) {
+		return "", fmt.Errorf("%w %q: only letters, digits, '-' and '_' are allowed and at most 64 characters", errInvalidRunID, id)
+	}
+	return id, nil
+}
+
+// PostRunComplete end a run of the data source sending its updates in several requests. The data source has
+// successfully updated its graph once the run is completed. The last revision produced by the run is returned, 0 if
+// the run did not update the graph.
+func PostRunComplete(registry sources.Registry, graphUpdater *knowledge.GraphUpdater, limiter *UpdateLimiter) http.HandlerFunc {
+	return handleSourceRequest(registry, func(r *http.Request, source string) (interface{}, error) {
+		run, err := runID(mux.Vars(r)["id"])
+		if err != nil {
+			return nil, err
+		}
+		revision, err := graphUpdater.CompleteRun(r.Context(), source, run)
+		if err != nil {
+			return nil, err
+		}
+		markSourceUpdated(r.Context(), registry, source)
+		return client.RevisionResponseBody{Revision: revision}, nil
+	}, limiter, "complete_run")
 }
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
+	"github.com/clems4ever/go-graphkb/internal/metrics"
+	"github.com/clems4ever/go-graphkb/internal/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/semaphore"
+)
+
+// UpdateLimiter limits the number of graph updates processed at once and the rate of the updates of each source so
+// that a single source cannot take all the update slots
+type UpdateLimiter struct {
+	semaphore *semaphore.Weighted
+	rates     *ratelimit.Limiter
+}
+
+// NewUpdateLimiter create a limiter processing at most concurrency updates at once. The rate of the updates of the
+// sources is not limited when rates is nil.
+func NewUpdateLimiter(concurrency int64, rates *ratelimit.Limiter) *UpdateLimiter {
+	return &UpdateLimiter{semaphore: semaphore.NewWeighted(concurrency), rates: rates}
+}
+
+// queryRateLimitKey return the key of the client of the query: the user, the source or the address of the client when
+// the request is not authenticated
+func queryRateLimitKey(r *http.Request) ratelimit.Key {
+	if user := kbcontext.User(r.Context()); user != "" {
+		return ratelimit.Key{Kind: ratelimit.KeyUser, Name: user}
+	}
+	if source := kbcontext.Source(r.Context()); source != "" {
+		return ratelimit.Key{Kind: ratelimit.KeySource, Name: source}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return ratelimit.Key{Kind: ratelimit.KeyAddress, Name: host}
+}
+
+// WithQueryRateLimit serve the queries of the clients which have not exceeded their rate limit with the handler. The
+// handler must be wrapped by the authentication so that the users and the sources are limited separately.
+func WithQueryRateLimit(limiter *ratelimit.Limiter, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := queryRateLimitKey(r)
+		if ok, retryAfter := limiter.Allow(key); !ok {
+			label := key.Name
+			if key.Kind == ratelimit.KeyAddress {
+				label = "anonymous"
+			}
+			metrics.GraphQueryRateLimitedCounter.
+				With(prometheus.Labels{"user": label}).
+				Inc()
+			ReplyWithTooManyRequests(w, retryAfter)
+			return
+		}
+		h(w, r)
+	}
+}
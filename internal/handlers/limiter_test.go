@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/ratelimit"
+	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldLimitRateOfUpdatesOfEachSource(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"noisy": "0123456789abcdef", "quiet": "0123456789fedcba"}}
+	rates := ratelimit.NewLimiter(ratelimit.Configuration{
+		Sources: map[string]ratelimit.Limit{"noisy": {Rate: 0.1, Burst: 2}},
+	})
+	handler := handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		return nil
+	}, NewUpdateLimiter(10, rates), "insert_assets")
+
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/graph/assets", nil)
+		req.Header.Set(utils.XAuthTokenHeader, token)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, send("0123456789abcdef").Code)
+	assert.Equal(t, http.StatusOK, send("0123456789abcdef").Code)
+	rec := send("0123456789abcdef")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+
+	// The other sources are not limited
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, send("0123456789fedcba").Code)
+	}
+}
+
+func TestShouldReplyWithQuotaExceeded(t *testing.T) {
+	registry := &mockRegistry{tokens: map[string]string{"scanner": "0123456789abcdef"}}
+	handler := handleUpdate(registry, func(ctx context.Context, source string, r *http.Request) error {
+		return fmt.Errorf("Unable to insert assets: %w", knowledge.ErrQuotaExceeded)
+	}, NewUpdateLimiter(1, nil), "insert_assets")
+
+	req := httptest.NewRequest("PUT", "/api/graph/assets", nil)
+	req.Header.Set(utils.XAuthTokenHeader, "0123456789abcdef")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, utils.QuotaExceededErrorCode, rec.Header().Get(utils.XErrorCodeHeader))
+}
+
+func TestShouldLimitRateOfQueriesOfEachUser(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Configuration{
+		Limit: ratelimit.Limit{Rate: 1, Burst: 1},
+		Users: map[string]ratelimit.Limit{"bob": {}},
+	})
+	handler := WithQueryRateLimit(limiter, func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func(key interface{}, value string, remoteAddr string) int {
+		req := httptest.NewRequest("POST", "/api/query", strings.NewReader(`{}`))
+		req.RemoteAddr = remoteAddr
+		if key != nil {
+			req = req.WithContext(context.WithValue(req.Context(), key, value))
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send(kbcontext.ContextKeyUser, "alice", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, send(kbcontext.ContextKeyUser, "alice", "10.0.0.2:1234"))
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusOK, send(kbcontext.ContextKeyUser, "bob", "10.0.0.1:1234"))
+	}
+
+	assert.Equal(t, http.StatusOK, send(kbcontext.ContextKeySource, "scanner", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, send(kbcontext.ContextKeySource, "scanner", "10.0.0.1:1234"))
+
+	// The anonymous clients are limited by address
+	assert.Equal(t, http.StatusOK, send(nil, "", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, send(nil, "", "10.0.0.1:5678"))
+	assert.Equal(t, http.StatusOK, send(nil, "", "10.0.0.2:1234"))
+}
@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/clems4ever/go-graphkb/internal/utils"
 	"github.com/sirupsen/logrus"
 )
 
@@ -31,8 +35,19 @@ func ReplyWithInternalError(w http.ResponseWriter, err error) {
 	}
 }
 
-// ReplyWithBadRequest send response with bad request.
+// ReplyWithSchemaViolation send response with bad request when an update does not match the schema.
+func ReplyWithSchemaViolation(w http.ResponseWriter, err error) {
+	w.Header().Set(utils.XErrorCodeHeader, utils.SchemaViolationErrorCode)
+	ReplyWithBadRequest(w, err)
+}
+
+// ReplyWithBadRequest send response with bad request or with request entity too large when the body of the request
+// could not be read entirely.
 func ReplyWithBadRequest(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrRequestBodyTooLarge) {
+		ReplyWithRequestEntityTooLarge(w, err)
+		return
+	}
 	logrus.Error(err)
 	w.WriteHeader(http.StatusBadRequest)
 	_, werr := w.Write([]byte(err.Error()))
@@ -41,6 +56,33 @@ func ReplyWithBadRequest(w http.ResponseWriter, err error) {
 	}
 }
 
+// ReplyWithNotFound send response with not found.
+func ReplyWithNotFound(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusNotFound)
+	_, werr := w.Write([]byte(err.Error()))
+	if werr != nil {
+		logrus.Error(werr)
+	}
+}
+
+// ReplyWithConflict send response with conflict.
+func ReplyWithConflict(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusConflict)
+	_, werr := w.Write([]byte(err.Error()))
+	if werr != nil {
+		logrus.Error(werr)
+	}
+}
+
+// ReplyWithGone send response with gone.
+func ReplyWithGone(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusGone)
+	_, werr := w.Write([]byte(err.Error()))
+	if werr != nil {
+		logrus.Error(werr)
+	}
+}
+
 // ReplyWithUnauthorized send unauthorized response.
 func ReplyWithUnauthorized(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusUnauthorized)
@@ -50,11 +92,64 @@ func ReplyWithUnauthorized(w http.ResponseWriter) {
 	}
 }
 
-// ReplyWithTooManyRequests send unauthorized response.
-func ReplyWithTooManyRequests(w http.ResponseWriter) {
+// ReplyWithForbidden send forbidden response.
+func ReplyWithForbidden(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusForbidden)
+	_, werr := w.Write([]byte(err.Error()))
+	if werr != nil {
+		logrus.Error(werr)
+	}
+}
+
+// ReplyWithTooManyRequests send too many requests response telling the client when to retry.
+func ReplyWithTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	// Retry-After is expressed in whole seconds, the delay is rounded up so that the client does not retry too early
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
 	w.WriteHeader(http.StatusTooManyRequests)
 	_, werr := w.Write([]byte("Too Many Requests. Retry later."))
 	if werr != nil {
 		logrus.Error(werr)
 	}
 }
+
+// ReplyWithRequestEntityTooLarge send response with request entity too large.
+func ReplyWithRequestEntityTooLarge(w http.ResponseWriter, err error) {
+	logrus.Warn(err)
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	_, werr := w.Write([]byte(err.Error()))
+	if werr != nil {
+		logrus.Error(werr)
+	}
+}
+
+// ReplyWithUnsupportedMediaType send response with unsupported media type.
+func ReplyWithUnsupportedMediaType(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusUnsupportedMediaType)
+	_, werr := w.Write([]byte(err.Error()))
+	if werr != nil {
+		logrus.Error(werr)
+	}
+}
+
+// ReplyWithDeletionGuard send response with conflict when the deletion guard refused an update.
+func ReplyWithDeletionGuard(w http.ResponseWriter, err error) {
+	logrus.Warn(err)
+	w.Header().Set(utils.XErrorCodeHeader, utils.DeletionGuardErrorCode)
+	w.WriteHeader(http.StatusConflict)
+	_, werr := w.Write([]byte(err.Error()))
+	if werr != nil {
+		logrus.Error(werr)
+	}
+}
+
+// ReplyWithQuotaExceeded send response with forbidden when an update would make the graph of the source exceed its
+// quota.
+func ReplyWithQuotaExceeded(w http.ResponseWriter, err error) {
+	logrus.Warn(err)
+	w.Header().Set(utils.XErrorCodeHeader, utils.QuotaExceededErrorCode)
+	ReplyWithForbidden(w, err)
+}
@@ -1,32 +1,89 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/clems4ever/go-graphkb/internal/audit"
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
 	"github.com/clems4ever/go-graphkb/internal/sources"
 	"github.com/clems4ever/go-graphkb/internal/utils"
+	"github.com/sirupsen/logrus"
 )
 
-// IsTokenValid is the token valid
-func IsTokenValid(registry sources.Registry, r *http.Request) (bool, string, error) {
+// IsTokenValid is the token valid and granted the scope. The error wraps sources.ErrInsufficientScope when the
+// token is valid but not granted the scope.
+func IsTokenValid(registry sources.Registry, r *http.Request, scope sources.Scope) (bool, string, error) {
 	token := r.Header.Get(utils.XAuthTokenHeader)
 
 	if token == "" {
 		return false, "", fmt.Errorf("No auth token provided")
 	}
 
-	sourceToToken, err := registry.ListSources(r.Context())
+	credential, err := sources.Authenticate(r.Context(), registry, token)
+	if err != nil {
+		return false, "", fmt.Errorf("Unable to authenticate the source: %v", err)
+	}
+	if credential == nil {
+		return false, "", nil
+	}
+	audit.FromContext(r.Context()).SetSourceToken(credential.Source, credential.Name)
+	if !credential.HasScope(scope) {
+		return false, credential.Source, fmt.Errorf("%w: token %s of source %s is not granted scope %s",
+			sources.ErrInsufficientScope, credential.Name, credential.Source, scope)
+	}
+	return true, credential.Source, nil
+}
+
+// IsSourceAuthenticated is the request authenticated by a verified client certificate mapped to a source or by a
+// token granted the scope. The client certificates are granted all the scopes and take precedence over the tokens.
+func IsSourceAuthenticated(registry sources.Registry, r *http.Request, scope sources.Scope) (bool, string, error) {
+	source := ClientCertificateSource(r)
+	if source == "" {
+		return IsTokenValid(registry, r, scope)
+	}
 
+	availableSources, err := registry.ListSources(r.Context())
 	if err != nil {
 		return false, "", fmt.Errorf("Unable to list the sources: %v", err)
 	}
+	if !utils.IsStringInSlice(source, availableSources) {
+		logrus.Warnf("Client certificate is mapped to source %s which does not exist", source)
+		return false, "", nil
+	}
+	audit.FromContext(r.Context()).SetSourceCertificate(source)
+	return true, source, nil
+}
 
-	for sn, t := range sourceToToken {
-		if t == token {
-			return true, sn, nil
-		}
+// replyWithSourceAuthError reply to a source which failed to authenticate
+func replyWithSourceAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sources.ErrInsufficientScope):
+		ReplyWithForbidden(w, err)
+	case err != nil:
+		ReplyWithInternalError(w, err)
+	default:
+		ReplyWithUnauthorized(w)
 	}
+}
 
-	return false, "", nil
+// WithSourceToken serve the requests carrying a source token granted the scope or a client certificate of a source
+// with the handler and the other requests with the fallback so that an endpoint can be reached by both the users and
+// the sources
+func WithSourceToken(registry sources.Registry, scope sources.Scope, handler http.HandlerFunc, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(utils.XAuthTokenHeader) == "" && ClientCertificateSource(r) == "" {
+			fallback(w, r)
+			return
+		}
+
+		ok, source, err := IsSourceAuthenticated(registry, r, scope)
+		if !ok {
+			replyWithSourceAuthError(w, err)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), kbcontext.ContextKeySource, source)))
+	}
 }
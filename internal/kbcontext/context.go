@@ -5,7 +5,10 @@ import (
 )
 
 var (
-	ContextKeyXForwardedUser = contextKey("xForwardedUser")
+	ContextKeyUser           = contextKey("user")
+	ContextKeyAllowedSources = contextKey("allowedSources")
+	ContextKeySource         = contextKey("source")
+	ContextKeyStaleSources   = contextKey("staleSources")
 )
 
 type contextKey string
@@ -14,8 +17,26 @@ func (c contextKey) String() string {
 	return "server" + string(c)
 }
 
-// XForwardedUser gets the service user from context
-func XForwardedUser(ctx context.Context) string {
-	xForwardedUser, _ := ctx.Value(ContextKeyXForwardedUser).(string)
-	return xForwardedUser
+// User gets the authenticated user from context
+func User(ctx context.Context) string {
+	user, _ := ctx.Value(ContextKeyUser).(string)
+	return user
+}
+
+// AllowedSources gets the only sources the user can query from context, it is nil when the user is not restricted
+func AllowedSources(ctx context.Context) []string {
+	sources, _ := ctx.Value(ContextKeyAllowedSources).([]string)
+	return sources
+}
+
+// Source gets the source authenticated by a token or a client certificate from context
+func Source(ctx context.Context) string {
+	source, _ := ctx.Value(ContextKeySource).(string)
+	return source
+}
+
+// StaleSources gets the sources stale beyond the threshold whose data is flagged in the query results from context
+func StaleSources(ctx context.Context) []string {
+	sources, _ := ctx.Value(ContextKeyStaleSources).([]string)
+	return sources
 }
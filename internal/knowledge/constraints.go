@@ -0,0 +1,67 @@
+package knowledge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/clems4ever/go-graphkb/internal/schema"
+)
+
+// ConstraintViolation represent an asset violating a relation constraint
+type ConstraintViolation struct {
+	Constraint schema.RelationConstraint `json:"constraint"`
+	Asset      AssetKey                  `json:"asset"`
+	// Count is the number of relations of the constrained type the asset has
+	Count int64 `json:"count"`
+}
+
+func (cv ConstraintViolation) String() string {
+	return fmt.Sprintf("asset %s:%s has %d relations violating constraint %s",
+		cv.Asset.Type, cv.Asset.Key, cv.Count, cv.Constraint)
+}
+
+// CheckConstraints evaluates the relation constraints against the assets and relations of the graph which are
+// not about to be removed.
+func CheckConstraints(g *Graph, constraints []schema.RelationConstraint) []ConstraintViolation {
+	if len(constraints) == 0 {
+		return nil
+	}
+
+	type counterKey struct {
+		asset    AssetKey
+		relation schema.RelationType
+	}
+	counters := make(map[counterKey]int64)
+	for r, action := range g.Relations() {
+		if action == GraphEntryRemove {
+			continue
+		}
+		k := counterKey{
+			asset:    r.From,
+			relation: schema.RelationType{FromType: r.From.Type, Type: r.Type, ToType: r.To.Type},
+		}
+		counters[k]++
+	}
+
+	violations := []ConstraintViolation{}
+	for _, c := range constraints {
+		for a, action := range g.Assets() {
+			if action == GraphEntryRemove || a.Type != c.Relation.FromType {
+				continue
+			}
+			count := counters[counterKey{asset: AssetKey(a), relation: c.Relation}]
+			if (c.Required && count == 0) || (c.MaxCardinality > 0 && count > int64(c.MaxCardinality)) {
+				violations = append(violations, ConstraintViolation{
+					Constraint: c,
+					Asset:      AssetKey(a),
+					Count:      count,
+				})
+			}
+		}
+	}
+
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].String() < violations[j].String()
+	})
+	return violations
+}
@@ -0,0 +1,50 @@
+package knowledge
+
+import (
+	"testing"
+
+	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldDetectConstraintViolations(t *testing.T) {
+	locatedIn := schema.RelationType{FromType: "host", Type: "located_in", ToType: "datacenter"}
+	ownedBy := schema.RelationType{FromType: "service", Type: "owned_by", ToType: "team"}
+
+	constraints := []schema.RelationConstraint{
+		{Relation: locatedIn, MaxCardinality: 1},
+		{Relation: ownedBy, Required: true},
+	}
+
+	g := NewGraph()
+	binder := NewGraphBinder(g)
+	require.NoError(t, binder.Relate("host1", locatedIn, "dc1"))
+	require.NoError(t, binder.Relate("host2", locatedIn, "dc1"))
+	require.NoError(t, binder.Relate("host2", locatedIn, "dc2"))
+	require.NoError(t, binder.Relate("service1", ownedBy, "team1"))
+	require.NoError(t, binder.Bind("service2", "service"))
+
+	violations := CheckConstraints(g, constraints)
+	require.Len(t, violations, 2)
+	assert.Equal(t, AssetKey{Type: "host", Key: "host2"}, violations[0].Asset)
+	assert.Equal(t, int64(2), violations[0].Count)
+	assert.Equal(t, AssetKey{Type: "service", Key: "service2"}, violations[1].Asset)
+	assert.Equal(t, int64(0), violations[1].Count)
+}
+
+func TestShouldIgnoreEntriesMarkedForRemoval(t *testing.T) {
+	ownedBy := schema.RelationType{FromType: "service", Type: "owned_by", ToType: "team"}
+	constraints := []schema.RelationConstraint{{Relation: ownedBy, Required: true}}
+
+	g := NewGraph()
+	binder := NewGraphBinder(g)
+	require.NoError(t, binder.Relate("service1", ownedBy, "team1"))
+	g.Clean()
+
+	// The service is not emitted anymore so it is not expected to be owned.
+	assert.Empty(t, CheckConstraints(g, constraints))
+
+	require.NoError(t, binder.Bind("service1", "service"))
+	assert.Len(t, CheckConstraints(g, constraints), 1)
+}
@@ -0,0 +1,53 @@
+package knowledge
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDeletionGuard is returned when an update is refused because it would remove too many assets and relations
+var ErrDeletionGuard = errors.New("deletion guard triggered")
+
+// DeletionGuard limits the number of assets and relations an update can remove from the graph of a source. It
+// protects the graph from a misconfigured source suddenly emitting an empty graph.
+type DeletionGuard struct {
+	// MaxRemovedRatio is the maximum ratio, between 0 and 1, of the graph an update can remove. 0 disables the check.
+	MaxRemovedRatio float64 `mapstructure:"max_removed_ratio" json:"max_removed_ratio,omitempty"`
+	// MaxRemoved is the maximum number of assets and relations an update can remove. 0 disables the check.
+	MaxRemoved int64 `mapstructure:"max_removed" json:"max_removed,omitempty"`
+}
+
+// Enabled return true if at least one of the limits is set
+func (g DeletionGuard) Enabled() bool {
+	return g.MaxRemovedRatio > 0 || g.MaxRemoved > 0
+}
+
+// Check verifies removing the given number of assets and relations from a graph containing total of them is allowed
+func (g DeletionGuard) Check(removed, total int64) error {
+	if g.MaxRemoved > 0 && removed > g.MaxRemoved {
+		return fmt.Errorf("%w: %d assets and relations would be removed while the limit is %d",
+			ErrDeletionGuard, removed, g.MaxRemoved)
+	}
+	if g.MaxRemovedRatio > 0 && total > 0 && float64(removed)/float64(total) > g.MaxRemovedRatio {
+		return fmt.Errorf("%w: %d out of %d assets and relations would be removed while the limit is %.0f%%",
+			ErrDeletionGuard, removed, total, g.MaxRemovedRatio*100)
+	}
+	return nil
+}
+
+// RunIdleTimeout is the time after the last removal of a run after which the removals of the run are forgotten
+const RunIdleTimeout = time.Hour
+
+// implicitRunIdleTimeout is the time after which the removals sent without run ID are forgotten. Such removals are
+// counted together as one implicit run of the source.
+const implicitRunIdleTimeout = 5 * time.Minute
+
+// RunRemovals are the assets and relations removed by a run of a source sending its updates in several requests.
+// They are stored in the database so that the deletion guard applies to the whole run even when its requests are
+// served by several servers or when a server restarts.
+type RunRemovals struct {
+	// Total is the number of assets and relations of the graph of the source when the run started removing
+	Total   int64
+	Removed int64
+}
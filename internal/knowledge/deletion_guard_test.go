@@ -0,0 +1,27 @@
+package knowledge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldAllowAnyDeletionWhenGuardIsDisabled(t *testing.T) {
+	guard := DeletionGuard{}
+	assert.False(t, guard.Enabled())
+	assert.NoError(t, guard.Check(100, 100))
+}
+
+func TestShouldRefuseRemovingMoreThanMaxRemoved(t *testing.T) {
+	guard := DeletionGuard{MaxRemoved: 10}
+	assert.NoError(t, guard.Check(10, 1000))
+	assert.ErrorIs(t, guard.Check(11, 1000), ErrDeletionGuard)
+}
+
+func TestShouldRefuseRemovingMoreThanMaxRemovedRatio(t *testing.T) {
+	guard := DeletionGuard{MaxRemovedRatio: 0.5}
+	assert.NoError(t, guard.Check(50, 100))
+	assert.ErrorIs(t, guard.Check(51, 100), ErrDeletionGuard)
+	// Nothing can be removed from an empty graph
+	assert.NoError(t, guard.Check(0, 0))
+}
@@ -0,0 +1,104 @@
+package knowledge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/clems4ever/go-graphkb/internal/schema"
+)
+
+// ErrInvalidResolutionRule is returned when an entity resolution rule or a same-as link is malformed
+var ErrInvalidResolutionRule = errors.New("invalid entity resolution rule")
+
+// ErrUnknownAsset is returned when a same-as link refers to an asset which is not in the graph
+var ErrUnknownAsset = errors.New("unknown asset")
+
+// Normalizer is the function applied to the values of the assets before comparing them
+type Normalizer string
+
+const (
+	// IdentityNormalizer compares the values as is
+	IdentityNormalizer Normalizer = "identity"
+	// LowercaseNormalizer compares the values case insensitively
+	LowercaseNormalizer Normalizer = "lowercase"
+	// TrimNormalizer compares the values without their leading and trailing spaces
+	TrimNormalizer Normalizer = "trim"
+	// TrimLowercaseNormalizer compares the values case insensitively and without leading and trailing spaces
+	TrimLowercaseNormalizer Normalizer = "trim_lowercase"
+)
+
+// SQLExpression return the SQL expression normalizing the given column
+func (n Normalizer) SQLExpression(column string) (string, error) {
+	switch n {
+	case IdentityNormalizer, "":
+		return column, nil
+	case LowercaseNormalizer:
+		return fmt.Sprintf("LOWER(%s)", column), nil
+	case TrimNormalizer:
+		return fmt.Sprintf("TRIM(%s)", column), nil
+	case TrimLowercaseNormalizer:
+		return fmt.Sprintf("LOWER(TRIM(%s))", column), nil
+	}
+	return "", fmt.Errorf("%w: unknown normalizer %q", ErrInvalidResolutionRule, n)
+}
+
+// ResolutionRule declares that the assets of AliasType are the same entities as the assets of CanonicalType having
+// the same value once normalized.
+type ResolutionRule struct {
+	AliasType     schema.AssetType `json:"alias_type"`
+	CanonicalType schema.AssetType `json:"canonical_type"`
+	Normalizer    Normalizer       `json:"normalizer"`
+}
+
+func (r ResolutionRule) String() string {
+	return fmt.Sprintf("%s -> %s (%s)", r.AliasType, r.CanonicalType, r.Normalizer)
+}
+
+// Check verifies the rule is well formed
+func (r ResolutionRule) Check() error {
+	if r.AliasType == "" || r.CanonicalType == "" {
+		return fmt.Errorf("%w: alias and canonical types must be provided", ErrInvalidResolutionRule)
+	}
+	if r.AliasType == r.CanonicalType {
+		return fmt.Errorf("%w: %s cannot be resolved to itself", ErrInvalidResolutionRule, r.AliasType)
+	}
+	if _, err := r.Normalizer.SQLExpression("value"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SameAsLink declares the Alias asset as being the same entity as the Canonical asset
+type SameAsLink struct {
+	Alias     AssetKey `json:"alias"`
+	Canonical AssetKey `json:"canonical"`
+}
+
+// Check verifies the link is well formed
+func (l SameAsLink) Check() error {
+	if l.Alias.Type == "" || l.Canonical.Type == "" {
+		return fmt.Errorf("%w: alias and canonical assets must have a type", ErrInvalidResolutionRule)
+	}
+	if l.Alias == l.Canonical {
+		return fmt.Errorf("%w: asset %s:%s cannot be an alias of itself", ErrInvalidResolutionRule, l.Alias.Type, l.Alias.Key)
+	}
+	return nil
+}
+
+// EntityResolver stores the same-as links between assets and the rules creating them
+type EntityResolver interface {
+	ListResolutionRules(ctx context.Context) ([]ResolutionRule, error)
+	AddResolutionRule(ctx context.Context, rule ResolutionRule) error
+	RemoveResolutionRule(ctx context.Context, rule ResolutionRule) error
+
+	// AddSameAsLink add a link declared manually, it is kept until explicitly removed
+	AddSameAsLink(ctx context.Context, link SameAsLink) error
+	RemoveSameAsLink(ctx context.Context, link SameAsLink) error
+
+	// ResolveEntities recompute the links created by the rules and return the number of links
+	ResolveEntities(ctx context.Context) (int64, error)
+
+	// GetAssetAliases return the aliases of the canonical assets having the provided ids
+	GetAssetAliases(ctx context.Context, ids []string) (map[string][]AssetWithID, error)
+}
@@ -0,0 +1,43 @@
+package knowledge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldBuildNormalizerSQLExpression(t *testing.T) {
+	cases := map[Normalizer]string{
+		IdentityNormalizer:      "a.value",
+		LowercaseNormalizer:     "LOWER(a.value)",
+		TrimNormalizer:          "TRIM(a.value)",
+		TrimLowercaseNormalizer: "LOWER(TRIM(a.value))",
+	}
+
+	for n, expected := range cases {
+		exp, err := n.SQLExpression("a.value")
+		require.NoError(t, err)
+		assert.Equal(t, expected, exp)
+	}
+
+	_, err := Normalizer("unknown").SQLExpression("a.value")
+	assert.ErrorIs(t, err, ErrInvalidResolutionRule)
+}
+
+func TestShouldRejectMalformedResolutionRules(t *testing.T) {
+	assert.NoError(t, ResolutionRule{AliasType: "address", CanonicalType: "ip", Normalizer: TrimNormalizer}.Check())
+
+	rules := []ResolutionRule{
+		{AliasType: "address"},
+		{AliasType: "ip", CanonicalType: "ip"},
+		{AliasType: "address", CanonicalType: "ip", Normalizer: "unknown"},
+	}
+	for _, r := range rules {
+		assert.ErrorIs(t, r.Check(), ErrInvalidResolutionRule)
+	}
+
+	ip := AssetKey{Type: "ip", Key: "10.0.0.1"}
+	assert.NoError(t, SameAsLink{Alias: AssetKey{Type: "address", Key: "10.0.0.1"}, Canonical: ip}.Check())
+	assert.ErrorIs(t, SameAsLink{Alias: ip, Canonical: ip}.Check(), ErrInvalidResolutionRule)
+}
@@ -2,7 +2,6 @@ package knowledge
 
 import (
 	"encoding/json"
-	"fmt"
 
 	"github.com/clems4ever/go-graphkb/internal/schema"
 )
@@ -65,10 +64,8 @@ func NewGraph() *Graph {
 // AddAsset add an asset to the graph
 func (g *Graph) AddAsset(assetType schema.AssetType, assetKey string) (AssetKey, error) {
 	validators, _ := schema.AssetValidationRegistry.Get(assetType)
-	for _, v := range validators {
-		if !v(assetKey) {
-			return AssetKey{}, fmt.Errorf("asset value %q does not match the type %q validators", assetKey, assetType)
-		}
+	if err := schema.ValidateAsset(validators, assetType, assetKey); err != nil {
+		return AssetKey{}, err
 	}
 
 	asset := Asset{Type: assetType, Key: assetKey}
@@ -162,6 +159,18 @@ func (g *Graph) ExtractSchema() schema.SchemaGraph {
 		sg.AddAsset(string(a.Type))
 	}
 
+	for _, a := range sg.Assets() {
+		validators, _ := schema.AssetValidationRegistry.Get(a)
+		for _, v := range validators {
+			sg.AddValidator(a, v)
+		}
+
+		constraints, _ := schema.RelationConstraintRegistry.Get(a)
+		for _, c := range constraints {
+			sg.AddConstraint(c)
+		}
+	}
+
 	for r := range g.Relations() {
 		sg.AddRelation(r.From.Type, string(r.Type), r.To.Type)
 	}
@@ -0,0 +1,198 @@
+package knowledge
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/clems4ever/go-graphkb/internal/schema"
+)
+
+// ErrInvalidBinaryPayload is returned when a binary payload is malformed
+var ErrInvalidBinaryPayload = errors.New("invalid binary payload")
+
+const (
+	binaryFormatVersion = 2
+
+	binaryAssetsKind    = 'A'
+	binaryRelationsKind = 'R'
+
+	// maxBinaryStringLength is the maximum length of a type or a key in a binary payload
+	maxBinaryStringLength = 1 << 20
+)
+
+// The binary payload is made of a header (kind and version), the number of items as an uvarint and the items.
+// An asset is encoded as its type and key. A relation is encoded as its type and its endpoints. Strings are prefixed
+// by their length as an uvarint. The IDs of the assets and relations are not sent since the server could not trust
+// them and would have to hash the items anyway to check them.
+
+type binaryWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (bw *binaryWriter) writeUvarint(v uint64) {
+	if bw.err != nil {
+		return
+	}
+	n := binary.PutUvarint(bw.buf[:], v)
+	_, bw.err = bw.w.Write(bw.buf[:n])
+}
+
+func (bw *binaryWriter) writeString(s string) {
+	bw.writeUvarint(uint64(len(s)))
+	if bw.err != nil {
+		return
+	}
+	_, bw.err = bw.w.WriteString(s)
+}
+
+func (bw *binaryWriter) writeHeader(kind byte, count int) {
+	if bw.err != nil {
+		return
+	}
+	_, bw.err = bw.w.Write([]byte{kind, binaryFormatVersion})
+	bw.writeUvarint(uint64(count))
+}
+
+func (bw *binaryWriter) flush() error {
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.w.Flush()
+}
+
+// EncodeAssetsBinary write the assets in the binary format
+func EncodeAssetsBinary(w io.Writer, assets []Asset) error {
+	bw := &binaryWriter{w: bufio.NewWriter(w)}
+	bw.writeHeader(binaryAssetsKind, len(assets))
+	for _, a := range assets {
+		bw.writeString(string(a.Type))
+		bw.writeString(a.Key)
+	}
+	return bw.flush()
+}
+
+// EncodeRelationsBinary write the relations in the binary format
+func EncodeRelationsBinary(w io.Writer, relations []Relation) error {
+	bw := &binaryWriter{w: bufio.NewWriter(w)}
+	bw.writeHeader(binaryRelationsKind, len(relations))
+	for _, r := range relations {
+		bw.writeString(string(r.Type))
+		bw.writeString(string(r.From.Type))
+		bw.writeString(r.From.Key)
+		bw.writeString(string(r.To.Type))
+		bw.writeString(r.To.Key)
+	}
+	return bw.flush()
+}
+
+type binaryReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (br *binaryReader) fail(err error) {
+	if br.err == nil {
+		br.err = fmt.Errorf("%w: %v", ErrInvalidBinaryPayload, err)
+	}
+}
+
+func (br *binaryReader) readUvarint() uint64 {
+	if br.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		br.fail(err)
+	}
+	return v
+}
+
+func (br *binaryReader) readString() string {
+	length := br.readUvarint()
+	if br.err != nil {
+		return ""
+	}
+	if length > maxBinaryStringLength {
+		br.fail(fmt.Errorf("string of %d bytes is too long", length))
+		return ""
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(br.r, b); err != nil {
+		br.fail(err)
+		return ""
+	}
+	return string(b)
+}
+
+func (br *binaryReader) readHeader(kind byte) uint64 {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(br.r, header); err != nil {
+		br.fail(err)
+		return 0
+	}
+	if header[0] != kind {
+		br.fail(fmt.Errorf("unexpected payload kind %q", header[0]))
+		return 0
+	}
+	if header[1] != binaryFormatVersion {
+		br.fail(fmt.Errorf("unsupported version %d", header[1]))
+		return 0
+	}
+	return br.readUvarint()
+}
+
+// capacity bound the preallocated size of the decoded slices since the count is provided by the client
+func capacity(count uint64) int {
+	if count > 1<<16 {
+		return 1 << 16
+	}
+	return int(count)
+}
+
+// DecodeAssetsBinary read assets encoded in the binary format
+func DecodeAssetsBinary(r io.Reader) ([]Asset, error) {
+	br := &binaryReader{r: bufio.NewReader(r)}
+	count := br.readHeader(binaryAssetsKind)
+
+	assets := make([]Asset, 0, capacity(count))
+	for i := uint64(0); i < count && br.err == nil; i++ {
+		assetType := br.readString()
+		asset := NewAsset(schema.AssetType(assetType), br.readString())
+		if br.err != nil {
+			break
+		}
+		assets = append(assets, asset)
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	return assets, nil
+}
+
+// DecodeRelationsBinary read relations encoded in the binary format
+func DecodeRelationsBinary(r io.Reader) ([]Relation, error) {
+	br := &binaryReader{r: bufio.NewReader(r)}
+	count := br.readHeader(binaryRelationsKind)
+
+	relations := make([]Relation, 0, capacity(count))
+	for i := uint64(0); i < count && br.err == nil; i++ {
+		relation := Relation{Type: schema.RelationKeyType(br.readString())}
+		relation.From.Type = schema.AssetType(br.readString())
+		relation.From.Key = br.readString()
+		relation.To.Type = schema.AssetType(br.readString())
+		relation.To.Key = br.readString()
+		if br.err != nil {
+			break
+		}
+		relations = append(relations, relation)
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	return relations, nil
+}
@@ -0,0 +1,65 @@
+package knowledge
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldEncodeAndDecodeAssetsInBinary(t *testing.T) {
+	buff := bytes.NewBuffer(nil)
+	require.NoError(t, EncodeAssetsBinary(buff, []Asset{Asset1, Asset2, Asset3}))
+
+	assets, err := DecodeAssetsBinary(buff)
+	require.NoError(t, err)
+	assert.Equal(t, []Asset{Asset1, Asset2, Asset3}, assets)
+}
+
+func TestShouldEncodeAndDecodeRelationsInBinary(t *testing.T) {
+	buff := bytes.NewBuffer(nil)
+	require.NoError(t, EncodeRelationsBinary(buff, []Relation{Relation1, Relation2, Relation3}))
+
+	relations, err := DecodeRelationsBinary(buff)
+	require.NoError(t, err)
+	assert.Equal(t, []Relation{Relation1, Relation2, Relation3}, relations)
+}
+
+func TestShouldDecodeEmptyBinaryPayload(t *testing.T) {
+	buff := bytes.NewBuffer(nil)
+	require.NoError(t, EncodeAssetsBinary(buff, nil))
+
+	assets, err := DecodeAssetsBinary(buff)
+	require.NoError(t, err)
+	assert.Len(t, assets, 0)
+}
+
+func TestShouldRejectBinaryPayloadOfUnsupportedVersion(t *testing.T) {
+	buff := bytes.NewBuffer(nil)
+	require.NoError(t, EncodeAssetsBinary(buff, []Asset{Asset1}))
+
+	// The version directly follows the kind in the header
+	b := buff.Bytes()
+	b[1] = 1
+
+	_, err := DecodeAssetsBinary(bytes.NewReader(b))
+	assert.ErrorIs(t, err, ErrInvalidBinaryPayload)
+}
+
+func TestShouldRejectTruncatedBinaryPayload(t *testing.T) {
+	buff := bytes.NewBuffer(nil)
+	require.NoError(t, EncodeRelationsBinary(buff, []Relation{Relation1}))
+
+	b := buff.Bytes()
+	_, err := DecodeRelationsBinary(bytes.NewReader(b[:len(b)-2]))
+	assert.ErrorIs(t, err, ErrInvalidBinaryPayload)
+}
+
+func TestShouldRejectBinaryPayloadOfWrongKind(t *testing.T) {
+	buff := bytes.NewBuffer(nil)
+	require.NoError(t, EncodeAssetsBinary(buff, []Asset{Asset1}))
+
+	_, err := DecodeRelationsBinary(buff)
+	assert.ErrorIs(t, err, ErrInvalidBinaryPayload)
+}
@@ -0,0 +1,168 @@
+package knowledge
+
+import (
+	"errors"
+)
+
+// ErrRevisionTooOld is returned when the changes since a revision are not available anymore
+var ErrRevisionTooOld = errors.New("revision is too old")
+
+// GraphChange is an asset or a relation added to or removed from the graph of a source. The removed assets and
+// relations may not exist anymore, they are only identified by their ID.
+type GraphChange struct {
+	Removed    bool      `json:"removed,omitempty"`
+	Asset      *Asset    `json:"asset,omitempty"`
+	Relation   *Relation `json:"relation,omitempty"`
+	AssetID    uint64    `json:"asset_id,omitempty"`
+	RelationID uint64    `json:"relation_id,omitempty"`
+}
+
+// GraphChecksums are the checksums of the graph of a source split in buckets. The checksum of a bucket is the XOR
+// of the IDs of the assets or relations falling in the bucket.
+type GraphChecksums struct {
+	Revision  int64    `json:"revision"`
+	Assets    []uint64 `json:"assets"`
+	Relations []uint64 `json:"relations"`
+}
+
+// ComputeChecksums compute the checksums of the assets and relations of the graph which are not about to be removed
+func ComputeChecksums(g *Graph, buckets int) GraphChecksums {
+	checksums := GraphChecksums{
+		Assets:    make([]uint64, buckets),
+		Relations: make([]uint64, buckets),
+	}
+	for a, action := range g.assets {
+		if action == GraphEntryRemove {
+			continue
+		}
+		h := HashAsset(a)
+		checksums.Assets[h%uint64(buckets)] ^= h
+	}
+	for r, action := range g.relations {
+		if action == GraphEntryRemove {
+			continue
+		}
+		h := HashRelation(r)
+		checksums.Relations[h%uint64(buckets)] ^= h
+	}
+	return checksums
+}
+
+// MismatchingBuckets return the buckets whose checksums differ
+func MismatchingBuckets(local, remote []uint64) []int {
+	mismatches := []int{}
+	for i := range remote {
+		if i >= len(local) || local[i] != remote[i] {
+			mismatches = append(mismatches, i)
+		}
+	}
+	return mismatches
+}
+
+// ApplyChanges update the graph with the changes made on the server. The entries are left unchanged so that the
+// graph can be cleaned before the next transaction.
+func (g *Graph) ApplyChanges(changes []GraphChange) {
+	// The assets and relations removed by ID are indexed on first need
+	var assetsByID map[uint64]Asset
+	var relationsByID map[uint64]Relation
+
+	for _, c := range changes {
+		if c.Asset != nil {
+			if c.Removed {
+				delete(g.assets, *c.Asset)
+			} else {
+				g.assets[*c.Asset] = GraphEntryNone
+			}
+		}
+		if c.Relation != nil {
+			if c.Removed {
+				delete(g.relations, *c.Relation)
+			} else {
+				g.relations[*c.Relation] = GraphEntryNone
+			}
+		}
+		if c.Removed && c.AssetID != 0 {
+			if assetsByID == nil {
+				assetsByID = make(map[uint64]Asset, len(g.assets))
+				for a := range g.assets {
+					assetsByID[HashAsset(a)] = a
+				}
+			}
+			if a, ok := assetsByID[c.AssetID]; ok {
+				delete(g.assets, a)
+			}
+		}
+		if c.Removed && c.RelationID != 0 {
+			if relationsByID == nil {
+				relationsByID = make(map[uint64]Relation, len(g.relations))
+				for r := range g.relations {
+					relationsByID[HashRelation(r)] = r
+				}
+			}
+			if r, ok := relationsByID[c.RelationID]; ok {
+				delete(g.relations, r)
+			}
+		}
+	}
+}
+
+// ReplaceBuckets replace the assets and relations of the graph falling in the given buckets by the ones of the
+// other graph.
+func (g *Graph) ReplaceBuckets(buckets int, assetBuckets, relationBuckets []int, other *Graph) {
+	// The selected buckets are indexed once so that each item is checked with a single lookup
+	selection := func(selected []int) []bool {
+		index := make([]bool, buckets)
+		for _, b := range selected {
+			if b >= 0 && b < buckets {
+				index[b] = true
+			}
+		}
+		return index
+	}
+	selectedAssetBuckets := selection(assetBuckets)
+	selectedRelationBuckets := selection(relationBuckets)
+	inBuckets := func(h uint64, selected []bool) bool {
+		return selected[h%uint64(buckets)]
+	}
+
+	for a := range g.assets {
+		if inBuckets(HashAsset(a), selectedAssetBuckets) {
+			delete(g.assets, a)
+		}
+	}
+	for a := range other.assets {
+		if inBuckets(HashAsset(a), selectedAssetBuckets) {
+			g.assets[a] = GraphEntryNone
+		}
+	}
+
+	for r := range g.relations {
+		if inBuckets(HashRelation(r), selectedRelationBuckets) {
+			delete(g.relations, r)
+		}
+	}
+	for r := range other.relations {
+		if inBuckets(HashRelation(r), selectedRelationBuckets) {
+			g.relations[r] = GraphEntryNone
+		}
+	}
+}
+
+// Rollback discard the entries added since the graph has been cleaned and restore the other ones, i.e., the
+// graph represents the state it had before the failed transaction.
+func (g *Graph) Rollback() {
+	for k, v := range g.assets {
+		if v == GraphEntryAdd {
+			delete(g.assets, k)
+		} else {
+			g.assets[k] = GraphEntryNone
+		}
+	}
+	for k, v := range g.relations {
+		if v == GraphEntryAdd {
+			delete(g.relations, k)
+		} else {
+			g.relations[k] = GraphEntryNone
+		}
+	}
+}
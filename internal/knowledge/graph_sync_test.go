@@ -0,0 +1,71 @@
+package knowledge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildSyncGraph(t *testing.T, ips ...string) *Graph {
+	g := NewGraph()
+	for _, ip := range ips {
+		_, err := g.AddAsset("ip", ip)
+		require.NoError(t, err)
+	}
+	return g
+}
+
+func TestShouldReconcileMismatchingBuckets(t *testing.T) {
+	local := buildSyncGraph(t, "10.0.0.1", "10.0.0.2", "10.0.0.3")
+	remote := buildSyncGraph(t, "10.0.0.1", "10.0.0.2", "10.0.0.4")
+
+	localChecksums := ComputeChecksums(local, 16)
+	remoteChecksums := ComputeChecksums(remote, 16)
+
+	assetBuckets := MismatchingBuckets(localChecksums.Assets, remoteChecksums.Assets)
+	relationBuckets := MismatchingBuckets(localChecksums.Relations, remoteChecksums.Relations)
+	assert.NotEmpty(t, assetBuckets)
+	assert.Empty(t, relationBuckets)
+
+	local.ReplaceBuckets(16, assetBuckets, relationBuckets, remote)
+	assert.Equal(t, remoteChecksums, ComputeChecksums(local, 16))
+	assert.True(t, local.HasAsset(NewAsset("ip", "10.0.0.4")))
+	assert.False(t, local.HasAsset(NewAsset("ip", "10.0.0.3")))
+}
+
+func TestShouldApplyChanges(t *testing.T) {
+	g := buildSyncGraph(t, "10.0.0.1", "10.0.0.2")
+
+	added := NewAsset("ip", "10.0.0.3")
+	removed := NewAsset("ip", "10.0.0.1")
+	g.ApplyChanges([]GraphChange{{Asset: &added}, {Removed: true, Asset: &removed}})
+
+	assert.True(t, g.HasAsset(added))
+	assert.False(t, g.HasAsset(removed))
+	assert.Equal(t, GraphEntryNone, g.Assets()[added])
+
+	// The removals only carry the ID of the removed asset
+	g.ApplyChanges([]GraphChange{{Removed: true, AssetID: HashAsset(NewAsset("ip", "10.0.0.2"))}})
+	assert.False(t, g.HasAsset(NewAsset("ip", "10.0.0.2")))
+	assert.True(t, g.HasAsset(added))
+}
+
+func TestShouldRollbackFailedTransaction(t *testing.T) {
+	g := buildSyncGraph(t, "10.0.0.1", "10.0.0.2")
+	g.Clean()
+
+	_, err := g.AddAsset("ip", "10.0.0.1")
+	require.NoError(t, err)
+	_, err = g.AddAsset("ip", "10.0.0.3")
+	require.NoError(t, err)
+
+	g.Rollback()
+	assert.True(t, g.HasAsset(NewAsset("ip", "10.0.0.1")))
+	assert.True(t, g.HasAsset(NewAsset("ip", "10.0.0.2")))
+	assert.False(t, g.HasAsset(NewAsset("ip", "10.0.0.3")))
+
+	// The entries are kept by the next clean so that they are removed if not bound again
+	g.Clean()
+	assert.Equal(t, GraphEntryRemove, g.Assets()[NewAsset("ip", "10.0.0.2")])
+}
@@ -3,11 +3,17 @@ package knowledge
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 )
 
+// SchemaCacheTTL is how long the schema of a source used to validate the inserted assets is cached before being read
+// again from the database. The cached schema is dropped as soon as it is updated by this server.
+const SchemaCacheTTL = 30 * time.Second
+
 // SourceSubGraphUpdates represents the updates to perform on a source subgraph
 type SourceSubGraphUpdates struct {
 	Schema schema.SchemaGraph
@@ -18,20 +24,53 @@ type SourceSubGraphUpdates struct {
 type GraphUpdater struct {
 	graphDB         GraphDB
 	schemaPersistor schema.Persistor
+	stager          TransactionStager
+
+	// schemas caches the schemas of the sources so that they are not read for every chunk of inserted items
+	schemas *cache.Cache
 }
 
 // NewGraphUpdater create a new instance of graph updater
-func NewGraphUpdater(graphDB GraphDB, schemaPersistor schema.Persistor) *GraphUpdater {
-	return &GraphUpdater{graphDB, schemaPersistor}
+func NewGraphUpdater(graphDB GraphDB, schemaPersistor schema.Persistor, stager TransactionStager) *GraphUpdater {
+	return &GraphUpdater{
+		graphDB:         graphDB,
+		schemaPersistor: schemaPersistor,
+		stager:          stager,
+		schemas:         cache.New(SchemaCacheTTL, 2*SchemaCacheTTL),
+	}
 }
 
 // UpdateSchema update the schema for the source with the one provided in the request
 func (sl *GraphUpdater) UpdateSchema(ctx context.Context, source string, sg schema.SchemaGraph) error {
+	return sl.updateSchema(ctx, source, sg, false)
+}
+
+// MergeSchema add the asset types, relation types, validators and constraints of the provided schema to the schema
+// of the source. It is used by the sources sending incremental updates which only know a part of their schema.
+func (sl *GraphUpdater) MergeSchema(ctx context.Context, source string, sg schema.SchemaGraph) error {
+	return sl.updateSchema(ctx, source, sg, true)
+}
+
+func (sl *GraphUpdater) updateSchema(ctx context.Context, source string, sg schema.SchemaGraph, merge bool) error {
 	previousSchema, err := sl.schemaPersistor.LoadSchema(ctx, source)
 	if err != nil {
 		return fmt.Errorf("Unable to read schema from DB: %v", err)
 	}
 
+	if err := sg.CheckValidators(); err != nil {
+		return err
+	}
+
+	if merge {
+		merged := schema.NewSchemaGraph()
+		merged.Merge(previousSchema)
+		merged.Merge(sg)
+		sg = merged
+	}
+
+	// The ontology is managed by the server, it cannot be provided by a source.
+	sg.Ontology = nil
+
 	schemaEqual := previousSchema.Equal(sg)
 
 	if !schemaEqual {
@@ -39,38 +78,353 @@ func (sl *GraphUpdater) UpdateSchema(ctx context.Context, source string, sg sche
 		if err := sl.schemaPersistor.SaveSchema(ctx, source, sg); err != nil {
 			return fmt.Errorf("Unable to write schema in DB: %v", err)
 		}
+		sl.schemas.Delete(source)
 	}
 	return nil
 }
 
-// InsertAssets insert multiple assets in the graph of the data source
-func (sl *GraphUpdater) InsertAssets(ctx context.Context, source string, assets []Asset) error {
-	if err := sl.graphDB.InsertAssets(ctx, source, assets); err != nil {
-		return fmt.Errorf("Unable to insert assets from source %s: %v", source, err)
+// loadSchema read the schema of the source from the cache or from the database when it is not cached
+func (sl *GraphUpdater) loadSchema(ctx context.Context, source string) (schema.SchemaGraph, error) {
+	if sg, ok := sl.schemas.Get(source); ok {
+		return sg.(schema.SchemaGraph), nil
+	}
+	sg, err := sl.schemaPersistor.LoadSchema(ctx, source)
+	if err != nil {
+		return schema.SchemaGraph{}, fmt.Errorf("Unable to read schema from DB: %v", err)
+	}
+	sl.schemas.SetDefault(source, sg)
+	return sg, nil
+}
+
+// validateAssets checks the assets satisfy the validation rules declared in the schema of the source
+func (sl *GraphUpdater) validateAssets(ctx context.Context, source string, assets []AssetKey) error {
+	sg, err := sl.loadSchema(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	return validateAssetsWithSchema(sg, assets)
+}
+
+func validateAssetsWithSchema(sg schema.SchemaGraph, assets []AssetKey) error {
+	if len(sg.Validators) == 0 {
+		return nil
+	}
+
+	for _, a := range assets {
+		if err := sg.ValidateAsset(a.Type, a.Key); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// InsertRelations insert multiple relations in the graph of the data source
-func (sl *GraphUpdater) InsertRelations(ctx context.Context, source string, relations []Relation) error {
-	if err := sl.graphDB.InsertRelations(ctx, source, relations); err != nil {
+// distinctAssetIDs compute the IDs of the assets without duplicates
+func distinctAssetIDs(assets []Asset) []uint64 {
+	seen := make(map[uint64]struct{}, len(assets))
+	ids := make([]uint64, 0, len(assets))
+	for _, a := range assets {
+		id := HashAsset(a)
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// distinctRelationIDs compute the IDs of the relations without duplicates
+func distinctRelationIDs(relations []Relation) []uint64 {
+	seen := make(map[uint64]struct{}, len(relations))
+	ids := make([]uint64, 0, len(relations))
+	for _, r := range relations {
+		id := HashRelation(r)
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// countGrowth compute by how many assets and relations the graph of the source grows once the given assets and
+// relations are inserted and removed. The inserted items already bound to the source and the removed items which
+// are not bound to it do not change the size of the graph.
+func (sl *GraphUpdater) countGrowth(ctx context.Context, source string, insertedAssets []Asset, insertedRelations []Relation,
+	removedAssets []Asset, removedRelations []Relation) (int64, int64, error) {
+	insertedAssetIDs := distinctAssetIDs(insertedAssets)
+	boundInsertedAssets, err := sl.graphDB.CountBoundAssets(ctx, source, insertedAssetIDs)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to count assets: %v", err)
+	}
+	boundRemovedAssets, err := sl.graphDB.CountBoundAssets(ctx, source, distinctAssetIDs(removedAssets))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to count assets: %v", err)
+	}
+
+	insertedRelationIDs := distinctRelationIDs(insertedRelations)
+	boundInsertedRelations, err := sl.graphDB.CountBoundRelations(ctx, source, insertedRelationIDs)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to count relations: %v", err)
+	}
+	boundRemovedRelations, err := sl.graphDB.CountBoundRelations(ctx, source, distinctRelationIDs(removedRelations))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to count relations: %v", err)
+	}
+
+	addedAssets := int64(len(insertedAssetIDs)) - boundInsertedAssets - boundRemovedAssets
+	addedRelations := int64(len(insertedRelationIDs)) - boundInsertedRelations - boundRemovedRelations
+	return addedAssets, addedRelations, nil
+}
+
+// checkQuota verifies the graph of the source still fits in the quota once the given assets and relations are
+// inserted and removed. Only the assets and relations which are not bound to the source yet count against the quota
+// so that a source pushing its whole graph again is not refused. The graph of the source is only counted when it
+// grows.
+func (sl *GraphUpdater) checkQuota(ctx context.Context, source string, quota Quota, insertedAssets []Asset,
+	insertedRelations []Relation, removedAssets []Asset, removedRelations []Relation) error {
+	if !quota.Enabled() {
+		return nil
+	}
+
+	addedAssets, addedRelations, err := sl.countGrowth(ctx, source, insertedAssets, insertedRelations, removedAssets, removedRelations)
+	if err != nil {
+		return err
+	}
+	if addedAssets <= 0 && addedRelations <= 0 {
+		return nil
+	}
+
+	assets, relations, err := sl.graphDB.CountSourceGraph(ctx, source)
+	if err != nil {
+		return fmt.Errorf("Unable to count the assets and relations of source %s: %v", source, err)
+	}
+	return quota.Check(assets+addedAssets, relations+addedRelations)
+}
+
+// InsertAssets insert multiple assets in the graph of the data source if the quota allows it
+func (sl *GraphUpdater) InsertAssets(ctx context.Context, source, run string, assets []Asset, quota Quota) error {
+	keys := make([]AssetKey, 0, len(assets))
+	for _, a := range assets {
+		keys = append(keys, AssetKey(a))
+	}
+	if err := sl.validateAssets(ctx, source, keys); err != nil {
+		return fmt.Errorf("Unable to insert assets from source %s: %w", source, err)
+	}
+	if err := sl.checkQuota(ctx, source, quota, assets, nil, nil, nil); err != nil {
+		return fmt.Errorf("Unable to insert assets from source %s: %w", source, err)
+	}
+
+	revision, err := sl.graphDB.InsertAssets(ctx, source, assets)
+	if err != nil {
+		return fmt.Errorf("Unable to insert assets from source %s: %v", source, err)
+	}
+	return sl.recordRunRevision(ctx, source, run, revision)
+}
+
+// InsertRelations insert multiple relations in the graph of the data source if the quota allows it
+func (sl *GraphUpdater) InsertRelations(ctx context.Context, source, run string, relations []Relation, quota Quota) error {
+	keys := make([]AssetKey, 0, 2*len(relations))
+	for _, r := range relations {
+		keys = append(keys, r.From, r.To)
+	}
+	if err := sl.validateAssets(ctx, source, keys); err != nil {
+		return fmt.Errorf("Unable to insert relations from source %s: %w", source, err)
+	}
+	if err := sl.checkQuota(ctx, source, quota, nil, relations, nil, nil); err != nil {
+		return fmt.Errorf("Unable to insert relations from source %s: %w", source, err)
+	}
+
+	revision, err := sl.graphDB.InsertRelations(ctx, source, relations)
+	if err != nil {
 		return fmt.Errorf("Unable to insert relations from source %s: %v", source, err)
 	}
+	return sl.recordRunRevision(ctx, source, run, revision)
+}
+
+// runIdleTimeout return the time after the last update of the run after which the run is forgotten
+func runIdleTimeout(run string) time.Duration {
+	if run == "" {
+		return implicitRunIdleTimeout
+	}
+	return RunIdleTimeout
+}
+
+// recordRunRevision record the revision produced by an update of the run so that it is returned to the source when
+// the run completes. The updates sent without run ID are not recorded, the source reads the revision on its own.
+func (sl *GraphUpdater) recordRunRevision(ctx context.Context, source, run string, revision int64) error {
+	if run == "" || revision == 0 {
+		return nil
+	}
+	if err := sl.graphDB.RecordRunRevision(ctx, source, run, revision, runIdleTimeout(run)); err != nil {
+		return fmt.Errorf("Unable to record the revision of run %q of source %s: %v", run, source, err)
+	}
 	return nil
 }
 
-// RemoveAssets remove multiple assets from the graph of the data source
-func (sl *GraphUpdater) RemoveAssets(ctx context.Context, source string, assets []Asset) error {
-	if err := sl.graphDB.RemoveAssets(ctx, source, assets); err != nil {
+// checkRunDeletion verifies the guard allows the removals of the run once the given number of assets and relations
+// are removed. The graph of the source is counted when the run starts removing and the removals of all the requests
+// of the run are added up in the database so that a run cannot remove the whole graph by small chunks, whichever
+// server receives them. The removals sent without run ID are counted together until the source stops removing for a
+// while.
+func (sl *GraphUpdater) checkRunDeletion(ctx context.Context, source, run string, guard DeletionGuard, removed int64) error {
+	if !guard.Enabled() || removed == 0 {
+		return nil
+	}
+
+	return sl.graphDB.AddRunRemovals(ctx, source, run, removed, runIdleTimeout(run), func(r RunRemovals) error {
+		return guard.Check(r.Removed, r.Total)
+	})
+}
+
+// RemoveAssets remove multiple assets from the graph of the data source if the guard allows the removals of the run
+func (sl *GraphUpdater) RemoveAssets(ctx context.Context, source, run string, assets []Asset, guard DeletionGuard) error {
+	if err := sl.checkRunDeletion(ctx, source, run, guard, int64(len(assets))); err != nil {
+		return fmt.Errorf("Unable to remove assets from source %s: %w", source, err)
+	}
+
+	revision, err := sl.graphDB.RemoveAssets(ctx, source, assets)
+	if err != nil {
 		return fmt.Errorf("Unable to remove assets from source %s: %v", source, err)
 	}
-	return nil
+	return sl.recordRunRevision(ctx, source, run, revision)
 }
 
-// RemoveRelations remove multiple relations from the graph of the data source
-func (sl *GraphUpdater) RemoveRelations(ctx context.Context, source string, relations []Relation) error {
-	if err := sl.graphDB.RemoveRelations(ctx, source, relations); err != nil {
+// RemoveRelations remove multiple relations from the graph of the data source if the guard allows the removals of
+// the run
+func (sl *GraphUpdater) RemoveRelations(ctx context.Context, source, run string, relations []Relation, guard DeletionGuard) error {
+	if err := sl.checkRunDeletion(ctx, source, run, guard, int64(len(relations))); err != nil {
+		return fmt.Errorf("Unable to remove relations from source %s: %w", source, err)
+	}
+
+	revision, err := sl.graphDB.RemoveRelations(ctx, source, relations)
+	if err != nil {
 		return fmt.Errorf("Unable to remove relations from source %s: %v", source, err)
 	}
+	return sl.recordRunRevision(ctx, source, run, revision)
+}
+
+// CompleteRun end a run of the data source sending its updates in several requests, its removals are forgotten. The
+// last revision produced by the run is returned, 0 if the run did not update the graph.
+func (sl *GraphUpdater) CompleteRun(ctx context.Context, source, run string) (int64, error) {
+	revision, err := sl.graphDB.CompleteRun(ctx, source, run)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to complete run %q of source %s: %v", run, source, err)
+	}
+	return revision, nil
+}
+
+// BeginTransaction open a staged transaction for the data source
+func (sl *GraphUpdater) BeginTransaction(ctx context.Context, source string, ttl time.Duration) (string, error) {
+	id, err := sl.stager.BeginTransaction(ctx, source, ttl)
+	if err != nil {
+		return "", fmt.Errorf("Unable to begin transaction for source %s: %v", source, err)
+	}
+	return id, nil
+}
+
+// StageSchema stage the schema of the data source in the transaction
+func (sl *GraphUpdater) StageSchema(ctx context.Context, source, id string, sg schema.SchemaGraph) error {
+	if err := sg.CheckValidators(); err != nil {
+		return err
+	}
+
+	// The ontology is managed by the server, it cannot be provided by a source.
+	sg.Ontology = nil
+
+	if err := sl.stager.StageSchema(ctx, source, id, sg); err != nil {
+		return fmt.Errorf("Unable to stage schema of source %s: %w", source, err)
+	}
+	return nil
+}
+
+// StageAssets stage assets to insert or remove in the transaction
+func (sl *GraphUpdater) StageAssets(ctx context.Context, source, id string, operation StagedOperation, assets []Asset) error {
+	if err := sl.stager.StageAssets(ctx, source, id, operation, assets); err != nil {
+		return fmt.Errorf("Unable to stage assets of source %s: %w", source, err)
+	}
+	return nil
+}
+
+// StageRelations stage relations to insert or remove in the transaction
+func (sl *GraphUpdater) StageRelations(ctx context.Context, source, id string, operation StagedOperation, relations []Relation) error {
+	if err := sl.stager.StageRelations(ctx, source, id, operation, relations); err != nil {
+		return fmt.Errorf("Unable to stage relations of source %s: %w", source, err)
+	}
+	return nil
+}
+
+// CommitTransaction apply all the changes staged in the transaction atomically. The inserted assets are validated
+// against the staged schema or, when no schema has been staged, against the current schema of the source. The
+// commit is refused if the guard does not allow the staged removals or if the graph would exceed the quota. The
+// revision produced by the commit is returned.
+func (sl *GraphUpdater) CommitTransaction(ctx context.Context, source, id string, guard DeletionGuard, quota Quota) (int64, error) {
+	var sg schema.SchemaGraph
+	var removed int64
+	schemaChanged := false
+
+	revision, err := sl.stager.CommitTransaction(ctx, source, id, CommitHooks{
+		PrepareSchema: func(staged *schema.SchemaGraph) (*schema.SchemaGraph, error) {
+			previousSchema, err := sl.schemaPersistor.LoadSchema(ctx, source)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read schema from DB: %v", err)
+			}
+
+			sg = previousSchema
+			if staged == nil || previousSchema.Equal(*staged) {
+				return nil, nil
+			}
+			sg = *staged
+			schemaChanged = true
+			return staged, nil
+		},
+		CheckBatch: func(batch StagedBatch) error {
+			switch batch.Operation {
+			case InsertAssetsOperation:
+				keys := make([]AssetKey, 0, len(batch.Assets))
+				for _, a := range batch.Assets {
+					keys = append(keys, AssetKey(a))
+				}
+				return validateAssetsWithSchema(sg, keys)
+			case InsertRelationsOperation:
+				keys := make([]AssetKey, 0, 2*len(batch.Relations))
+				for _, r := range batch.Relations {
+					keys = append(keys, r.From, r.To)
+				}
+				return validateAssetsWithSchema(sg, keys)
+			case RemoveAssetsOperation:
+				removed += int64(len(batch.Assets))
+			case RemoveRelationsOperation:
+				removed += int64(len(batch.Relations))
+			}
+			return nil
+		},
+		Check: func(stats CommitStats) error {
+			if guard.Enabled() && removed > 0 {
+				if err := guard.Check(removed, stats.AssetsBefore+stats.RelationsBefore); err != nil {
+					return err
+				}
+			}
+			// The graph of a source already exceeding its quota can still shrink
+			if quota.Enabled() && (stats.AssetsAfter > stats.AssetsBefore || stats.RelationsAfter > stats.RelationsBefore) {
+				return quota.Check(stats.AssetsAfter, stats.RelationsAfter)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Unable to commit transaction of source %s: %w", source, err)
+	}
+	if schemaChanged {
+		sl.schemas.Delete(source)
+	}
+	return revision, nil
+}
+
+// AbortTransaction discard the changes staged in the transaction
+func (sl *GraphUpdater) AbortTransaction(ctx context.Context, source, id string) error {
+	if err := sl.stager.AbortTransaction(ctx, source, id); err != nil {
+		return fmt.Errorf("Unable to abort transaction of source %s: %w", source, err)
+	}
 	return nil
 }
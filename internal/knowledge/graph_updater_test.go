@@ -0,0 +1,353 @@
+package knowledge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSchemaPersistor struct {
+	schema.Persistor
+	sg    schema.SchemaGraph
+	saved *schema.SchemaGraph
+}
+
+func (m *mockSchemaPersistor) LoadSchema(ctx context.Context, sourceName string) (schema.SchemaGraph, error) {
+	return m.sg, nil
+}
+
+func (m *mockSchemaPersistor) SaveSchema(ctx context.Context, sourceName string, sg schema.SchemaGraph) error {
+	m.saved = &sg
+	return nil
+}
+
+type mockTransactionStager struct {
+	TransactionStager
+	schema  *schema.SchemaGraph
+	batches []StagedBatch
+	stats   CommitStats
+
+	committed   bool
+	savedSchema *schema.SchemaGraph
+}
+
+func (m *mockTransactionStager) CommitTransaction(ctx context.Context, source, id string, hooks CommitHooks) (int64, error) {
+	sg, err := hooks.PrepareSchema(m.schema)
+	if err != nil {
+		return 0, err
+	}
+	for _, batch := range m.batches {
+		if err := hooks.CheckBatch(batch); err != nil {
+			return 0, err
+		}
+	}
+	if err := hooks.Check(m.stats); err != nil {
+		return 0, err
+	}
+	m.committed, m.savedSchema = true, sg
+	return 7, nil
+}
+
+func newValidatedSchema() schema.SchemaGraph {
+	sg := schema.NewSchemaGraph()
+	ip := sg.AddAsset("ip")
+	sg.AddValidator(ip, schema.AssetValidationRule{Type: schema.RegexpValidationRule, Pattern: "^[0-9.]+$"})
+	return sg
+}
+
+func TestShouldNotSaveUnchangedSchemaOnCommit(t *testing.T) {
+	sg := newValidatedSchema()
+	stager := &mockTransactionStager{
+		schema:  &sg,
+		batches: []StagedBatch{{Operation: InsertAssetsOperation, Assets: []Asset{NewAsset("ip", "10.0.0.1")}}},
+	}
+	updater := NewGraphUpdater(nil, &mockSchemaPersistor{sg: newValidatedSchema()}, stager)
+
+	revision, err := updater.CommitTransaction(context.Background(), "source", "tx", DeletionGuard{}, Quota{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), revision)
+	assert.True(t, stager.committed)
+	assert.Nil(t, stager.savedSchema)
+}
+
+func TestShouldValidateAssetsWithStagedSchemaOnCommit(t *testing.T) {
+	sg := newValidatedSchema()
+	stager := &mockTransactionStager{
+		schema: &sg,
+		batches: []StagedBatch{{Operation: InsertRelationsOperation, Relations: []Relation{{
+			Type: "linked",
+			From: AssetKey{Type: "ip", Key: "10.0.0.1"},
+			To:   AssetKey{Type: "ip", Key: "not-an-ip"},
+		}}}},
+	}
+	updater := NewGraphUpdater(nil, &mockSchemaPersistor{sg: schema.NewSchemaGraph()}, stager)
+
+	_, err := updater.CommitTransaction(context.Background(), "source", "tx", DeletionGuard{}, Quota{})
+	assert.ErrorIs(t, err, schema.ErrAssetValidation)
+	assert.False(t, stager.committed)
+}
+
+func TestShouldMergeSchemaIntoCurrentSchema(t *testing.T) {
+	persistor := &mockSchemaPersistor{sg: newValidatedSchema()}
+	updater := NewGraphUpdater(nil, persistor, nil)
+
+	sg := schema.NewSchemaGraph()
+	device := sg.AddAsset("device")
+	sg.AddRelation(device, "has_ip", "ip")
+
+	require.NoError(t, updater.MergeSchema(context.Background(), "source", sg))
+	require.NotNil(t, persistor.saved)
+	assert.ElementsMatch(t, []schema.AssetType{"ip", "device"}, persistor.saved.Assets())
+	assert.Len(t, persistor.saved.Relations(), 1)
+	assert.Len(t, persistor.saved.Validators["ip"], 1)
+}
+
+func TestShouldNotSaveSchemaWhenMergeAddsNothing(t *testing.T) {
+	persistor := &mockSchemaPersistor{sg: newValidatedSchema()}
+	updater := NewGraphUpdater(nil, persistor, nil)
+
+	sg := schema.NewSchemaGraph()
+	sg.AddAsset("ip")
+
+	require.NoError(t, updater.MergeSchema(context.Background(), "source", sg))
+	assert.Nil(t, persistor.saved)
+}
+
+type mockGraphDB struct {
+	GraphDB
+	assets    map[string]int64
+	relations map[string]int64
+	// bound are the IDs of the assets and relations bound to the sources
+	bound   map[uint64]bool
+	counts  int
+	removed int
+	runs    map[string]*RunRemovals
+	// revision is the revision of the graph, bumped by every update
+	revision     int64
+	runRevisions map[string]int64
+}
+
+func (m *mockGraphDB) CountSourceGraph(ctx context.Context, sourceName string) (int64, int64, error) {
+	m.counts++
+	return m.assets[sourceName], m.relations[sourceName], nil
+}
+
+func (m *mockGraphDB) InsertAssets(ctx context.Context, sourceName string, assets []Asset) (int64, error) {
+	m.revision++
+	return m.revision, nil
+}
+
+func (m *mockGraphDB) InsertRelations(ctx context.Context, sourceName string, relations []Relation) (int64, error) {
+	m.revision++
+	return m.revision, nil
+}
+
+func (m *mockGraphDB) RemoveAssets(ctx context.Context, sourceName string, assets []Asset) (int64, error) {
+	m.removed += len(assets)
+	m.revision++
+	return m.revision, nil
+}
+
+func (m *mockGraphDB) RecordRunRevision(ctx context.Context, sourceName, run string, revision int64, ttl time.Duration) error {
+	if m.runRevisions == nil {
+		m.runRevisions = make(map[string]int64)
+	}
+	if revision > m.runRevisions[sourceName+"/"+run] {
+		m.runRevisions[sourceName+"/"+run] = revision
+	}
+	return nil
+}
+
+func (m *mockGraphDB) AddRunRemovals(ctx context.Context, sourceName, run string, removed int64, ttl time.Duration, check func(RunRemovals) error) error {
+	if m.runs == nil {
+		m.runs = make(map[string]*RunRemovals)
+	}
+	r, ok := m.runs[sourceName+"/"+run]
+	if !ok {
+		assets, relations, _ := m.CountSourceGraph(ctx, sourceName)
+		r = &RunRemovals{Total: assets + relations}
+		m.runs[sourceName+"/"+run] = r
+	}
+	removals := RunRemovals{Total: r.Total, Removed: r.Removed + removed}
+	if err := check(removals); err != nil {
+		return err
+	}
+	*r = removals
+	return nil
+}
+
+func (m *mockGraphDB) CompleteRun(ctx context.Context, sourceName, run string) (int64, error) {
+	revision := m.runRevisions[sourceName+"/"+run]
+	delete(m.runs, sourceName+"/"+run)
+	delete(m.runRevisions, sourceName+"/"+run)
+	return revision, nil
+}
+
+func (m *mockGraphDB) countBound(ids []uint64) int64 {
+	var bound int64
+	for _, id := range ids {
+		if m.bound[id] {
+			bound++
+		}
+	}
+	return bound
+}
+
+func (m *mockGraphDB) CountBoundAssets(ctx context.Context, sourceName string, ids []uint64) (int64, error) {
+	return m.countBound(ids), nil
+}
+
+func (m *mockGraphDB) CountBoundRelations(ctx context.Context, sourceName string, ids []uint64) (int64, error) {
+	return m.countBound(ids), nil
+}
+
+func TestShouldRefuseCommitRemovingTooManyEntities(t *testing.T) {
+	stager := &mockTransactionStager{
+		batches: []StagedBatch{
+			{Operation: RemoveRelationsOperation, Relations: []Relation{Relation1}},
+			{Operation: RemoveAssetsOperation, Assets: []Asset{NewAsset("ip", "10.0.0.1"), NewAsset("ip", "10.0.0.2")}},
+		},
+		stats: CommitStats{AssetsBefore: 4, RelationsBefore: 1, AssetsAfter: 2},
+	}
+	updater := NewGraphUpdater(&mockGraphDB{}, &mockSchemaPersistor{sg: schema.NewSchemaGraph()}, stager)
+
+	_, err := updater.CommitTransaction(context.Background(), "source", "tx", DeletionGuard{MaxRemovedRatio: 0.5}, Quota{})
+	assert.ErrorIs(t, err, ErrDeletionGuard)
+	assert.False(t, stager.committed)
+
+	_, err = updater.CommitTransaction(context.Background(), "source", "tx", DeletionGuard{MaxRemovedRatio: 0.6}, Quota{})
+	require.NoError(t, err)
+	assert.True(t, stager.committed)
+}
+
+func TestShouldGuardRemovalsOfWholeRun(t *testing.T) {
+	graphDB := &mockGraphDB{assets: map[string]int64{"source": 10}}
+	updater := NewGraphUpdater(graphDB, &mockSchemaPersistor{sg: schema.NewSchemaGraph()}, nil)
+	guard := DeletionGuard{MaxRemovedRatio: 0.5}
+	chunk := []Asset{NewAsset("ip", "10.0.0.1"), NewAsset("ip", "10.0.0.2")}
+
+	// The chunks are small but the run would remove the whole graph
+	for i := 0; i < 2; i++ {
+		require.NoError(t, updater.RemoveAssets(context.Background(), "source", "run1", chunk, guard))
+	}
+	err := updater.RemoveAssets(context.Background(), "source", "run1", chunk, guard)
+	assert.ErrorIs(t, err, ErrDeletionGuard)
+	assert.Equal(t, 4, graphDB.removed)
+	// The graph is only counted once per run
+	assert.Equal(t, 1, graphDB.counts)
+
+	// The other runs have their own budget
+	require.NoError(t, updater.RemoveAssets(context.Background(), "source", "run2", chunk, guard))
+
+	// The removals are forgotten once the run is completed
+	_, err = updater.CompleteRun(context.Background(), "source", "run1")
+	require.NoError(t, err)
+	require.NoError(t, updater.RemoveAssets(context.Background(), "source", "run1", chunk, guard))
+
+	// The removals without run ID are added up as well
+	for i := 0; i < 2; i++ {
+		require.NoError(t, updater.RemoveAssets(context.Background(), "source", "", chunk, guard))
+	}
+	err = updater.RemoveAssets(context.Background(), "source", "", chunk, guard)
+	assert.ErrorIs(t, err, ErrDeletionGuard)
+}
+
+func TestShouldReturnLastRevisionOfRun(t *testing.T) {
+	graphDB := &mockGraphDB{assets: map[string]int64{"source": 10}}
+	updater := NewGraphUpdater(graphDB, &mockSchemaPersistor{sg: schema.NewSchemaGraph()}, nil)
+	chunk := []Asset{NewAsset("ip", "10.0.0.1")}
+
+	require.NoError(t, updater.InsertAssets(context.Background(), "source", "run1", chunk, Quota{}))
+	require.NoError(t, updater.RemoveAssets(context.Background(), "source", "run1", chunk, DeletionGuard{}))
+	// The updates of the other runs are not returned to the run
+	require.NoError(t, updater.InsertAssets(context.Background(), "source", "run2", chunk, Quota{}))
+
+	revision, err := updater.CompleteRun(context.Background(), "source", "run1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), revision)
+
+	// A run which did not update the graph produced no revision
+	revision, err = updater.CompleteRun(context.Background(), "source", "run3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), revision)
+}
+
+func TestShouldRefuseUpdatesExceedingQuota(t *testing.T) {
+	stager := &mockTransactionStager{
+		batches: []StagedBatch{
+			{Operation: InsertAssetsOperation, Assets: []Asset{NewAsset("ip", "10.0.0.1"), NewAsset("ip", "10.0.0.2"), NewAsset("ip", "10.0.0.4")}},
+			{Operation: RemoveAssetsOperation, Assets: []Asset{NewAsset("ip", "10.0.0.3"), NewAsset("ip", "10.0.0.5")}},
+		},
+		stats: CommitStats{AssetsBefore: 4, RelationsBefore: 1, AssetsAfter: 5, RelationsAfter: 1},
+	}
+	graphDB := &mockGraphDB{
+		assets:    map[string]int64{"source": 4, "other": 100},
+		relations: map[string]int64{"source": 1},
+		bound: map[uint64]bool{
+			HashAsset(NewAsset("ip", "10.0.0.1")): true,
+			HashAsset(NewAsset("ip", "10.0.0.3")): true,
+			HashRelation(Relation1):               true,
+		},
+	}
+	updater := NewGraphUpdater(graphDB, &mockSchemaPersistor{sg: schema.NewSchemaGraph()}, stager)
+
+	err := updater.InsertAssets(context.Background(), "source", "", []Asset{NewAsset("ip", "10.0.0.2")}, Quota{MaxAssets: 4})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	err = updater.InsertRelations(context.Background(), "source", "", []Relation{Relation2}, Quota{MaxRelations: 1})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// Inserting again the assets and relations already bound to the source does not grow its graph
+	graphDB.counts = 0
+	require.NoError(t, updater.InsertAssets(context.Background(), "source", "",
+		[]Asset{NewAsset("ip", "10.0.0.1"), NewAsset("ip", "10.0.0.1")}, Quota{MaxAssets: 4}))
+	require.NoError(t, updater.InsertRelations(context.Background(), "source", "", []Relation{Relation1}, Quota{MaxRelations: 1}))
+	assert.Equal(t, 0, graphDB.counts)
+
+	// The commit adds two assets and removes a single asset bound to the source
+	_, err = updater.CommitTransaction(context.Background(), "source", "tx", DeletionGuard{}, Quota{MaxAssets: 4})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.False(t, stager.committed)
+
+	_, err = updater.CommitTransaction(context.Background(), "source", "tx", DeletionGuard{}, Quota{MaxAssets: 5})
+	require.NoError(t, err)
+	assert.True(t, stager.committed)
+
+	// A source exceeding its quota can still shrink its graph
+	stager.stats = CommitStats{AssetsBefore: 10, AssetsAfter: 9}
+	_, err = updater.CommitTransaction(context.Background(), "source", "tx", DeletionGuard{}, Quota{MaxAssets: 5})
+	require.NoError(t, err)
+}
+
+type countingSchemaPersistor struct {
+	mockSchemaPersistor
+	loads int
+}
+
+func (m *countingSchemaPersistor) LoadSchema(ctx context.Context, sourceName string) (schema.SchemaGraph, error) {
+	m.loads++
+	return m.mockSchemaPersistor.LoadSchema(ctx, sourceName)
+}
+
+func (m *countingSchemaPersistor) SaveSchema(ctx context.Context, sourceName string, sg schema.SchemaGraph) error {
+	m.sg = sg
+	return m.mockSchemaPersistor.SaveSchema(ctx, sourceName, sg)
+}
+
+func TestShouldCacheSchemaUntilItIsUpdated(t *testing.T) {
+	persistor := &countingSchemaPersistor{mockSchemaPersistor: mockSchemaPersistor{sg: schema.NewSchemaGraph()}}
+	updater := NewGraphUpdater(&mockGraphDB{}, persistor, nil)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, updater.InsertAssets(context.Background(), "source", "", []Asset{NewAsset("ip", "not-an-ip")}, Quota{}))
+	}
+	assert.Equal(t, 1, persistor.loads)
+
+	// The new validators apply to the next insertions
+	require.NoError(t, updater.UpdateSchema(context.Background(), "source", newValidatedSchema()))
+	err := updater.InsertAssets(context.Background(), "source", "", []Asset{NewAsset("ip", "not-an-ip")}, Quota{})
+	assert.ErrorIs(t, err, schema.ErrAssetValidation)
+}
@@ -3,6 +3,7 @@ package knowledge
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/clems4ever/go-graphkb/internal/schema"
 )
@@ -20,12 +21,23 @@ type GraphDB interface {
 	InitializeSchema() error
 
 	ReadGraph(ctx context.Context, sourceName string, encoder *GraphEncoder) error
-
-	// Atomic operations on the graph
-	InsertAssets(ctx context.Context, sourceName string, assets []Asset) error
-	InsertRelations(ctx context.Context, sourceName string, relations []Relation) error
-	RemoveAssets(ctx context.Context, sourceName string, assets []Asset) error
-	RemoveRelations(ctx context.Context, sourceName string, relations []Relation) error
+	// ReadGraphBuckets read the assets and relations of the source subgraph whose IDs fall in the given buckets
+	ReadGraphBuckets(ctx context.Context, sourceName string, buckets int, assetBuckets, relationBuckets []int, encoder *GraphEncoder) error
+
+	// GetRevision return the current revision of the graph of the source
+	GetRevision(ctx context.Context, sourceName string) (int64, error)
+	// ReadChanges return the current revision and the changes made to the graph of the source since the given revision
+	ReadChanges(ctx context.Context, sourceName string, since int64) (int64, []GraphChange, error)
+	// PruneChanges remove the changes recorded before the given time
+	PruneChanges(ctx context.Context, before time.Time) (int64, error)
+	// GetChecksums compute the checksums of the graph of the source split in buckets
+	GetChecksums(ctx context.Context, sourceName string, buckets int) (GraphChecksums, error)
+
+	// Atomic operations on the graph, they return the revision they produced or 0 when there was nothing to apply
+	InsertAssets(ctx context.Context, sourceName string, assets []Asset) (int64, error)
+	InsertRelations(ctx context.Context, sourceName string, relations []Relation) (int64, error)
+	RemoveAssets(ctx context.Context, sourceName string, assets []Asset) (int64, error)
+	RemoveRelations(ctx context.Context, sourceName string, relations []Relation) (int64, error)
 
 	GetAssetSources(ctx context.Context, ids []string) (map[string][]string, error)
 	GetRelationSources(ctx context.Context, ids []string) (map[string][]string, error)
@@ -36,9 +48,29 @@ type GraphDB interface {
 	CountAssetsBySource(ctx context.Context) (map[string]int64, error)
 	CountRelations(ctx context.Context) (int64, error)
 	CountRelationsBySource(ctx context.Context) (map[string]int64, error)
+	// CountSourceGraph count the assets and relations of the graph of the source
+	CountSourceGraph(ctx context.Context, sourceName string) (int64, int64, error)
+	// AddRunRemovals lock the removals of the run of the source, call check with the removals of the run including
+	// the given number of assets and relations and record them if check succeeds. The graph of the source is counted
+	// when the run starts removing. The run is forgotten after ttl without removals.
+	AddRunRemovals(ctx context.Context, sourceName, run string, removed int64, ttl time.Duration, check func(RunRemovals) error) error
+	// RecordRunRevision record a revision produced by the run of the source, the run is forgotten after ttl without
+	// updates
+	RecordRunRevision(ctx context.Context, sourceName, run string, revision int64, ttl time.Duration) error
+	// CompleteRun forget the run of the source and return the last revision it produced, 0 if it produced none
+	CompleteRun(ctx context.Context, sourceName, run string) (int64, error)
+	// ExpireRuns forget the runs which have not updated anything for a while
+	ExpireRuns(ctx context.Context) (int64, error)
+	// CountBoundAssets count the assets among the given IDs which are already bound to the source
+	CountBoundAssets(ctx context.Context, sourceName string, ids []uint64) (int64, error)
+	// CountBoundRelations count the relations among the given IDs which are already bound to the source
+	CountBoundRelations(ctx context.Context, sourceName string, ids []uint64) (int64, error)
 
 	Query(ctx context.Context, query SQLTranslation) (*GraphQueryResult, error)
 
+	// FindConstraintViolations find at most limit assets violating the relation constraint in the whole graph
+	FindConstraintViolations(ctx context.Context, constraint schema.RelationConstraint, limit int) ([]ConstraintViolation, error)
+
 	// Collect some metrics about the database
 	CollectMetrics(ctx context.Context) (map[string]int, error)
 }
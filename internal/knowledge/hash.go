@@ -0,0 +1,48 @@
+package knowledge
+
+import (
+	"hash/fnv"
+	"io"
+)
+
+var zeroBytes = []byte{0}
+
+func writeAsset(w io.Writer, asset Asset) error {
+	_, err := w.Write([]byte(asset.Type))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(zeroBytes)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(asset.Key))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// HashAsset compute the ID of the asset
+func HashAsset(asset Asset) uint64 {
+	h := fnv.New64()
+	writeAsset(h, asset)
+	return h.Sum64()
+}
+
+// HashRelation compute the ID of the relation
+func HashRelation(relation Relation) uint64 {
+	h := fnv.New64()
+
+	rel := []byte(relation.Type)
+
+	writeAsset(h, Asset(relation.From))
+
+	h.Write(zeroBytes)
+	h.Write(rel)
+	h.Write(zeroBytes)
+
+	writeAsset(h, Asset(relation.To))
+
+	return h.Sum64()
+}
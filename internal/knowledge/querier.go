@@ -16,6 +16,9 @@ import (
 type Querier struct {
 	GraphDB    GraphDB
 	historizer history.Historizer
+
+	// Options are the options used to translate the queries into SQL
+	Options TranslationOptions
 }
 
 type QuerierResult struct {
@@ -60,9 +63,9 @@ func (q *Querier) queryInternal(ctx context.Context, cypherQuery string) (*Queri
 	if err != nil {
 		return nil, "", err
 	}
-	user := kbcontext.XForwardedUser(ctx)
+	user := kbcontext.User(ctx)
 
-	translation, err := NewSQLQueryTranslator().Translate(queryCypher)
+	translation, err := NewSQLQueryTranslatorWithOptions(q.Options).Translate(queryCypher)
 	if err != nil {
 		metrics.GraphQueryStatusCounter.With(prometheus.Labels{
 			"status": metrics.TRANSLATION_ERROR,
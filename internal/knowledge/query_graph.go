@@ -93,6 +93,9 @@ type QueryGraph struct {
 	Relations []QueryRelation
 
 	VariablesIndex map[string]TypeAndIndex
+
+	// options of the translation this query graph is built for
+	options *TranslationOptions
 }
 
 // NewQueryGraph create an instance of a query graph
@@ -121,6 +124,7 @@ func (qg *QueryGraph) Clone() *QueryGraph {
 		Nodes:          nodesCopy,
 		Relations:      relationsCopy,
 		VariablesIndex: variableIndexCopy,
+		options:        qg.options,
 	}
 
 	return &queryGraphClone
@@ -5,11 +5,38 @@ import (
 	"strings"
 
 	"github.com/clems4ever/go-graphkb/internal/query"
+	"github.com/clems4ever/go-graphkb/internal/schema"
+	"github.com/clems4ever/go-graphkb/internal/sources"
+)
+
+// TranslationOptions are options altering the SQL produced by the translator
+type TranslationOptions struct {
+	// Ontology is used to expand the labels of the nodes with the aliases and subtypes of the asset types
+	Ontology *schema.Ontology
+	// ResolveEntities makes the queries match the canonical assets only and follow the relations of their aliases
+	ResolveEntities bool
+	// AllowedSources makes the queries match only the assets and relations bound to at least one of these sources.
+	// The queries are not restricted when it is nil.
+	AllowedSources []string
+}
+
+const (
+	// canonicalAssetsTable is the derived table of the assets which are not aliases of another asset
+	canonicalAssetsTable = "(SELECT * FROM assets WHERE id NOT IN (SELECT alias_id FROM asset_same_as))"
+	// canonicalRelationsSelect selects the relations whose ends are replaced by the canonical assets
+	canonicalRelationsSelect = "SELECT r.id, COALESCE(sf.canonical_id, r.from_id) AS from_id, " +
+		"COALESCE(st.canonical_id, r.to_id) AS to_id, r.type FROM relations r " +
+		"LEFT JOIN asset_same_as sf ON sf.alias_id = r.from_id " +
+		"LEFT JOIN asset_same_as st ON st.alias_id = r.to_id"
+	// canonicalRelationsTable is the derived table of the relations whose ends are replaced by the canonical assets
+	canonicalRelationsTable = "(" + canonicalRelationsSelect + ")"
 )
 
 // SQLQueryTranslator represent an SQL translator object converting cypher queries into SQL
 type SQLQueryTranslator struct {
 	QueryGraph QueryGraph
+
+	Options TranslationOptions
 }
 
 // NewSQLQueryTranslator create an instance of SQL query translator
@@ -17,6 +44,93 @@ func NewSQLQueryTranslator() *SQLQueryTranslator {
 	return &SQLQueryTranslator{QueryGraph: NewQueryGraph()}
 }
 
+// NewSQLQueryTranslatorWithOptions create an instance of SQL query translator with translation options
+func NewSQLQueryTranslatorWithOptions(options TranslationOptions) *SQLQueryTranslator {
+	return &SQLQueryTranslator{QueryGraph: NewQueryGraph(), Options: options}
+}
+
+// sourceBindingCondition build the SQL condition matching the IDs bound to at least one of the sources in the
+// binding table. The names of the sources are checked by Translate, an invalid name never matches.
+func sourceBindingCondition(column, bindingTable, bindingColumn string, allowedSources []string) string {
+	quotedSources := make([]string, 0, len(allowedSources))
+	for _, s := range allowedSources {
+		if sources.CheckName(s) != nil {
+			continue
+		}
+		quotedSources = append(quotedSources, fmt.Sprintf("'%s'", s))
+	}
+	if len(quotedSources) == 0 {
+		return "1 = 0"
+	}
+	return fmt.Sprintf("%s IN (SELECT b.%s FROM %s b JOIN sources s ON s.id = b.source_id WHERE s.name IN (%s))",
+		column, bindingColumn, bindingTable, strings.Join(quotedSources, ", "))
+}
+
+// assetsTable return the table the assets are read from
+func assetsTable(queryGraph *QueryGraph) string {
+	if queryGraph.options == nil {
+		return "assets"
+	}
+	if queryGraph.options.AllowedSources == nil {
+		if queryGraph.options.ResolveEntities {
+			return canonicalAssetsTable
+		}
+		return "assets"
+	}
+
+	// The restriction is part of the derived table so that counts and aggregations only see the allowed assets
+	condition := sourceBindingCondition("id", "assets_by_source", "asset_id", queryGraph.options.AllowedSources)
+	if queryGraph.options.ResolveEntities {
+		condition = "id NOT IN (SELECT alias_id FROM asset_same_as) AND " + condition
+	}
+	return fmt.Sprintf("(SELECT * FROM assets WHERE %s)", condition)
+}
+
+// relationsTable return the table the relations are read from
+func relationsTable(queryGraph *QueryGraph) string {
+	if queryGraph.options == nil {
+		return "relations"
+	}
+	if queryGraph.options.AllowedSources == nil {
+		if queryGraph.options.ResolveEntities {
+			return canonicalRelationsTable
+		}
+		return "relations"
+	}
+
+	if queryGraph.options.ResolveEntities {
+		condition := sourceBindingCondition("r.id", "relations_by_source", "relation_id", queryGraph.options.AllowedSources)
+		return fmt.Sprintf("(%s WHERE %s)", canonicalRelationsSelect, condition)
+	}
+	condition := sourceBindingCondition("id", "relations_by_source", "relation_id", queryGraph.options.AllowedSources)
+	return fmt.Sprintf("(SELECT * FROM relations WHERE %s)", condition)
+}
+
+// quoteString quote a string to be used as a literal in the SQL query. Backslashes are escaped too since MariaDB
+// treats them as escape characters in string literals.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", "''") + "'"
+}
+
+// assetTypeCondition build the SQL condition matching the assets having the type of the label or, when an
+// ontology is provided, one of its aliases or subtypes.
+func assetTypeCondition(queryGraph *QueryGraph, alias, label string) string {
+	if queryGraph.options == nil || queryGraph.options.Ontology == nil {
+		return fmt.Sprintf("%s.type = %s", alias, quoteString(label))
+	}
+
+	types := queryGraph.options.Ontology.Expand(schema.AssetType(label))
+	if len(types) == 1 {
+		return fmt.Sprintf("%s.type = %s", alias, quoteString(label))
+	}
+
+	quotedTypes := make([]string, 0, len(types))
+	for _, t := range types {
+		quotedTypes = append(quotedTypes, quoteString(string(t)))
+	}
+	return fmt.Sprintf("%s.type IN (%s)", alias, strings.Join(quotedTypes, ", "))
+}
+
 // Projection represent the type and alias of one item in the RETURN statement (called a projection).
 type Projection struct {
 	Alias          string
@@ -149,24 +263,24 @@ func buildSQLConstraintsFromPatterns(queryGraph *QueryGraph, constrainedNodes ma
 		// Scan the assets table again for this particular node.
 		if !relationToAssetExists {
 			if len(n.Labels) == 0 {
-				from = append(from, SQLFrom{Value: "assets", Alias: alias})
+				from = append(from, SQLFrom{Value: assetsTable(queryGraph), Alias: alias})
 			}
 
 			for j, label := range n.Labels {
 				subAlias := fmt.Sprintf("%s_%d", alias, j)
-				from = append(from, SQLFrom{Value: "assets", Alias: subAlias})
+				from = append(from, SQLFrom{Value: assetsTable(queryGraph), Alias: subAlias})
 
 				if scope.Context == WhereContext {
 					joins = append(joins, SQLJoin{
-						Table: "assets",
+						Table: assetsTable(queryGraph),
 						Alias: alias,
-						On:    fmt.Sprintf("%s.type = '%s' AND %s.id = %s.id", alias, label, alias, strings.ReplaceAll(alias, "w", "")),
+						On:    fmt.Sprintf("%s AND %s.id = %s.id", assetTypeCondition(queryGraph, alias, label), alias, strings.ReplaceAll(alias, "w", "")),
 					})
 				} else {
 					joins = append(joins, SQLJoin{
-						Table: "assets",
+						Table: assetsTable(queryGraph),
 						Alias: alias,
-						On:    fmt.Sprintf("%s.type = '%s' AND %s.id = %s.id", alias, label, alias, subAlias),
+						On:    fmt.Sprintf("%s AND %s.id = %s.id", assetTypeCondition(queryGraph, alias, label), alias, subAlias),
 					})
 				}
 
@@ -177,7 +291,7 @@ func buildSQLConstraintsFromPatterns(queryGraph *QueryGraph, constrainedNodes ma
 
 			if len(n.Labels) > 0 {
 				for _, label := range n.Labels {
-					exp = append(exp, fmt.Sprintf("%s.type = '%s'", alias, label))
+					exp = append(exp, assetTypeCondition(queryGraph, alias, label))
 				}
 			}
 
@@ -201,7 +315,7 @@ func buildSQLConstraintsFromPatterns(queryGraph *QueryGraph, constrainedNodes ma
 				}
 			}
 			joins = append(joins, SQLJoin{
-				Table: "assets",
+				Table: assetsTable(queryGraph),
 				Alias: alias,
 				On:    strings.Join(exp, " AND "),
 				Index: "PRIMARY",
@@ -243,7 +357,7 @@ func buildSQLConstraintsFromPatterns(queryGraph *QueryGraph, constrainedNodes ma
 
 			if len(relation.Labels) > 0 {
 				for _, label := range relation.Labels {
-					exps = append(exps, fmt.Sprintf("%s.type = '%s'", ralias, label))
+					exps = append(exps, fmt.Sprintf("%s.type = %s", ralias, quoteString(label)))
 				}
 			}
 
@@ -297,7 +411,7 @@ func buildSQLConstraintsFromPatterns(queryGraph *QueryGraph, constrainedNodes ma
 			relationSet[relation] = ralias
 
 			joins = append(joins, SQLJoin{
-				Table: "relations",
+				Table: relationsTable(queryGraph),
 				Alias: ralias,
 				On:    strings.Join(exps, " AND "),
 				Index: index,
@@ -316,6 +430,13 @@ func buildSQLConstraintsFromPatterns(queryGraph *QueryGraph, constrainedNodes ma
 
 // Translate a Cypher query into a SQL model
 func (sqt *SQLQueryTranslator) Translate(query *query.QueryCypher) (*SQLTranslation, error) {
+	for _, s := range sqt.Options.AllowedSources {
+		if err := sources.CheckName(s); err != nil {
+			return nil, fmt.Errorf("Unable to restrict the query to the allowed sources: %w", err)
+		}
+	}
+
+	sqt.QueryGraph.options = &sqt.Options
 	constrainedNodes := make(map[int]bool)
 
 	whereExpressions := AndOrExpression{And: true}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/clems4ever/go-graphkb/internal/query"
+	"github.com/clems4ever/go-graphkb/internal/schema"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -643,3 +644,85 @@ func TestUnwindOrExpressions(t *testing.T) {
 		assert.Equal(t, And(And(exprC), And(exprE)), unwoundExpr[3])
 	})
 }
+
+func TestShouldExpandTypeConditionWithOntology(t *testing.T) {
+	queryGraph := NewQueryGraph()
+	assert.Equal(t, "a0.type = 'device'", assetTypeCondition(&queryGraph, "a0", "device"))
+
+	ontology := schema.NewOntology(
+		schema.OntologyEntry{Type: "host", Kind: schema.AliasOntologyRelation, Parent: "device"},
+		schema.OntologyEntry{Type: "server", Kind: schema.SubtypeOntologyRelation, Parent: "device"},
+	)
+	queryGraph.options = &TranslationOptions{Ontology: &ontology}
+
+	assert.Equal(t, "a0.type IN ('device', 'host', 'server')", assetTypeCondition(&queryGraph, "a0", "device"))
+	assert.Equal(t, "a0.type = 'server'", assetTypeCondition(&queryGraph, "a0", "server"))
+	assert.Equal(t, "a0.type = 'ip'", assetTypeCondition(&queryGraph, "a0", "ip"))
+}
+
+func TestShouldEscapeTypesInTypeCondition(t *testing.T) {
+	queryGraph := NewQueryGraph()
+	assert.Equal(t, `a0.type = 'o''reilly\\'`, assetTypeCondition(&queryGraph, "a0", `o'reilly\`))
+
+	ontology := schema.NewOntology(
+		schema.OntologyEntry{Type: "o'host", Kind: schema.AliasOntologyRelation, Parent: "device"},
+	)
+	queryGraph.options = &TranslationOptions{Ontology: &ontology}
+	assert.Equal(t, "a0.type = 'o''reilly'", assetTypeCondition(&queryGraph, "a0", "o'reilly"))
+	assert.Equal(t, "a0.type IN ('device', 'o''host')", assetTypeCondition(&queryGraph, "a0", "device"))
+}
+
+func TestShouldReadCanonicalTablesWhenResolvingEntities(t *testing.T) {
+	queryGraph := NewQueryGraph()
+	assert.Equal(t, "assets", assetsTable(&queryGraph))
+	assert.Equal(t, "relations", relationsTable(&queryGraph))
+
+	queryGraph.options = &TranslationOptions{ResolveEntities: true}
+	assert.Equal(t, canonicalAssetsTable, assetsTable(&queryGraph))
+	assert.Equal(t, canonicalRelationsTable, relationsTable(&queryGraph))
+}
+
+func TestShouldRestrictTablesToAllowedSources(t *testing.T) {
+	queryGraph := NewQueryGraph()
+	queryGraph.options = &TranslationOptions{AllowedSources: []string{"scanner", "ldap"}}
+
+	assert.Equal(t, "(SELECT * FROM assets WHERE id IN (SELECT b.asset_id FROM assets_by_source b "+
+		"JOIN sources s ON s.id = b.source_id WHERE s.name IN ('scanner', 'ldap')))", assetsTable(&queryGraph))
+	assert.Equal(t, "(SELECT * FROM relations WHERE id IN (SELECT b.relation_id FROM relations_by_source b "+
+		"JOIN sources s ON s.id = b.source_id WHERE s.name IN ('scanner', 'ldap')))", relationsTable(&queryGraph))
+
+	queryGraph.options.ResolveEntities = true
+	assert.Equal(t, "(SELECT * FROM assets WHERE id NOT IN (SELECT alias_id FROM asset_same_as) AND "+
+		"id IN (SELECT b.asset_id FROM assets_by_source b JOIN sources s ON s.id = b.source_id "+
+		"WHERE s.name IN ('scanner', 'ldap')))", assetsTable(&queryGraph))
+	assert.Equal(t, "("+canonicalRelationsSelect+" WHERE r.id IN (SELECT b.relation_id FROM relations_by_source b "+
+		"JOIN sources s ON s.id = b.source_id WHERE s.name IN ('scanner', 'ldap')))", relationsTable(&queryGraph))
+
+	queryGraph.options = &TranslationOptions{AllowedSources: []string{}}
+	assert.Equal(t, "(SELECT * FROM assets WHERE 1 = 0)", assetsTable(&queryGraph))
+
+	// The invalid names are never put in the query
+	queryGraph.options = &TranslationOptions{AllowedSources: []string{"o'reilly"}}
+	assert.Equal(t, "(SELECT * FROM assets WHERE 1 = 0)", assetsTable(&queryGraph))
+}
+
+func TestShouldRefuseInvalidAllowedSources(t *testing.T) {
+	translator := NewSQLQueryTranslatorWithOptions(TranslationOptions{AllowedSources: []string{"scanner", "x') OR ('1' = '1"}})
+	_, err := translator.Translate(&query.QueryCypher{})
+	assert.Error(t, err)
+}
+
+func TestShouldAggregateOnlyAllowedSources(t *testing.T) {
+	translator := NewSQLQueryTranslatorWithOptions(TranslationOptions{AllowedSources: []string{"scanner"}})
+	q, err := query.TransformCypher("MATCH (v:variable)-[r]-(n:name) RETURN v.value, COUNT(n.value)")
+	require.NoError(t, err)
+
+	sql, err := translator.Translate(q)
+	require.NoError(t, err)
+
+	// The assets and relations are counted in the tables restricted to the allowed sources
+	assert.Contains(t, sql.Query, "COUNT(a1.value)")
+	assert.Contains(t, sql.Query, "JOIN "+assetsTable(&translator.QueryGraph)+" a1 ")
+	assert.Contains(t, sql.Query, "JOIN "+relationsTable(&translator.QueryGraph)+" r0 ")
+	assert.NotRegexp(t, `JOIN (assets|relations) `, sql.Query)
+}
@@ -0,0 +1,33 @@
+package knowledge
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is returned when an update is refused because the graph of the source would exceed its quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the number of assets and relations bound to a source so that a single source cannot fill the database.
+type Quota struct {
+	// MaxAssets is the maximum number of assets bound to the source. 0 disables the check.
+	MaxAssets int64 `mapstructure:"max_assets" json:"max_assets,omitempty"`
+	// MaxRelations is the maximum number of relations bound to the source. 0 disables the check.
+	MaxRelations int64 `mapstructure:"max_relations" json:"max_relations,omitempty"`
+}
+
+// Enabled return true if at least one of the limits is set
+func (q Quota) Enabled() bool {
+	return q.MaxAssets > 0 || q.MaxRelations > 0
+}
+
+// Check verifies a graph of the given number of assets and relations fits in the quota
+func (q Quota) Check(assets, relations int64) error {
+	if q.MaxAssets > 0 && assets > q.MaxAssets {
+		return fmt.Errorf("%w: the graph would contain %d assets while the limit is %d", ErrQuotaExceeded, assets, q.MaxAssets)
+	}
+	if q.MaxRelations > 0 && relations > q.MaxRelations {
+		return fmt.Errorf("%w: the graph would contain %d relations while the limit is %d", ErrQuotaExceeded, relations, q.MaxRelations)
+	}
+	return nil
+}
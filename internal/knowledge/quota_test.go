@@ -0,0 +1,21 @@
+package knowledge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldAllowAnyGraphWhenQuotaIsDisabled(t *testing.T) {
+	quota := Quota{}
+	assert.False(t, quota.Enabled())
+	assert.NoError(t, quota.Check(1000000, 1000000))
+}
+
+func TestShouldRefuseGraphExceedingQuota(t *testing.T) {
+	quota := Quota{MaxAssets: 10, MaxRelations: 20}
+	assert.True(t, quota.Enabled())
+	assert.NoError(t, quota.Check(10, 20))
+	assert.ErrorIs(t, quota.Check(11, 0), ErrQuotaExceeded)
+	assert.ErrorIs(t, quota.Check(0, 21), ErrQuotaExceeded)
+}
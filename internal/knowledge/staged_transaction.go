@@ -0,0 +1,73 @@
+package knowledge
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/schema"
+)
+
+// ErrTransactionNotFound is returned when a staged transaction does not exist, has expired or belongs to another source
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// StagedOperation is the kind of update staged in a transaction
+type StagedOperation string
+
+const (
+	// InsertAssetsOperation inserts assets in the graph of the source
+	InsertAssetsOperation StagedOperation = "insert_assets"
+	// InsertRelationsOperation inserts relations in the graph of the source
+	InsertRelationsOperation StagedOperation = "insert_relations"
+	// RemoveAssetsOperation removes assets from the graph of the source
+	RemoveAssetsOperation StagedOperation = "remove_assets"
+	// RemoveRelationsOperation removes relations from the graph of the source
+	RemoveRelationsOperation StagedOperation = "remove_relations"
+)
+
+// StagedBatch is a batch of the operations staged in a transaction. Only the assets or the relations are set
+// depending on the operation.
+type StagedBatch struct {
+	Operation StagedOperation
+	Assets    []Asset
+	Relations []Relation
+}
+
+// CommitStats are the sizes of the graph of the source before and after the staged changes are applied
+type CommitStats struct {
+	AssetsBefore    int64
+	RelationsBefore int64
+	AssetsAfter     int64
+	RelationsAfter  int64
+}
+
+// CommitHooks let the caller of a commit amend or veto the staged changes. The staged operations are streamed in
+// batches so that a large transaction is never held in memory and all the changes are rolled back when a hook fails.
+type CommitHooks struct {
+	// PrepareSchema is called with the staged schema, nil when no schema has been staged, and returns the schema to
+	// save or nil when it does not need to be saved
+	PrepareSchema func(sg *schema.SchemaGraph) (*schema.SchemaGraph, error)
+	// CheckBatch is called with each batch of staged operations before it is applied
+	CheckBatch func(batch StagedBatch) error
+	// Check is called once all the batches have been applied, before the database transaction is committed
+	Check func(stats CommitStats) error
+}
+
+// TransactionStager stores the updates of a source until they are committed atomically or discarded
+type TransactionStager interface {
+	// BeginTransaction open a transaction for the source which expires after ttl without activity
+	BeginTransaction(ctx context.Context, source string, ttl time.Duration) (string, error)
+
+	StageSchema(ctx context.Context, source, id string, sg schema.SchemaGraph) error
+	StageAssets(ctx context.Context, source, id string, operation StagedOperation, assets []Asset) error
+	StageRelations(ctx context.Context, source, id string, operation StagedOperation, relations []Relation) error
+
+	// CommitTransaction apply all the staged changes in one database transaction. The inserted assets, the inserted
+	// relations, the removed relations and the removed assets are applied in this order, each by batches. The
+	// revision produced by the commit is returned.
+	CommitTransaction(ctx context.Context, source, id string, hooks CommitHooks) (int64, error)
+	AbortTransaction(ctx context.Context, source, id string) error
+
+	// ExpireTransactions discard the transactions which have expired and return how many were discarded
+	ExpireTransactions(ctx context.Context) (int64, error)
+}
@@ -29,6 +29,12 @@ var GraphQueryStatusCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "The number of queries executed by status: (ERROR|SUCCESS)",
 }, []string{"status", "user"})
 
+// GraphQueryRateLimitedCounter reports the number of queries refused because the user exceeded its rate limit
+var GraphQueryRateLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "go_graphkb_query_rate_limited_counter",
+	Help: "The number of queries refused because the user or the source exceeded its rate limit",
+}, []string{"user"})
+
 // ********************* GRAPH METRICS ******************
 
 // GraphAssetsTotalGauge reports the number of nodes in the graph of a given source
@@ -69,6 +75,12 @@ var GraphUpdateRequestsRateLimitedCounter = promauto.NewCounterVec(prometheus.Co
 	Help: "The number of graph updates which were rate limited",
 }, []string{"source", "operation"})
 
+// GraphUpdateRequestsSourceRateLimitedCounter reports the number of updates requests refused because the source exceeded its rate limit
+var GraphUpdateRequestsSourceRateLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "go_graphkb_graph_update_requests_source_rate_limited_counter",
+	Help: "The number of graph updates which were refused because the source exceeded its rate limit",
+}, []string{"source", "operation"})
+
 // GraphUpdateRequestsUnauthorizedCounter reports the number of unauthorized updates requests received by the webserver
 var GraphUpdateRequestsUnauthorizedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "go_graphkb_graph_update_requests_unauthorized_counter",
@@ -119,6 +131,30 @@ var GraphUpdateRelationsDeletedCounter = promauto.NewCounterVec(prometheus.Count
 	Help: "The number of relations deleted since the start of the process",
 }, []string{"source"})
 
+// GraphUpdateTransactionsCommittedCounter reports the number of staged transactions committed since the start of the process
+var GraphUpdateTransactionsCommittedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "go_graphkb_graph_update_transactions_committed_counter",
+	Help: "The number of staged transactions committed since the start of the process",
+}, []string{"source"})
+
+// GraphUpdateTransactionsExpiredCounter reports the number of staged transactions discarded because they expired
+var GraphUpdateTransactionsExpiredCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "go_graphkb_graph_update_transactions_expired_counter",
+	Help: "The number of staged transactions discarded because they were abandoned",
+})
+
+// GraphUpdateDeletionGuardTriggeredCounter reports the number of updates refused by the deletion guard
+var GraphUpdateDeletionGuardTriggeredCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "go_graphkb_graph_update_deletion_guard_triggered_counter",
+	Help: "The number of updates refused because they would remove too many assets and relations",
+}, []string{"source"})
+
+// GraphUpdateQuotaExceededCounter reports the number of updates refused because the graph of the source would exceed its quota
+var GraphUpdateQuotaExceededCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "go_graphkb_graph_update_quota_exceeded_counter",
+	Help: "The number of updates refused because the graph of the source would exceed its quota",
+}, []string{"source"})
+
 // ********************* SOURCES ******************
 
 // LastSuccessfulDatasourceUpdateTimestampGauge reports the timestamp of the last successful update operation for a given source
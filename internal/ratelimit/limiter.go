@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// bucketIdleTimeout is the minimum time after which the bucket of an inactive client is dropped
+	bucketIdleTimeout = 10 * time.Minute
+	// pruneInterval is the minimum time between two scans for idle buckets
+	pruneInterval = time.Minute
+	// maxBuckets is the number of buckets above which the least recently used ones are dropped even if they are not
+	// idle, so that the clients cannot grow the memory of the limiter without bound
+	maxBuckets = 10000
+)
+
+// Limit is the rate of requests a client is allowed to send
+type Limit struct {
+	// Rate is the number of requests per second, 0 disables the limit
+	Rate float64 `mapstructure:"rate"`
+	// Burst is the number of requests which can be sent at once, it defaults to the rate rounded up
+	Burst int `mapstructure:"burst"`
+}
+
+// Enabled return true if the limit is set
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// Configuration is the default limit of the clients and its overrides for some sources and users. The names of the
+// sources and users are lowercased by the configuration loader.
+type Configuration struct {
+	Limit   `mapstructure:",squash"`
+	Sources map[string]Limit `mapstructure:"sources"`
+	Users   map[string]Limit `mapstructure:"users"`
+}
+
+// KeyKind is the kind of client a bucket is kept for
+type KeyKind string
+
+const (
+	// KeySource is the kind of the keys of the sources
+	KeySource KeyKind = "source"
+	// KeyUser is the kind of the keys of the users
+	KeyUser KeyKind = "user"
+	// KeyAddress is the kind of the keys of the anonymous clients identified by their address
+	KeyAddress KeyKind = "address"
+)
+
+// Key identifies a client
+type Key struct {
+	Kind KeyKind
+	Name string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps a token bucket per client. A request takes a token from the bucket of its client and the buckets are
+// refilled at the rate of the limit of the client.
+type Limiter struct {
+	config Configuration
+
+	mutex     sync.Mutex
+	buckets   map[Key]*bucket
+	lastPrune time.Time
+
+	now func() time.Time
+}
+
+// NewLimiter create a limiter applying the configuration
+func NewLimiter(config Configuration) *Limiter {
+	return &Limiter{config: config, buckets: make(map[Key]*bucket), now: time.Now}
+}
+
+// LimitOf return the limit applying to the client
+func (l *Limiter) LimitOf(key Key) Limit {
+	var overrides map[string]Limit
+	switch key.Kind {
+	case KeySource:
+		overrides = l.config.Sources
+	case KeyUser:
+		overrides = l.config.Users
+	}
+	if limit, ok := overrides[strings.ToLower(key.Name)]; ok {
+		return limit
+	}
+	return l.config.Limit
+}
+
+// Allow take a token from the bucket of the client. It returns false along with the delay after which a token is
+// available when the bucket is empty. Every request is allowed when the limiter is nil or the client is not limited.
+// The names are case insensitive like the overrides of the configuration.
+func (l *Limiter) Allow(key Key) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	key.Name = strings.ToLower(key.Name)
+	limit := l.LimitOf(key)
+	if !limit.Enabled() {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if now.Sub(l.lastPrune) >= pruneInterval || len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: limit.burst(), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// idleTimeout return the time after which the bucket of an inactive client is dropped. It is never shorter than the
+// time the bucket takes to be refilled so that dropping it does not give tokens back to the client.
+func (l Limit) idleTimeout() time.Duration {
+	refill := time.Duration(l.burst() / l.Rate * float64(time.Second))
+	if refill > bucketIdleTimeout {
+		return refill
+	}
+	return bucketIdleTimeout
+}
+
+// prune drop the buckets of the clients which have been inactive for long enough and then the least recently used
+// buckets if there are still too many of them, the lock must be held
+func (l *Limiter) prune(now time.Time) {
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.LimitOf(key).idleTimeout() {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) < maxBuckets {
+		return
+	}
+
+	// Drop a tenth of the buckets at once so that the keys are not sorted again on every new client
+	keys := make([]Key, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return l.buckets[keys[i]].last.Before(l.buckets[keys[j]].last) })
+	for _, key := range keys[:len(keys)-maxBuckets+maxBuckets/10] {
+		delete(l.buckets, key)
+	}
+}
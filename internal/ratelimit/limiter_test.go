@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldLimitRateOfEachClient(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(Configuration{Limit: Limit{Rate: 2, Burst: 3}})
+	limiter.now = func() time.Time { return now }
+
+	scanner := Key{Kind: KeySource, Name: "scanner"}
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow(scanner)
+		assert.True(t, ok)
+	}
+	ok, retryAfter := limiter.Allow(scanner)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// The other clients have their own bucket
+	ok, _ = limiter.Allow(Key{Kind: KeySource, Name: "crawler"})
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = limiter.Allow(scanner)
+	assert.True(t, ok)
+	ok, _ = limiter.Allow(scanner)
+	assert.False(t, ok)
+
+	// The bucket is not filled above the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow(scanner)
+		assert.True(t, ok)
+	}
+	ok, _ = limiter.Allow(scanner)
+	assert.False(t, ok)
+}
+
+func TestShouldApplyOverridesOfSourcesAndUsers(t *testing.T) {
+	limiter := NewLimiter(Configuration{
+		Limit:   Limit{Rate: 1},
+		Sources: map[string]Limit{"noisy": {Rate: 0.5, Burst: 1}, "trusted": {}},
+		Users:   map[string]Limit{"alice": {Rate: 10}},
+	})
+
+	assert.Equal(t, Limit{Rate: 0.5, Burst: 1}, limiter.LimitOf(Key{Kind: KeySource, Name: "Noisy"}))
+	assert.Equal(t, Limit{Rate: 1}, limiter.LimitOf(Key{Kind: KeyUser, Name: "noisy"}))
+	assert.Equal(t, Limit{Rate: 10}, limiter.LimitOf(Key{Kind: KeyUser, Name: "alice"}))
+	assert.Equal(t, Limit{Rate: 1}, limiter.LimitOf(Key{Kind: KeyAddress, Name: "10.0.0.1"}))
+
+	for i := 0; i < 100; i++ {
+		ok, _ := limiter.Allow(Key{Kind: KeySource, Name: "trusted"})
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 10.0, Limit{Rate: 10}.burst())
+	assert.Equal(t, 1.0, Limit{Rate: 0.1}.burst())
+}
+
+func TestShouldAllowEverythingWithoutLimiter(t *testing.T) {
+	var limiter *Limiter
+	ok, _ := limiter.Allow(Key{Kind: KeyUser, Name: "alice"})
+	assert.True(t, ok)
+
+	limiter = NewLimiter(Configuration{})
+	for i := 0; i < 100; i++ {
+		ok, _ := limiter.Allow(Key{Kind: KeyUser, Name: "alice"})
+		assert.True(t, ok)
+	}
+	assert.Empty(t, limiter.buckets)
+}
+
+func TestShouldShareBucketOfNamesDifferingByCase(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(Configuration{Limit: Limit{Rate: 1}, Sources: map[string]Limit{"scanner": {Rate: 1, Burst: 2}}})
+	limiter.now = func() time.Time { return now }
+
+	ok, _ := limiter.Allow(Key{Kind: KeySource, Name: "scanner"})
+	assert.True(t, ok)
+	ok, _ = limiter.Allow(Key{Kind: KeySource, Name: "Scanner"})
+	assert.True(t, ok)
+	ok, _ = limiter.Allow(Key{Kind: KeySource, Name: "SCANNER"})
+	assert.False(t, ok)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestShouldPruneIdleBuckets(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(Configuration{Limit: Limit{Rate: 1}, Users: map[string]Limit{"slow": {Rate: 0.001, Burst: 1}}})
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow(Key{Kind: KeyAddress, Name: "10.0.0.1"})
+	limiter.Allow(Key{Kind: KeyUser, Name: "slow"})
+
+	now = now.Add(bucketIdleTimeout / 2)
+	limiter.Allow(Key{Kind: KeyAddress, Name: "10.0.0.2"})
+	assert.Len(t, limiter.buckets, 3)
+
+	// The bucket of the slow user is kept until it is refilled so that it cannot get its tokens back by waiting
+	now = now.Add(bucketIdleTimeout / 2)
+	limiter.Allow(Key{Kind: KeyAddress, Name: "10.0.0.3"})
+	assert.Len(t, limiter.buckets, 3)
+	assert.NotContains(t, limiter.buckets, Key{Kind: KeyAddress, Name: "10.0.0.1"})
+
+	ok, _ := limiter.Allow(Key{Kind: KeyUser, Name: "slow"})
+	assert.False(t, ok)
+}
+
+func TestShouldCapNumberOfBuckets(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(Configuration{Limit: Limit{Rate: 1}})
+	limiter.now = func() time.Time { return now }
+
+	// The clients are all active so none of the buckets is idle
+	for i := 0; i < maxBuckets; i++ {
+		limiter.Allow(Key{Kind: KeyAddress, Name: time.Duration(i).String()})
+		now = now.Add(time.Millisecond)
+	}
+	assert.Len(t, limiter.buckets, maxBuckets)
+
+	limiter.Allow(Key{Kind: KeyAddress, Name: "new"})
+	assert.Len(t, limiter.buckets, maxBuckets-maxBuckets/10+1)
+	assert.NotContains(t, limiter.buckets, Key{Kind: KeyAddress, Name: time.Duration(0).String()})
+	assert.Contains(t, limiter.buckets, Key{Kind: KeyAddress, Name: time.Duration(maxBuckets - 1).String()})
+	assert.Contains(t, limiter.buckets, Key{Kind: KeyAddress, Name: "new"})
+}
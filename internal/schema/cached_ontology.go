@@ -0,0 +1,64 @@
+package schema
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OntologyCacheTTL is how long the ontology is kept in memory before being read again from the database
+const OntologyCacheTTL = 10 * time.Second
+
+// CachedOntologyPersistor is an ontology persistor keeping the ontology in memory for a short time so that each
+// query does not read it from the database. The cache is invalidated by the updates made through it.
+type CachedOntologyPersistor struct {
+	persistor OntologyPersistor
+	ttl       time.Duration
+
+	mutex     sync.Mutex
+	ontology  *Ontology
+	expiresAt time.Time
+
+	now func() time.Time
+}
+
+// NewCachedOntologyPersistor create a persistor caching the ontology of the underlying persistor for the given
+// duration
+func NewCachedOntologyPersistor(persistor OntologyPersistor, ttl time.Duration) *CachedOntologyPersistor {
+	return &CachedOntologyPersistor{persistor: persistor, ttl: ttl, now: time.Now}
+}
+
+// Invalidate drop the cached ontology so that it is read again on next access
+func (cp *CachedOntologyPersistor) Invalidate() {
+	cp.mutex.Lock()
+	cp.ontology = nil
+	cp.mutex.Unlock()
+}
+
+// LoadOntology load the ontology from the cache or from the underlying persistor when the cache has expired
+func (cp *CachedOntologyPersistor) LoadOntology(ctx context.Context) (Ontology, error) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if cp.ontology == nil || !cp.now().Before(cp.expiresAt) {
+		ontology, err := cp.persistor.LoadOntology(ctx)
+		if err != nil {
+			return NewOntology(), err
+		}
+		cp.ontology = &ontology
+		cp.expiresAt = cp.now().Add(cp.ttl)
+	}
+	return NewOntology(append([]OntologyEntry{}, cp.ontology.Entries...)...), nil
+}
+
+// AddOntologyEntry add an entry to the ontology
+func (cp *CachedOntologyPersistor) AddOntologyEntry(ctx context.Context, entry OntologyEntry) error {
+	defer cp.Invalidate()
+	return cp.persistor.AddOntologyEntry(ctx, entry)
+}
+
+// RemoveOntologyEntry remove an entry from the ontology
+func (cp *CachedOntologyPersistor) RemoveOntologyEntry(ctx context.Context, entry OntologyEntry) error {
+	defer cp.Invalidate()
+	return cp.persistor.RemoveOntologyEntry(ctx, entry)
+}
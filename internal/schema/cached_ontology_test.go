@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingOntologyPersistor struct {
+	entries []OntologyEntry
+	reads   int
+}
+
+func (c *countingOntologyPersistor) LoadOntology(ctx context.Context) (Ontology, error) {
+	c.reads++
+	return NewOntology(append([]OntologyEntry{}, c.entries...)...), nil
+}
+
+func (c *countingOntologyPersistor) AddOntologyEntry(ctx context.Context, entry OntologyEntry) error {
+	c.entries = append(c.entries, entry)
+	return nil
+}
+
+func (c *countingOntologyPersistor) RemoveOntologyEntry(ctx context.Context, entry OntologyEntry) error {
+	c.entries = nil
+	return nil
+}
+
+func TestShouldCacheOntologyUntilItIsUpdated(t *testing.T) {
+	persistor := &countingOntologyPersistor{}
+	cached := NewCachedOntologyPersistor(persistor, time.Minute)
+	now := time.Now()
+	cached.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ontology, err := cached.LoadOntology(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, ontology.Entries, 0)
+	}
+	assert.Equal(t, 1, persistor.reads)
+
+	entry := OntologyEntry{Type: "host", Kind: AliasOntologyRelation, Parent: "device"}
+	require.NoError(t, cached.AddOntologyEntry(context.Background(), entry))
+	ontology, err := cached.LoadOntology(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []OntologyEntry{entry}, ontology.Entries)
+	assert.Equal(t, 2, persistor.reads)
+
+	// The ontology updated by another server is read again once the cache expires
+	persistor.entries = nil
+	now = now.Add(2 * time.Minute)
+	ontology, err = cached.LoadOntology(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, ontology.Entries, 0)
+}
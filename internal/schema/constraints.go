@@ -0,0 +1,56 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/clems4ever/go-graphkb/internal/utils"
+)
+
+// ErrConstraintViolation is returned when a graph does not satisfy the constraints of its schema.
+var ErrConstraintViolation = errors.New("constraint violation")
+
+// RelationConstraint is a constraint on the relations of a given type going out of the assets of type Relation.FromType
+type RelationConstraint struct {
+	Relation RelationType `json:"relation"`
+
+	// MaxCardinality is the maximum number of such relations an asset can have. 0 means unlimited.
+	MaxCardinality int `json:"max_cardinality,omitempty"`
+	// Required tells whether every asset must have at least one such relation.
+	Required bool `json:"required,omitempty"`
+}
+
+func (c RelationConstraint) String() string {
+	rules := []string{}
+	if c.Required {
+		rules = append(rules, "required")
+	}
+	if c.MaxCardinality > 0 {
+		rules = append(rules, fmt.Sprintf("at most %d", c.MaxCardinality))
+	}
+	return fmt.Sprintf("(%s)-[%s]->(%s) %s", c.Relation.FromType, c.Relation.Type, c.Relation.ToType,
+		strings.Join(rules, ", "))
+}
+
+type ConstraintRegistry interface {
+	Get(AssetType) ([]RelationConstraint, bool)
+}
+
+var (
+	// RelationConstraintRegistry stores the relation constraints indexed by the type of the asset they apply to
+	RelationConstraintRegistry ConstraintRegistry = utils.NewRegistry[AssetType, []RelationConstraint]()
+)
+
+// AddRelationConstraint registers a constraint on a relation type. The constraints are shipped with the schema
+// extracted from the graph.
+func AddRelationConstraint(c RelationConstraint) {
+	constraints, _ := RelationConstraintRegistry.Get(c.Relation.FromType)
+	for _, existing := range constraints {
+		if existing == c {
+			return
+		}
+	}
+	constraints = append(constraints, c)
+	RelationConstraintRegistry.(*utils.Registry[AssetType, []RelationConstraint]).Set(c.Relation.FromType, constraints)
+}
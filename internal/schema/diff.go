@@ -0,0 +1,62 @@
+package schema
+
+import "sort"
+
+// SchemaDiff represent the differences between two versions of a schema graph
+type SchemaDiff struct {
+	AddedVertices   []AssetType    `json:"added_vertices"`
+	RemovedVertices []AssetType    `json:"removed_vertices"`
+	AddedEdges      []RelationType `json:"added_edges"`
+	RemovedEdges    []RelationType `json:"removed_edges"`
+}
+
+// IsEmpty return true if there is no difference
+func (sd SchemaDiff) IsEmpty() bool {
+	return len(sd.AddedVertices) == 0 && len(sd.RemovedVertices) == 0 &&
+		len(sd.AddedEdges) == 0 && len(sd.RemovedEdges) == 0
+}
+
+func sortAssetTypes(types []AssetType) {
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+}
+
+func sortRelationTypes(types []RelationType) {
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].FromType != types[j].FromType {
+			return types[i].FromType < types[j].FromType
+		}
+		if types[i].Type != types[j].Type {
+			return types[i].Type < types[j].Type
+		}
+		return types[i].ToType < types[j].ToType
+	})
+}
+
+// Diff compute the vertices and edges added and removed when going from one schema to the other
+func Diff(from, to SchemaGraph) SchemaDiff {
+	diff := SchemaDiff{
+		AddedVertices:   []AssetType{},
+		RemovedVertices: []AssetType{},
+		AddedEdges:      []RelationType{},
+		RemovedEdges:    []RelationType{},
+	}
+
+	for v := range to.Vertices.Difference(from.Vertices).Iter() {
+		diff.AddedVertices = append(diff.AddedVertices, v.(AssetType))
+	}
+	for v := range from.Vertices.Difference(to.Vertices).Iter() {
+		diff.RemovedVertices = append(diff.RemovedVertices, v.(AssetType))
+	}
+	for e := range to.Edges.Difference(from.Edges).Iter() {
+		diff.AddedEdges = append(diff.AddedEdges, e.(RelationType))
+	}
+	for e := range from.Edges.Difference(to.Edges).Iter() {
+		diff.RemovedEdges = append(diff.RemovedEdges, e.(RelationType))
+	}
+
+	sortAssetTypes(diff.AddedVertices)
+	sortAssetTypes(diff.RemovedVertices)
+	sortRelationTypes(diff.AddedEdges)
+	sortRelationTypes(diff.RemovedEdges)
+	return diff
+}
@@ -0,0 +1,28 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldDiffSchemas(t *testing.T) {
+	from := NewSchemaGraph()
+	ip := from.AddAsset("ip")
+	host := from.AddAsset("host")
+	from.AddRelation(ip, "linked", host)
+
+	to := NewSchemaGraph()
+	to.AddAsset("ip")
+	subnet := to.AddAsset("subnet")
+	to.AddRelation(ip, "belongs_to", subnet)
+
+	diff := Diff(from, to)
+	assert.Equal(t, []AssetType{subnet}, diff.AddedVertices)
+	assert.Equal(t, []AssetType{host}, diff.RemovedVertices)
+	assert.Equal(t, []RelationType{{FromType: ip, Type: "belongs_to", ToType: subnet}}, diff.AddedEdges)
+	assert.Equal(t, []RelationType{{FromType: ip, Type: "linked", ToType: host}}, diff.RemovedEdges)
+	assert.False(t, diff.IsEmpty())
+
+	assert.True(t, Diff(to, to).IsEmpty())
+}
@@ -1,26 +1,41 @@
 package schema
 
-import mapset "github.com/deckarep/golang-set"
+import (
+	"encoding/json"
+	"reflect"
 
-import "encoding/json"
+	mapset "github.com/deckarep/golang-set"
+)
 
 // SchemaGraph represent the graph of a source
 type SchemaGraph struct {
 	Vertices mapset.Set
 	Edges    mapset.Set
+
+	// Validators are the validation rules the values of each asset type must satisfy
+	Validators map[AssetType][]AssetValidationRule
+	// Constraints are the constraints the relations of the graph must satisfy
+	Constraints []RelationConstraint
+	// Ontology lists the relations between asset types. It is managed by the server and only
+	// set on the merged schema it serves.
+	Ontology []OntologyEntry
 }
 
 // SchemaGraphJSON is the json representation of a schema graph
 type SchemaGraphJSON struct {
-	Vertices []AssetType    `json:"vertices"`
-	Edges    []RelationType `json:"edges"`
+	Vertices    []AssetType                         `json:"vertices"`
+	Edges       []RelationType                      `json:"edges"`
+	Validators  map[AssetType][]AssetValidationRule `json:"validators,omitempty"`
+	Constraints []RelationConstraint                `json:"constraints,omitempty"`
+	Ontology    []OntologyEntry                     `json:"ontology,omitempty"`
 }
 
 // NewSchemaGraph create a source graph
 func NewSchemaGraph() SchemaGraph {
 	return SchemaGraph{
-		Vertices: mapset.NewSet(),
-		Edges:    mapset.NewSet(),
+		Vertices:   mapset.NewSet(),
+		Edges:      mapset.NewSet(),
+		Validators: map[AssetType][]AssetValidationRule{},
 	}
 }
 
@@ -51,6 +66,46 @@ func (sg *SchemaGraph) AddRelation(fromType AssetType, relationType string, toTy
 	return rt
 }
 
+// AddValidator add a validation rule to an asset type
+func (sg *SchemaGraph) AddValidator(assetType AssetType, rule AssetValidationRule) {
+	if sg.Validators == nil {
+		sg.Validators = map[AssetType][]AssetValidationRule{}
+	}
+	for _, r := range sg.Validators[assetType] {
+		if reflect.DeepEqual(r, rule) {
+			return
+		}
+	}
+	sg.Validators[assetType] = append(sg.Validators[assetType], rule)
+}
+
+// ValidateAsset checks the value of an asset of the given type satisfies the validation rules of the schema
+func (sg *SchemaGraph) ValidateAsset(assetType AssetType, value string) error {
+	return ValidateAsset(sg.Validators[assetType], assetType, value)
+}
+
+// CheckValidators verifies all the validation rules of the schema are well formed
+func (sg *SchemaGraph) CheckValidators() error {
+	for _, rules := range sg.Validators {
+		for _, r := range rules {
+			if err := r.Check(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddConstraint add a constraint on a relation type
+func (sg *SchemaGraph) AddConstraint(constraint RelationConstraint) {
+	for _, c := range sg.Constraints {
+		if c == constraint {
+			return
+		}
+	}
+	sg.Constraints = append(sg.Constraints, constraint)
+}
+
 // Relations return all the relations in the graph
 func (sg *SchemaGraph) Relations() []RelationType {
 	relations := []RelationType{}
@@ -68,6 +123,14 @@ func (sg *SchemaGraph) Merge(other SchemaGraph) {
 	for edge := range other.Edges.Iter() {
 		sg.Edges.Add(edge)
 	}
+	for assetType, rules := range other.Validators {
+		for _, r := range rules {
+			sg.AddValidator(assetType, r)
+		}
+	}
+	for _, c := range other.Constraints {
+		sg.AddConstraint(c)
+	}
 }
 
 // Equal check if two schema graphs are equal
@@ -79,6 +142,28 @@ func (sg *SchemaGraph) Equal(other SchemaGraph) bool {
 	if !sg.Edges.Equal(other.Edges) {
 		return false
 	}
+
+	if len(sg.Validators) != len(other.Validators) {
+		return false
+	}
+	for assetType, rules := range sg.Validators {
+		if !reflect.DeepEqual(rules, other.Validators[assetType]) {
+			return false
+		}
+	}
+
+	if len(sg.Constraints) != len(other.Constraints) {
+		return false
+	}
+	otherConstraints := make(map[RelationConstraint]struct{}, len(other.Constraints))
+	for _, c := range other.Constraints {
+		otherConstraints[c] = struct{}{}
+	}
+	for _, c := range sg.Constraints {
+		if _, ok := otherConstraints[c]; !ok {
+			return false
+		}
+	}
 	return true
 }
 
@@ -98,6 +183,12 @@ func (sg *SchemaGraph) MarshalJSON() ([]byte, error) {
 		schemaJSON.Edges = append(schemaJSON.Edges, edge)
 	}
 
+	if len(sg.Validators) > 0 {
+		schemaJSON.Validators = sg.Validators
+	}
+	schemaJSON.Constraints = sg.Constraints
+	schemaJSON.Ontology = sg.Ontology
+
 	return json.Marshal(schemaJSON)
 }
 
@@ -110,6 +201,7 @@ func (sg *SchemaGraph) UnmarshalJSON(b []byte) error {
 
 	sg.Vertices = mapset.NewSet()
 	sg.Edges = mapset.NewSet()
+	sg.Validators = map[AssetType][]AssetValidationRule{}
 
 	for _, v := range j.Vertices {
 		sg.Vertices.Add(v)
@@ -118,5 +210,17 @@ func (sg *SchemaGraph) UnmarshalJSON(b []byte) error {
 	for _, e := range j.Edges {
 		sg.Edges.Add(e)
 	}
+
+	for assetType, rules := range j.Validators {
+		for _, r := range rules {
+			sg.AddValidator(assetType, r)
+		}
+	}
+
+	sg.Constraints = nil
+	for _, c := range j.Constraints {
+		sg.AddConstraint(c)
+	}
+	sg.Ontology = j.Ontology
 	return nil
 }
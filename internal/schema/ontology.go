@@ -0,0 +1,88 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidOntologyEntry is returned when an ontology entry is malformed
+var ErrInvalidOntologyEntry = errors.New("invalid ontology entry")
+
+// OntologyRelationKind is the kind of relation between two asset types in the ontology
+type OntologyRelationKind string
+
+const (
+	// AliasOntologyRelation declares two asset types as names of the same concept
+	AliasOntologyRelation OntologyRelationKind = "alias"
+	// SubtypeOntologyRelation declares an asset type as a specialization of another asset type
+	SubtypeOntologyRelation OntologyRelationKind = "subtype"
+)
+
+// OntologyEntry declares an asset type as an alias or a subtype of a parent asset type
+type OntologyEntry struct {
+	Type   AssetType            `json:"type"`
+	Kind   OntologyRelationKind `json:"kind"`
+	Parent AssetType            `json:"parent"`
+}
+
+// Check verifies the entry is well formed
+func (oe OntologyEntry) Check() error {
+	if oe.Type == "" || oe.Parent == "" {
+		return fmt.Errorf("%w: type and parent must be provided", ErrInvalidOntologyEntry)
+	}
+	if oe.Type == oe.Parent {
+		return fmt.Errorf("%w: %s cannot be related to itself", ErrInvalidOntologyEntry, oe.Type)
+	}
+	if oe.Kind != AliasOntologyRelation && oe.Kind != SubtypeOntologyRelation {
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidOntologyEntry, oe.Kind)
+	}
+	return nil
+}
+
+// Ontology is a set of relations between asset types shared across the sources
+type Ontology struct {
+	Entries []OntologyEntry `json:"entries"`
+}
+
+// NewOntology create an ontology from entries
+func NewOntology(entries ...OntologyEntry) Ontology {
+	if entries == nil {
+		entries = []OntologyEntry{}
+	}
+	return Ontology{Entries: entries}
+}
+
+// Expand return the asset type along with its aliases and its subtypes, transitively. Aliases are
+// symmetric while subtypes are only included when expanding the parent type.
+func (o *Ontology) Expand(assetType AssetType) []AssetType {
+	visited := map[AssetType]struct{}{assetType: {}}
+	queue := []AssetType{assetType}
+
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+
+		for _, e := range o.Entries {
+			var next AssetType
+			if e.Parent == t {
+				next = e.Type
+			} else if e.Kind == AliasOntologyRelation && e.Type == t {
+				next = e.Parent
+			} else {
+				continue
+			}
+
+			if _, ok := visited[next]; !ok {
+				visited[next] = struct{}{}
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	types := make([]AssetType, 0, len(visited))
+	for t := range visited {
+		types = append(types, t)
+	}
+	sortAssetTypes(types)
+	return types
+}
@@ -0,0 +1,33 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldExpandOntology(t *testing.T) {
+	ontology := NewOntology(
+		OntologyEntry{Type: "host", Kind: AliasOntologyRelation, Parent: "device"},
+		OntologyEntry{Type: "server", Kind: SubtypeOntologyRelation, Parent: "device"},
+		OntologyEntry{Type: "vm", Kind: SubtypeOntologyRelation, Parent: "server"},
+	)
+
+	assert.Equal(t, []AssetType{"device", "host", "server", "vm"}, ontology.Expand("device"))
+	assert.Equal(t, []AssetType{"device", "host", "server", "vm"}, ontology.Expand("host"))
+	assert.Equal(t, []AssetType{"server", "vm"}, ontology.Expand("server"))
+	assert.Equal(t, []AssetType{"ip"}, ontology.Expand("ip"))
+}
+
+func TestShouldRejectMalformedOntologyEntries(t *testing.T) {
+	entries := []OntologyEntry{
+		{Type: "host", Kind: AliasOntologyRelation},
+		{Type: "host", Kind: AliasOntologyRelation, Parent: "host"},
+		{Type: "host", Kind: "unknown", Parent: "device"},
+	}
+
+	for _, e := range entries {
+		assert.ErrorIs(t, e.Check(), ErrInvalidOntologyEntry)
+	}
+	assert.NoError(t, OntologyEntry{Type: "host", Kind: SubtypeOntologyRelation, Parent: "device"}.Check())
+}
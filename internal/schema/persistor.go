@@ -1,9 +1,34 @@
 package schema
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrSchemaVersionNotFound is returned when the requested version of a schema does not exist
+var ErrSchemaVersionNotFound = errors.New("schema version not found")
+
+// SchemaVersion represent one version of the schema of a source
+type SchemaVersion struct {
+	Version   int64     `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
 
 // Persistor is a persistor of schema
 type Persistor interface {
 	SaveSchema(ctx context.Context, sourceName string, sg SchemaGraph) error
 	LoadSchema(ctx context.Context, sourceName string) (SchemaGraph, error)
+
+	// ListSchemaVersions list the versions of the schema of the source from the oldest to the newest
+	ListSchemaVersions(ctx context.Context, sourceName string) ([]SchemaVersion, error)
+	// LoadSchemaVersion load a given version of the schema of the source
+	LoadSchemaVersion(ctx context.Context, sourceName string, version int64) (SchemaGraph, error)
+}
+
+// OntologyPersistor is a persistor of the ontology
+type OntologyPersistor interface {
+	LoadOntology(ctx context.Context) (Ontology, error)
+	AddOntologyEntry(ctx context.Context, entry OntologyEntry) error
+	RemoveOntologyEntry(ctx context.Context, entry OntologyEntry) error
 }
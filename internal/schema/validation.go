@@ -1,21 +1,201 @@
 package schema
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
 	"github.com/clems4ever/go-graphkb/internal/utils"
 )
 
-type AssetValidationFunc func(string) bool
+// ErrAssetValidation is returned when an asset value does not satisfy the validation rules of its type.
+var ErrAssetValidation = errors.New("asset validation failed")
+
+// ErrInvalidValidationRule is returned when a validation rule is malformed.
+var ErrInvalidValidationRule = errors.New("invalid validation rule")
+
+// ValidationRuleType is the type of a validation rule
+type ValidationRuleType string
+
+const (
+	// RegexpValidationRule checks the value matches a regular expression
+	RegexpValidationRule ValidationRuleType = "regexp"
+	// EnumValidationRule checks the value is part of a list of accepted values
+	EnumValidationRule ValidationRuleType = "enum"
+	// MaxLengthValidationRule checks the length of the value does not exceed a maximum
+	MaxLengthValidationRule ValidationRuleType = "max_length"
+	// CIDRValidationRule checks the value is a CIDR notation of an IP network
+	CIDRValidationRule ValidationRuleType = "cidr"
+	// HostnameValidationRule checks the value is a valid hostname as defined by RFC 1123
+	HostnameValidationRule ValidationRuleType = "hostname"
+	// EmailValidationRule checks the value is an email address
+	EmailValidationRule ValidationRuleType = "email"
+	// URLValidationRule checks the value is an absolute URL
+	URLValidationRule ValidationRuleType = "url"
+)
+
+// AssetValidationRule is a serializable rule an asset value must satisfy
+type AssetValidationRule struct {
+	Type ValidationRuleType `json:"type"`
+
+	// Pattern is the regular expression used by regexp rules
+	Pattern string `json:"pattern,omitempty"`
+	// Values is the list of accepted values used by enum rules
+	Values []string `json:"values,omitempty"`
+	// MaxLength is the maximum length of the value used by max_length rules
+	MaxLength int `json:"max_length,omitempty"`
+}
+
+func (r AssetValidationRule) String() string {
+	switch r.Type {
+	case RegexpValidationRule:
+		return fmt.Sprintf("%s(%s)", r.Type, r.Pattern)
+	case EnumValidationRule:
+		return fmt.Sprintf("%s(%s)", r.Type, strings.Join(r.Values, ","))
+	case MaxLengthValidationRule:
+		return fmt.Sprintf("%s(%d)", r.Type, r.MaxLength)
+	}
+	return string(r.Type)
+}
+
+// maxCompiledRegexps is the maximum number of compiled regular expressions kept in the cache. The patterns come from
+// the schemas sent by the sources so the patterns seen once the cache is full are compiled on every use instead of
+// growing the cache without bound.
+const maxCompiledRegexps = 1000
+
+// compiledRegexps caches the compiled regular expressions of the regexp rules. The patterns are compiled when the
+// rules are registered or checked so that validating a value only takes the read lock.
+var compiledRegexps = struct {
+	sync.RWMutex
+	regexps map[string]*regexp.Regexp
+}{regexps: map[string]*regexp.Regexp{}}
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	compiledRegexps.RLock()
+	r, ok := compiledRegexps.regexps[pattern]
+	compiledRegexps.RUnlock()
+	if ok {
+		return r, nil
+	}
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	compiledRegexps.Lock()
+	defer compiledRegexps.Unlock()
+	if cached, ok := compiledRegexps.regexps[pattern]; ok {
+		return cached, nil
+	}
+	if len(compiledRegexps.regexps) < maxCompiledRegexps {
+		compiledRegexps.regexps[pattern] = r
+	}
+	return r, nil
+}
+
+var hostnameLabelRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+func isHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if len(s) == 0 || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if !hostnameLabelRegexp.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check verifies the rule is well formed
+func (r AssetValidationRule) Check() error {
+	switch r.Type {
+	case RegexpValidationRule:
+		if _, err := compileRegexp(r.Pattern); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidValidationRule, r, err)
+		}
+	case EnumValidationRule:
+		if len(r.Values) == 0 {
+			return fmt.Errorf("%w: %s: no value provided", ErrInvalidValidationRule, r)
+		}
+	case MaxLengthValidationRule:
+		if r.MaxLength <= 0 {
+			return fmt.Errorf("%w: %s: max length must be positive", ErrInvalidValidationRule, r)
+		}
+	case CIDRValidationRule, HostnameValidationRule, EmailValidationRule, URLValidationRule:
+	default:
+		return fmt.Errorf("%w: unknown rule type %q", ErrInvalidValidationRule, r.Type)
+	}
+	return nil
+}
+
+// Validate returns true if the value satisfies the rule
+func (r AssetValidationRule) Validate(value string) bool {
+	switch r.Type {
+	case RegexpValidationRule:
+		reg, err := compileRegexp(r.Pattern)
+		if err != nil {
+			return false
+		}
+		return reg.MatchString(value)
+	case EnumValidationRule:
+		return utils.IsStringInSlice(value, r.Values)
+	case MaxLengthValidationRule:
+		return len(value) <= r.MaxLength
+	case CIDRValidationRule:
+		_, _, err := net.ParseCIDR(value)
+		return err == nil
+	case HostnameValidationRule:
+		return isHostname(value)
+	case EmailValidationRule:
+		addr, err := mail.ParseAddress(value)
+		return err == nil && addr.Address == value
+	case URLValidationRule:
+		u, err := url.Parse(value)
+		return err == nil && u.Scheme != "" && u.Host != ""
+	}
+	return false
+}
+
+// ValidateAsset checks the value of an asset of the given type satisfies all the rules
+func ValidateAsset(rules []AssetValidationRule, assetType AssetType, value string) error {
+	for _, r := range rules {
+		if !r.Validate(value) {
+			return fmt.Errorf("%w: asset value %q does not match the type %q validator %s", ErrAssetValidation, value, assetType, r)
+		}
+	}
+	return nil
+}
 
 type ValidationRegistry interface {
-	Get(AssetType) ([]AssetValidationFunc, bool)
+	Get(AssetType) ([]AssetValidationRule, bool)
 }
 
 var (
-	AssetValidationRegistry ValidationRegistry = utils.NewRegistry[AssetType, []AssetValidationFunc]()
+	AssetValidationRegistry ValidationRegistry = utils.NewRegistry[AssetType, []AssetValidationRule]()
 )
 
-func AddAssetValidator(asset AssetType, v AssetValidationFunc) {
+// AddAssetValidator registers a validation rule for the given asset type. The rules are shipped with the schema
+// extracted from the graph so that the server can enforce them too.
+func AddAssetValidator(asset AssetType, v AssetValidationRule) {
+	if v.Type == RegexpValidationRule {
+		// The malformed patterns are reported when the schema is checked
+		compileRegexp(v.Pattern)
+	}
 	validators, _ := AssetValidationRegistry.Get(asset)
+	for _, existing := range validators {
+		if reflect.DeepEqual(existing, v) {
+			return
+		}
+	}
 	validators = append(validators, v)
-	AssetValidationRegistry.(*utils.Registry[AssetType, []AssetValidationFunc]).Set(asset, validators)
+	AssetValidationRegistry.(*utils.Registry[AssetType, []AssetValidationRule]).Set(asset, validators)
 }
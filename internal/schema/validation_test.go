@@ -0,0 +1,132 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationRules(t *testing.T) {
+	cases := []struct {
+		Rule    AssetValidationRule
+		Valid   []string
+		Invalid []string
+	}{
+		{
+			Rule:    AssetValidationRule{Type: RegexpValidationRule, Pattern: "^[a-z]+$"},
+			Valid:   []string{"abc"},
+			Invalid: []string{"ABC", "a1"},
+		},
+		{
+			Rule:    AssetValidationRule{Type: EnumValidationRule, Values: []string{"prod", "dev"}},
+			Valid:   []string{"prod", "dev"},
+			Invalid: []string{"staging"},
+		},
+		{
+			Rule:    AssetValidationRule{Type: MaxLengthValidationRule, MaxLength: 3},
+			Valid:   []string{"", "abc"},
+			Invalid: []string{"abcd"},
+		},
+		{
+			Rule:    AssetValidationRule{Type: CIDRValidationRule},
+			Valid:   []string{"10.0.0.0/8", "2001:db8::/32"},
+			Invalid: []string{"10.0.0.1", "10.0.0.0/33"},
+		},
+		{
+			Rule:    AssetValidationRule{Type: HostnameValidationRule},
+			Valid:   []string{"localhost", "my-host.example.com", "example.com."},
+			Invalid: []string{"", "-host", "host_name", "a..b"},
+		},
+		{
+			Rule:    AssetValidationRule{Type: EmailValidationRule},
+			Valid:   []string{"john@example.com"},
+			Invalid: []string{"john", "John <john@example.com>"},
+		},
+		{
+			Rule:    AssetValidationRule{Type: URLValidationRule},
+			Valid:   []string{"https://example.com/path"},
+			Invalid: []string{"example.com", "/path"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Rule.String(), func(t *testing.T) {
+			require.NoError(t, c.Rule.Check())
+			for _, v := range c.Valid {
+				assert.True(t, c.Rule.Validate(v), "%q should be valid", v)
+			}
+			for _, v := range c.Invalid {
+				assert.False(t, c.Rule.Validate(v), "%q should be invalid", v)
+			}
+		})
+	}
+}
+
+func TestShouldRejectMalformedRules(t *testing.T) {
+	rules := []AssetValidationRule{
+		{Type: RegexpValidationRule, Pattern: "("},
+		{Type: EnumValidationRule},
+		{Type: MaxLengthValidationRule},
+		{Type: "unknown"},
+	}
+
+	for _, r := range rules {
+		assert.ErrorIs(t, r.Check(), ErrInvalidValidationRule)
+	}
+}
+
+func TestShouldSerializeValidatorsWithSchema(t *testing.T) {
+	sg := NewSchemaGraph()
+	ip := sg.AddAsset("ip")
+	sg.AddValidator(ip, AssetValidationRule{Type: RegexpValidationRule, Pattern: "^[0-9.]+$"})
+	sg.AddValidator(ip, AssetValidationRule{Type: MaxLengthValidationRule, MaxLength: 15})
+
+	b, err := json.Marshal(&sg)
+	require.NoError(t, err)
+
+	sg2 := NewSchemaGraph()
+	require.NoError(t, json.Unmarshal(b, &sg2))
+
+	assert.True(t, sg.Equal(sg2))
+	assert.NoError(t, sg2.ValidateAsset(ip, "10.0.0.1"))
+	assert.ErrorIs(t, sg2.ValidateAsset(ip, "not-an-ip"), ErrAssetValidation)
+
+	sg3 := NewSchemaGraph()
+	sg3.AddAsset("ip")
+	assert.False(t, sg.Equal(sg3))
+}
+
+func TestShouldBoundCacheOfCompiledRegexps(t *testing.T) {
+	for i := 0; i < 2*maxCompiledRegexps; i++ {
+		rule := AssetValidationRule{Type: RegexpValidationRule, Pattern: fmt.Sprintf("^%d$", i)}
+		assert.True(t, rule.Validate(fmt.Sprint(i)))
+	}
+	assert.LessOrEqual(t, len(compiledRegexps.regexps), maxCompiledRegexps)
+}
+
+func TestShouldCompilePatternWhenRuleIsChecked(t *testing.T) {
+	compiledRegexps.Lock()
+	compiledRegexps.regexps = map[string]*regexp.Regexp{}
+	compiledRegexps.Unlock()
+
+	rule := AssetValidationRule{Type: RegexpValidationRule, Pattern: "^checked-[a-z]+$"}
+	require.NoError(t, rule.Check())
+
+	compiledRegexps.RLock()
+	compiled, ok := compiledRegexps.regexps[rule.Pattern]
+	compiledRegexps.RUnlock()
+	require.True(t, ok)
+
+	// The cache is not emptied when it is full so the pattern is not compiled again
+	for i := 0; i < 2*maxCompiledRegexps; i++ {
+		AssetValidationRule{Type: RegexpValidationRule, Pattern: fmt.Sprintf("^checked-%d$", i)}.Validate(fmt.Sprint(i))
+	}
+	r, err := compileRegexp(rule.Pattern)
+	require.NoError(t, err)
+	assert.Same(t, compiled, r)
+	assert.True(t, rule.Validate("checked-value"))
+}
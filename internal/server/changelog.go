@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// startChangelogPruner periodically remove the changes older than the retention period. The sources which have
+// not synchronized since then need to read their whole graph again.
+func startChangelogPruner(graphDB knowledge.GraphDB) {
+	retention := viper.GetDuration("changelog_retention")
+	if retention == 0 {
+		retention = 7 * 24 * time.Hour
+	}
+	interval := time.Hour
+
+	logrus.Infof("retention of the graph changes is %s", retention)
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			count, err := graphDB.PruneChanges(ctx, time.Now().Add(-retention))
+			if err != nil {
+				logrus.Errorf("changelog pruner: %s", err)
+			} else if count > 0 {
+				logrus.Infof("changelog pruner: removed %d changes", count)
+			}
+			cancel()
+
+			time.Sleep(interval)
+		}
+	}()
+}
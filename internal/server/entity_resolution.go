@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// startEntityResolution periodically recompute the same-as links created by the entity resolution rules so that
+// the assets pushed since the last run get resolved too.
+func startEntityResolution(resolver knowledge.EntityResolver) {
+	interval := viper.GetDuration("entity_resolution_interval")
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+
+	logrus.Infof("resolution of the entities every %s", interval)
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			count, err := resolver.ResolveEntities(ctx)
+			if err != nil {
+				logrus.Errorf("entity resolution: %s", err)
+			} else {
+				logrus.Debugf("entity resolution: %d same-as links", count)
+			}
+			cancel()
+
+			time.Sleep(interval)
+		}
+	}()
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
 	"github.com/clems4ever/go-graphkb/internal/metrics"
+	"github.com/clems4ever/go-graphkb/internal/sources"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -104,3 +105,21 @@ func getMonitoringIntervalSeconds() time.Duration {
 	}
 	return intervalDuration
 }
+
+// initLastUpdateGauge set the time of the last successful update of each source from the database so that the gauge
+// survives restarts of the server
+func initLastUpdateGauge(registry sources.Registry) {
+	freshness, err := registry.ListFreshness(context.Background())
+	if err != nil {
+		logrus.Errorf("Unable to read the last updates of the sources: %v", err)
+		return
+	}
+	for _, f := range freshness {
+		if f.LastUpdateAt == nil {
+			continue
+		}
+		metrics.LastSuccessfulDatasourceUpdateTimestampGauge.
+			With(prometheus.Labels{"source": f.Source}).
+			Set(float64(f.LastUpdateAt.Unix()))
+	}
+}
@@ -8,35 +8,43 @@ import (
 	"strings"
 	"time"
 
+	"github.com/clems4ever/go-graphkb/internal/audit"
+	"github.com/clems4ever/go-graphkb/internal/auth"
 	"github.com/clems4ever/go-graphkb/internal/handlers"
 	"github.com/clems4ever/go-graphkb/internal/history"
+	"github.com/clems4ever/go-graphkb/internal/kbcontext"
 	"github.com/clems4ever/go-graphkb/internal/knowledge"
 	"github.com/clems4ever/go-graphkb/internal/metrics"
+	"github.com/clems4ever/go-graphkb/internal/ratelimit"
 	"github.com/clems4ever/go-graphkb/internal/schema"
 	"github.com/clems4ever/go-graphkb/internal/sources"
 	"github.com/clems4ever/go-graphkb/internal/utils"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sync/semaphore"
-
-	auth "github.com/abbot/go-http-auth"
 
 	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
 )
 
-func getSourceGraph(registry sources.Registry, db schema.Persistor) http.HandlerFunc {
+func getSourceGraph(registry sources.Registry, db schema.Persistor, ontologyPersistor schema.OntologyPersistor) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		sources := []string{}
-		availableSources := []string{}
 
-		sourceToToken, err := registry.ListSources(r.Context())
+		availableSources, err := registry.ListSources(r.Context())
 		if err != nil {
 			handlers.ReplyWithInternalError(w, err)
 			return
 		}
-		for k := range sourceToToken {
-			availableSources = append(availableSources, k)
+
+		// The schemas of the sources the user is not allowed to query are hidden as if the sources did not exist
+		if allowedSources := kbcontext.AllowedSources(r.Context()); allowedSources != nil {
+			visibleSources := []string{}
+			for _, s := range availableSources {
+				if utils.IsStringInSlice(s, allowedSources) {
+					visibleSources = append(visibleSources, s)
+				}
+			}
+			availableSources = visibleSources
 		}
 
 		sourcesParams, ok := r.URL.Query()["sources"]
@@ -63,23 +71,26 @@ func getSourceGraph(registry sources.Registry, db schema.Persistor) http.Handler
 			}
 			sg.Merge(g)
 		}
+
+		ontology, err := ontologyPersistor.LoadOntology(r.Context())
+		if err != nil {
+			handlers.ReplyWithInternalError(w, err)
+			return
+		}
+		sg.Ontology = ontology.Entries
+
 		handlers.ReplyWithSourceGraph(w, &sg)
 	}
 }
 
 func listSources(registry sources.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sourcesToTokens, err := registry.ListSources(r.Context())
+		sources, err := registry.ListSources(r.Context())
 		if err != nil {
 			handlers.ReplyWithInternalError(w, err)
 			return
 		}
 
-		sources := []string{}
-		for k := range sourcesToTokens {
-			sources = append(sources, k)
-		}
-
 		err = json.NewEncoder(w).Encode(sources)
 		if err != nil {
 			handlers.ReplyWithInternalError(w, err)
@@ -117,87 +128,185 @@ func flushDatabase(graphDB knowledge.GraphDB) http.HandlerFunc {
 	}
 }
 
-// Secret is the secret provider function for basic auth
-func Secret(user, realm string) string {
-	if user == "admin" {
-		return viper.GetString("password")
-	}
-	return ""
-}
-
 // StartServer start the web server
 func StartServer(listenInterface string,
 	database knowledge.GraphDB,
 	schemaPersistor schema.Persistor,
+	ontologyPersistor schema.OntologyPersistor,
+	entityResolver knowledge.EntityResolver,
+	transactionStager knowledge.TransactionStager,
 	sourcesRegistry sources.Registry,
 	queryHistorizer history.Historizer,
+	auditStore audit.Store,
 	writeConcurrency int64) {
 
 	dbMonitor := newDBMonitor(database)
 	dbMonitor.Start()
 
+	if viper.GetBool("entity_resolution") {
+		startEntityResolution(entityResolver)
+	}
+
 	r := mux.NewRouter()
 	cacheTTL := viper.GetDuration("query_cache_ttl")
 	if cacheTTL == 0 {
 		cacheTTL = 10 * time.Minute
 	}
 
-	graphUpdater := knowledge.NewGraphUpdater(database, schemaPersistor)
+	// The sources are cached so that authenticating a request does not hit the database
+	sourcesCacheTTL := viper.GetDuration("sources_cache_ttl")
+	if sourcesCacheTTL == 0 {
+		sourcesCacheTTL = 10 * time.Second
+	}
+	sourcesRegistry = sources.NewCachedRegistry(sourcesRegistry, sourcesCacheTTL)
 
-	listSourcesHandler := listSources(sourcesRegistry)
-	getSourceGraphHandler := getSourceGraph(sourcesRegistry, schemaPersistor)
-	getDatabaseDetailsHandler := getDatabaseDetails(dbMonitor)
-	postQueryHandler := handlers.PostQuery(database, queryHistorizer, cacheTTL)
-	flushDatabaseHandler := flushDatabase(database)
+	// The ontology is cached so that each query does not read it from the database
+	ontologyPersistor = schema.NewCachedOntologyPersistor(ontologyPersistor, schema.OntologyCacheTTL)
 
-	if viper.GetString("password") != "" {
-		authenticator := auth.NewBasicAuthenticator("example.com", Secret)
+	graphUpdater := knowledge.NewGraphUpdater(database, schemaPersistor, transactionStager)
+	startTransactionReaper(transactionStager)
+	startRunReaper(database)
+	startChangelogPruner(database)
 
-		AuthMiddleware := func(h http.HandlerFunc) http.HandlerFunc {
-			return authenticator.Wrap(func(w http.ResponseWriter, ar *auth.AuthenticatedRequest) {
-				h.ServeHTTP(w, &ar.Request)
-			})
-		}
-
-		listSourcesHandler = AuthMiddleware(listSourcesHandler)
-		getSourceGraphHandler = AuthMiddleware(getSourceGraphHandler)
-		getDatabaseDetailsHandler = AuthMiddleware(getDatabaseDetailsHandler)
-		postQueryHandler = AuthMiddleware(postQueryHandler)
-		flushDatabaseHandler = AuthMiddleware(flushDatabaseHandler)
+	authenticator, err := auth.NewAuthenticatorFromConfig()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if !authenticator.Enabled() {
+		logrus.Warn("No user is configured, the query and admin APIs are not authenticated. Use `auth.users` option to declare users")
 	}
 
-	r.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
-	r.HandleFunc("/api/sources", listSourcesHandler).Methods("GET")
-	r.HandleFunc("/api/schema", getSourceGraphHandler).Methods("GET")
-	r.HandleFunc("/api/database", getDatabaseDetailsHandler).Methods("GET")
-
-	r.HandleFunc("/api/admin/flush", flushDatabaseHandler).Methods("POST")
+	// The entries of the audit log can be mirrored to a file to be shipped to an external system
+	if path := viper.GetString("audit_log_file"); path != "" {
+		auditStore, err = audit.NewJSONLMirror(auditStore, path)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	audited := func(operation string, h http.HandlerFunc) http.HandlerFunc {
+		return audit.Middleware(auditStore, operation, h)
+	}
 
+	viewer := func(h http.HandlerFunc) http.HandlerFunc { return authenticator.Require(auth.RoleViewer, h) }
+	analyst := func(h http.HandlerFunc) http.HandlerFunc { return authenticator.Require(auth.RoleAnalyst, h) }
+	admin := func(h http.HandlerFunc) http.HandlerFunc { return authenticator.Require(auth.RoleAdmin, h) }
+
+	r.PathPrefix("/debug/pprof/").Handler(admin(http.DefaultServeMux.ServeHTTP))
+	r.HandleFunc("/api/sources", viewer(listSources(sourcesRegistry))).Methods("GET")
+	r.HandleFunc("/api/schema", viewer(getSourceGraph(sourcesRegistry, schemaPersistor, ontologyPersistor))).Methods("GET")
+	r.HandleFunc("/api/schema/history", viewer(handlers.GetSchemaHistory(sourcesRegistry, schemaPersistor))).Methods("GET")
+	r.HandleFunc("/api/schema/diff", viewer(handlers.GetSchemaDiff(sourcesRegistry, schemaPersistor))).Methods("GET")
+	r.HandleFunc("/api/schema/violations", viewer(handlers.GetConstraintViolations(sourcesRegistry, schemaPersistor, database))).Methods("GET")
+	r.HandleFunc("/api/database", viewer(getDatabaseDetails(dbMonitor))).Methods("GET")
+
+	r.HandleFunc("/api/admin/flush", audited("flush_database", admin(flushDatabase(database)))).Methods("POST")
+	r.HandleFunc("/api/admin/audit", admin(handlers.GetAdminAudit(auditStore))).Methods("GET")
+	r.HandleFunc("/api/admin/ontology", viewer(handlers.GetOntology(ontologyPersistor))).Methods("GET")
+	r.HandleFunc("/api/admin/ontology", audited("put_ontology_entry", analyst(handlers.PutOntologyEntry(ontologyPersistor)))).Methods("PUT")
+	r.HandleFunc("/api/admin/ontology", audited("delete_ontology_entry", analyst(handlers.DeleteOntologyEntry(ontologyPersistor)))).Methods("DELETE")
+	r.HandleFunc("/api/admin/entities/rules", viewer(handlers.GetResolutionRules(entityResolver))).Methods("GET")
+	r.HandleFunc("/api/admin/entities/rules", audited("put_resolution_rule", analyst(handlers.PutResolutionRule(entityResolver)))).Methods("PUT")
+	r.HandleFunc("/api/admin/entities/rules", audited("delete_resolution_rule", analyst(handlers.DeleteResolutionRule(entityResolver)))).Methods("DELETE")
+	r.HandleFunc("/api/admin/entities/links", audited("put_same_as_link", analyst(handlers.PutSameAsLink(entityResolver)))).Methods("PUT")
+	r.HandleFunc("/api/admin/entities/links", audited("delete_same_as_link", analyst(handlers.DeleteSameAsLink(entityResolver)))).Methods("DELETE")
+	r.HandleFunc("/api/admin/entities/resolve", audited("resolve_entities", analyst(handlers.PostResolveEntities(entityResolver)))).Methods("POST")
+	r.HandleFunc("/api/admin/sources", audited("list_sources", admin(handlers.GetAdminSources(sourcesRegistry)))).Methods("GET")
+	r.HandleFunc("/api/admin/sources", audited("create_source", admin(handlers.PostAdminSource(sourcesRegistry)))).Methods("POST")
+	r.HandleFunc("/api/admin/sources/{name}", audited("rename_source", admin(handlers.PutAdminSource(sourcesRegistry)))).Methods("PUT")
+	r.HandleFunc("/api/admin/sources/{name}", audited("remove_source", admin(handlers.DeleteAdminSource(sourcesRegistry)))).Methods("DELETE")
+	r.HandleFunc("/api/admin/sources/{name}/token", audited("rotate_token", admin(handlers.PostAdminSourceToken(sourcesRegistry)))).Methods("POST")
+	r.HandleFunc("/api/admin/sources/{name}/tokens", audited("list_tokens", admin(handlers.GetAdminSourceTokens(sourcesRegistry)))).Methods("GET")
+	r.HandleFunc("/api/admin/sources/{name}/tokens", audited("add_token", admin(handlers.PostAdminSourceTokens(sourcesRegistry)))).Methods("POST")
+	r.HandleFunc("/api/admin/sources/{name}/tokens/{token}", audited("revoke_token", admin(handlers.DeleteAdminSourceToken(sourcesRegistry)))).Methods("DELETE")
+	r.HandleFunc("/api/admin/sources/{name}/tokens/{token}/rotate", audited("rotate_token", admin(handlers.PostAdminSourceToken(sourcesRegistry)))).Methods("POST")
+
+	// The metrics are scraped by Prometheus and do not expose any data of the graph
 	r.Handle("/metrics", promhttp.Handler())
 
-	r.HandleFunc("/api/graph/read", handlers.GetGraphRead(sourcesRegistry, database)).Methods("GET")
+	// The requests are decompressed on the fly, their decompressed size is limited so that a small compressed body
+	// cannot exhaust the memory of the server
+	maxBodySize := viper.GetInt64("max_decompressed_body_size")
+	if maxBodySize == 0 {
+		maxBodySize = handlers.DefaultMaxDecompressedBodySize
+	}
+	compressed := func(h http.HandlerFunc) http.HandlerFunc { return handlers.WithCompression(maxBodySize, h) }
+
+	r.HandleFunc("/api/graph/read", compressed(handlers.GetGraphRead(sourcesRegistry, database))).Methods("GET")
+	r.HandleFunc("/api/graph/schema", compressed(handlers.GetGraphSchema(sourcesRegistry, schemaPersistor))).Methods("GET")
+	r.HandleFunc("/api/graph/revision", compressed(handlers.GetGraphRevision(sourcesRegistry, database))).Methods("GET")
+	r.HandleFunc("/api/graph/changes", compressed(handlers.GetGraphChanges(sourcesRegistry, database))).Methods("GET")
+	r.HandleFunc("/api/graph/checksum", compressed(handlers.GetGraphChecksum(sourcesRegistry, database))).Methods("GET")
+
+	// The updates of a source and the queries of a user are limited so that a single client cannot starve the others
+	rateLimits := struct {
+		Writes  ratelimit.Configuration `mapstructure:"writes"`
+		Queries ratelimit.Configuration `mapstructure:"queries"`
+	}{}
+	if err := viper.UnmarshalKey("rate_limit", &rateLimits); err != nil {
+		logrus.Fatalf("Unable to read the rate limit configuration: %v", err)
+	}
+	limiter := handlers.NewUpdateLimiter(writeConcurrency, ratelimit.NewLimiter(rateLimits.Writes))
+	queryLimiter := ratelimit.NewLimiter(rateLimits.Queries)
+	limited := func(h http.HandlerFunc) http.HandlerFunc { return handlers.WithQueryRateLimit(queryLimiter, h) }
 
-	sem := semaphore.NewWeighted(writeConcurrency)
+	// The data of the sources which have not updated their graph for too long can be flagged or hidden in the queries
+	freshness, err := handlers.LoadFreshnessConfiguration()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	fresh := func(h http.HandlerFunc) http.HandlerFunc {
+		return handlers.WithStaleSources(sourcesRegistry, freshness, h)
+	}
+	initLastUpdateGauge(sourcesRegistry)
 
-	r.HandleFunc("/api/graph/schema", handlers.PutSchema(sourcesRegistry, graphUpdater, sem)).Methods("PUT")
-	r.HandleFunc("/api/graph/assets", handlers.PutAssets(sourcesRegistry, graphUpdater, sem)).Methods("PUT")
-	r.HandleFunc("/api/graph/assets", handlers.DeleteAssets(sourcesRegistry, graphUpdater, sem)).Methods("DELETE")
-	r.HandleFunc("/api/graph/relations", handlers.PutRelations(sourcesRegistry, graphUpdater, sem)).Methods("PUT")
-	r.HandleFunc("/api/graph/relations", handlers.DeleteRelations(sourcesRegistry, graphUpdater, sem)).Methods("DELETE")
+	quotas, err := handlers.LoadQuotaConfiguration()
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	r.HandleFunc("/api/query", postQueryHandler).Methods("POST")
-	r.HandleFunc("/api/query/assets/sources", handlers.PostQueryAssetsSources(database)).Methods("POST")
-	r.HandleFunc("/api/query/relations/sources", handlers.PostQueryRelationsSources(database)).Methods("POST")
+	r.HandleFunc("/api/graph/schema", compressed(audited("update_schema", handlers.PutSchema(sourcesRegistry, graphUpdater, limiter)))).Methods("PUT")
+	r.HandleFunc("/api/graph/assets", compressed(audited("insert_assets", handlers.PutAssets(sourcesRegistry, graphUpdater, limiter, quotas)))).Methods("PUT")
+	r.HandleFunc("/api/graph/assets", compressed(audited("delete_assets", handlers.DeleteAssets(sourcesRegistry, graphUpdater, limiter)))).Methods("DELETE")
+	r.HandleFunc("/api/graph/relations", compressed(audited("insert_relations", handlers.PutRelations(sourcesRegistry, graphUpdater, limiter, quotas)))).Methods("PUT")
+	r.HandleFunc("/api/graph/relations", compressed(audited("delete_relations", handlers.DeleteRelations(sourcesRegistry, graphUpdater, limiter)))).Methods("DELETE")
+	r.HandleFunc("/api/graph/runs/{id}/complete", compressed(audited("complete_run", handlers.PostRunComplete(sourcesRegistry, graphUpdater, limiter)))).Methods("POST")
+
+	r.HandleFunc("/api/graph/transactions", compressed(audited("begin_transaction", handlers.PostTransaction(sourcesRegistry, graphUpdater, limiter)))).Methods("POST")
+	r.HandleFunc("/api/graph/transactions/{id}/schema", compressed(audited("stage_schema", handlers.PutTransactionSchema(sourcesRegistry, graphUpdater, limiter)))).Methods("PUT")
+	r.HandleFunc("/api/graph/transactions/{id}/assets", compressed(audited("stage_insert_assets", handlers.PutTransactionAssets(sourcesRegistry, graphUpdater, limiter)))).Methods("PUT")
+	r.HandleFunc("/api/graph/transactions/{id}/assets", compressed(audited("stage_remove_assets", handlers.DeleteTransactionAssets(sourcesRegistry, graphUpdater, limiter)))).Methods("DELETE")
+	r.HandleFunc("/api/graph/transactions/{id}/relations", compressed(audited("stage_insert_relations", handlers.PutTransactionRelations(sourcesRegistry, graphUpdater, limiter)))).Methods("PUT")
+	r.HandleFunc("/api/graph/transactions/{id}/relations", compressed(audited("stage_remove_relations", handlers.DeleteTransactionRelations(sourcesRegistry, graphUpdater, limiter)))).Methods("DELETE")
+	r.HandleFunc("/api/graph/transactions/{id}/commit", compressed(audited("commit_transaction", handlers.PostTransactionCommit(sourcesRegistry, graphUpdater, limiter, quotas)))).Methods("POST")
+	r.HandleFunc("/api/graph/transactions/{id}/abort", compressed(audited("abort_transaction", handlers.PostTransactionAbort(sourcesRegistry, graphUpdater, limiter)))).Methods("POST")
+	r.HandleFunc("/api/graph/freshness", compressed(audited("set_expected_interval", handlers.PutSourceFreshness(sourcesRegistry)))).Methods("PUT")
+	r.HandleFunc("/api/sources/status", viewer(handlers.GetSourcesStatus(sourcesRegistry, freshness))).Methods("GET")
+
+	postQueryHandler := handlers.PostQuery(database, queryHistorizer, ontologyPersistor, entityResolver, cacheTTL)
+	r.HandleFunc("/api/query", compressed(
+		handlers.WithSourceToken(sourcesRegistry, sources.ScopeQuery, limited(fresh(postQueryHandler)), viewer(limited(fresh(postQueryHandler)))))).Methods("POST")
+	r.HandleFunc("/api/query/assets/sources", viewer(limited(fresh(handlers.PostQueryAssetsSources(database))))).Methods("POST")
+	r.HandleFunc("/api/query/relations/sources", viewer(limited(fresh(handlers.PostQueryRelationsSources(database))))).Methods("POST")
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./web/build/")))
 
 	metrics.StartTimeGauge.Set(float64(time.Now().Unix()))
 
-	var err error
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
 	if viper.GetString("server_tls_cert") != "" {
 		logrus.Infof("Listening on %s with TLS enabled, the connection is secure [concurrency=%d", listenInterface, writeConcurrency)
-		err = http.ListenAndServeTLS(listenInterface, viper.GetString("server_tls_cert"),
-			viper.GetString("server_tls_key"), r)
+		if tlsConfig != nil {
+			logrus.Infof("Client certificates issued by the CAs of %s authenticate the sources", viper.GetString("server_tls_client_ca"))
+		}
+		srv := &http.Server{Addr: listenInterface, Handler: r, TLSConfig: tlsConfig}
+		err = srv.ListenAndServeTLS(viper.GetString("server_tls_cert"), viper.GetString("server_tls_key"))
 	} else {
+		if tlsConfig != nil {
+			logrus.Fatal("Option `server_tls_client_ca` requires TLS to be enabled with `server_tls_cert`")
+		}
 		logrus.Warnf("Listening on %s with TLS disabled. Use `server_tls_cert` option to setup a certificate [concurrency=%d]",
 			listenInterface, writeConcurrency)
 		err = http.ListenAndServe(listenInterface, r)
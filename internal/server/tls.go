@@ -0,0 +1,36 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+)
+
+// newTLSConfig build the TLS configuration verifying the client certificates against the CAs of
+// `server_tls_client_ca`. It returns nil when mutual TLS is disabled.
+func newTLSConfig() (*tls.Config, error) {
+	caFile := viper.GetString("server_tls_client_ca")
+	if caFile == "" {
+		return nil, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the client CA bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificate found in client CA bundle %s", caFile)
+	}
+
+	// The users of the web UI usually do not have a certificate, the certificate is only verified when provided
+	// unless it is required
+	clientAuth := tls.VerifyClientCertIfGiven
+	if viper.GetBool("server_tls_client_cert_required") {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{ClientCAs: pool, ClientAuth: clientAuth, MinVersion: tls.VersionTLS12}, nil
+}
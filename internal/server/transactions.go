@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/clems4ever/go-graphkb/internal/knowledge"
+	"github.com/clems4ever/go-graphkb/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// startTransactionReaper periodically discard the staged transactions abandoned by the sources
+func startTransactionReaper(stager knowledge.TransactionStager) {
+	interval := time.Minute
+
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			count, err := stager.ExpireTransactions(ctx)
+			if err != nil {
+				logrus.Errorf("transaction reaper: %s", err)
+			} else if count > 0 {
+				logrus.Infof("transaction reaper: discarded %d expired transactions", count)
+				metrics.GraphUpdateTransactionsExpiredCounter.Add(float64(count))
+			}
+			cancel()
+
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// startRunReaper periodically forget the removals of the runs abandoned by the sources
+func startRunReaper(graphDB knowledge.GraphDB) {
+	interval := time.Minute
+
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			count, err := graphDB.ExpireRuns(ctx)
+			if err != nil {
+				logrus.Errorf("run reaper: %s", err)
+			} else if count > 0 {
+				logrus.Infof("run reaper: forgot %d expired runs", count)
+			}
+			cancel()
+
+			time.Sleep(interval)
+		}
+	}()
+}
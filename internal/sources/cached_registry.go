@@ -0,0 +1,221 @@
+package sources
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CachedRegistry is a registry keeping the sources and their credentials in memory for a short time so that
+// authenticating a request does not hit the database. The cache is invalidated by the updates made through it.
+// The uses of the tokens are buffered as well and recorded when the cache is refreshed while the updates of the sources
+// are recorded right away so that a restart does not lose them.
+type CachedRegistry struct {
+	registry Registry
+	ttl      time.Duration
+
+	mutex       sync.Mutex
+	sources     []string
+	credentials []Credential
+	freshness   []Freshness
+	expiresAt   time.Time
+	usages      map[[2]string]TokenUsage
+
+	now func() time.Time
+}
+
+// NewCachedRegistry create a registry caching the sources of the underlying registry for the given duration
+func NewCachedRegistry(registry Registry, ttl time.Duration) *CachedRegistry {
+	return &CachedRegistry{registry: registry, ttl: ttl, now: time.Now,
+		usages: make(map[[2]string]TokenUsage)}
+}
+
+// flushUsages record the buffered uses of the tokens, the lock must be held
+func (cr *CachedRegistry) flushUsages(ctx context.Context) error {
+	if len(cr.usages) == 0 {
+		return nil
+	}
+	usages := make([]TokenUsage, 0, len(cr.usages))
+	for _, u := range cr.usages {
+		usages = append(usages, u)
+	}
+	if err := cr.registry.MarkTokensUsed(ctx, usages); err != nil {
+		return err
+	}
+	cr.usages = make(map[[2]string]TokenUsage)
+	return nil
+}
+
+// load refresh the cache if it has expired, the lock must be held
+func (cr *CachedRegistry) load(ctx context.Context) error {
+	if cr.credentials != nil && cr.now().Before(cr.expiresAt) {
+		return nil
+	}
+
+	// Failing to record the uses of the tokens must not prevent the sources from authenticating
+	if err := cr.flushUsages(ctx); err != nil {
+		logrus.Errorf("Unable to record the last use of the tokens: %v", err)
+	}
+
+	sources, err := cr.registry.ListSources(ctx)
+	if err != nil {
+		return err
+	}
+	credentials, err := cr.registry.ListCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	freshness, err := cr.registry.ListFreshness(ctx)
+	if err != nil {
+		return err
+	}
+	cr.sources = sources
+	cr.credentials = credentials
+	cr.freshness = freshness
+	cr.expiresAt = cr.now().Add(cr.ttl)
+	return nil
+}
+
+// Invalidate drop the cached sources so that they are read again on next access
+func (cr *CachedRegistry) Invalidate() {
+	cr.mutex.Lock()
+	cr.credentials = nil
+	cr.sources = nil
+	cr.freshness = nil
+	cr.mutex.Unlock()
+}
+
+// ListSources list the names of the data sources
+func (cr *CachedRegistry) ListSources(ctx context.Context) ([]string, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if err := cr.load(ctx); err != nil {
+		return nil, err
+	}
+	return append([]string{}, cr.sources...), nil
+}
+
+// ListCredentials list the hashed authentication tokens of all the data sources
+func (cr *CachedRegistry) ListCredentials(ctx context.Context) ([]Credential, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if err := cr.load(ctx); err != nil {
+		return nil, err
+	}
+	return append([]Credential{}, cr.credentials...), nil
+}
+
+// CreateSource register a new data source authenticated by the token
+func (cr *CachedRegistry) CreateSource(ctx context.Context, name, authToken string) error {
+	defer cr.Invalidate()
+	return cr.registry.CreateSource(ctx, name, authToken)
+}
+
+// RotateToken replace the secret of a token of the source
+func (cr *CachedRegistry) RotateToken(ctx context.Context, name, tokenName, authToken string) error {
+	defer cr.Invalidate()
+	return cr.registry.RotateToken(ctx, name, tokenName, authToken)
+}
+
+// RenameSource rename the source, its graph is kept
+func (cr *CachedRegistry) RenameSource(ctx context.Context, name, newName string) error {
+	defer cr.Invalidate()
+	return cr.registry.RenameSource(ctx, name, newName)
+}
+
+// RemoveSource unregister the source and remove the assets and relations no other source references
+func (cr *CachedRegistry) RemoveSource(ctx context.Context, name string) (RemovalReport, error) {
+	defer cr.Invalidate()
+	return cr.registry.RemoveSource(ctx, name)
+}
+
+// ListTokens list the tokens of the source with their last use including the buffered ones
+func (cr *CachedRegistry) ListTokens(ctx context.Context, name string) ([]Token, error) {
+	cr.mutex.Lock()
+	err := cr.flushUsages(ctx)
+	cr.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return cr.registry.ListTokens(ctx, name)
+}
+
+// AddToken add a token to the source
+func (cr *CachedRegistry) AddToken(ctx context.Context, name string, token Token, authToken string) error {
+	defer cr.Invalidate()
+	return cr.registry.AddToken(ctx, name, token, authToken)
+}
+
+// RevokeToken remove a token of the source
+func (cr *CachedRegistry) RevokeToken(ctx context.Context, name, tokenName string) error {
+	defer cr.Invalidate()
+	return cr.registry.RevokeToken(ctx, name, tokenName)
+}
+
+// MarkTokensUsed buffer the uses of the tokens until the cache is refreshed. The rehashed tokens are updated in the
+// cache right away so that they are not rehashed again.
+func (cr *CachedRegistry) MarkTokensUsed(ctx context.Context, usages []TokenUsage) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	for _, u := range usages {
+		key := [2]string{u.Source, u.Token}
+		buffered, ok := cr.usages[key]
+		if !ok || u.At.After(buffered.At) {
+			buffered.At = u.At
+		}
+		buffered.Source, buffered.Token = u.Source, u.Token
+		if u.Rehashed != nil {
+			buffered.Rehashed = u.Rehashed
+			for i, c := range cr.credentials {
+				if c.Source == u.Source && c.Name == u.Token {
+					cr.credentials[i].HashedToken = *u.Rehashed
+				}
+			}
+		}
+		cr.usages[key] = buffered
+	}
+	return nil
+}
+
+// ListFreshness list the last successful update and the expected update interval of the sources
+func (cr *CachedRegistry) ListFreshness(ctx context.Context) ([]Freshness, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if err := cr.load(ctx); err != nil {
+		return nil, err
+	}
+	return append([]Freshness{}, cr.freshness...), nil
+}
+
+// MarkSourcesUpdated record the updates of the sources in the underlying registry along with the update of their
+// graph and update the cached freshness so that it is visible before the cache is refreshed
+func (cr *CachedRegistry) MarkSourcesUpdated(ctx context.Context, updates []SourceUpdate) error {
+	if err := cr.registry.MarkSourcesUpdated(ctx, updates); err != nil {
+		return err
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	for _, u := range updates {
+		for i, f := range cr.freshness {
+			if f.Source == u.Source && (f.LastUpdateAt == nil || u.At.After(*f.LastUpdateAt)) {
+				at := u.At
+				cr.freshness[i].LastUpdateAt = &at
+			}
+		}
+	}
+	return nil
+}
+
+// SetExpectedInterval record the interval the source declares updating its graph at
+func (cr *CachedRegistry) SetExpectedInterval(ctx context.Context, name string, interval time.Duration) error {
+	defer cr.Invalidate()
+	return cr.registry.SetExpectedInterval(ctx, name, interval)
+}
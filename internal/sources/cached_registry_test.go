@@ -0,0 +1,175 @@
+package sources
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingRegistry struct {
+	Registry
+	credentials []Credential
+	usages      []TokenUsage
+	updates     []SourceUpdate
+	reads       int
+}
+
+func newCountingRegistry(t *testing.T, source, token string) *countingRegistry {
+	hashed, err := HashToken(token)
+	require.NoError(t, err)
+	return &countingRegistry{credentials: []Credential{{
+		Source:      source,
+		Token:       Token{Name: DefaultTokenName, Scopes: AllScopes},
+		HashedToken: hashed,
+	}}}
+}
+
+func (c *countingRegistry) ListSources(ctx context.Context) ([]string, error) {
+	names := []string{}
+	for _, credential := range c.credentials {
+		names = append(names, credential.Source)
+	}
+	return names, nil
+}
+
+func (c *countingRegistry) ListCredentials(ctx context.Context) ([]Credential, error) {
+	c.reads++
+	return c.credentials, nil
+}
+
+func (c *countingRegistry) RotateToken(ctx context.Context, name, tokenName, authToken string) error {
+	hashed, err := HashToken(authToken)
+	if err != nil {
+		return err
+	}
+	for i, credential := range c.credentials {
+		if credential.Source == name && credential.Name == tokenName {
+			c.credentials[i].HashedToken = hashed
+			return nil
+		}
+	}
+	return ErrTokenNotFound
+}
+
+func (c *countingRegistry) ListTokens(ctx context.Context, name string) ([]Token, error) {
+	return nil, nil
+}
+
+func (c *countingRegistry) MarkTokensUsed(ctx context.Context, usages []TokenUsage) error {
+	c.usages = append(c.usages, usages...)
+	return nil
+}
+
+func (c *countingRegistry) ListFreshness(ctx context.Context) ([]Freshness, error) {
+	freshness := []Freshness{}
+	for _, credential := range c.credentials {
+		f := Freshness{Source: credential.Source}
+		for _, u := range c.updates {
+			if u.Source == credential.Source {
+				at := u.At
+				f.LastUpdateAt = &at
+			}
+		}
+		freshness = append(freshness, f)
+	}
+	return freshness, nil
+}
+
+func (c *countingRegistry) MarkSourcesUpdated(ctx context.Context, updates []SourceUpdate) error {
+	c.updates = append(c.updates, updates...)
+	return nil
+}
+
+func TestShouldCacheCredentialsUntilExpiration(t *testing.T) {
+	backend := newCountingRegistry(t, "scanner", "0123456789abcdef")
+
+	now := time.Now()
+	registry := NewCachedRegistry(backend, time.Minute)
+	registry.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		credential, err := Authenticate(context.Background(), registry, "0123456789abcdef")
+		require.NoError(t, err)
+		require.NotNil(t, credential)
+		assert.Equal(t, "scanner", credential.Source)
+		assert.Equal(t, DefaultTokenName, credential.Name)
+	}
+	assert.Equal(t, 1, backend.reads)
+
+	now = now.Add(2 * time.Minute)
+	_, err := registry.ListSources(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.reads)
+}
+
+func TestShouldInvalidateCacheOnTokenRotation(t *testing.T) {
+	backend := newCountingRegistry(t, "scanner", "0123456789abcdef")
+	registry := NewCachedRegistry(backend, time.Minute)
+
+	credential, err := Authenticate(context.Background(), registry, "0123456789abcdef")
+	require.NoError(t, err)
+	assert.NotNil(t, credential)
+
+	require.NoError(t, registry.RotateToken(context.Background(), "scanner", DefaultTokenName, "fedcba9876543210"))
+
+	credential, err = Authenticate(context.Background(), registry, "0123456789abcdef")
+	require.NoError(t, err)
+	assert.Nil(t, credential)
+	credential, err = Authenticate(context.Background(), registry, "fedcba9876543210")
+	require.NoError(t, err)
+	assert.NotNil(t, credential)
+}
+
+func TestShouldBufferTokenUsagesUntilRefresh(t *testing.T) {
+	backend := newCountingRegistry(t, "scanner", "0123456789abcdef")
+
+	now := time.Now()
+	registry := NewCachedRegistry(backend, time.Minute)
+	registry.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := Authenticate(context.Background(), registry, "0123456789abcdef")
+		require.NoError(t, err)
+	}
+	assert.Empty(t, backend.usages)
+
+	_, err := registry.ListTokens(context.Background(), "scanner")
+	require.NoError(t, err)
+	require.Len(t, backend.usages, 1)
+	assert.Equal(t, "scanner", backend.usages[0].Source)
+	assert.Equal(t, DefaultTokenName, backend.usages[0].Token)
+
+	_, err = Authenticate(context.Background(), registry, "0123456789abcdef")
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = registry.ListSources(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, backend.usages, 2)
+}
+
+func TestShouldRecordUpdatesOfSourcesRightAway(t *testing.T) {
+	backend := newCountingRegistry(t, "scanner", "0123456789abcdef")
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	registry := NewCachedRegistry(backend, time.Minute)
+	registry.now = func() time.Time { return now }
+
+	freshness, err := registry.ListFreshness(context.Background())
+	require.NoError(t, err)
+	require.Len(t, freshness, 1)
+	assert.Nil(t, freshness[0].LastUpdateAt)
+
+	// The updates are not lost if the server stops before the cache is refreshed
+	require.NoError(t, registry.MarkSourcesUpdated(context.Background(), []SourceUpdate{{Source: "scanner", At: now}}))
+	assert.Equal(t, []SourceUpdate{{Source: "scanner", At: now}}, backend.updates)
+
+	// The cached freshness is updated as well and an older update does not roll it back
+	require.NoError(t, registry.MarkSourcesUpdated(context.Background(), []SourceUpdate{{Source: "scanner", At: now.Add(-time.Hour)}}))
+	freshness, err = registry.ListFreshness(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, freshness[0].LastUpdateAt)
+	assert.Equal(t, now, *freshness[0].LastUpdateAt)
+}
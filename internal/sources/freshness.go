@@ -0,0 +1,64 @@
+package sources
+
+import (
+	"time"
+)
+
+// FreshnessState is the state of the data of a source with regard to its expected update interval
+type FreshnessState string
+
+const (
+	// StateFresh is the state of the sources which updated their graph within their expected interval or which
+	// have no expected interval
+	StateFresh FreshnessState = "fresh"
+	// StateStale is the state of the sources which have not updated their graph within their expected interval
+	StateStale FreshnessState = "stale"
+	// StateNeverReported is the state of the sources which never updated their graph
+	StateNeverReported FreshnessState = "never_reported"
+)
+
+// SourceUpdate is a successful update of the graph of a source
+type SourceUpdate struct {
+	Source string
+	At     time.Time
+}
+
+// Freshness is the last successful update of the graph of a source and the interval the source declared updating
+// its graph at
+type Freshness struct {
+	Source       string
+	LastUpdateAt *time.Time
+	// ExpectedInterval is the interval declared by the source, 0 if none
+	ExpectedInterval time.Duration
+}
+
+// Interval return the interval declared by the source or the default interval when the source declared none
+func (f Freshness) Interval(defaultInterval time.Duration) time.Duration {
+	if f.ExpectedInterval > 0 {
+		return f.ExpectedInterval
+	}
+	return defaultInterval
+}
+
+// StaleFor return for how long the source has been stale, 0 when it is not. A source without interval is never stale.
+func (f Freshness) StaleFor(now time.Time, defaultInterval time.Duration) time.Duration {
+	interval := f.Interval(defaultInterval)
+	if f.LastUpdateAt == nil || interval <= 0 {
+		return 0
+	}
+	if late := now.Sub(*f.LastUpdateAt) - interval; late > 0 {
+		return late
+	}
+	return 0
+}
+
+// State return the state of the data of the source
+func (f Freshness) State(now time.Time, defaultInterval time.Duration) FreshnessState {
+	if f.LastUpdateAt == nil {
+		return StateNeverReported
+	}
+	if f.StaleFor(now, defaultInterval) > 0 {
+		return StateStale
+	}
+	return StateFresh
+}
@@ -0,0 +1,29 @@
+package sources
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldComputeStateOfSources(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	anHourAgo := now.Add(-time.Hour)
+
+	assert.Equal(t, StateNeverReported, Freshness{Source: "scanner", ExpectedInterval: time.Hour}.State(now, 0))
+
+	f := Freshness{Source: "scanner", LastUpdateAt: &anHourAgo, ExpectedInterval: 2 * time.Hour}
+	assert.Equal(t, StateFresh, f.State(now, 0))
+	assert.Equal(t, time.Duration(0), f.StaleFor(now, 0))
+
+	f.ExpectedInterval = 20 * time.Minute
+	assert.Equal(t, StateStale, f.State(now, 0))
+	assert.Equal(t, 40*time.Minute, f.StaleFor(now, 0))
+
+	// The default interval applies to the sources which did not declare one
+	f.ExpectedInterval = 0
+	assert.Equal(t, StateFresh, f.State(now, 0))
+	assert.Equal(t, StateStale, f.State(now, 30*time.Minute))
+	assert.Equal(t, StateFresh, f.State(now, 2*time.Hour))
+}
@@ -1,9 +1,73 @@
 package sources
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var (
+	// ErrSourceNotFound error returned when the source is not registered
+	ErrSourceNotFound = errors.New("source not found")
+	// ErrSourceAlreadyExists error returned when a source with the same name is already registered
+	ErrSourceAlreadyExists = errors.New("source already exists")
+	// ErrInvalidSourceName error returned when the name of a source or of a token is not valid
+	ErrInvalidSourceName = errors.New("invalid source name")
+	// ErrTokenNotFound error returned when the source has no token with the name
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrTokenAlreadyExists error returned when the source already has a token with the same name
+	ErrTokenAlreadyExists = errors.New("token already exists")
+)
+
+// RemovalReport reports the data removed along with a source
+type RemovalReport struct {
+	// The number of assets and relations which were only bound to the removed source and therefore removed from the graph
+	RemovedAssets    int64 `json:"removed_assets"`
+	RemovedRelations int64 `json:"removed_relations"`
+}
 
 // Registry is a regostry of data sources with their auth tokens
 type Registry interface {
-	// List data sources with their authentication tokens
-	ListSources(ctx context.Context) (map[string]string, error)
+	// ListSources list the names of the data sources
+	ListSources(ctx context.Context) ([]string, error)
+	// ListCredentials list the hashed authentication tokens of all the data sources
+	ListCredentials(ctx context.Context) ([]Credential, error)
+
+	// CreateSource register a new data source along with its default token granted all the scopes. Only a hash of
+	// the token is stored.
+	CreateSource(ctx context.Context, name, authToken string) error
+	// RotateToken replace the secret of a token of the source, its scopes and expiry are kept
+	RotateToken(ctx context.Context, name, tokenName, authToken string) error
+	// RenameSource rename the source, its graph is kept
+	RenameSource(ctx context.Context, name, newName string) error
+	// RemoveSource unregister the source, unbind its assets and relations and remove the ones no other source references
+	RemoveSource(ctx context.Context, name string) (RemovalReport, error)
+
+	// ListTokens list the tokens of the source, the secrets are not returned
+	ListTokens(ctx context.Context, name string) ([]Token, error)
+	// AddToken add a token to the source, only a hash of the token is stored
+	AddToken(ctx context.Context, name string, token Token, authToken string) error
+	// RevokeToken remove a token of the source
+	RevokeToken(ctx context.Context, name, tokenName string) error
+	// MarkTokensUsed record the last time the tokens have been used
+	MarkTokensUsed(ctx context.Context, usages []TokenUsage) error
+
+	// ListFreshness list the last successful update and the expected update interval of all the sources
+	ListFreshness(ctx context.Context) ([]Freshness, error)
+	// MarkSourcesUpdated record the last time the sources successfully updated their graph
+	MarkSourcesUpdated(ctx context.Context, updates []SourceUpdate) error
+	// SetExpectedInterval record the interval the source declares updating its graph at, 0 removes it
+	SetExpectedInterval(ctx context.Context, name string, interval time.Duration) error
+}
+
+var sourceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// CheckName verifies the name of a source or of a token is made of at most 64 letters, digits, dots, dashes or underscores
+func CheckName(name string) error {
+	if !sourceNameRegexp.MatchString(name) {
+		return fmt.Errorf("%w %q: only letters, digits, '.', '-' and '_' are allowed and at most 64 characters", ErrInvalidSourceName, name)
+	}
+	return nil
 }
@@ -0,0 +1,276 @@
+package sources
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scope is a permission granted to a token
+type Scope string
+
+const (
+	// ScopeGraphRead allows reading the graph of the source
+	ScopeGraphRead Scope = "graph:read"
+	// ScopeGraphWrite allows updating the graph of the source
+	ScopeGraphWrite Scope = "graph:write"
+	// ScopeQuery allows querying the whole graph
+	ScopeQuery Scope = "query"
+)
+
+// AllScopes are the scopes a token can be granted, the default token of a source is granted all of them
+var AllScopes = []Scope{ScopeGraphRead, ScopeGraphWrite, ScopeQuery}
+
+var (
+	// ErrInvalidScope error returned when a scope does not exist
+	ErrInvalidScope = errors.New("invalid scope")
+	// ErrInsufficientScope error returned when the token is not granted the scope required by the request
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// DefaultTokenName is the name of the token created along with a source
+const DefaultTokenName = "default"
+
+// ParseScopes parse a list of scopes, the scopes can also be separated by commas
+func ParseScopes(values []string) ([]Scope, error) {
+	scopes := []Scope{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			scope := Scope(s)
+			valid := false
+			for _, known := range AllScopes {
+				valid = valid || known == scope
+			}
+			if !valid {
+				return nil, fmt.Errorf("%w %q: must be one of %v", ErrInvalidScope, s, AllScopes)
+			}
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// Token describes a token of a source, the secret is not part of it
+type Token struct {
+	Name       string     `json:"name"`
+	Scopes     []Scope    `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// HasScope returns true if the token is granted the scope
+func (t Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns true if the token cannot be used anymore
+func (t Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// Credential is a hashed token of a source used for authentication
+type Credential struct {
+	Source string
+	Token
+	HashedToken
+}
+
+// TokenUsage is the last time a token was used
+type TokenUsage struct {
+	Source string
+	Token  string
+	At     time.Time
+	// Rehashed is the hash replacing the legacy SHA-256 hash of the token, nil when the token is already hashed
+	// with bcrypt
+	Rehashed *HashedToken
+}
+
+// TokenIDLength is the length of the public ID of the issued tokens
+const TokenIDLength = 16
+
+// tokenHashCost is the cost of the bcrypt hashes of the tokens
+var tokenHashCost = bcrypt.DefaultCost
+
+// HashedToken is the bcrypt hash of an authentication token. The tokens issued by the server are made of a public
+// ID used to look the hash up and a secret, the tokens in any other format are legacy tokens without ID whose hash
+// is looked up by a fingerprint of the token instead.
+type HashedToken struct {
+	// ID is the public ID of the token or the fingerprint of a legacy token, empty for the legacy SHA-256 hashes
+	ID string
+	// Salt is only set for the legacy SHA-256 hashes which are replaced by bcrypt hashes when the token is used
+	Salt string
+	Hash string
+}
+
+// GenerateToken generate a random authentication token for a source in the format <id>.<secret>
+func GenerateToken() (string, error) {
+	id := make([]byte, TokenIDLength/2)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("unable to generate token: %w", err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("unable to generate token: %w", err)
+	}
+	return hex.EncodeToString(id) + "." + hex.EncodeToString(secret), nil
+}
+
+// TokenID returns the public ID of the token or an empty string if the token is a legacy token without ID
+func TokenID(token string) string {
+	i := strings.IndexByte(token, '.')
+	if i != TokenIDLength || i == len(token)-1 {
+		return ""
+	}
+	if _, err := hex.DecodeString(token[:i]); err != nil {
+		return ""
+	}
+	return token[:i]
+}
+
+// legacyTokenFingerprint returns the fingerprint of a legacy token without ID. It is stored in place of the ID so that
+// the hash of a legacy token is looked up like the one of an issued token and a presented token is never compared
+// with the hashes of all the legacy tokens.
+func legacyTokenFingerprint(token string) string {
+	h := sha256.Sum256([]byte("legacy-token:" + token))
+	return hex.EncodeToString(h[:TokenIDLength/2])
+}
+
+// lookupID returns the ID the hash of the token is stored under, the fingerprint of the legacy tokens
+func lookupID(token string) string {
+	if id := TokenID(token); id != "" {
+		return id
+	}
+	return legacyTokenFingerprint(token)
+}
+
+// prehashToken hash the token with SHA-256 so that the tokens longer than the 72 bytes handled by bcrypt are fully
+// taken into account
+func prehashToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return []byte(hex.EncodeToString(h[:]))
+}
+
+func legacyHashToken(salt, token string) string {
+	h := sha256.Sum256([]byte(salt + token))
+	return hex.EncodeToString(h[:])
+}
+
+// HashToken hash the token with bcrypt
+func HashToken(token string) (HashedToken, error) {
+	hash, err := bcrypt.GenerateFromPassword(prehashToken(token), tokenHashCost)
+	if err != nil {
+		return HashedToken{}, fmt.Errorf("unable to hash token: %w", err)
+	}
+	return HashedToken{ID: lookupID(token), Hash: string(hash)}, nil
+}
+
+// Legacy returns true if the hash is a legacy salted SHA-256 hash to be replaced by a bcrypt hash
+func (h HashedToken) Legacy() bool {
+	return h.Salt != ""
+}
+
+// Matches returns true if the hash is the one of the token
+func (h HashedToken) Matches(token string) bool {
+	if h.Legacy() {
+		return subtle.ConstantTimeCompare([]byte(legacyHashToken(h.Salt, token)), []byte(h.Hash)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(h.Hash), prehashToken(token)) == nil
+}
+
+// verifiedTokens remembers the hashes the presented tokens matched so that the slow bcrypt comparison is not run on
+// every request. The tokens are keyed by their HMAC under a key only known by the process and a cached match is only
+// used while the hash of the token has not changed.
+var verifiedTokens = newVerifiedTokens()
+
+type verifiedTokenCache struct {
+	key   []byte
+	cache *cache.Cache
+}
+
+func newVerifiedTokens() *verifiedTokenCache {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("unable to generate the key of the token cache: %v", err))
+	}
+	return &verifiedTokenCache{key: key, cache: cache.New(10*time.Minute, 10*time.Minute)}
+}
+
+func (v *verifiedTokenCache) cacheKey(token string) string {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// matches returns true if the hash is the one of the token, using the cached match if any
+func (v *verifiedTokenCache) matches(h HashedToken, token string) bool {
+	key := v.cacheKey(token)
+	if hash, ok := v.cache.Get(key); ok && hash.(string) == h.Hash {
+		return true
+	}
+	if !h.Matches(token) {
+		return false
+	}
+	v.cache.SetDefault(key, h.Hash)
+	return true
+}
+
+// Authenticate returns the credential matching the token or nil if none matches or the token has expired. Only the
+// bcrypt hashes stored under the ID of the token, or the fingerprint of a legacy token, are compared so that an
+// unknown token costs at most one slow comparison. The legacy tokens are also compared with the legacy SHA-256 hashes
+// which are cheap to compute, such a hash is replaced by a bcrypt hash along with the record of the use of the token.
+func Authenticate(ctx context.Context, registry Registry, token string) (*Credential, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	credentials, err := registry.ListCredentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the credentials of the sources: %w", err)
+	}
+
+	now := time.Now()
+	id := lookupID(token)
+	legacy := TokenID(token) == ""
+	for _, credential := range credentials {
+		candidate := credential.ID == id || (legacy && credential.Legacy())
+		if !candidate || !verifiedTokens.matches(credential.HashedToken, token) {
+			continue
+		}
+		if credential.Expired(now) {
+			return nil, nil
+		}
+		usage := TokenUsage{Source: credential.Source, Token: credential.Name, At: now}
+		if credential.Legacy() {
+			rehashed, err := HashToken(token)
+			if err != nil {
+				return nil, err
+			}
+			usage.Rehashed = &rehashed
+		}
+		if err := registry.MarkTokensUsed(ctx, []TokenUsage{usage}); err != nil {
+			return nil, fmt.Errorf("unable to record the use of token %s of source %s: %w", credential.Name, credential.Source, err)
+		}
+		credential := credential
+		return &credential, nil
+	}
+	return nil, nil
+}
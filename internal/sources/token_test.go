@@ -0,0 +1,154 @@
+package sources
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	// The tests hash many tokens, the cost does not matter
+	tokenHashCost = bcrypt.MinCost
+}
+
+func TestShouldMatchHashedToken(t *testing.T) {
+	token, err := GenerateToken()
+	require.NoError(t, err)
+
+	hashed, err := HashToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, TokenID(token), hashed.ID)
+	assert.False(t, hashed.Legacy())
+	assert.NotContains(t, hashed.Hash, token[TokenIDLength+1:])
+	assert.True(t, hashed.Matches(token))
+	assert.False(t, hashed.Matches(token[:len(token)-1]+"x"))
+
+	other, err := HashToken(token)
+	require.NoError(t, err)
+	assert.NotEqual(t, hashed.Hash, other.Hash)
+}
+
+func TestShouldParseTokenID(t *testing.T) {
+	token, err := GenerateToken()
+	require.NoError(t, err)
+	assert.Len(t, TokenID(token), TokenIDLength)
+	assert.Equal(t, token[:TokenIDLength], TokenID(token))
+
+	// The legacy tokens have no ID and no part of them is stored in clear
+	assert.Equal(t, "", TokenID("0123456789abcdef"))
+	assert.Equal(t, "", TokenID("abc"))
+	assert.Equal(t, "", TokenID("0123456789abcdef."))
+	assert.Equal(t, "", TokenID("0123456789abcdeg.secret"))
+
+	// The hashes of the legacy tokens are looked up by a fingerprint of the token
+	hashed, err := HashToken("abc")
+	require.NoError(t, err)
+	assert.Equal(t, legacyTokenFingerprint("abc"), hashed.ID)
+	assert.Len(t, hashed.ID, TokenIDLength)
+	assert.True(t, hashed.Matches("abc"))
+}
+
+func TestShouldMatchLongTokens(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	hashed, err := HashToken(long)
+	require.NoError(t, err)
+	assert.True(t, hashed.Matches(long))
+	assert.False(t, hashed.Matches(long[:99]+"b"))
+}
+
+func TestShouldRehashLegacyToken(t *testing.T) {
+	legacy := HashedToken{Salt: "0123456789abcdef0123456789abcdef"}
+	legacy.Hash = legacyHashToken(legacy.Salt, "0123456789abcdef")
+	backend := &countingRegistry{credentials: []Credential{{
+		Source:      "scanner",
+		Token:       Token{Name: DefaultTokenName, Scopes: AllScopes},
+		HashedToken: legacy,
+	}}}
+
+	credential, err := Authenticate(context.Background(), backend, "0123456789abcdef")
+	require.NoError(t, err)
+	require.NotNil(t, credential)
+	require.Len(t, backend.usages, 1)
+	require.NotNil(t, backend.usages[0].Rehashed)
+	assert.False(t, backend.usages[0].Rehashed.Legacy())
+	assert.True(t, backend.usages[0].Rehashed.Matches("0123456789abcdef"))
+}
+
+func TestShouldOnlyCompareHashesOfTokensWithSameID(t *testing.T) {
+	token, err := GenerateToken()
+	require.NoError(t, err)
+	backend := newCountingRegistry(t, "scanner", token)
+
+	// A legacy token whose hash is the one of the token is not compared with the tokens having an ID
+	backend.credentials[0].ID = ""
+	credential, err := Authenticate(context.Background(), backend, token)
+	require.NoError(t, err)
+	assert.Nil(t, credential)
+
+	backend.credentials[0].ID = TokenID(token)
+	credential, err = Authenticate(context.Background(), backend, token)
+	require.NoError(t, err)
+	assert.NotNil(t, credential)
+}
+
+func TestShouldNotCompareUnknownLegacyTokenWithAllHashes(t *testing.T) {
+	backend := &countingRegistry{}
+	for _, token := range []string{"legacy-token-1", "legacy-token-2", "legacy-token-3"} {
+		hashed, err := HashToken(token)
+		require.NoError(t, err)
+		backend.credentials = append(backend.credentials, Credential{
+			Source:      token,
+			Token:       Token{Name: DefaultTokenName, Scopes: AllScopes},
+			HashedToken: hashed,
+		})
+	}
+
+	// The hash of a legacy token is found by its fingerprint
+	credential, err := Authenticate(context.Background(), backend, "legacy-token-2")
+	require.NoError(t, err)
+	require.NotNil(t, credential)
+	assert.Equal(t, "legacy-token-2", credential.Source)
+
+	// Only the hash stored under the fingerprint of the token is compared
+	hashed, err := HashToken("unknown-token")
+	require.NoError(t, err)
+	backend.credentials[0].Hash = hashed.Hash
+	credential, err = Authenticate(context.Background(), backend, "unknown-token")
+	require.NoError(t, err)
+	assert.Nil(t, credential)
+}
+
+func TestShouldParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"graph:read,query", " graph:write "})
+	require.NoError(t, err)
+	assert.Equal(t, []Scope{ScopeGraphRead, ScopeQuery, ScopeGraphWrite}, scopes)
+
+	_, err = ParseScopes([]string{"graph:delete"})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestShouldExpireToken(t *testing.T) {
+	now := time.Now()
+	assert.False(t, Token{}.Expired(now))
+
+	expiresAt := now.Add(time.Hour)
+	token := Token{ExpiresAt: &expiresAt}
+	assert.False(t, token.Expired(now))
+	assert.True(t, token.Expired(expiresAt))
+}
+
+func TestShouldNotAuthenticateExpiredToken(t *testing.T) {
+	backend := newCountingRegistry(t, "scanner", "0123456789abcdef")
+	expiresAt := time.Now().Add(-time.Minute)
+	backend.credentials[0].ExpiresAt = &expiresAt
+
+	credential, err := Authenticate(context.Background(), backend, "0123456789abcdef")
+	require.NoError(t, err)
+	assert.Nil(t, credential)
+	assert.Empty(t, backend.usages)
+}
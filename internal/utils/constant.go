@@ -3,4 +3,29 @@ package utils
 var (
 	// XAuthTokenHeader is the name of the header supposed to contain the auth token
 	XAuthTokenHeader = "X-Auth-Token"
+
+	// XRevisionHeader is the name of the header containing the revision of the graph of the source
+	XRevisionHeader = "X-Graphkb-Revision"
+
+	// XForceDeletionHeader is the name of the header bypassing the deletion guard of the source when set to true
+	XForceDeletionHeader = "X-Graphkb-Force-Deletion"
+
+	// XRunIDHeader is the name of the header containing the ID of the run a graph update belongs to. The removals of
+	// the requests of a run are added up by the deletion guard of the source.
+	XRunIDHeader = "X-Graphkb-Run-ID"
+
+	// XErrorCodeHeader is the name of the header containing the code of the error returned by the API
+	XErrorCodeHeader = "X-Graphkb-Error-Code"
 )
+
+// BinaryContentType is the content type of the graph updates encoded in the compact binary format
+const BinaryContentType = "application/x-graphkb-binary"
+
+// DeletionGuardErrorCode is the error code returned when the deletion guard of the source refused an update
+const DeletionGuardErrorCode = "deletion_guard"
+
+// QuotaExceededErrorCode is the error code returned when an update would make the graph of the source exceed its quota
+const QuotaExceededErrorCode = "quota_exceeded"
+
+// SchemaViolationErrorCode is the error code returned when the assets or relations of an update violate the schema
+const SchemaViolationErrorCode = "schema_violation"